@@ -27,12 +27,19 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/google/uuid"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/iam"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/subcommands"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
 )
@@ -50,17 +57,39 @@ func exit(cntx context.Context, exitCode int) {
 }
 
 func main() {
-	cntx := context.Background()
+	// Cancelled on interrupt or termination so waits between attempts and requests in flight end right away
+	cntx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Signals are only caught once, a second one terminates the process without waiting for leases to be released
+	go func() {
+		<-cntx.Done()
+		cancel()
+	}()
 
 	// Cleanup and exit handling
 	defer func() { exit(cntx, exitCode); os.Exit(exitCode) }()
 
 	// Flag subcommands
-	versionCommand := flag.NewFlagSet("version", flag.ExitOnError)
-	createLeaseBlobCommand := flag.NewFlagSet("createleaseblob", flag.ExitOnError)
-	acquireCommand := flag.NewFlagSet("acquire", flag.ExitOnError)
-	renewCommand := flag.NewFlagSet("renew", flag.ExitOnError)
-	// TODO: Implement release command
+	versionCommand := flag.NewFlagSet("version", flag.ContinueOnError)
+	createLeaseBlobCommand := flag.NewFlagSet("createleaseblob", flag.ContinueOnError)
+	acquireCommand := flag.NewFlagSet("acquire", flag.ContinueOnError)
+	renewCommand := flag.NewFlagSet("renew", flag.ContinueOnError)
+	releaseCommand := flag.NewFlagSet("release", flag.ContinueOnError)
+	resumeCommand := flag.NewFlagSet("resume", flag.ContinueOnError)
+	statusCommand := flag.NewFlagSet("status", flag.ContinueOnError)
+	listCommand := flag.NewFlagSet("list", flag.ContinueOnError)
+	purgeCommand := flag.NewFlagSet("purge", flag.ContinueOnError)
+	doctorCommand := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	benchCommand := flag.NewFlagSet("bench", flag.ContinueOnError)
+	testAuthCommand := flag.NewFlagSet("test-auth", flag.ContinueOnError)
+	healthCheckCommand := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	serveCommand := flag.NewFlagSet("serve", flag.ContinueOnError)
+	agentCommand := flag.NewFlagSet("agent", flag.ContinueOnError)
+	watchCommand := flag.NewFlagSet("watch", flag.ContinueOnError)
+	handoffCommand := flag.NewFlagSet("handoff", flag.ContinueOnError)
+	semaphoreCommand := flag.NewFlagSet("semaphore", flag.ContinueOnError)
+	rwLockCommand := flag.NewFlagSet("rwlock", flag.ContinueOnError)
 
 	// CreateLeaseBlob subcommand flag pointers
 	createLeaseBlobSubscriptionID := createLeaseBlobCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
@@ -68,63 +97,505 @@ func main() {
 	createLeaseBlobAccountName := createLeaseBlobCommand.String("accountname", "", "Storage Account Name")
 	createLeaseBlobBlobContainer := createLeaseBlobCommand.String("container", "", "Blob container name")
 	createLeaseBlobBlobBlobName := createLeaseBlobCommand.String("blobname", config.BlobName(), "Blob name")
+	createLeaseBlobPrefix := addPrefixFlag(createLeaseBlobCommand)
 	createLeaseBlobEnvironment := createLeaseBlobCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
 	createLeaseBlobManagedIdentityId := createLeaseBlobCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
 	createLeaseBlobUseSystemManagedIdentity := createLeaseBlobCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
-	createLeaseBlobCustomCloudConfigFile := createLeaseBlobCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment")
+	createLeaseBlobCustomCloudConfigFile := createLeaseBlobCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	createLeaseBlobConnection := addConnectionFlags(createLeaseBlobCommand)
+	createLeaseBlobOutput := addOutputFlag(createLeaseBlobCommand)
+	createLeaseBlobTags := createLeaseBlobCommand.String("tags", "", "Blob index tags applied when the blob is created, format is key=value,key=value")
+	createLeaseBlobSize := createLeaseBlobCommand.Int("blob-size", 1024, "Size in bytes of the random content uploaded when the blob is created, 0 creates an empty blob, ignored when content-file is used")
+	createLeaseBlobContentFile := createLeaseBlobCommand.String("content-file", "", "Uploads the content of this file when the blob is created instead of random bytes, use - to read from stdin")
+	createLeaseBlobType := createLeaseBlobCommand.String("blob-type", "block", fmt.Sprintf("Type of the blob created, valid values are: %v, acquire and renew work with any of them", config.ValidBlobTypes()))
+	createLeaseBlobContainerMetadata := createLeaseBlobCommand.String("container-metadata", "", "Metadata applied when the container is created, format is key=value,key=value")
+	createLeaseBlobNoCreateContainer := createLeaseBlobCommand.Bool("no-create-container", false, "Fails instead of creating the container when it does not exist")
+	createLeaseBlobAccessTier := createLeaseBlobCommand.String("access-tier", "", fmt.Sprintf("Access tier the blob is created with, valid values are: %v, only supported with block blobs, the account default tier is used when empty", config.ValidAccessTiers()))
+	createLeaseBlobContentType := createLeaseBlobCommand.String("content-type", "", "Content-Type of the blob created (e.g. application/json), the storage service default is used when empty")
+	createLeaseBlobMetadata := createLeaseBlobCommand.String("metadata", "", "Metadata applied when the blob is created, format is key=value,key=value, keys must be valid C# identifiers and cannot be the ones recording lease holder information")
+	createLeaseBlobUndelete := createLeaseBlobCommand.Bool("undelete", false, "Restores the lease blob when it was deleted and is retained by blob soft delete, instead of creating a new blob while the deleted one is retained")
+	createLeaseBlobDryRun := createLeaseBlobCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed without touching the blob")
 
 	// Acquire subcommand flag pointers
 	acquireSubscriptionID := acquireCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
 	acquireResourceGroupName := acquireCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
 	acquireAccountName := acquireCommand.String("accountname", "", "Storage Account Name")
 	acquireBlobContainer := acquireCommand.String("container", "", "Blob container name")
-	acquireBlobName := acquireCommand.String("blobname", config.BlobName(), "Blob name")
-	acquireLeaseDuration := acquireCommand.Int("leaseduration", 60, "Lease duration in seconds, valid values are between 15 and 60, -1 is not supported in this tool")
+	acquireBlobNames := utils.NewStringListFlag(config.BlobName())
+	acquireCommand.Var(acquireBlobNames, "blobname", "Blob name, can be repeated or comma separated to acquire several independent leases")
+	acquirePrefix := addPrefixFlag(acquireCommand)
+	acquireLeaseDuration := utils.NewSecondsFlag(acquireCommand, "leaseduration", 60, "Lease `duration`, in seconds or as a duration (e.g. 45s), valid values are between 15 and 60 seconds, -1 is not supported in this tool")
 	acquireRetries := acquireCommand.Int("retries", 1, "Lease acquire operation, number of retry attempts")
-	acquireWaitTimeSec := acquireCommand.Int("waittimesec", 0, "Time in seconds between iterations to renew current lease, must be between 1 and 59 seconds, ideally half of the time used when acquiring lease")
+	acquireWaitTimeSec := utils.NewSecondsFlag(acquireCommand, "waittimesec", 0, "Wait `time` between acquire attempts, in seconds or as a duration (e.g. 5s, 2m), 0 retries right away")
 	acquireEnvironment := acquireCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
 	acquireManagedIdentityId := acquireCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
 	acquireUseSystemManagedIdentity := acquireCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
-	acquireCustomCloudConfigFile := acquireCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment")
+	acquireCustomCloudConfigFile := acquireCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	acquireConnection := addConnectionFlags(acquireCommand)
+	acquireStatsD := addStatsDFlags(acquireCommand)
+	acquireOutput := addOutputFlag(acquireCommand)
+	acquireHolder := acquireCommand.String("holder", defaultHolder(), "Identity of the lease holder recorded in blob metadata, defaults to the hostname")
+	acquireShards := acquireCommand.String("shards", "", "Comma separated list of blob names, the lease is acquired on the first free one instead of blobname")
+	acquireShardPrefix := acquireCommand.String("shard-prefix", "", "Prefix of shard blob names, used with shard-count to build names <prefix>0 to <prefix><count-1>")
+	acquireShardCount := acquireCommand.Int("shard-count", 0, "Number of shards when shard-prefix is used")
+	acquireQuorumAccounts := acquireCommand.String("quorum-accounts", "", "Additional storage accounts, format is [subscriptionid/]resourcegroup/account,..., where the lease is also acquired, succeeding only when a majority of leases is held")
+	acquireAuditLogBlob := acquireCommand.String("audit-log-blob", "", "Name of an append blob, in the same container, where a json line is appended for every successful acquisition")
+	acquireAuditSnapshots := acquireCommand.Bool("audit-snapshots", false, "Creates a blob snapshot, including holder metadata, on every successful acquisition as a record of leadership history")
+	acquireJitter := acquireCommand.Int("jitter", 0, "Randomly spreads wait intervals between attempts by up to this percentage in both directions (0 to 50), to avoid synchronized bursts from fleets")
+	acquireMaxWait := acquireCommand.Duration("max-wait", 0, "Total time spent attempting acquisition (e.g. 5m), retries is ignored when informed and the status is Contended when the lease was not obtained in time, requires waittimesec greater than 0")
+	acquireSteal := acquireCommand.Bool("steal", false, "Breaks a lease held by someone else and acquires it right away, for controlled leadership takeover, not supported in sharded or quorum mode")
+	acquireStateFile := acquireCommand.String("state-file", "", "Local file (e.g. /run/azbloblease/state.json) where the acquired lease is persisted, so resume can re-attach to it if the process crashes before renewing, not supported with several blobs or quorum mode")
+	acquireParallelism := acquireCommand.Int("parallelism", 8, "Maximum number of blobs attempted concurrently when acquiring several blobs or in sharded mode")
+	acquireOnAcquireExec := acquireCommand.String("on-acquire-exec", "", "Local script run once the lease is acquired, event details are passed as AZBLOBLEASE_* environment variables")
+	acquireCreateIfMissing := acquireCommand.Bool("create-if-missing", false, "Creates the container and the lease blob, as createleaseblob does with its defaults, when the blob does not exist, then acquires the lease, only supported when acquiring a single blob")
+	acquireSkipExistsCheck := acquireCommand.Bool("skip-exists-check", false, "Attempts the lease right away instead of reading blob properties first, saving a round trip, holder metadata is then read and updated once the lease is held")
+	acquirePrecheckTimeout := acquireCommand.Duration("precheck-timeout", 0, "Probes the blob endpoint with an anonymous HEAD request before attempting acquisition, failing within this duration (e.g. 5s) with a distinct exit code when dns lookup, tcp connect or tls handshake fails instead of spending all retries, 0 skips it, not supported in quorum mode")
+	acquireDeleteOldVersions := acquireCommand.Bool("delete-old-versions", false, "Deletes the previous versions of the lease blob once holder metadata is recorded, on storage accounts with blob versioning enabled every metadata update creates one")
+	acquirePriority := acquireCommand.Int("priority", 0, fmt.Sprintf("Candidate priority (1 to 100), the first attempt is delayed by a random time up to %v divided by priority so when several candidates race for a freed lease the preferred one statistically wins, 0 attempts right away", config.PriorityBaseDelay()))
+	acquireStartupJitter := addStartupJitterFlag(acquireCommand)
+	acquireDryRun := acquireCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed, including the proposed lease id, without touching the blob")
 
 	// Renew subcommand flag pointers
 	renewSubscriptionID := renewCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
 	renewResourceGroupName := renewCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
 	renewAccountName := renewCommand.String("accountname", "", "Storage Account Name")
 	renewBlobContainer := renewCommand.String("container", "", "Blob container name")
-	renewBlobName := renewCommand.String("blobname", config.BlobName(), "Blob name")
-	renewLeaseID := renewCommand.String("leaseid", "", "GUID value that represents the acquired lease")
+	renewBlobNames := utils.NewStringListFlag(config.BlobName())
+	renewCommand.Var(renewBlobNames, "blobname", "Blob name, can be repeated or comma separated to renew several independent leases concurrently")
+	renewPrefix := addPrefixFlag(renewCommand)
+	renewLeaseIDs := utils.NewStringListFlag("")
+	renewCommand.Var(renewLeaseIDs, "leaseid", "GUID value that represents the acquired lease, when renewing several blobs it can be repeated or comma separated, one per blob, or a single one shared by all blobs")
 	renewIterations := renewCommand.Int("iterations", 20, "Lease renew, number of times it will repeat renew operation")
-	renewWaitTimeSec := renewCommand.Int("waittimesec", 30, "Time in seconds between iterations to renew current lease, must be between 1 and 59 seconds, ideally half of the time used when acquiring lease")
+	renewWaitTimeSec := utils.NewSecondsFlag(renewCommand, "waittimesec", 0, "Wait `time` between iterations to renew current lease, in seconds or as a duration (e.g. 20s), must be below the lease duration, 0 renews every third of the lease duration recorded in blob metadata")
 	renewEnvironment := renewCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
 	renewManagedIdentityId := renewCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
 	renewUseSystemManagedIdentity := renewCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
-	renewCustomCloudConfigFile := renewCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment")
+	renewCustomCloudConfigFile := renewCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	renewConnection := addConnectionFlags(renewCommand)
+	renewStatsD := addStatsDFlags(renewCommand)
+	renewLogTarget := addLogTargetFlag(renewCommand)
+	renewOutput := addOutputFlag(renewCommand)
+	renewQuorumAccounts := renewCommand.String("quorum-accounts", "", "Additional storage accounts, format is [subscriptionid/]resourcegroup/account,..., where the lease was acquired in quorum mode")
+	renewAuditLogBlob := renewCommand.String("audit-log-blob", "", "Name of an append blob, in the same container, where a json line is appended for every renewal outcome, failed renewals included")
+	renewAtFraction := renewCommand.Float64("renew-at-fraction", 0, "Schedules renewals when this fraction of the lease duration remains (e.g. 0.33), measured from the last successful renewal, instead of every waittimesec, not supported in quorum mode")
+	renewThreshold := renewCommand.Duration("renew-threshold", 0, "Schedules renewals when less than this time of the lease remains (e.g. 10s), measured from the last successful renewal, instead of every waittimesec, must be below the lease duration, not supported in quorum mode")
+	renewStateFile := renewCommand.String("state-file", "", "Local file (e.g. /run/azbloblease/state.json) atomically updated with leadership status, lease id and expiration after every renewal, not supported with several blobs or quorum mode")
+	renewKubernetesLease := renewCommand.String("k8s-lease", "", "Mirrors the held blob lease into a kubernetes coordination.k8s.io/v1 Lease, format is [namespace/]name, using the pod service account, not supported with several blobs or quorum mode")
+	renewJitter := renewCommand.Int("jitter", 0, "Randomly spreads wait intervals between renewals by up to this percentage in both directions (0 to 50), to avoid synchronized bursts from fleets, a renewal is never delayed into the last quarter of the lease")
+	renewRecordRenewals := renewCommand.Bool("record-renewals", true, "Records the time of every successful renewal in blob metadata as a heartbeat, under the lease condition, so status can tell a leader actively renewing from a wedged one and list -report when the lease expires, -record-renewals=false saves the extra request per renewal")
+	renewOnRenewExec := renewCommand.String("on-renew-exec", "", "Local script run after every successful renewal, event details are passed as AZBLOBLEASE_* environment variables, not supported with several blobs or quorum mode")
+	renewOnLostExec := renewCommand.String("on-lost-exec", "", "Local script run when a renewal fails and the lease is considered lost, event details are passed as AZBLOBLEASE_* environment variables, not supported with several blobs or quorum mode")
+	renewHolder := renewCommand.String("holder", "", "Expected lease holder, renew fails fast when blob metadata records another holder, not checked when empty")
+	renewDeleteOldVersions := renewCommand.Bool("delete-old-versions", false, "Deletes the previous versions of the lease blob after every recorded renewal, on storage accounts with blob versioning enabled every metadata update creates one")
+	renewSkipPreflight := renewCommand.Bool("skip-preflight", false, "Starts renewing without first checking that the lease is active and, with holder, recorded for that holder, e.g. to renew an expired lease nobody else acquired")
+	renewDryRun := renewCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed without touching the blob")
+
+	// Release subcommand flag pointers
+	releaseSubscriptionID := releaseCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	releaseResourceGroupName := releaseCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	releaseAccountName := releaseCommand.String("accountname", "", "Storage Account Name")
+	releaseBlobContainer := releaseCommand.String("container", "", "Blob container name")
+	releaseBlobName := releaseCommand.String("blobname", config.BlobName(), "Blob name")
+	releasePrefix := addPrefixFlag(releaseCommand)
+	releaseLeaseID := releaseCommand.String("leaseid", "", "GUID value that represents the acquired lease")
+	releaseQuorumAccounts := releaseCommand.String("quorum-accounts", "", "Additional storage accounts, format is [subscriptionid/]resourcegroup/account,..., where the lease was acquired in quorum mode, succeeding when a majority of leases is released")
+	releaseEnvironment := releaseCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	releaseManagedIdentityId := releaseCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	releaseUseSystemManagedIdentity := releaseCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	releaseCustomCloudConfigFile := releaseCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	releaseConnection := addConnectionFlags(releaseCommand)
+	releaseOutput := addOutputFlag(releaseCommand)
+	releaseDryRun := releaseCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed without touching the blob")
+	releaseFromState := releaseCommand.String("from-state", "", "Local state file written by acquire, renew or resume subcommands with -state-file, the lease is identified by it instead of subscriptionid, resourcegroupname, accountname, container, blobname and leaseid, for supervisors releasing an orphaned lease on restart")
+	releaseAuditLogBlob := releaseCommand.String("audit-log-blob", "", "Name of an append blob, in the same container, where a json line is appended once the lease is released")
+	releaseOnReleaseExec := releaseCommand.String("on-release-exec", "", "Local script run once the lease is released, event details are passed as AZBLOBLEASE_* environment variables")
+
+	// Handoff subcommand flag pointers
+	handoffSubscriptionID := handoffCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	handoffResourceGroupName := handoffCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	handoffAccountName := handoffCommand.String("accountname", "", "Storage Account Name")
+	handoffBlobContainer := handoffCommand.String("container", "", "Blob container name")
+	handoffBlobName := handoffCommand.String("blobname", config.BlobName(), "Blob name")
+	handoffPrefix := addPrefixFlag(handoffCommand)
+	handoffLeaseID := handoffCommand.String("leaseid", "", "GUID value that represents the acquired lease")
+	handoffEnvironment := handoffCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	handoffManagedIdentityId := handoffCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	handoffUseSystemManagedIdentity := handoffCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	handoffCustomCloudConfigFile := handoffCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	handoffConnection := addConnectionFlags(handoffCommand)
+	handoffOutput := addOutputFlag(handoffCommand)
+	handoffDryRun := handoffCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed without touching the blob")
+	handoffSuccessor := handoffCommand.String("successor", "", "Holder the lease is handed off to, as informed in its acquire holder argument, recorded in blob metadata before the lease is released")
+	handoffPreferenceWindow := handoffCommand.Duration("preference-window", 30*time.Second, "Time the successor has to acquire the released lease, acquire by any other holder waits until it is over")
+
+	// Semaphore subcommand flag pointers
+	semaphoreSubscriptionID := semaphoreCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	semaphoreResourceGroupName := semaphoreCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	semaphoreAccountName := semaphoreCommand.String("accountname", "", "Storage Account Name")
+	semaphoreBlobContainer := semaphoreCommand.String("container", "", "Blob container name")
+	semaphoreName := semaphoreCommand.String("name", "", "Semaphore name, its slots are the lease blobs <name>-0 to <name>-<slots-1>")
+	semaphoreSlots := semaphoreCommand.Int("slots", 0, "Number of slots, the maximum number of concurrent holders")
+	semaphoreAction := semaphoreCommand.String("action", "acquire", fmt.Sprintf("Semaphore action, currently supported ones are: %v, acquire grabs any free slot, renew maintains and release frees the slot held", config.ValidSemaphoreActions()))
+	semaphoreSlot := semaphoreCommand.Int("slot", -1, "Index of the slot held, as returned by acquire, required by renew and release")
+	semaphorePrefix := addPrefixFlag(semaphoreCommand)
+	semaphoreLeaseID := semaphoreCommand.String("leaseid", "", "GUID value that represents the acquired lease, required by renew and release")
+	semaphoreLeaseDuration := utils.NewSecondsFlag(semaphoreCommand, "leaseduration", 60, "Lease `duration` of acquire, in seconds or as a duration (e.g. 45s), valid values are between 15 and 60 seconds")
+	semaphoreHolder := semaphoreCommand.String("holder", defaultHolder(), "Identity of the slot holder recorded in blob metadata, defaults to the hostname")
+	semaphoreRetries := semaphoreCommand.Int("retries", 1, "Slot acquire operation, number of retry attempts")
+	semaphoreMaxWait := semaphoreCommand.Duration("max-wait", 0, "Total time spent attempting to acquire a slot (e.g. 5m), retries is ignored when informed and the status is Contended when no slot was obtained in time, requires waittimesec greater than 0")
+	semaphoreParallelism := semaphoreCommand.Int("parallelism", 8, "Maximum number of slots attempted concurrently by acquire")
+	semaphoreIterations := semaphoreCommand.Int("iterations", 20, "Slot renew, number of times it will repeat renew operation")
+	semaphoreRecordRenewals := semaphoreCommand.Bool("record-renewals", true, "Records the time of every successful renewal in blob metadata as a heartbeat, under the lease condition, so status can tell a leader actively renewing from a wedged one and list -report when the lease expires, -record-renewals=false saves the extra request per renewal")
+	semaphoreWaitTimeSec := utils.NewSecondsFlag(semaphoreCommand, "waittimesec", 0, "Wait `time` between acquire attempts or renew iterations, in seconds or as a duration (e.g. 5s), 0 retries acquire right away and renews every third of the lease duration")
+	semaphoreEnvironment := semaphoreCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	semaphoreManagedIdentityId := semaphoreCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	semaphoreUseSystemManagedIdentity := semaphoreCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	semaphoreCustomCloudConfigFile := semaphoreCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	semaphoreConnection := addConnectionFlags(semaphoreCommand)
+	semaphoreOutput := addOutputFlag(semaphoreCommand)
+	semaphoreDryRun := semaphoreCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed without touching the slot blobs")
+
+	// RWLock subcommand flag pointers
+	rwLockSubscriptionID := rwLockCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	rwLockResourceGroupName := rwLockCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	rwLockAccountName := rwLockCommand.String("accountname", "", "Storage Account Name")
+	rwLockBlobContainer := rwLockCommand.String("container", "", "Blob container name")
+	rwLockBlobName := rwLockCommand.String("blobname", config.BlobName(), "Writer blob name, readers are registered in the metadata of the blob <blobname>-readers")
+	rwLockPrefix := addPrefixFlag(rwLockCommand)
+	rwLockMode := rwLockCommand.String("mode", "read", fmt.Sprintf("Lock mode, currently supported ones are: %v, read locks are shared by any number of readers and write locks are exclusive", config.ValidRWLockModes()))
+	rwLockAction := rwLockCommand.String("action", "acquire", fmt.Sprintf("Lock action, currently supported ones are: %v", config.ValidRWLockActions()))
+	rwLockReaderID := rwLockCommand.String("readerid", "", "Id of the reader returned by read mode acquire, required by read mode release")
+	rwLockReadTTL := rwLockCommand.Duration("read-ttl", time.Hour, "Time a read lock is held when it is not released, so the read lock of a crashed reader does not block writers forever")
+	rwLockLeaseID := rwLockCommand.String("leaseid", "", "GUID value that represents the acquired write lock, required by write mode release, renewed with renew subcommand")
+	rwLockLeaseDuration := utils.NewSecondsFlag(rwLockCommand, "leaseduration", 60, "Lease `duration` of the write lock, in seconds or as a duration (e.g. 45s), valid values are between 15 and 60 seconds")
+	rwLockHolder := rwLockCommand.String("holder", defaultHolder(), "Identity of the write lock holder recorded in blob metadata, defaults to the hostname")
+	rwLockRetries := rwLockCommand.Int("retries", 1, "Lock acquire operation, number of retry attempts")
+	rwLockWaitTimeSec := utils.NewSecondsFlag(rwLockCommand, "waittimesec", 0, "Wait `time` between acquire attempts, in seconds or as a duration (e.g. 5s, 2m), 0 retries right away")
+	rwLockMaxWait := rwLockCommand.Duration("max-wait", 0, "Total time spent attempting to acquire the lock (e.g. 5m), retries is ignored when informed and the status is Contended when the lock was not obtained in time, requires waittimesec greater than 0")
+	rwLockEnvironment := rwLockCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	rwLockManagedIdentityId := rwLockCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	rwLockUseSystemManagedIdentity := rwLockCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	rwLockCustomCloudConfigFile := rwLockCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	rwLockConnection := addConnectionFlags(rwLockCommand)
+	rwLockOutput := addOutputFlag(rwLockCommand)
+	rwLockDryRun := rwLockCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed without touching the lock blobs")
+
+	// Resume subcommand flag pointers
+	resumeStateFile := resumeCommand.String("state-file", "", "Local state file written by acquire or renew subcommands with -state-file, identifying the lease to re-attach to")
+	resumeRelease := resumeCommand.Bool("release", false, "Releases the lease instead of resuming its renewal")
+	resumeIterations := resumeCommand.Int("iterations", 20, "Lease renew, number of times it will repeat renew operation")
+	resumeWaitTimeSec := utils.NewSecondsFlag(resumeCommand, "waittimesec", 0, "Wait `time` between iterations to renew current lease, in seconds or as a duration (e.g. 20s), must be below the lease duration, 0 renews every third of the lease duration recorded in blob metadata")
+	resumeAtFraction := resumeCommand.Float64("renew-at-fraction", 0, "Schedules renewals when this fraction of the lease duration remains (e.g. 0.33), measured from the last successful renewal, instead of every waittimesec")
+	resumeThreshold := resumeCommand.Duration("renew-threshold", 0, "Schedules renewals when less than this time of the lease remains (e.g. 10s), measured from the last successful renewal, instead of every waittimesec, must be below the lease duration")
+	resumeRecordRenewals := resumeCommand.Bool("record-renewals", true, "Records the time of every successful renewal in blob metadata as a heartbeat, under the lease condition, so status can tell a leader actively renewing from a wedged one and list -report when the lease expires, -record-renewals=false saves the extra request per renewal")
+	resumeDeleteOldVersions := resumeCommand.Bool("delete-old-versions", false, "Deletes the previous versions of the lease blob after every recorded renewal, on storage accounts with blob versioning enabled every metadata update creates one")
+	resumeEnvironment := resumeCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	resumeManagedIdentityId := resumeCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	resumeUseSystemManagedIdentity := resumeCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	resumeCustomCloudConfigFile := resumeCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	resumeConnection := addConnectionFlags(resumeCommand)
+	resumeStatsD := addStatsDFlags(resumeCommand)
+	resumeLogTarget := addLogTargetFlag(resumeCommand)
+	resumeOutput := addOutputFlag(resumeCommand)
+	resumeDryRun := resumeCommand.Bool("dry-run", false, "Validates, authenticates and resolves endpoints, then outputs the operation that would be executed without touching the blob")
+
+	// Status subcommand flag pointers
+	statusSubscriptionID := statusCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	statusResourceGroupName := statusCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	statusAccountName := statusCommand.String("accountname", "", "Storage Account Name")
+	statusBlobContainer := statusCommand.String("container", "", "Blob container name")
+	statusBlobName := statusCommand.String("blobname", config.BlobName(), "Blob name")
+	statusPrefix := addPrefixFlag(statusCommand)
+	statusEnvironment := statusCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	statusManagedIdentityId := statusCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	statusUseSystemManagedIdentity := statusCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	statusCustomCloudConfigFile := statusCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	statusConnection := addConnectionFlags(statusCommand)
+	statusOutput := addOutputFlag(statusCommand)
+	statusStaleAfter := statusCommand.Duration("stale-after", 0, "Flags the lease as stale when no renewal was recorded for this long (e.g. 90s), 0 uses the lease duration recorded in blob metadata")
+	statusAllowSecondary := statusCommand.Bool("allow-secondary", false, "Falls back to the secondary blob endpoint of RA-GRS/RA-GZRS accounts when the primary endpoint is unavailable, i.e. dns or network failures and server errors")
+
+	// Watch subcommand flag pointers
+	watchSubscriptionID := watchCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	watchResourceGroupName := watchCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	watchAccountName := watchCommand.String("accountname", "", "Storage Account Name")
+	watchBlobContainer := watchCommand.String("container", "", "Blob container name")
+	watchBlobName := watchCommand.String("blobname", config.BlobName(), "Blob name")
+	watchPrefix := addPrefixFlag(watchCommand)
+	watchEnvironment := watchCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	watchManagedIdentityId := watchCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	watchUseSystemManagedIdentity := watchCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	watchCustomCloudConfigFile := watchCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	watchConnection := addConnectionFlags(watchCommand)
+	watchInterval := watchCommand.Duration("interval", 10*time.Second, "Time between polls of the blob lease, transitions shorter than this may go unnoticed")
+	watchCount := watchCommand.Int("count", 0, "Number of polls before exiting, 0 watches until interrupted")
+	watchAllowSecondary := watchCommand.Bool("allow-secondary", false, "Falls back to the secondary blob endpoint of RA-GRS/RA-GZRS accounts when the primary endpoint is unavailable, i.e. dns or network failures and server errors")
+	watchOnLeaderChangeExec := watchCommand.String("on-leader-change-exec", "", "Local script run when a new leader is observed, elected or changed events, e.g. to reconfigure this node as standby or update dns, event details are passed as AZBLOBLEASE_* environment variables")
+
+	// List subcommand flag pointers
+	listSubscriptionID := listCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	listResourceGroupName := listCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	listAccountName := listCommand.String("accountname", "", "Storage Account Name")
+	listBlobContainer := listCommand.String("container", "", "Blob container name, optional when tags are informed")
+	listPrefix := listCommand.String("prefix", "", "Lists only blobs whose name starts with this prefix, e.g. the namespace informed to the other subcommands")
+	listTags := listCommand.String("tags", "", "Lists only blobs matching all these blob index tags, format is key=value,key=value")
+	listReport := listCommand.Bool("report", false, "Lists only blobs with holder metadata, with their estimated lease expiration, leases closer to expiry first, use -output table for a human readable report")
+	listEnvironment := listCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	listManagedIdentityId := listCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	listUseSystemManagedIdentity := listCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	listCustomCloudConfigFile := listCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	listConnection := addConnectionFlags(listCommand)
+	listOutput := addOutputFlag(listCommand)
+
+	// Purge subcommand flag pointers
+	purgeSubscriptionID := purgeCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	purgeResourceGroupName := purgeCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	purgeAccountName := purgeCommand.String("accountname", "", "Storage Account Name")
+	purgeBlobContainer := purgeCommand.String("container", "", "Blob container name, optional when tags are informed")
+	purgePrefix := purgeCommand.String("prefix", "", "Purges only blobs whose name starts with this prefix, e.g. the namespace informed to the other subcommands")
+	purgeTags := purgeCommand.String("tags", "", "Purges only blobs matching all these blob index tags, format is key=value,key=value")
+	purgeOlderThan := purgeCommand.Duration("older-than", 7*24*time.Hour, "Deletes blobs not leased whose lease was last acquired, or that were last modified when there is no holder metadata, longer than this ago")
+	purgeDryRun := purgeCommand.Bool("dry-run", false, "Only outputs the stale blobs that would be deleted")
+	purgeEnvironment := purgeCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	purgeManagedIdentityId := purgeCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	purgeUseSystemManagedIdentity := purgeCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	purgeCustomCloudConfigFile := purgeCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	purgeConnection := addConnectionFlags(purgeCommand)
+	purgeOutput := addOutputFlag(purgeCommand)
+
+	// Doctor subcommand flag pointers
+	doctorSubscriptionID := doctorCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	doctorResourceGroupName := doctorCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	doctorAccountName := doctorCommand.String("accountname", "", "Storage Account Name")
+	doctorBlobContainer := doctorCommand.String("container", "", "Blob container name")
+	doctorBlobName := doctorCommand.String("blobname", config.BlobName(), "Blob name")
+	doctorPrefix := addPrefixFlag(doctorCommand)
+	doctorEnvironment := doctorCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	doctorManagedIdentityId := doctorCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	doctorUseSystemManagedIdentity := doctorCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	doctorCustomCloudConfigFile := doctorCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	doctorConnection := addConnectionFlags(doctorCommand)
+	doctorOutput := addOutputFlag(doctorCommand)
+
+	// Bench subcommand flag pointers
+	benchSubscriptionID := benchCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	benchResourceGroupName := benchCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	benchAccountName := benchCommand.String("accountname", "", "Storage Account Name")
+	benchBlobContainer := benchCommand.String("container", "", "Blob container name")
+	benchBlobName := benchCommand.String("blobname", config.BlobName(), "Blob name, ideally a dedicated one since it is leased and released on every cycle")
+	benchPrefix := addPrefixFlag(benchCommand)
+	benchEnvironment := benchCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	benchManagedIdentityId := benchCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	benchUseSystemManagedIdentity := benchCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	benchCustomCloudConfigFile := benchCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	benchConnection := addConnectionFlags(benchCommand)
+	benchOutput := addOutputFlag(benchCommand)
+	benchCycles := benchCommand.Int("cycles", 20, "Number of acquire, renew and release cycles performed")
+	benchLeaseDuration := utils.NewSecondsFlag(benchCommand, "leaseduration", 15, "Lease `duration` of the leases acquired, in seconds or as a duration (e.g. 30s), valid values are between 15 and 60 seconds")
+
+	// TestAuth subcommand flag pointers
+	testAuthScope := testAuthCommand.String("scope", "", "Token scope, defaults to the storage data plane scope (e.g. https://storage.azure.com/.default)")
+	testAuthEnvironment := testAuthCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	testAuthManagedIdentityId := testAuthCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	testAuthUseSystemManagedIdentity := testAuthCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	testAuthCustomCloudConfigFile := testAuthCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	testAuthConnection := addConnectionFlags(testAuthCommand)
+	testAuthOutput := addOutputFlag(testAuthCommand)
+
+	// HealthCheck subcommand flag pointers
+	healthCheckStateFile := healthCheckCommand.String("state-file", "", "Local state file written by renew subcommand with -state-file")
+	healthCheckMaxAge := healthCheckCommand.Duration("max-age", 90*time.Second, "Maximum time since the last successful renewal for the lease to be considered healthy, ideally a bit more than waittimesec")
+	healthCheckOutput := addOutputFlag(healthCheckCommand)
+
+	// Serve subcommand flag pointers
+	serveSubscriptionID := serveCommand.String("subscriptionid", "", "Subscription where the Storage Account is located")
+	serveResourceGroupName := serveCommand.String("resourcegroupname", "", "Storage Account Resource Group Name")
+	serveAccountName := serveCommand.String("accountname", "", "Storage Account Name")
+	serveBlobContainer := serveCommand.String("container", "", "Blob container name")
+	servePrefix := addPrefixFlag(serveCommand)
+	serveListen := serveCommand.String("listen", "127.0.0.1:8080", "Address the lease api listens on, use unix:<path> to listen on a local unix socket only the current user can connect to")
+	serveLeaseDuration := utils.NewSecondsFlag(serveCommand, "leaseduration", 60, "Lease `duration` used when a request does not inform one, in seconds or as a duration (e.g. 45s), valid values are between 15 and 60 seconds")
+	serveRecordRenewals := serveCommand.Bool("record-renewals", true, "Records the time of every successful renewal in blob metadata as a heartbeat, under the lease condition, so status can tell a leader actively renewing from a wedged one and list -report when the lease expires, -record-renewals=false saves the extra request per renewal")
+	serveHolder := serveCommand.String("holder", defaultHolder(), "Identity of the lease holder recorded in blob metadata when a request does not inform one, defaults to the hostname")
+	serveEnvironment := serveCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	serveManagedIdentityId := serveCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	serveUseSystemManagedIdentity := serveCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	serveCustomCloudConfigFile := serveCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	serveConnection := addConnectionFlags(serveCommand)
+	serveStatsD := addStatsDFlags(serveCommand)
+	serveLogTarget := addLogTargetFlag(serveCommand)
+	serveARMQPS := serveCommand.Float64("arm-qps", 0, "Maximum azure resource manager requests per second, every lease operation queries the storage account unless skip-arm is set, 0 is unlimited")
+	serveOutput := addOutputFlag(serveCommand)
+
+	// Agent subcommand flag pointers
+	agentConfigFile := agentCommand.String("config", "", "Yaml (.yaml or .yml) or json file describing the leases maintained, re-read on SIGHUP")
+	agentListen := agentCommand.String("listen", "", "Address health and metrics of all leases are served on, as /healthz and /metrics, use unix:<path> for a local unix socket, not served when not informed")
+	agentHolder := agentCommand.String("holder", defaultHolder(), "Identity of the lease holder recorded in blob metadata for leases that do not inform one, defaults to the hostname")
+	agentEnvironment := agentCommand.String("environment", "AZUREPUBLICCLOUD", fmt.Sprintf("Azure cloud type, currently supported ones are: %v", config.ValidEnvironments()))
+	agentManagedIdentityId := agentCommand.String("managed-identity-id", "", "uses user managed identities (accepts resource id or client id)")
+	agentUseSystemManagedIdentity := agentCommand.Bool("use-system-managed-identity", false, "uses system managed identity")
+	agentCustomCloudConfigFile := agentCommand.String("custom-cloudconfig-file", "", "passes a custom cloud configuration to the sdk for use with non-public azure clouds, only used for CUSTOMCLOUD environment, use - to read it from stdin, when not informed AZBLOBLEASE_CLOUD_CONFIG environment variable with inline json is used")
+	agentConnection := addConnectionFlags(agentCommand)
+	agentStatsD := addStatsDFlags(agentCommand)
+	agentLogTarget := addLogTargetFlag(agentCommand)
+	agentARMQPS := agentCommand.Float64("arm-qps", 0, "Maximum azure resource manager requests per second, every lease operation queries the storage account unless skip-arm is set, 0 is unlimited")
+	agentOutput := addOutputFlag(agentCommand)
+	agentStartupJitter := addStartupJitterFlag(agentCommand)
 
-	flag.Parse()
+	// Version subcommand flag pointers
+	versionOutput := versionCommand.String("output", "", fmt.Sprintf("Output format, currently supported ones are: %v, the plain version number is printed when not informed", config.ValidOutputFormats()))
+
+	// Global output format, for scripts handling every subcommand the same way
+	output := flag.String("output", "", fmt.Sprintf("Output format of every subcommand, currently supported ones are: %v, the output flag of the subcommand takes precedence", config.ValidOutputFormats()))
+
+	// Global switches for cron jobs and ci logs
+	silent := flag.Bool("silent", false, "Discards all diagnostics written to stderr, including hook script output, only the result is written to stdout, a log-target still receives them")
+	noColor := flag.Bool("no-color", os.Getenv("NO_COLOR") != "", "Disables ansi colors, also passed to hook scripts as NO_COLOR, defaults to true when the NO_COLOR environment variable is set")
+	diagFormat := flag.String("diag-format", config.DiagFormat(), fmt.Sprintf("Format of the diagnostics written to stderr, currently supported ones are: %v, json writes one object per line with time, level, message and, e.g. for renewals, structured fields", config.ValidDiagFormats()))
+	debug := flag.Bool("debug", false, "Writes debug diagnostics to stderr, e.g. the credential type selected and the credential of the chain that authenticated")
+
+	// Global bound of the cleanup done once interrupted, e.g. by systemctl stop or a kubernetes preStop hook
+	shutdownTimeout := flag.Duration("shutdown-timeout", config.ShutdownTimeout(), "Maximum time spent releasing leases and draining api requests once interrupted or terminated, keep it below the termination grace period of the supervisor")
+
+	// Global bound of the difference between the local clock and storage, lease expiry estimates rely on the local clock
+	clockSkewThreshold := flag.Duration("clock-skew-threshold", config.ClockSkewThreshold(), "Warns, and returns clockSkewMs in the result, when the local clock differs from the Date header of storage responses by more than this duration, 0 disables the check")
+
+	// Global bound of the retries of failed requests, once exhausted the circuit opens and requests fail fast
+	retryBudget := flag.Duration("retry-budget", 0, "Maximum time spent retrying failed requests over the whole invocation, once exhausted retries are no longer attempted and the operation fails fast with a distinct exit code, 0 is unbounded, e.g. 30s")
+	retryBudgetAttempts := flag.Int("retry-budget-attempts", 0, "Maximum retries of failed requests over the whole invocation, once exhausted retries are no longer attempted and the operation fails fast with a distinct exit code, 0 is unbounded, e.g. 10")
+
+	// Global guarantee for callers piping stdout straight into a json parser
+	strictOutput := flag.Bool("strict-output", false, "Guarantees nothing but the json result is written to stdout, usage, validation errors, github actions annotations and the plain version number go to stderr, table and csv output formats are rejected")
+
+	// Operation passed as a json document instead of subcommand and flags
+	params := flag.String("params", "", "Subcommand and options as a json document, e.g. {\"subcommand\":\"acquire\",\"options\":{\"accountname\":\"mystorageaccount\"}}, use - to read it from stdin")
+	paramsFile := flag.String("params-file", "", "File with the subcommand and options as a json document, same format as params")
+
+	// Invalid flags exit with the invalid argument code, the default exit status 2 is the one of a lease held by
+	// someone else
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		exitCode = flagParseExitCode(err)
+		return
+	}
+
+	// The result logger keeps the original stdout, everything else printed to stdout, e.g. the subcommand usage
+	// on validation errors or writes of dependencies, goes to stderr instead
+	if *strictOutput {
+		config.SetStrictOutput(true)
+		os.Stdout = os.Stderr
+	}
+
+	if *params != "" || *paramsFile != "" {
+		paramsArgs, err := readParams(*params, *paramsFile)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while reading params: %v", err), config.Stderr())
+			exitCode = errorCode("ErrInvalidArgumentParams")
+			return
+		}
+		os.Args = append([]string{os.Args[0]}, paramsArgs...)
+	} else {
+		// Global flags, e.g. output, precede the subcommand
+		os.Args = append([]string{os.Args[0]}, flag.Args()...)
+	}
 
 	if len(os.Args) < 2 {
 		utils.PrintHeader(fmt.Sprintf("azbloblease - CLI tool to help on leader elections based on Azure Blob Storage blob leasing process - v%v", config.Version()))
 
-		utils.PrintUsage(createLeaseBlobCommand, acquireCommand, renewCommand, versionCommand)
+		utils.PrintUsage(createLeaseBlobCommand, acquireCommand, renewCommand, releaseCommand, resumeCommand, statusCommand, listCommand, purgeCommand, doctorCommand, benchCommand, testAuthCommand, healthCheckCommand, serveCommand, agentCommand, watchCommand, handoffCommand, semaphoreCommand, rwLockCommand, versionCommand)
+
+		exitCode = errorCode("ErrInvalidArgument")
+		return
+	}
+
+	config.SetSilent(*silent)
+	config.SetNoColor(*noColor)
+	config.SetDebug(*debug)
+
+	if _, found := utils.FindInSlice(config.ValidDiagFormats(), strings.ToLower(*diagFormat)); !found {
+		flag.PrintDefaults()
+		exitCode = errorCode("ErrInvalidArgumentDiagFormat")
+		return
+	}
+	config.SetDiagFormat(strings.ToLower(*diagFormat))
+
+	if *shutdownTimeout <= 0 {
+		flag.PrintDefaults()
+		exitCode = errorCode("ErrInvalidArgumentShutdownTimeout")
+		return
+	}
+	config.SetShutdownTimeout(*shutdownTimeout)
+
+	if *clockSkewThreshold < 0 {
+		flag.PrintDefaults()
+		exitCode = errorCode("ErrInvalidArgumentClockSkewThreshold")
+		return
+	}
+	config.SetClockSkewThreshold(*clockSkewThreshold)
 
-		exitCode = config.ErrorCode("ErrInvalidArgument")
+	if *retryBudget < 0 || *retryBudgetAttempts < 0 {
+		flag.PrintDefaults()
+		exitCode = errorCode("ErrInvalidArgumentRetryBudget")
 		return
 	}
+	config.SetRetryBudget(*retryBudget)
+	config.SetRetryBudgetAttempts(*retryBudgetAttempts)
+
+	// The global output format becomes the default of the subcommand output flags
+	if *output != "" {
+		for _, command := range []*flag.FlagSet{createLeaseBlobCommand, acquireCommand, renewCommand, releaseCommand, resumeCommand, statusCommand, listCommand, purgeCommand, doctorCommand, benchCommand, testAuthCommand, healthCheckCommand, serveCommand, agentCommand, handoffCommand, semaphoreCommand, rwLockCommand, versionCommand} {
+			command.Set("output", *output)
+		}
+	}
 
 	// Parsing flags based on subcommand
+	var parseErr error
 	switch os.Args[1] {
 	case "version":
-		versionCommand.Parse(os.Args[2:])
+		parseErr = versionCommand.Parse(os.Args[2:])
 	case "createleaseblob":
-		createLeaseBlobCommand.Parse(os.Args[2:])
+		parseErr = createLeaseBlobCommand.Parse(os.Args[2:])
 	case "acquire":
-		acquireCommand.Parse(os.Args[2:])
+		parseErr = acquireCommand.Parse(os.Args[2:])
 	case "renew":
-		renewCommand.Parse(os.Args[2:])
+		parseErr = renewCommand.Parse(os.Args[2:])
+	case "release":
+		parseErr = releaseCommand.Parse(os.Args[2:])
+	case "handoff":
+		parseErr = handoffCommand.Parse(os.Args[2:])
+	case "semaphore":
+		parseErr = semaphoreCommand.Parse(os.Args[2:])
+	case "rwlock":
+		parseErr = rwLockCommand.Parse(os.Args[2:])
+	case "resume":
+		parseErr = resumeCommand.Parse(os.Args[2:])
+	case "status":
+		parseErr = statusCommand.Parse(os.Args[2:])
+	case "list":
+		parseErr = listCommand.Parse(os.Args[2:])
+	case "purge":
+		parseErr = purgeCommand.Parse(os.Args[2:])
+	case "doctor":
+		parseErr = doctorCommand.Parse(os.Args[2:])
+	case "bench":
+		parseErr = benchCommand.Parse(os.Args[2:])
+	case "test-auth":
+		parseErr = testAuthCommand.Parse(os.Args[2:])
+	case "healthcheck":
+		parseErr = healthCheckCommand.Parse(os.Args[2:])
+	case "serve":
+		parseErr = serveCommand.Parse(os.Args[2:])
+	case "agent":
+		parseErr = agentCommand.Parse(os.Args[2:])
+	case "watch":
+		parseErr = watchCommand.Parse(os.Args[2:])
 	default:
 		flag.PrintDefaults()
-		exitCode = config.ErrorCode("ErrInvalidArgument")
+		exitCode = errorCode("ErrInvalidArgument")
+		return
+	}
+	if parseErr != nil {
+		exitCode = flagParseExitCode(parseErr)
 		return
 	}
 
@@ -132,8 +603,29 @@ func main() {
 
 	// Version subcommand execution
 	if versionCommand.Parsed() {
-		fmt.Println(config.Version())
-		exitCode = 0
+		if *versionOutput == "" && !config.StrictOutput() {
+			fmt.Println(config.Version())
+			exitCode = 0
+			return
+		}
+		if *versionOutput == "" {
+			*versionOutput = "json"
+		}
+
+		if errorName := applyOutputFormat(*versionOutput); errorName != "" {
+			fmt.Println(versionCommand.Name())
+			versionCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		// Outputs json result in stdout
+		versionResult := models.ResponseInfo{
+			Operation: to.StringPtr(versionCommand.Name()),
+			Status:    to.StringPtr(config.Success()),
+			Version:   to.StringPtr(config.Version()),
+		}
+		exitCode = outputResult(versionResult, 0)
 		return
 	}
 
@@ -148,28 +640,28 @@ func main() {
 		if *createLeaseBlobSubscriptionID == "" {
 			fmt.Println(createLeaseBlobCommand.Name())
 			createLeaseBlobCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingSubscriptionID")
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
 			return
 		}
 
 		if *createLeaseBlobResourceGroupName == "" {
 			fmt.Println(createLeaseBlobCommand.Name())
 			createLeaseBlobCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingResourceGroupName")
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
 			return
 		}
 
 		if *createLeaseBlobAccountName == "" {
 			fmt.Println(createLeaseBlobCommand.Name())
 			createLeaseBlobCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingAccountName")
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
 			return
 		}
 
 		if *createLeaseBlobBlobContainer == "" {
 			fmt.Println(createLeaseBlobCommand.Name())
 			createLeaseBlobCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingContainer")
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
 			return
 		}
 
@@ -179,7 +671,7 @@ func main() {
 			if !found {
 				fmt.Println(createLeaseBlobCommand.Name())
 				createLeaseBlobCommand.PrintDefaults()
-				exitCode = config.ErrorCode("ErrInvalidCloudType")
+				exitCode = errorCode("ErrInvalidCloudType")
 				return
 			}
 		}
@@ -187,32 +679,186 @@ func main() {
 		if strings.ToUpper(*createLeaseBlobEnvironment) != "CUSTOMCLOUD" && *createLeaseBlobCustomCloudConfigFile != "" {
 			fmt.Println(createLeaseBlobCommand.Name())
 			createLeaseBlobCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
 			return
 		}
 
 		if strings.ToUpper(*createLeaseBlobEnvironment) == "CUSTOMCLOUD" && *createLeaseBlobCustomCloudConfigFile == "" {
+			*createLeaseBlobCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*createLeaseBlobEnvironment) == "CUSTOMCLOUD" && *createLeaseBlobCustomCloudConfigFile == "" && !createLeaseBlobConnection.replacesCloudConfigFile() {
 			fmt.Println(createLeaseBlobCommand.Name())
 			createLeaseBlobCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
 			return
 		}
 
-		if strings.ToUpper(*createLeaseBlobEnvironment) == "CUSTOMCLOUD" && *createLeaseBlobCustomCloudConfigFile != "" {
+		if strings.ToUpper(*createLeaseBlobEnvironment) == "CUSTOMCLOUD" && *createLeaseBlobCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*createLeaseBlobCustomCloudConfigFile) {
 			// Checks if custom cloud config file exists
 			if _, err := os.Stat(*createLeaseBlobCustomCloudConfigFile); os.IsNotExist(err) {
 				fmt.Println(createLeaseBlobCommand.Name())
 				createLeaseBlobCommand.PrintDefaults()
-				exitCode = config.ErrorCode("ErrCloudConfigFileNotFound")
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		createLeaseBlobTagsMap, err := utils.ParseTags(*createLeaseBlobTags)
+		if err == nil {
+			err = utils.ValidateTags(createLeaseBlobTagsMap)
+		}
+		if err != nil {
+			utils.ConsoleOutput(err.Error(), config.Stderr())
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentTags")
+			return
+		}
+
+		if *createLeaseBlobSize < 0 {
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentBlobSize")
+			return
+		}
+
+		createLeaseBlobContainerMetadataMap, err := utils.ParseTags(*createLeaseBlobContainerMetadata)
+		if err != nil {
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentContainerMetadata")
+			return
+		}
+
+		// Holder information and reader keys are left to acquire, renew and rwlock, metadata keys are case insensitive
+		createLeaseBlobMetadataMap, err := utils.ParseTags(*createLeaseBlobMetadata)
+		if err == nil {
+			for key := range createLeaseBlobMetadataMap {
+				for _, reservedKey := range config.ReservedMetadataKeys() {
+					if strings.EqualFold(key, reservedKey) {
+						err = fmt.Errorf("metadata key %v records lease holder information", key)
+					}
+				}
+				for _, reservedPrefix := range config.ReservedMetadataPrefixes() {
+					if strings.HasPrefix(strings.ToLower(key), strings.ToLower(reservedPrefix)) {
+						err = fmt.Errorf("metadata key %v registers read/write lock readers", key)
+					}
+				}
+			}
+		}
+		if err != nil {
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentBlobMetadata")
+			return
+		}
+
+		if _, found := utils.FindInSlice(config.ValidBlobTypes(), strings.ToLower(*createLeaseBlobType)); !found {
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentBlobType")
+			return
+		}
+
+		// Access tiers are matched case insensitively and sent with the casing of the storage service
+		createLeaseBlobTier := ""
+		for _, tier := range config.ValidAccessTiers() {
+			if strings.EqualFold(tier, *createLeaseBlobAccessTier) {
+				createLeaseBlobTier = tier
+			}
+		}
+
+		if (*createLeaseBlobAccessTier != "" && createLeaseBlobTier == "") || (createLeaseBlobTier != "" && strings.ToLower(*createLeaseBlobType) != "block") {
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentAccessTier")
+			return
+		}
+
+		if *createLeaseBlobContentFile == "-" && *createLeaseBlobCustomCloudConfigFile == "-" {
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentContentFile")
+			return
+		}
+
+		var createLeaseBlobContent []byte
+		if *createLeaseBlobContentFile != "" {
+			createLeaseBlobContent, err = utils.ReadContent(*createLeaseBlobContentFile)
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while reading content file: %v", err), config.Stderr())
+				exitCode = errorCode("ErrInvalidArgumentContentFile")
+				return
+			}
+		}
+
+		if errorName := createLeaseBlobConnection.apply(*createLeaseBlobCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		config.SetUndeleteBlob(*createLeaseBlobUndelete)
+
+		if errorName := applyOutputFormat(*createLeaseBlobOutput); errorName != "" {
+			fmt.Println(createLeaseBlobCommand.Name())
+			createLeaseBlobCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*createLeaseBlobEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*createLeaseBlobCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
 				return
 			}
 		}
 
+		// Blob namespacing
+		*createLeaseBlobBlobBlobName = *createLeaseBlobPrefix + *createLeaseBlobBlobBlobName
+
 		// Azure authentication
 		cred, err = iam.GetTokenCredentials(*createLeaseBlobManagedIdentityId, *createLeaseBlobUseSystemManagedIdentity)
 		if err != nil {
 			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
-			exitCode = config.ErrorCode("ErrAuthentication")
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode
+		if *createLeaseBlobDryRun {
+			createLeaseBlobPlan := models.OperationPlan{
+				Operation: createLeaseBlobCommand.Name(),
+				Mode:      "single",
+				BlobSize:  to.IntPtr(*createLeaseBlobSize),
+				BlobType:  to.StringPtr(strings.ToLower(*createLeaseBlobType)),
+				Tags:      createLeaseBlobTagsMap,
+			}
+			if createLeaseBlobTier != "" {
+				createLeaseBlobPlan.AccessTier = to.StringPtr(createLeaseBlobTier)
+			}
+			if *createLeaseBlobContentType != "" {
+				createLeaseBlobPlan.ContentType = createLeaseBlobContentType
+			}
+			if len(createLeaseBlobMetadataMap) > 0 {
+				createLeaseBlobPlan.Metadata = createLeaseBlobMetadataMap
+			}
+
+			createLeaseBlobDryRunResult := subcommands.DryRun(
+				cntx,
+				strings.ToLower(*createLeaseBlobBlobContainer),
+				[]string{*createLeaseBlobBlobBlobName},
+				strings.ToUpper(*createLeaseBlobEnvironment),
+				*createLeaseBlobCustomCloudConfigFile,
+				[]models.StorageAccountRef{{SubscriptionID: *createLeaseBlobSubscriptionID, ResourceGroupName: *createLeaseBlobResourceGroupName, AccountName: *createLeaseBlobAccountName}},
+				createLeaseBlobPlan,
+				"",
+				cred,
+			)
+
+			// Outputs json result in stdout
+			createLeaseBlobDryRunResult.Operation = to.StringPtr(createLeaseBlobCommand.Name())
+			exitCode = outputResult(createLeaseBlobDryRunResult, resultExitCode(createLeaseBlobDryRunResult))
 			return
 		}
 
@@ -226,15 +872,21 @@ func main() {
 			*createLeaseBlobBlobBlobName,
 			strings.ToUpper(*createLeaseBlobEnvironment),
 			*createLeaseBlobCustomCloudConfigFile,
+			createLeaseBlobTagsMap,
+			createLeaseBlobMetadataMap,
+			*createLeaseBlobSize,
+			createLeaseBlobContent,
+			strings.ToLower(*createLeaseBlobType),
+			createLeaseBlobTier,
+			*createLeaseBlobContentType,
+			createLeaseBlobContainerMetadataMap,
+			!*createLeaseBlobNoCreateContainer,
 			cred,
 		)
 
 		// Outputs json result in stdout
 		createLeaseBlobResult.Operation = to.StringPtr(createLeaseBlobCommand.Name())
-		utils.ConsoleOutput(
-			utils.BuildResultResponse(createLeaseBlobResult),
-			config.StdoutJSON(),
-		)
+		exitCode = outputResult(createLeaseBlobResult, resultExitCode(createLeaseBlobResult))
 	}
 
 	// Acquire subcommand execution
@@ -244,49 +896,49 @@ func main() {
 		if *acquireSubscriptionID == "" {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingSubscriptionID")
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
 			return
 		}
 
 		if *acquireResourceGroupName == "" {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingResourceGroupName")
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
 			return
 		}
 
 		if *acquireAccountName == "" {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingAccountName")
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
 			return
 		}
 
 		if *acquireBlobContainer == "" {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingContainer")
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
 			return
 		}
 
 		if *acquireLeaseDuration < 15 || *acquireLeaseDuration > 60 {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentInvalidLeaseDuration")
+			exitCode = errorCode("ErrInvalidArgumentInvalidLeaseDuration")
 			return
 		}
 
 		if *acquireRetries < 1 {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentRetryCount")
+			exitCode = errorCode("ErrInvalidArgumentRetryCount")
 			return
 		}
 
-		if *acquireWaitTimeSec < 0 || *acquireWaitTimeSec > 59 {
+		if *acquireWaitTimeSec < 0 {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentWaitTimeAcquire")
+			exitCode = errorCode("ErrInvalidArgumentWaitTimeAcquire")
 			return
 		}
 
@@ -296,7 +948,7 @@ func main() {
 			if !found {
 				fmt.Println(acquireCommand.Name())
 				acquireCommand.PrintDefaults()
-				exitCode = config.ErrorCode("ErrInvalidCloudType")
+				exitCode = errorCode("ErrInvalidCloudType")
 				return
 			}
 		}
@@ -304,57 +956,331 @@ func main() {
 		if strings.ToUpper(*acquireEnvironment) != "CUSTOMCLOUD" && *acquireCustomCloudConfigFile != "" {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
 			return
 		}
 
 		if strings.ToUpper(*acquireEnvironment) == "CUSTOMCLOUD" && *acquireCustomCloudConfigFile == "" {
+			*acquireCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*acquireEnvironment) == "CUSTOMCLOUD" && *acquireCustomCloudConfigFile == "" && !acquireConnection.replacesCloudConfigFile() {
 			fmt.Println(acquireCommand.Name())
 			acquireCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
 			return
 		}
 
-		if strings.ToUpper(*acquireEnvironment) == "CUSTOMCLOUD" && *acquireCustomCloudConfigFile != "" {
+		if strings.ToUpper(*acquireEnvironment) == "CUSTOMCLOUD" && *acquireCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*acquireCustomCloudConfigFile) {
 			// Checks if custom cloud config file exists
 			if _, err := os.Stat(*acquireCustomCloudConfigFile); os.IsNotExist(err) {
 				fmt.Println(acquireCommand.Name())
 				acquireCommand.PrintDefaults()
-				exitCode = config.ErrorCode("ErrCloudConfigFileNotFound")
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		acquireShardNames, err := utils.BuildShardNames(*acquireShards, *acquireShardPrefix, *acquireShardCount)
+		if err != nil {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentShards")
+			return
+		}
+
+		acquireQuorumAccountRefs, err := utils.ParseAccountRefs(*acquireQuorumAccounts, *acquireSubscriptionID)
+		if err != nil || (len(acquireQuorumAccountRefs) > 0 && len(acquireQuorumAccountRefs) < 2) {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentQuorumAccounts")
+			return
+		}
+
+		if len(acquireBlobNames.Values()) > 1 && len(acquireQuorumAccountRefs) > 0 {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentBatch")
+			return
+		}
+
+		if *acquireMaxWait < 0 || (*acquireMaxWait > 0 && *acquireWaitTimeSec < 1) {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMaxWait")
+			return
+		}
+
+		if *acquireStateFile != "" && (len(acquireBlobNames.Values()) > 1 || len(acquireQuorumAccountRefs) > 0) {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentStateFile")
+			return
+		}
+
+		if *acquireParallelism < 1 {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentParallelism")
+			return
+		}
+
+		if *acquireSteal && (len(acquireShardNames) > 0 || len(acquireQuorumAccountRefs) > 0) {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentSteal")
+			return
+		}
+
+		if *acquirePrecheckTimeout < 0 || (*acquirePrecheckTimeout > 0 && len(acquireQuorumAccountRefs) > 0) {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentPrecheck")
+			return
+		}
+
+		if *acquireCreateIfMissing && (len(acquireBlobNames.Values()) > 1 || len(acquireShardNames) > 0 || len(acquireQuorumAccountRefs) > 0) {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentCreateIfMissing")
+			return
+		}
+
+		if errorName := acquireConnection.apply(*acquireCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := acquireStatsD.apply(); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if *acquireJitter < 0 || *acquireJitter > 50 {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentJitter")
+			return
+		}
+		config.SetJitterPercent(*acquireJitter)
+
+		if *acquirePriority < 0 || *acquirePriority > 100 {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentPriority")
+			return
+		}
+
+		if *acquireStartupJitter < 0 {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentStartupJitter")
+			return
+		}
+
+		config.SetSkipExistsCheck(*acquireSkipExistsCheck)
+		config.SetPrecheckTimeout(*acquirePrecheckTimeout)
+		config.SetDeleteOldVersions(*acquireDeleteOldVersions)
+
+		if errorName := applyOutputFormat(*acquireOutput); errorName != "" {
+			fmt.Println(acquireCommand.Name())
+			acquireCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*acquireEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*acquireCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
 				return
 			}
 		}
 
+		// Blob namespacing, the shard names as informed are kept to report which one was obtained
+		acquireBlobNames.AddPrefix(*acquirePrefix)
+		acquireLockNames := acquireShardNames
+		acquireShardNames = utils.PrefixNames(*acquirePrefix, acquireShardNames)
+
 		// Azure authentication
 		cred, err = iam.GetTokenCredentials(*acquireManagedIdentityId, *acquireUseSystemManagedIdentity)
 		if err != nil {
 			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
-			exitCode = config.ErrorCode("ErrAuthentication")
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode
+		if *acquireDryRun {
+			acquireDryRunBlobNames := acquireBlobNames.Values()
+			acquireDryRunMode := "single"
+			if len(acquireShardNames) > 0 {
+				acquireDryRunBlobNames = acquireShardNames
+				acquireDryRunMode = "shard"
+			} else if len(acquireQuorumAccountRefs) > 0 {
+				acquireDryRunMode = "quorum"
+			} else if len(acquireBlobNames.Values()) > 1 {
+				acquireDryRunMode = "batch"
+			}
+
+			acquireDryRunResult := subcommands.DryRun(
+				cntx,
+				strings.ToLower(*acquireBlobContainer),
+				acquireDryRunBlobNames,
+				strings.ToUpper(*acquireEnvironment),
+				*acquireCustomCloudConfigFile,
+				append([]models.StorageAccountRef{{SubscriptionID: *acquireSubscriptionID, ResourceGroupName: *acquireResourceGroupName, AccountName: *acquireAccountName}}, acquireQuorumAccountRefs...),
+				models.OperationPlan{
+					Operation:            acquireCommand.Name(),
+					Mode:                 acquireDryRunMode,
+					LeaseDurationSeconds: to.IntPtr(*acquireLeaseDuration),
+					Retries:              to.IntPtr(*acquireRetries),
+					WaitTimeSec:          to.IntPtr(*acquireWaitTimeSec),
+				},
+				*acquireAuditLogBlob,
+				cred,
+			)
+
+			// Outputs json result in stdout
+			acquireDryRunResult.Operation = to.StringPtr(acquireCommand.Name())
+			exitCode = outputResult(acquireDryRunResult, resultExitCode(acquireDryRunResult))
 			return
 		}
 
-		// Run acquire
-		acquireResult := subcommands.AcquireLease(
+		startupJitter(cntx, *acquireStartupJitter)
+
+		// Lower priority candidates start later, a cancelled delay is reported by the acquisition itself
+		if acquirePriorityDelay := utils.PriorityDelay(*acquirePriority); acquirePriorityDelay > 0 {
+			utils.ConsoleOutput(fmt.Sprintf("priority %v, delaying first attempt by %v", *acquirePriority, acquirePriorityDelay.Round(time.Millisecond)), config.Stderr())
+			utils.Sleep(cntx, acquirePriorityDelay)
+		}
+
+		// Run acquire in sharded mode
+		if len(acquireShardNames) > 0 {
+			acquireShardResult := subcommands.AcquireShardLease(
+				cntx,
+				*acquireSubscriptionID,
+				*acquireResourceGroupName,
+				*acquireAccountName,
+				strings.ToLower(*acquireBlobContainer),
+				acquireShardNames,
+				strings.ToUpper(*acquireEnvironment),
+				*acquireCustomCloudConfigFile,
+				*acquireHolder,
+				*acquireLeaseDuration,
+				*acquireRetries,
+				*acquireWaitTimeSec,
+				*acquireMaxWait,
+				*acquireParallelism,
+				*acquireAuditSnapshots,
+				*acquireAuditLogBlob,
+				cred,
+			)
+
+			if acquireShardResult.ShardIndex != nil {
+				acquireShardResult.LockName = to.StringPtr(acquireLockNames[*acquireShardResult.ShardIndex])
+			}
+
+			// Outputs json result in stdout
+			acquireShardResult.Operation = to.StringPtr(acquireCommand.Name())
+			exitCode = outputResult(acquireShardResult, resultExitCode(acquireShardResult))
+			writeResultState(*acquireStateFile, acquireShardResult, *acquireHolder, *acquireLeaseDuration)
+			runResultHook(cntx, *acquireOnAcquireExec, common.HookEventAcquire, acquireShardResult, *acquireHolder, *acquireLeaseDuration)
+			return
+		}
+
+		// Run acquire in quorum mode
+		if len(acquireQuorumAccountRefs) > 0 {
+			acquireQuorumResult := subcommands.AcquireQuorumLease(
+				cntx,
+				strings.ToLower(*acquireBlobContainer),
+				acquireBlobNames.Values()[0],
+				strings.ToUpper(*acquireEnvironment),
+				*acquireCustomCloudConfigFile,
+				*acquireHolder,
+				append([]models.StorageAccountRef{{SubscriptionID: *acquireSubscriptionID, ResourceGroupName: *acquireResourceGroupName, AccountName: *acquireAccountName}}, acquireQuorumAccountRefs...),
+				*acquireLeaseDuration,
+				*acquireRetries,
+				*acquireWaitTimeSec,
+				*acquireMaxWait,
+				*acquireAuditSnapshots,
+				*acquireAuditLogBlob,
+				cred,
+			)
+
+			// Outputs json result in stdout
+			acquireQuorumResult.Operation = to.StringPtr(acquireCommand.Name())
+			exitCode = outputResult(acquireQuorumResult, quorumExitCode(acquireQuorumResult))
+			runResultHook(cntx, *acquireOnAcquireExec, common.HookEventAcquire, acquireQuorumResult, *acquireHolder, *acquireLeaseDuration)
+			return
+		}
+
+		// Run acquire on several blobs
+		if len(acquireBlobNames.Values()) > 1 {
+			acquireBatchResults := subcommands.AcquireLeaseBatch(
+				cntx,
+				*acquireSubscriptionID,
+				*acquireResourceGroupName,
+				*acquireAccountName,
+				strings.ToLower(*acquireBlobContainer),
+				acquireBlobNames.Values(),
+				strings.ToUpper(*acquireEnvironment),
+				*acquireCustomCloudConfigFile,
+				*acquireHolder,
+				*acquireLeaseDuration,
+				*acquireRetries,
+				*acquireWaitTimeSec,
+				*acquireMaxWait,
+				*acquireAuditSnapshots,
+				*acquireAuditLogBlob,
+				*acquireSteal,
+				*acquireParallelism,
+				cred,
+			)
+
+			// Outputs json results with their summary in stdout
+			for i := range acquireBatchResults {
+				acquireBatchResults[i].Operation = to.StringPtr(acquireCommand.Name())
+			}
+			warnRetries()
+			exitCode = batchExitCode(acquireBatchResults)
+			utils.OutputResults(acquireBatchResults, exitCode)
+			for _, acquireBatchResult := range acquireBatchResults {
+				runResultHook(cntx, *acquireOnAcquireExec, common.HookEventAcquire, acquireBatchResult, *acquireHolder, *acquireLeaseDuration)
+			}
+			return
+		}
+
+		// Run acquire, creating the blob first when missing with create-if-missing
+		acquireLease := subcommands.AcquireLease
+		if *acquireCreateIfMissing {
+			acquireLease = subcommands.AcquireLeaseCreatingBlob
+		}
+
+		acquireResult := acquireLease(
 			cntx,
 			*acquireSubscriptionID,
 			*acquireResourceGroupName,
 			*acquireAccountName,
 			strings.ToLower(*acquireBlobContainer),
-			*acquireBlobName,
+			acquireBlobNames.Values()[0],
 			strings.ToUpper(*acquireEnvironment),
 			*acquireCustomCloudConfigFile,
+			*acquireHolder,
 			*acquireLeaseDuration,
 			*acquireRetries,
 			*acquireWaitTimeSec,
+			*acquireMaxWait,
+			*acquireAuditSnapshots,
+			*acquireAuditLogBlob,
+			*acquireSteal,
 			cred,
 		)
 
 		// Outputs json result in stdout
 		acquireResult.Operation = to.StringPtr(acquireCommand.Name())
-		utils.ConsoleOutput(
-			utils.BuildResultResponse(acquireResult),
-			config.StdoutJSON(),
-		)
+		exitCode = outputResult(acquireResult, resultExitCode(acquireResult))
+		writeResultState(*acquireStateFile, acquireResult, *acquireHolder, *acquireLeaseDuration)
+		runResultHook(cntx, *acquireOnAcquireExec, common.HookEventAcquire, acquireResult, *acquireHolder, *acquireLeaseDuration)
 	}
 
 	// Renew subcommand execution
@@ -364,49 +1290,58 @@ func main() {
 		if *renewSubscriptionID == "" {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingSubscriptionID")
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
 			return
 		}
 
 		if *renewResourceGroupName == "" {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingResourceGroupName")
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
 			return
 		}
 
 		if *renewAccountName == "" {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingAccountName")
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
 			return
 		}
 
 		if *renewBlobContainer == "" {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingContainer")
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
 			return
 		}
 
-		if *renewLeaseID == "" {
+		if len(renewLeaseIDs.Values()) == 0 || renewLeaseIDs.Values()[0] == "" {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentMissingLeaseID")
+			exitCode = errorCode("ErrInvalidArgumentMissingLeaseID")
 			return
 		}
 
+		for _, renewLeaseID := range renewLeaseIDs.Values() {
+			if _, err := uuid.Parse(renewLeaseID); err != nil {
+				fmt.Println(renewCommand.Name())
+				renewCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidArgumentLeaseIDFormat")
+				return
+			}
+		}
+
 		if *renewIterations < 1 {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentIterationsCount")
+			exitCode = errorCode("ErrInvalidArgumentIterationsCount")
 			return
 		}
 
-		if *renewWaitTimeSec < 1 || *renewWaitTimeSec > 59 {
+		if *renewWaitTimeSec < 0 {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrInvalidArgumentWaitTime")
+			exitCode = errorCode("ErrInvalidArgumentWaitTime")
 			return
 		}
 
@@ -416,7 +1351,7 @@ func main() {
 			if !found {
 				fmt.Println(renewCommand.Name())
 				renewCommand.PrintDefaults()
-				exitCode = config.ErrorCode("ErrInvalidCloudType")
+				exitCode = errorCode("ErrInvalidCloudType")
 				return
 			}
 		}
@@ -424,32 +1359,237 @@ func main() {
 		if strings.ToUpper(*renewEnvironment) != "CUSTOMCLOUD" && *renewCustomCloudConfigFile != "" {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
 			return
 		}
 
 		if strings.ToUpper(*renewEnvironment) == "CUSTOMCLOUD" && *renewCustomCloudConfigFile == "" {
+			*renewCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*renewEnvironment) == "CUSTOMCLOUD" && *renewCustomCloudConfigFile == "" && !renewConnection.replacesCloudConfigFile() {
 			fmt.Println(renewCommand.Name())
 			renewCommand.PrintDefaults()
-			exitCode = config.ErrorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
 			return
 		}
 
-		if strings.ToUpper(*renewEnvironment) == "CUSTOMCLOUD" && *renewCustomCloudConfigFile != "" {
+		if strings.ToUpper(*renewEnvironment) == "CUSTOMCLOUD" && *renewCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*renewCustomCloudConfigFile) {
 			// Checks if custom cloud config file exists
 			if _, err := os.Stat(*renewCustomCloudConfigFile); os.IsNotExist(err) {
 				fmt.Println(renewCommand.Name())
 				renewCommand.PrintDefaults()
-				exitCode = config.ErrorCode("ErrCloudConfigFileNotFound")
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
 				return
 			}
 		}
 
-		// Azure authentication
-		cred, err = iam.GetTokenCredentials(*renewManagedIdentityId, *renewUseSystemManagedIdentity)
+		renewQuorumAccountRefs, err := utils.ParseAccountRefs(*renewQuorumAccounts, *renewSubscriptionID)
+		if err != nil || (len(renewQuorumAccountRefs) > 0 && len(renewQuorumAccountRefs) < 2) {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentQuorumAccounts")
+			return
+		}
+
+		if len(renewLeaseIDs.Values()) > 1 && len(renewLeaseIDs.Values()) != len(renewBlobNames.Values()) ||
+			len(renewBlobNames.Values()) > 1 && len(renewQuorumAccountRefs) > 0 {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentBatch")
+			return
+		}
+
+		if *renewAtFraction < 0 || *renewAtFraction >= 1 || (*renewAtFraction > 0 && len(renewQuorumAccountRefs) > 0) {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRenewAtFraction")
+			return
+		}
+
+		if *renewThreshold < 0 || (*renewThreshold > 0 && (*renewAtFraction > 0 || len(renewQuorumAccountRefs) > 0)) {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRenewThreshold")
+			return
+		}
+
+		if *renewStateFile != "" && (len(renewBlobNames.Values()) > 1 || len(renewQuorumAccountRefs) > 0) {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentStateFile")
+			return
+		}
+
+		if *renewKubernetesLease != "" && (len(renewBlobNames.Values()) > 1 || len(renewQuorumAccountRefs) > 0) {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentKubernetesLease")
+			return
+		}
+
+		if (*renewOnRenewExec != "" || *renewOnLostExec != "") && (len(renewBlobNames.Values()) > 1 || len(renewQuorumAccountRefs) > 0) {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentHooks")
+			return
+		}
+
+		// Leadership state observers
+		renewObservers := []common.LeadershipObserver{}
+		if *renewStateFile != "" {
+			renewObservers = append(renewObservers, &common.StateFileObserver{Path: *renewStateFile})
+		}
+
+		if *renewKubernetesLease != "" {
+			renewKubernetesLeaseObserver, err := common.NewKubernetesLeaseObserver(*renewKubernetesLease)
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while configuring kubernetes lease mirroring: %v", err), config.Stderr())
+				exitCode = errorCode("ErrInvalidArgumentKubernetesLease")
+				return
+			}
+			renewObservers = append(renewObservers, renewKubernetesLeaseObserver)
+		}
+
+		if *renewOnRenewExec != "" || *renewOnLostExec != "" {
+			renewObservers = append(renewObservers, &common.HookObserver{OnRenew: *renewOnRenewExec, OnLost: *renewOnLostExec})
+		}
+
+		if errorName := applyLogTarget(*renewLogTarget); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := renewConnection.apply(*renewCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := renewStatsD.apply(); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if *renewJitter < 0 || *renewJitter > 50 {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentJitter")
+			return
+		}
+		config.SetJitterPercent(*renewJitter)
+		config.SetDeleteOldVersions(*renewDeleteOldVersions)
+
+		if errorName := applyOutputFormat(*renewOutput); errorName != "" {
+			fmt.Println(renewCommand.Name())
+			renewCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*renewEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*renewCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		renewBlobNames.AddPrefix(*renewPrefix)
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*renewManagedIdentityId, *renewUseSystemManagedIdentity)
 		if err != nil {
 			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
-			exitCode = config.ErrorCode("ErrAuthentication")
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode
+		if *renewDryRun {
+			renewDryRunMode := "single"
+			if len(renewQuorumAccountRefs) > 0 {
+				renewDryRunMode = "quorum"
+			} else if len(renewBlobNames.Values()) > 1 {
+				renewDryRunMode = "batch"
+			}
+
+			renewDryRunResult := subcommands.DryRun(
+				cntx,
+				strings.ToLower(*renewBlobContainer),
+				renewBlobNames.Values(),
+				strings.ToUpper(*renewEnvironment),
+				*renewCustomCloudConfigFile,
+				append([]models.StorageAccountRef{{SubscriptionID: *renewSubscriptionID, ResourceGroupName: *renewResourceGroupName, AccountName: *renewAccountName}}, renewQuorumAccountRefs...),
+				models.OperationPlan{
+					Operation:   renewCommand.Name(),
+					Mode:        renewDryRunMode,
+					LeaseIDs:    renewLeaseIDs.Values(),
+					Iterations:  to.IntPtr(*renewIterations),
+					WaitTimeSec: to.IntPtr(*renewWaitTimeSec),
+				},
+				*renewAuditLogBlob,
+				cred,
+			)
+
+			// Outputs json result in stdout
+			renewDryRunResult.Operation = to.StringPtr(renewCommand.Name())
+			exitCode = outputResult(renewDryRunResult, resultExitCode(renewDryRunResult))
+			return
+		}
+
+		// Run renew in quorum mode
+		if len(renewQuorumAccountRefs) > 0 {
+			renewQuorumResult := subcommands.RenewQuorumLease(
+				cntx,
+				strings.ToLower(*renewBlobContainer),
+				renewBlobNames.Values()[0],
+				renewLeaseIDs.Values()[0],
+				strings.ToUpper(*renewEnvironment),
+				*renewCustomCloudConfigFile,
+				append([]models.StorageAccountRef{{SubscriptionID: *renewSubscriptionID, ResourceGroupName: *renewResourceGroupName, AccountName: *renewAccountName}}, renewQuorumAccountRefs...),
+				*renewIterations,
+				*renewWaitTimeSec,
+				*renewAuditLogBlob,
+				*renewRecordRenewals,
+				cred,
+			)
+
+			// Outputs result into stdout
+			renewQuorumResult.Operation = to.StringPtr(renewCommand.Name())
+			exitCode = outputResult(renewQuorumResult, quorumExitCode(renewQuorumResult))
+			return
+		}
+
+		// Run renew on several blobs
+		if len(renewBlobNames.Values()) > 1 {
+			renewBatchResults := subcommands.RenewLeaseBatch(
+				cntx,
+				*renewSubscriptionID,
+				*renewResourceGroupName,
+				*renewAccountName,
+				strings.ToLower(*renewBlobContainer),
+				renewBlobNames.Values(),
+				renewLeaseIDs.Values(),
+				*renewHolder,
+				strings.ToUpper(*renewEnvironment),
+				*renewCustomCloudConfigFile,
+				*renewIterations,
+				*renewWaitTimeSec,
+				*renewAtFraction,
+				*renewThreshold,
+				*renewAuditLogBlob,
+				*renewRecordRenewals,
+				!*renewSkipPreflight,
+				cred,
+			)
+
+			// Outputs json results with their summary into stdout
+			for i := range renewBatchResults {
+				renewBatchResults[i].Operation = to.StringPtr(renewCommand.Name())
+			}
+			warnRetries()
+			exitCode = batchExitCode(renewBatchResults)
+			utils.OutputResults(renewBatchResults, exitCode)
 			return
 		}
 
@@ -460,20 +1600,2862 @@ func main() {
 			*renewResourceGroupName,
 			*renewAccountName,
 			strings.ToLower(*renewBlobContainer),
-			*renewBlobName,
-			*renewLeaseID,
+			renewBlobNames.Values()[0],
+			renewLeaseIDs.Values()[0],
+			*renewHolder,
 			strings.ToUpper(*renewEnvironment),
 			*renewCustomCloudConfigFile,
 			*renewIterations,
 			*renewWaitTimeSec,
+			*renewAtFraction,
+			*renewThreshold,
+			*renewAuditLogBlob,
+			*renewRecordRenewals,
+			!*renewSkipPreflight,
+			renewObservers,
 			cred,
 		)
 
 		// Outputs result into stdout
 		renewResult.Operation = to.StringPtr(renewCommand.Name())
-		utils.ConsoleOutput(
-			utils.BuildResultResponse(renewResult),
-			config.StdoutJSON(),
+		exitCode = outputResult(renewResult, resultExitCode(renewResult))
+	}
+
+	// Release subcommand execution
+	if releaseCommand.Parsed() {
+
+		// Lease identified by a state file instead of flags
+		var releaseState models.LeadershipState
+		if *releaseFromState != "" {
+			releaseState, err = subcommands.ReadLeaseState(*releaseFromState)
+			if err != nil {
+				utils.ConsoleOutput(err.Error(), config.Stderr())
+				exitCode = errorCode("ErrInvalidArgumentStateFile")
+				return
+			}
+
+			*releaseSubscriptionID = releaseState.SubscriptionID
+			*releaseResourceGroupName = releaseState.ResourceGroupName
+			*releaseAccountName = releaseState.StorageAccountName
+			*releaseBlobContainer = releaseState.ContainerName
+			*releaseBlobName = releaseState.BlobName
+			*releaseLeaseID = releaseState.LeaseID
+			*releasePrefix = ""
+		}
+
+		// Validations
+		if *releaseSubscriptionID == "" {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *releaseResourceGroupName == "" {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *releaseAccountName == "" {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *releaseBlobContainer == "" {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if *releaseLeaseID == "" {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingLeaseID")
+			return
+		}
+
+		releaseQuorumAccountRefs, err := utils.ParseAccountRefs(*releaseQuorumAccounts, *releaseSubscriptionID)
+		if err != nil || (len(releaseQuorumAccountRefs) > 0 && (len(releaseQuorumAccountRefs) < 2 || *releaseFromState != "")) {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentQuorumAccounts")
+			return
+		}
+
+		if strings.ToUpper(*releaseEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*releaseEnvironment))
+			if !found {
+				fmt.Println(releaseCommand.Name())
+				releaseCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*releaseEnvironment) != "CUSTOMCLOUD" && *releaseCustomCloudConfigFile != "" {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*releaseEnvironment) == "CUSTOMCLOUD" && *releaseCustomCloudConfigFile == "" {
+			*releaseCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*releaseEnvironment) == "CUSTOMCLOUD" && *releaseCustomCloudConfigFile == "" && !releaseConnection.replacesCloudConfigFile() {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*releaseEnvironment) == "CUSTOMCLOUD" && *releaseCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*releaseCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*releaseCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(releaseCommand.Name())
+				releaseCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := releaseConnection.apply(*releaseCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*releaseOutput); errorName != "" {
+			fmt.Println(releaseCommand.Name())
+			releaseCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*releaseEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*releaseCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		*releaseBlobName = *releasePrefix + *releaseBlobName
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*releaseManagedIdentityId, *releaseUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode
+		if *releaseDryRun {
+			releaseDryRunMode := "single"
+			if len(releaseQuorumAccountRefs) > 0 {
+				releaseDryRunMode = "quorum"
+			}
+
+			releaseDryRunResult := subcommands.DryRun(
+				cntx,
+				strings.ToLower(*releaseBlobContainer),
+				[]string{*releaseBlobName},
+				strings.ToUpper(*releaseEnvironment),
+				*releaseCustomCloudConfigFile,
+				append([]models.StorageAccountRef{{SubscriptionID: *releaseSubscriptionID, ResourceGroupName: *releaseResourceGroupName, AccountName: *releaseAccountName}}, releaseQuorumAccountRefs...),
+				models.OperationPlan{
+					Operation: releaseCommand.Name(),
+					Mode:      releaseDryRunMode,
+					LeaseIDs:  []string{*releaseLeaseID},
+				},
+				*releaseAuditLogBlob,
+				cred,
+			)
+
+			// Outputs json result in stdout
+			releaseDryRunResult.Operation = to.StringPtr(releaseCommand.Name())
+			exitCode = outputResult(releaseDryRunResult, resultExitCode(releaseDryRunResult))
+			return
+		}
+
+		// Run release in quorum mode
+		if len(releaseQuorumAccountRefs) > 0 {
+			releaseQuorumResult := subcommands.ReleaseQuorumLease(
+				cntx,
+				strings.ToLower(*releaseBlobContainer),
+				*releaseBlobName,
+				*releaseLeaseID,
+				strings.ToUpper(*releaseEnvironment),
+				*releaseCustomCloudConfigFile,
+				append([]models.StorageAccountRef{{SubscriptionID: *releaseSubscriptionID, ResourceGroupName: *releaseResourceGroupName, AccountName: *releaseAccountName}}, releaseQuorumAccountRefs...),
+				*releaseAuditLogBlob,
+				cred,
+			)
+
+			// Outputs json result in stdout
+			releaseQuorumResult.Operation = to.StringPtr(releaseCommand.Name())
+			exitCode = outputResult(releaseQuorumResult, quorumExitCode(releaseQuorumResult))
+			runResultHook(cntx, *releaseOnReleaseExec, common.HookEventRelease, releaseQuorumResult, "", 0)
+			return
+		}
+
+		// Run release
+		releaseResult := subcommands.ReleaseLease(
+			cntx,
+			*releaseSubscriptionID,
+			*releaseResourceGroupName,
+			*releaseAccountName,
+			strings.ToLower(*releaseBlobContainer),
+			*releaseBlobName,
+			*releaseLeaseID,
+			strings.ToUpper(*releaseEnvironment),
+			*releaseCustomCloudConfigFile,
+			*releaseAuditLogBlob,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		releaseResult.Operation = to.StringPtr(releaseCommand.Name())
+		exitCode = outputResult(releaseResult, resultExitCode(releaseResult))
+		runResultHook(cntx, *releaseOnReleaseExec, common.HookEventRelease, releaseResult, "", 0)
+
+		// Recording in the state file that the lease is no longer held
+		if *releaseFromState != "" && releaseResult.ErrorMessage == nil {
+			releaseState.Leader = false
+			releaseState.LeaseExpiresAt = ""
+			releaseState.ErrorMessage = "lease released"
+			err = common.WriteStateFile(*releaseFromState, releaseState)
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while writing state file %v: %v", *releaseFromState, err), config.Stderr())
+			}
+		}
+	}
+
+	// Handoff subcommand execution
+	if handoffCommand.Parsed() {
+
+		// Validations
+		if *handoffSubscriptionID == "" {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *handoffResourceGroupName == "" {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *handoffAccountName == "" {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *handoffBlobContainer == "" {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if *handoffLeaseID == "" {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingLeaseID")
+			return
+		}
+
+		if *handoffSuccessor == "" || *handoffPreferenceWindow <= 0 {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentHandoff")
+			return
+		}
+
+		if strings.ToUpper(*handoffEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*handoffEnvironment))
+			if !found {
+				fmt.Println(handoffCommand.Name())
+				handoffCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*handoffEnvironment) != "CUSTOMCLOUD" && *handoffCustomCloudConfigFile != "" {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*handoffEnvironment) == "CUSTOMCLOUD" && *handoffCustomCloudConfigFile == "" {
+			*handoffCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*handoffEnvironment) == "CUSTOMCLOUD" && *handoffCustomCloudConfigFile == "" && !handoffConnection.replacesCloudConfigFile() {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*handoffEnvironment) == "CUSTOMCLOUD" && *handoffCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*handoffCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*handoffCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(handoffCommand.Name())
+				handoffCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := handoffConnection.apply(*handoffCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*handoffOutput); errorName != "" {
+			fmt.Println(handoffCommand.Name())
+			handoffCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*handoffEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*handoffCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		*handoffBlobName = *handoffPrefix + *handoffBlobName
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*handoffManagedIdentityId, *handoffUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode
+		if *handoffDryRun {
+			handoffDryRunResult := subcommands.DryRun(
+				cntx,
+				strings.ToLower(*handoffBlobContainer),
+				[]string{*handoffBlobName},
+				strings.ToUpper(*handoffEnvironment),
+				*handoffCustomCloudConfigFile,
+				[]models.StorageAccountRef{{SubscriptionID: *handoffSubscriptionID, ResourceGroupName: *handoffResourceGroupName, AccountName: *handoffAccountName}},
+				models.OperationPlan{
+					Operation: handoffCommand.Name(),
+					Mode:      "single",
+					LeaseIDs:  []string{*handoffLeaseID},
+				},
+				"",
+				cred,
+			)
+
+			// Outputs json result in stdout
+			handoffDryRunResult.Operation = to.StringPtr(handoffCommand.Name())
+			exitCode = outputResult(handoffDryRunResult, resultExitCode(handoffDryRunResult))
+			return
+		}
+
+		// Run handoff
+		handoffResult := subcommands.Handoff(
+			cntx,
+			*handoffSubscriptionID,
+			*handoffResourceGroupName,
+			*handoffAccountName,
+			strings.ToLower(*handoffBlobContainer),
+			*handoffBlobName,
+			*handoffLeaseID,
+			*handoffSuccessor,
+			strings.ToUpper(*handoffEnvironment),
+			*handoffCustomCloudConfigFile,
+			*handoffPreferenceWindow,
+			cred,
 		)
+
+		// Outputs json result in stdout
+		handoffResult.Operation = to.StringPtr(handoffCommand.Name())
+		exitCode = outputResult(handoffResult, resultExitCode(handoffResult))
+	}
+
+	// Semaphore subcommand execution
+	if semaphoreCommand.Parsed() {
+
+		// Validations
+		if *semaphoreSubscriptionID == "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *semaphoreResourceGroupName == "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *semaphoreAccountName == "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *semaphoreBlobContainer == "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		*semaphoreAction = strings.ToLower(*semaphoreAction)
+		if _, found := utils.FindInSlice(config.ValidSemaphoreActions(), *semaphoreAction); !found {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentSemaphore")
+			return
+		}
+
+		// Slots are the blobs <name>-0 to <name>-<slots-1>
+		semaphoreSlotNames, err := utils.BuildShardNames("", *semaphoreName+"-", *semaphoreSlots)
+		if err != nil || *semaphoreName == "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentSemaphore")
+			return
+		}
+
+		if *semaphoreAction != "acquire" && (*semaphoreSlot < 0 || *semaphoreSlot >= *semaphoreSlots) {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentSemaphore")
+			return
+		}
+
+		if *semaphoreAction != "acquire" && *semaphoreLeaseID == "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingLeaseID")
+			return
+		}
+
+		if *semaphoreLeaseDuration < 15 || *semaphoreLeaseDuration > 60 {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentInvalidLeaseDuration")
+			return
+		}
+
+		if *semaphoreRetries < 1 {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRetryCount")
+			return
+		}
+
+		if *semaphoreMaxWait < 0 || (*semaphoreMaxWait > 0 && *semaphoreWaitTimeSec < 1) {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMaxWait")
+			return
+		}
+
+		if *semaphoreParallelism < 1 {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentParallelism")
+			return
+		}
+
+		if *semaphoreIterations < 1 {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentIterationsCount")
+			return
+		}
+
+		if *semaphoreWaitTimeSec < 0 {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentWaitTime")
+			return
+		}
+
+		if strings.ToUpper(*semaphoreEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*semaphoreEnvironment))
+			if !found {
+				fmt.Println(semaphoreCommand.Name())
+				semaphoreCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*semaphoreEnvironment) != "CUSTOMCLOUD" && *semaphoreCustomCloudConfigFile != "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*semaphoreEnvironment) == "CUSTOMCLOUD" && *semaphoreCustomCloudConfigFile == "" {
+			*semaphoreCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*semaphoreEnvironment) == "CUSTOMCLOUD" && *semaphoreCustomCloudConfigFile == "" && !semaphoreConnection.replacesCloudConfigFile() {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*semaphoreEnvironment) == "CUSTOMCLOUD" && *semaphoreCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*semaphoreCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*semaphoreCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(semaphoreCommand.Name())
+				semaphoreCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := semaphoreConnection.apply(*semaphoreCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*semaphoreOutput); errorName != "" {
+			fmt.Println(semaphoreCommand.Name())
+			semaphoreCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*semaphoreEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*semaphoreCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		semaphoreSlotNames = utils.PrefixNames(*semaphorePrefix, semaphoreSlotNames)
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*semaphoreManagedIdentityId, *semaphoreUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode, acquire attempts every slot while renew
+		// and release act on the slot held
+		if *semaphoreDryRun {
+			semaphoreDryRunPlan := models.OperationPlan{
+				Operation: *semaphoreAction,
+				Mode:      semaphoreCommand.Name(),
+			}
+			semaphoreDryRunBlobNames := semaphoreSlotNames
+			switch *semaphoreAction {
+			case "acquire":
+				semaphoreDryRunPlan.LeaseDurationSeconds = to.IntPtr(*semaphoreLeaseDuration)
+				semaphoreDryRunPlan.Retries = to.IntPtr(*semaphoreRetries)
+				semaphoreDryRunPlan.WaitTimeSec = to.IntPtr(*semaphoreWaitTimeSec)
+			case "renew":
+				semaphoreDryRunPlan.Iterations = to.IntPtr(*semaphoreIterations)
+				semaphoreDryRunPlan.WaitTimeSec = to.IntPtr(*semaphoreWaitTimeSec)
+				fallthrough
+			default:
+				semaphoreDryRunPlan.LeaseIDs = []string{*semaphoreLeaseID}
+				semaphoreDryRunBlobNames = []string{semaphoreSlotNames[*semaphoreSlot]}
+			}
+
+			semaphoreDryRunResult := subcommands.DryRun(
+				cntx,
+				strings.ToLower(*semaphoreBlobContainer),
+				semaphoreDryRunBlobNames,
+				strings.ToUpper(*semaphoreEnvironment),
+				*semaphoreCustomCloudConfigFile,
+				[]models.StorageAccountRef{{SubscriptionID: *semaphoreSubscriptionID, ResourceGroupName: *semaphoreResourceGroupName, AccountName: *semaphoreAccountName}},
+				semaphoreDryRunPlan,
+				"",
+				cred,
+			)
+
+			// Outputs json result in stdout
+			semaphoreDryRunResult.Operation = to.StringPtr(semaphoreCommand.Name())
+			exitCode = outputResult(semaphoreDryRunResult, resultExitCode(semaphoreDryRunResult))
+			return
+		}
+
+		// Run semaphore action
+		var semaphoreResult models.ResponseInfo
+		switch *semaphoreAction {
+		case "acquire":
+			semaphoreResult = subcommands.AcquireSemaphoreSlot(
+				cntx,
+				*semaphoreSubscriptionID,
+				*semaphoreResourceGroupName,
+				*semaphoreAccountName,
+				strings.ToLower(*semaphoreBlobContainer),
+				semaphoreSlotNames,
+				strings.ToUpper(*semaphoreEnvironment),
+				*semaphoreCustomCloudConfigFile,
+				*semaphoreHolder,
+				*semaphoreLeaseDuration,
+				*semaphoreRetries,
+				*semaphoreWaitTimeSec,
+				*semaphoreMaxWait,
+				*semaphoreParallelism,
+				cred,
+			)
+		case "renew":
+			semaphoreResult = subcommands.RenewSemaphoreSlot(
+				cntx,
+				*semaphoreSubscriptionID,
+				*semaphoreResourceGroupName,
+				*semaphoreAccountName,
+				strings.ToLower(*semaphoreBlobContainer),
+				semaphoreSlotNames,
+				*semaphoreSlot,
+				*semaphoreLeaseID,
+				strings.ToUpper(*semaphoreEnvironment),
+				*semaphoreCustomCloudConfigFile,
+				*semaphoreIterations,
+				*semaphoreWaitTimeSec,
+				*semaphoreRecordRenewals,
+				cred,
+			)
+		case "release":
+			semaphoreResult = subcommands.ReleaseSemaphoreSlot(
+				cntx,
+				*semaphoreSubscriptionID,
+				*semaphoreResourceGroupName,
+				*semaphoreAccountName,
+				strings.ToLower(*semaphoreBlobContainer),
+				semaphoreSlotNames,
+				*semaphoreSlot,
+				*semaphoreLeaseID,
+				strings.ToUpper(*semaphoreEnvironment),
+				*semaphoreCustomCloudConfigFile,
+				cred,
+			)
+		}
+
+		// Outputs json result in stdout
+		semaphoreResult.Operation = to.StringPtr(semaphoreCommand.Name())
+		exitCode = outputResult(semaphoreResult, resultExitCode(semaphoreResult))
+	}
+
+	// RWLock subcommand execution
+	if rwLockCommand.Parsed() {
+
+		// Validations
+		if *rwLockSubscriptionID == "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *rwLockResourceGroupName == "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *rwLockAccountName == "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *rwLockBlobContainer == "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		*rwLockMode = strings.ToLower(*rwLockMode)
+		*rwLockAction = strings.ToLower(*rwLockAction)
+		_, validMode := utils.FindInSlice(config.ValidRWLockModes(), *rwLockMode)
+		_, validAction := utils.FindInSlice(config.ValidRWLockActions(), *rwLockAction)
+		if !validMode || !validAction || *rwLockReadTTL <= 0 {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRWLock")
+			return
+		}
+
+		if *rwLockMode == "read" && *rwLockAction == "release" && *rwLockReaderID == "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRWLock")
+			return
+		}
+
+		if *rwLockMode == "write" && *rwLockAction == "release" && *rwLockLeaseID == "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingLeaseID")
+			return
+		}
+
+		if *rwLockLeaseDuration < 15 || *rwLockLeaseDuration > 60 {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentInvalidLeaseDuration")
+			return
+		}
+
+		if *rwLockRetries < 1 {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRetryCount")
+			return
+		}
+
+		if *rwLockWaitTimeSec < 0 {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentWaitTime")
+			return
+		}
+
+		if *rwLockMaxWait < 0 || (*rwLockMaxWait > 0 && *rwLockWaitTimeSec < 1) {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMaxWait")
+			return
+		}
+
+		if strings.ToUpper(*rwLockEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*rwLockEnvironment))
+			if !found {
+				fmt.Println(rwLockCommand.Name())
+				rwLockCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*rwLockEnvironment) != "CUSTOMCLOUD" && *rwLockCustomCloudConfigFile != "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*rwLockEnvironment) == "CUSTOMCLOUD" && *rwLockCustomCloudConfigFile == "" {
+			*rwLockCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*rwLockEnvironment) == "CUSTOMCLOUD" && *rwLockCustomCloudConfigFile == "" && !rwLockConnection.replacesCloudConfigFile() {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*rwLockEnvironment) == "CUSTOMCLOUD" && *rwLockCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*rwLockCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*rwLockCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(rwLockCommand.Name())
+				rwLockCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := rwLockConnection.apply(*rwLockCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*rwLockOutput); errorName != "" {
+			fmt.Println(rwLockCommand.Name())
+			rwLockCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*rwLockEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*rwLockCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		*rwLockBlobName = *rwLockPrefix + *rwLockBlobName
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*rwLockManagedIdentityId, *rwLockUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode, read locks are registered in the readers
+		// blob and checked against the writer blob
+		if *rwLockDryRun {
+			rwLockDryRunPlan := models.OperationPlan{
+				Operation: *rwLockAction,
+				Mode:      *rwLockMode,
+			}
+			rwLockDryRunBlobNames := []string{*rwLockBlobName}
+			if *rwLockMode == "read" {
+				rwLockDryRunBlobNames = append(rwLockDryRunBlobNames, subcommands.RWLockReadersBlobName(*rwLockBlobName))
+			}
+			if *rwLockAction == "acquire" {
+				rwLockDryRunPlan.Retries = to.IntPtr(*rwLockRetries)
+				rwLockDryRunPlan.WaitTimeSec = to.IntPtr(*rwLockWaitTimeSec)
+				if *rwLockMode == "write" {
+					rwLockDryRunPlan.LeaseDurationSeconds = to.IntPtr(*rwLockLeaseDuration)
+				}
+			} else if *rwLockMode == "write" {
+				rwLockDryRunPlan.LeaseIDs = []string{*rwLockLeaseID}
+			}
+
+			rwLockDryRunResult := subcommands.DryRun(
+				cntx,
+				strings.ToLower(*rwLockBlobContainer),
+				rwLockDryRunBlobNames,
+				strings.ToUpper(*rwLockEnvironment),
+				*rwLockCustomCloudConfigFile,
+				[]models.StorageAccountRef{{SubscriptionID: *rwLockSubscriptionID, ResourceGroupName: *rwLockResourceGroupName, AccountName: *rwLockAccountName}},
+				rwLockDryRunPlan,
+				"",
+				cred,
+			)
+
+			// Outputs json result in stdout
+			rwLockDryRunResult.Operation = to.StringPtr(rwLockCommand.Name())
+			exitCode = outputResult(rwLockDryRunResult, resultExitCode(rwLockDryRunResult))
+			return
+		}
+
+		// Run read/write lock action
+		var rwLockResult models.ResponseInfo
+		switch {
+		case *rwLockMode == "read" && *rwLockAction == "acquire":
+			rwLockResult = subcommands.AcquireReadLock(
+				cntx,
+				*rwLockSubscriptionID,
+				*rwLockResourceGroupName,
+				*rwLockAccountName,
+				strings.ToLower(*rwLockBlobContainer),
+				*rwLockBlobName,
+				strings.ToUpper(*rwLockEnvironment),
+				*rwLockCustomCloudConfigFile,
+				*rwLockReadTTL,
+				*rwLockRetries,
+				*rwLockWaitTimeSec,
+				*rwLockMaxWait,
+				cred,
+			)
+		case *rwLockMode == "read":
+			rwLockResult = subcommands.ReleaseReadLock(
+				cntx,
+				*rwLockSubscriptionID,
+				*rwLockResourceGroupName,
+				*rwLockAccountName,
+				strings.ToLower(*rwLockBlobContainer),
+				*rwLockBlobName,
+				*rwLockReaderID,
+				strings.ToUpper(*rwLockEnvironment),
+				*rwLockCustomCloudConfigFile,
+				cred,
+			)
+		case *rwLockAction == "acquire":
+			rwLockResult = subcommands.AcquireWriteLock(
+				cntx,
+				*rwLockSubscriptionID,
+				*rwLockResourceGroupName,
+				*rwLockAccountName,
+				strings.ToLower(*rwLockBlobContainer),
+				*rwLockBlobName,
+				strings.ToUpper(*rwLockEnvironment),
+				*rwLockCustomCloudConfigFile,
+				*rwLockHolder,
+				*rwLockLeaseDuration,
+				*rwLockRetries,
+				*rwLockWaitTimeSec,
+				*rwLockMaxWait,
+				cred,
+			)
+		default:
+			rwLockResult = subcommands.ReleaseLease(
+				cntx,
+				*rwLockSubscriptionID,
+				*rwLockResourceGroupName,
+				*rwLockAccountName,
+				strings.ToLower(*rwLockBlobContainer),
+				*rwLockBlobName,
+				*rwLockLeaseID,
+				strings.ToUpper(*rwLockEnvironment),
+				*rwLockCustomCloudConfigFile,
+				"",
+				cred,
+			)
+		}
+
+		// Outputs json result in stdout
+		rwLockResult.Operation = to.StringPtr(rwLockCommand.Name())
+		exitCode = outputResult(rwLockResult, resultExitCode(rwLockResult))
+	}
+
+	// Resume subcommand execution
+	if resumeCommand.Parsed() {
+
+		// Validations
+		if *resumeStateFile == "" {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentStateFile")
+			return
+		}
+
+		if *resumeIterations < 1 {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentIterationsCount")
+			return
+		}
+
+		if *resumeWaitTimeSec < 0 {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentWaitTime")
+			return
+		}
+
+		if *resumeAtFraction < 0 || *resumeAtFraction >= 1 {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRenewAtFraction")
+			return
+		}
+
+		if *resumeThreshold < 0 || (*resumeThreshold > 0 && *resumeAtFraction > 0) {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentRenewThreshold")
+			return
+		}
+
+		if strings.ToUpper(*resumeEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*resumeEnvironment))
+			if !found {
+				fmt.Println(resumeCommand.Name())
+				resumeCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*resumeEnvironment) != "CUSTOMCLOUD" && *resumeCustomCloudConfigFile != "" {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*resumeEnvironment) == "CUSTOMCLOUD" && *resumeCustomCloudConfigFile == "" {
+			*resumeCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*resumeEnvironment) == "CUSTOMCLOUD" && *resumeCustomCloudConfigFile == "" && !resumeConnection.replacesCloudConfigFile() {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*resumeEnvironment) == "CUSTOMCLOUD" && *resumeCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*resumeCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*resumeCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(resumeCommand.Name())
+				resumeCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := applyLogTarget(*resumeLogTarget); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := resumeConnection.apply(*resumeCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := resumeStatsD.apply(); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		config.SetDeleteOldVersions(*resumeDeleteOldVersions)
+
+		if errorName := applyOutputFormat(*resumeOutput); errorName != "" {
+			fmt.Println(resumeCommand.Name())
+			resumeCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*resumeEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*resumeCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*resumeManagedIdentityId, *resumeUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Outputs the operation that would be executed in dry-run mode on the lease recorded in the state file
+		if *resumeDryRun {
+			resumeState, err := subcommands.ReadLeaseState(*resumeStateFile)
+			if err != nil {
+				utils.ConsoleOutput(err.Error(), config.Stderr())
+				exitCode = errorCode("ErrInvalidArgumentStateFile")
+				return
+			}
+
+			resumeDryRunPlan := models.OperationPlan{
+				Operation:   "renew",
+				Mode:        "single",
+				LeaseIDs:    []string{resumeState.LeaseID},
+				Iterations:  to.IntPtr(*resumeIterations),
+				WaitTimeSec: to.IntPtr(*resumeWaitTimeSec),
+			}
+			if *resumeRelease {
+				resumeDryRunPlan = models.OperationPlan{
+					Operation: "release",
+					Mode:      "single",
+					LeaseIDs:  []string{resumeState.LeaseID},
+				}
+			}
+
+			resumeDryRunResult := subcommands.DryRun(
+				cntx,
+				resumeState.ContainerName,
+				[]string{resumeState.BlobName},
+				strings.ToUpper(*resumeEnvironment),
+				*resumeCustomCloudConfigFile,
+				[]models.StorageAccountRef{{SubscriptionID: resumeState.SubscriptionID, ResourceGroupName: resumeState.ResourceGroupName, AccountName: resumeState.StorageAccountName}},
+				resumeDryRunPlan,
+				"",
+				cred,
+			)
+
+			// Outputs json result in stdout
+			resumeDryRunResult.Operation = to.StringPtr(resumeCommand.Name())
+			exitCode = outputResult(resumeDryRunResult, resultExitCode(resumeDryRunResult))
+			return
+		}
+
+		// Run resume, the state file keeps being updated while renewing
+		resumeResult := subcommands.ResumeLease(
+			cntx,
+			*resumeStateFile,
+			strings.ToUpper(*resumeEnvironment),
+			*resumeCustomCloudConfigFile,
+			*resumeRelease,
+			*resumeIterations,
+			*resumeWaitTimeSec,
+			*resumeAtFraction,
+			*resumeThreshold,
+			*resumeRecordRenewals,
+			[]common.LeadershipObserver{&common.StateFileObserver{Path: *resumeStateFile}},
+			cred,
+		)
+
+		// Outputs json result in stdout
+		resumeResult.Operation = to.StringPtr(resumeCommand.Name())
+		exitCode = outputResult(resumeResult, resultExitCode(resumeResult))
+	}
+
+	// Status subcommand execution
+	if statusCommand.Parsed() {
+
+		// Validations
+		if *statusSubscriptionID == "" {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *statusResourceGroupName == "" {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *statusAccountName == "" {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *statusBlobContainer == "" {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if *statusStaleAfter < 0 {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentStaleAfter")
+			return
+		}
+
+		if strings.ToUpper(*statusEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*statusEnvironment))
+			if !found {
+				fmt.Println(statusCommand.Name())
+				statusCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*statusEnvironment) != "CUSTOMCLOUD" && *statusCustomCloudConfigFile != "" {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*statusEnvironment) == "CUSTOMCLOUD" && *statusCustomCloudConfigFile == "" {
+			*statusCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*statusEnvironment) == "CUSTOMCLOUD" && *statusCustomCloudConfigFile == "" && !statusConnection.replacesCloudConfigFile() {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*statusEnvironment) == "CUSTOMCLOUD" && *statusCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*statusCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*statusCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(statusCommand.Name())
+				statusCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := statusConnection.apply(*statusCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*statusOutput); errorName != "" {
+			fmt.Println(statusCommand.Name())
+			statusCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*statusEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*statusCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		*statusBlobName = *statusPrefix + *statusBlobName
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*statusManagedIdentityId, *statusUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Run status
+		statusResult := subcommands.LeaseStatus(
+			cntx,
+			*statusSubscriptionID,
+			*statusResourceGroupName,
+			*statusAccountName,
+			strings.ToLower(*statusBlobContainer),
+			*statusBlobName,
+			strings.ToUpper(*statusEnvironment),
+			*statusCustomCloudConfigFile,
+			*statusAllowSecondary,
+			*statusStaleAfter,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		statusResult.Operation = to.StringPtr(statusCommand.Name())
+		exitCode = outputResult(statusResult, resultExitCode(statusResult))
+	}
+
+	// Watch subcommand execution
+	if watchCommand.Parsed() {
+
+		// Validations
+		if *watchSubscriptionID == "" {
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *watchResourceGroupName == "" {
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *watchAccountName == "" {
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *watchBlobContainer == "" {
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		// Events are written as json lines, other output formats cannot apply to them
+		if *output != "" && strings.ToLower(*output) != "json" {
+			utils.ConsoleOutput(fmt.Sprintf("watch only writes json lines, output format %v is not supported", *output), config.Stderr())
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentOutput")
+			return
+		}
+
+		if *watchInterval <= 0 || *watchCount < 0 {
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentWatchInterval")
+			return
+		}
+
+		if strings.ToUpper(*watchEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*watchEnvironment))
+			if !found {
+				fmt.Println(watchCommand.Name())
+				watchCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*watchEnvironment) != "CUSTOMCLOUD" && *watchCustomCloudConfigFile != "" {
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*watchEnvironment) == "CUSTOMCLOUD" && *watchCustomCloudConfigFile == "" {
+			*watchCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*watchEnvironment) == "CUSTOMCLOUD" && *watchCustomCloudConfigFile == "" && !watchConnection.replacesCloudConfigFile() {
+			fmt.Println(watchCommand.Name())
+			watchCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*watchEnvironment) == "CUSTOMCLOUD" && *watchCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*watchCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*watchCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(watchCommand.Name())
+				watchCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := watchConnection.apply(*watchCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*watchEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*watchCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		*watchBlobName = *watchPrefix + *watchBlobName
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*watchManagedIdentityId, *watchUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Run watch, events are written to stdout as they are observed
+		watchResult := subcommands.Watch(
+			cntx,
+			*watchSubscriptionID,
+			*watchResourceGroupName,
+			*watchAccountName,
+			strings.ToLower(*watchBlobContainer),
+			*watchBlobName,
+			strings.ToUpper(*watchEnvironment),
+			*watchCustomCloudConfigFile,
+			*watchAllowSecondary,
+			*watchInterval,
+			*watchCount,
+			func(event models.LeadershipEvent) {
+				utils.OutputEvent(event)
+				runLeaderChangeHook(cntx, *watchOnLeaderChangeExec, event)
+			},
+			cred,
+		)
+
+		// Failures are output as a json result once watching ends, stdout otherwise carries one event per line
+		if *watchResult.Status != config.Success() {
+			watchResult.Operation = to.StringPtr(watchCommand.Name())
+			exitCode = outputResult(watchResult, resultExitCode(watchResult))
+		}
+	}
+
+	// List subcommand execution
+	if listCommand.Parsed() {
+
+		// Validations
+		if *listSubscriptionID == "" {
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *listResourceGroupName == "" {
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *listAccountName == "" {
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		listTagsMap, err := utils.ParseTags(*listTags)
+		if err == nil {
+			err = utils.ValidateTags(listTagsMap)
+		}
+		if err != nil {
+			utils.ConsoleOutput(err.Error(), config.Stderr())
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentTags")
+			return
+		}
+
+		if *listBlobContainer == "" && len(listTagsMap) == 0 {
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if strings.ToUpper(*listEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*listEnvironment))
+			if !found {
+				fmt.Println(listCommand.Name())
+				listCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*listEnvironment) != "CUSTOMCLOUD" && *listCustomCloudConfigFile != "" {
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*listEnvironment) == "CUSTOMCLOUD" && *listCustomCloudConfigFile == "" {
+			*listCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*listEnvironment) == "CUSTOMCLOUD" && *listCustomCloudConfigFile == "" && !listConnection.replacesCloudConfigFile() {
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*listEnvironment) == "CUSTOMCLOUD" && *listCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*listCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*listCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(listCommand.Name())
+				listCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := listConnection.apply(*listCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*listOutput); errorName != "" {
+			fmt.Println(listCommand.Name())
+			listCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*listEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*listCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*listManagedIdentityId, *listUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Run list
+		listResult := subcommands.ListLeaseBlobs(
+			cntx,
+			*listSubscriptionID,
+			*listResourceGroupName,
+			*listAccountName,
+			strings.ToLower(*listBlobContainer),
+			*listPrefix,
+			strings.ToUpper(*listEnvironment),
+			*listCustomCloudConfigFile,
+			listTagsMap,
+			*listReport,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		listResult.Operation = to.StringPtr(listCommand.Name())
+		exitCode = outputResult(listResult, resultExitCode(listResult))
+	}
+
+	// Purge subcommand execution
+	if purgeCommand.Parsed() {
+
+		// Validations
+		if *purgeSubscriptionID == "" {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *purgeResourceGroupName == "" {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *purgeAccountName == "" {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		purgeTagsMap, err := utils.ParseTags(*purgeTags)
+		if err == nil {
+			err = utils.ValidateTags(purgeTagsMap)
+		}
+		if err != nil {
+			utils.ConsoleOutput(err.Error(), config.Stderr())
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentTags")
+			return
+		}
+
+		if *purgeOlderThan <= 0 {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentOlderThan")
+			return
+		}
+
+		if *purgeBlobContainer == "" && len(purgeTagsMap) == 0 {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if strings.ToUpper(*purgeEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*purgeEnvironment))
+			if !found {
+				fmt.Println(purgeCommand.Name())
+				purgeCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*purgeEnvironment) != "CUSTOMCLOUD" && *purgeCustomCloudConfigFile != "" {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*purgeEnvironment) == "CUSTOMCLOUD" && *purgeCustomCloudConfigFile == "" {
+			*purgeCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*purgeEnvironment) == "CUSTOMCLOUD" && *purgeCustomCloudConfigFile == "" && !purgeConnection.replacesCloudConfigFile() {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*purgeEnvironment) == "CUSTOMCLOUD" && *purgeCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*purgeCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*purgeCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(purgeCommand.Name())
+				purgeCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := purgeConnection.apply(*purgeCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*purgeOutput); errorName != "" {
+			fmt.Println(purgeCommand.Name())
+			purgeCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*purgeEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*purgeCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*purgeManagedIdentityId, *purgeUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Run purge
+		purgeResult := subcommands.PurgeLeaseBlobs(
+			cntx,
+			*purgeSubscriptionID,
+			*purgeResourceGroupName,
+			*purgeAccountName,
+			strings.ToLower(*purgeBlobContainer),
+			*purgePrefix,
+			strings.ToUpper(*purgeEnvironment),
+			*purgeCustomCloudConfigFile,
+			purgeTagsMap,
+			*purgeOlderThan,
+			*purgeDryRun,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		purgeResult.Operation = to.StringPtr(purgeCommand.Name())
+		exitCode = outputResult(purgeResult, resultExitCode(purgeResult))
+	}
+
+	// Doctor subcommand execution
+	if doctorCommand.Parsed() {
+
+		// Validations
+		if *doctorSubscriptionID == "" {
+			fmt.Println(doctorCommand.Name())
+			doctorCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *doctorResourceGroupName == "" {
+			fmt.Println(doctorCommand.Name())
+			doctorCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *doctorAccountName == "" {
+			fmt.Println(doctorCommand.Name())
+			doctorCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *doctorBlobContainer == "" {
+			fmt.Println(doctorCommand.Name())
+			doctorCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if strings.ToUpper(*doctorEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*doctorEnvironment))
+			if !found {
+				fmt.Println(doctorCommand.Name())
+				doctorCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*doctorEnvironment) != "CUSTOMCLOUD" && *doctorCustomCloudConfigFile != "" {
+			fmt.Println(doctorCommand.Name())
+			doctorCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*doctorEnvironment) == "CUSTOMCLOUD" && *doctorCustomCloudConfigFile == "" {
+			*doctorCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*doctorEnvironment) == "CUSTOMCLOUD" && *doctorCustomCloudConfigFile == "" && !doctorConnection.replacesCloudConfigFile() {
+			fmt.Println(doctorCommand.Name())
+			doctorCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*doctorEnvironment) == "CUSTOMCLOUD" && *doctorCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*doctorCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*doctorCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(doctorCommand.Name())
+				doctorCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := doctorConnection.apply(*doctorCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*doctorOutput); errorName != "" {
+			fmt.Println(doctorCommand.Name())
+			doctorCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*doctorEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*doctorCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		*doctorBlobName = *doctorPrefix + *doctorBlobName
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*doctorManagedIdentityId, *doctorUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Run doctor
+		doctorResult := subcommands.Doctor(
+			cntx,
+			*doctorSubscriptionID,
+			*doctorResourceGroupName,
+			*doctorAccountName,
+			strings.ToLower(*doctorBlobContainer),
+			*doctorBlobName,
+			strings.ToUpper(*doctorEnvironment),
+			*doctorCustomCloudConfigFile,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		doctorResult.Operation = to.StringPtr(doctorCommand.Name())
+		exitCode = outputResult(doctorResult, resultExitCode(doctorResult))
+	}
+
+	// Bench subcommand execution
+	if benchCommand.Parsed() {
+
+		// Validations
+		if *benchSubscriptionID == "" {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *benchResourceGroupName == "" {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *benchAccountName == "" {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *benchBlobContainer == "" {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if *benchCycles < 1 {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentCycles")
+			return
+		}
+
+		if *benchLeaseDuration < 15 || *benchLeaseDuration > 60 {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentInvalidLeaseDuration")
+			return
+		}
+
+		if strings.ToUpper(*benchEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*benchEnvironment))
+			if !found {
+				fmt.Println(benchCommand.Name())
+				benchCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*benchEnvironment) != "CUSTOMCLOUD" && *benchCustomCloudConfigFile != "" {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*benchEnvironment) == "CUSTOMCLOUD" && *benchCustomCloudConfigFile == "" {
+			*benchCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*benchEnvironment) == "CUSTOMCLOUD" && *benchCustomCloudConfigFile == "" && !benchConnection.replacesCloudConfigFile() {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*benchEnvironment) == "CUSTOMCLOUD" && *benchCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*benchCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*benchCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(benchCommand.Name())
+				benchCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := benchConnection.apply(*benchCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*benchOutput); errorName != "" {
+			fmt.Println(benchCommand.Name())
+			benchCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*benchEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*benchCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Blob namespacing
+		*benchBlobName = *benchPrefix + *benchBlobName
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*benchManagedIdentityId, *benchUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Run bench
+		benchResult := subcommands.Benchmark(
+			cntx,
+			*benchSubscriptionID,
+			*benchResourceGroupName,
+			*benchAccountName,
+			strings.ToLower(*benchBlobContainer),
+			*benchBlobName,
+			strings.ToUpper(*benchEnvironment),
+			*benchCustomCloudConfigFile,
+			*benchCycles,
+			*benchLeaseDuration,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		benchResult.Operation = to.StringPtr(benchCommand.Name())
+		exitCode = outputResult(benchResult, resultExitCode(benchResult))
+	}
+
+	// TestAuth subcommand execution
+	if testAuthCommand.Parsed() {
+
+		// Validations
+		if strings.ToUpper(*testAuthEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*testAuthEnvironment))
+			if !found {
+				fmt.Println(testAuthCommand.Name())
+				testAuthCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*testAuthEnvironment) != "CUSTOMCLOUD" && *testAuthCustomCloudConfigFile != "" {
+			fmt.Println(testAuthCommand.Name())
+			testAuthCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*testAuthEnvironment) == "CUSTOMCLOUD" && *testAuthCustomCloudConfigFile == "" {
+			*testAuthCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*testAuthEnvironment) == "CUSTOMCLOUD" && *testAuthCustomCloudConfigFile == "" && !testAuthConnection.replacesCloudConfigFile() {
+			fmt.Println(testAuthCommand.Name())
+			testAuthCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*testAuthEnvironment) == "CUSTOMCLOUD" && *testAuthCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*testAuthCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*testAuthCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(testAuthCommand.Name())
+				testAuthCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := testAuthConnection.apply(*testAuthCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := applyOutputFormat(*testAuthOutput); errorName != "" {
+			fmt.Println(testAuthCommand.Name())
+			testAuthCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*testAuthEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*testAuthCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*testAuthManagedIdentityId, *testAuthUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Run test-auth
+		testAuthResult := subcommands.TestAuth(
+			cntx,
+			*testAuthScope,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		testAuthResult.Operation = to.StringPtr(testAuthCommand.Name())
+		testAuthExitCode := 0
+		if *testAuthResult.Status != config.Success() {
+			testAuthExitCode = errorCode("ErrAuthentication")
+		}
+		exitCode = outputResult(testAuthResult, testAuthExitCode)
+	}
+
+	// HealthCheck subcommand execution
+	if healthCheckCommand.Parsed() {
+
+		// Validations
+		if *healthCheckStateFile == "" {
+			fmt.Println(healthCheckCommand.Name())
+			healthCheckCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentStateFile")
+			return
+		}
+
+		if errorName := applyOutputFormat(*healthCheckOutput); errorName != "" {
+			fmt.Println(healthCheckCommand.Name())
+			healthCheckCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		// Run healthcheck
+		healthCheckResult := subcommands.HealthCheck(
+			*healthCheckStateFile,
+			*healthCheckMaxAge,
+		)
+
+		// Outputs json result in stdout
+		healthCheckResult.Operation = to.StringPtr(healthCheckCommand.Name())
+		healthCheckExitCode := 0
+		if *healthCheckResult.Status != config.Success() {
+			healthCheckExitCode = errorCode("ErrUnhealthy")
+		}
+		exitCode = outputResult(healthCheckResult, healthCheckExitCode)
+	}
+
+	// Serve subcommand execution
+	if serveCommand.Parsed() {
+
+		// Validations
+		if *serveSubscriptionID == "" {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingSubscriptionID")
+			return
+		}
+
+		if *serveResourceGroupName == "" {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingResourceGroupName")
+			return
+		}
+
+		if *serveAccountName == "" {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingAccountName")
+			return
+		}
+
+		if *serveBlobContainer == "" {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentMissingContainer")
+			return
+		}
+
+		if *serveLeaseDuration < 15 || *serveLeaseDuration > 60 {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentInvalidLeaseDuration")
+			return
+		}
+
+		// The api key is read from the environment so it does not show up in the process list
+		serveAPIKey := os.Getenv(config.APIKeyEnvVar())
+		if serveAPIKey == "" && !subcommands.IsUnixSocket(*serveListen) {
+			utils.ConsoleOutput(fmt.Sprintf("%v environment variable is required to listen on %v, use a unix socket to go without an api key", config.APIKeyEnvVar(), *serveListen), config.Stderr())
+			exitCode = errorCode("ErrInvalidArgumentAPIKey")
+			return
+		}
+
+		if strings.ToUpper(*serveEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*serveEnvironment))
+			if !found {
+				fmt.Println(serveCommand.Name())
+				serveCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*serveEnvironment) != "CUSTOMCLOUD" && *serveCustomCloudConfigFile != "" {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*serveEnvironment) == "CUSTOMCLOUD" && *serveCustomCloudConfigFile == "" {
+			*serveCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*serveEnvironment) == "CUSTOMCLOUD" && *serveCustomCloudConfigFile == "" && !serveConnection.replacesCloudConfigFile() {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*serveEnvironment) == "CUSTOMCLOUD" && *serveCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*serveCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*serveCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(serveCommand.Name())
+				serveCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := applyLogTarget(*serveLogTarget); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := serveConnection.apply(*serveCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := serveStatsD.apply(); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if *serveARMQPS < 0 {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentARMQPS")
+			return
+		}
+		common.ConfigureARMRateLimit(*serveARMQPS)
+
+		// Long running subcommands query azure resource manager on every operation
+		if !config.SkipARM() {
+			utils.ConsoleOutput("every lease operation queries azure resource manager for the blob endpoint, use skip-arm to avoid subscription level throttling", config.Stderr())
+		}
+
+		if errorName := applyOutputFormat(*serveOutput); errorName != "" {
+			fmt.Println(serveCommand.Name())
+			serveCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*serveEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*serveCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*serveManagedIdentityId, *serveUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Serve lease api until interrupted
+		serveResult := subcommands.Serve(
+			cntx,
+			subcommands.LeaseServer{
+				SubscriptionID:    *serveSubscriptionID,
+				ResourceGroupName: *serveResourceGroupName,
+				AccountName:       *serveAccountName,
+				Container:         strings.ToLower(*serveBlobContainer),
+				Prefix:            *servePrefix,
+				Environment:       strings.ToUpper(*serveEnvironment),
+				CloudConfigFile:   *serveCustomCloudConfigFile,
+				Holder:            *serveHolder,
+				LeaseDuration:     *serveLeaseDuration,
+				RecordRenewals:    *serveRecordRenewals,
+				APIKey:            serveAPIKey,
+				Credential:        cred,
+			},
+			*serveListen,
+		)
+
+		// Outputs json result in stdout
+		serveResult.Operation = to.StringPtr(serveCommand.Name())
+		exitCode = outputResult(serveResult, resultExitCode(serveResult))
+	}
+
+	// Agent subcommand execution
+	if agentCommand.Parsed() {
+
+		// Validations
+		if *agentConfigFile == "" {
+			fmt.Println(agentCommand.Name())
+			agentCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentAgentConfig")
+			return
+		}
+
+		agentLeases, err := subcommands.ReadAgentConfig(*agentConfigFile, *agentHolder)
+		if err != nil {
+			utils.ConsoleOutput(err.Error(), config.Stderr())
+			exitCode = errorCode("ErrInvalidArgumentAgentConfig")
+			return
+		}
+
+		if strings.ToUpper(*agentEnvironment) != "AZUREPUBLICCLOUD" {
+			// Checks if valid cloud environment was passed
+			_, found := utils.FindInSlice(config.ValidEnvironments(), strings.ToUpper(*agentEnvironment))
+			if !found {
+				fmt.Println(agentCommand.Name())
+				agentCommand.PrintDefaults()
+				exitCode = errorCode("ErrInvalidCloudType")
+				return
+			}
+		}
+
+		if strings.ToUpper(*agentEnvironment) != "CUSTOMCLOUD" && *agentCustomCloudConfigFile != "" {
+			fmt.Println(agentCommand.Name())
+			agentCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileOnlyForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*agentEnvironment) == "CUSTOMCLOUD" && *agentCustomCloudConfigFile == "" {
+			*agentCustomCloudConfigFile = cloudConfigFromEnvironment()
+		}
+
+		if strings.ToUpper(*agentEnvironment) == "CUSTOMCLOUD" && *agentCustomCloudConfigFile == "" && !agentConnection.replacesCloudConfigFile() {
+			fmt.Println(agentCommand.Name())
+			agentCommand.PrintDefaults()
+			exitCode = errorCode("ErrCloudConfigFileRequiredForCustomCloud")
+			return
+		}
+
+		if strings.ToUpper(*agentEnvironment) == "CUSTOMCLOUD" && *agentCustomCloudConfigFile != "" && !utils.IsCloudConfigStream(*agentCustomCloudConfigFile) {
+			// Checks if custom cloud config file exists
+			if _, err := os.Stat(*agentCustomCloudConfigFile); os.IsNotExist(err) {
+				fmt.Println(agentCommand.Name())
+				agentCommand.PrintDefaults()
+				exitCode = errorCode("ErrCloudConfigFileNotFound")
+				return
+			}
+		}
+
+		if errorName := applyLogTarget(*agentLogTarget); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := agentConnection.apply(*agentCustomCloudConfigFile); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if errorName := agentStatsD.apply(); errorName != "" {
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if *agentARMQPS < 0 {
+			fmt.Println(agentCommand.Name())
+			agentCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentARMQPS")
+			return
+		}
+		common.ConfigureARMRateLimit(*agentARMQPS)
+
+		if *agentStartupJitter < 0 {
+			fmt.Println(agentCommand.Name())
+			agentCommand.PrintDefaults()
+			exitCode = errorCode("ErrInvalidArgumentStartupJitter")
+			return
+		}
+
+		// Long running subcommands query azure resource manager on every operation
+		if !config.SkipARM() {
+			utils.ConsoleOutput("every lease operation queries azure resource manager for the blob endpoint, use skip-arm to avoid subscription level throttling", config.Stderr())
+		}
+
+		if errorName := applyOutputFormat(*agentOutput); errorName != "" {
+			fmt.Println(agentCommand.Name())
+			agentCommand.PrintDefaults()
+			exitCode = errorCode(errorName)
+			return
+		}
+
+		if strings.ToUpper(*agentEnvironment) == "CUSTOMCLOUD" {
+			if errorName := loadCloudConfig(*agentCustomCloudConfigFile); errorName != "" {
+				exitCode = errorCode(errorName)
+				return
+			}
+		}
+
+		// Azure authentication
+		cred, err = iam.GetTokenCredentials(*agentManagedIdentityId, *agentUseSystemManagedIdentity)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token credential: %v", err), config.Stderr())
+			exitCode = errorCode("ErrAuthentication")
+			return
+		}
+
+		// Configuration is reloaded on hangup
+		agentReload := make(chan os.Signal, 1)
+		signal.Notify(agentReload, syscall.SIGHUP)
+		defer signal.Stop(agentReload)
+
+		startupJitter(cntx, *agentStartupJitter)
+
+		// Run agent until interrupted
+		agentResult := subcommands.RunAgent(
+			cntx,
+			*agentConfigFile,
+			*agentHolder,
+			agentLeases,
+			strings.ToUpper(*agentEnvironment),
+			*agentCustomCloudConfigFile,
+			*agentListen,
+			agentReload,
+			cred,
+		)
+
+		// Outputs json result in stdout
+		agentResult.Operation = to.StringPtr(agentCommand.Name())
+		exitCode = outputResult(agentResult, resultExitCode(agentResult))
+	}
+}
+
+// connectionFlags holds the flags, shared by all subcommands, that control how Azure endpoints are reached
+type connectionFlags struct {
+	skipARM               *bool
+	storageEndpointSuffix *string
+	authorityHost         *string
+	adfs                  *bool
+	storageAudience       *string
+	caBundle              *string
+	insecureSkipVerify    *bool
+	maxRetries            *int
+	retryDelay            *time.Duration
+	maxRetryDelay         *time.Duration
+	connectTimeout        *time.Duration
+	responseHeaderTimeout *time.Duration
+	userAgentSuffix       *string
+	correlationID         *string
+	imdsProbeTimeout      *time.Duration
+	imdsProbeRetries      *int
+	disableIMDSProbe      *bool
+	tenantID              *string
+	clientID              *string
+	tokenExchangeAudience *string
+	federatedTokenFile    *string
+	tokenCache            *string
+	blobHost              *string
+	privateEndpoint       *bool
+	privateEndpointRanges *string
+}
+
+// addConnectionFlags defines the connection flags on a subcommand
+func addConnectionFlags(command *flag.FlagSet) *connectionFlags {
+	return &connectionFlags{
+		skipARM:               command.Bool("skip-arm", false, "builds the blob endpoint from the account name and the cloud storage endpoint suffix instead of querying azure resource manager, removing the need for reader access on the storage account"),
+		storageEndpointSuffix: command.String("storage-endpoint-suffix", "", "storage endpoint suffix (e.g. core.windows.net), overrides the cloud one, together with authority-host replaces the custom cloud config file"),
+		authorityHost:         command.String("authority-host", "", "azure active directory authority host (e.g. https://login.microsoftonline.com/) used to obtain tokens, together with storage-endpoint-suffix replaces the custom cloud config file"),
+		adfs:                  command.Bool("adfs", false, "authenticates against active directory federation services of azure stack hub deployments, the authority is endpoints.activeDirectory of the custom cloud config (or authority-host) without tenant"),
+		storageAudience:       command.String("storage-audience", "", "token audience of the storage data plane, with adfs it defaults to endpoints.activeDirectoryResourceId of the custom cloud config"),
+		caBundle:              command.String("ca-bundle", "", "pem file with additional certificate authorities to trust, for tls intercepting proxies and custom clouds with private certificate authorities"),
+		insecureSkipVerify:    command.Bool("insecure-skip-verify", false, "disables tls certificate verification, for lab use only"),
+		maxRetries:            command.Int("max-retries", 0, "maximum retries of failed azure requests, 0 uses the sdk default (3) and -1 disables retries"),
+		retryDelay:            command.Duration("retry-delay", 0, "initial delay between retries of failed azure requests (e.g. 500ms), 0 uses the sdk default (4s)"),
+		maxRetryDelay:         command.Duration("max-retry-delay", 0, "maximum delay between retries of failed azure requests (e.g. 30s), 0 uses the sdk default (60s)"),
+		connectTimeout:        command.Duration("connect-timeout", 0, "maximum time to establish a connection, including tls handshake (e.g. 5s), 0 uses the default (30s)"),
+		responseHeaderTimeout: command.Duration("response-header-timeout", 0, "maximum time to wait for response headers after sending a request (e.g. 10s), 0 waits indefinitely"),
+		userAgentSuffix:       command.String("user-agent-suffix", "", "text appended to the user agent of all azure requests, e.g. the workload name, to identify callers in storage analytics logs"),
+		correlationID:         command.String("correlation-id", "", "id sent as x-ms-client-request-id on every azure resource manager and storage request and echoed in the json output, to trace the operation in azure diagnostic logs, defaults to a generated guid"),
+		imdsProbeTimeout:      command.Duration("imds-probe-timeout", 0, "maximum time to wait for the managed identity endpoint probe of the default credential chain (e.g. 300ms), 0 uses the sdk default (1s)"),
+		imdsProbeRetries:      command.Int("imds-probe-retries", 0, "additional managed identity endpoint probe attempts before the default credential chain moves on to azure cli credentials"),
+		disableIMDSProbe:      command.Bool("disable-imds-probe", false, "leaves managed identity out of the default credential chain, avoiding the probe delay on machines without the instance metadata service"),
+		tenantID:              command.String("tenant-id", "", "tenant tokens are requested from, e.g. the customer tenant owning the storage account, defaults to the tenant of the credential"),
+		clientID:              command.String("client-id", "", "multi-tenant application, with a federated credential trusting the current identity, tokens of tenant-id are requested for using a token of the current identity as client assertion"),
+		tokenExchangeAudience: command.String("token-exchange-audience", config.TokenExchangeAudience(), "audience of the current identity token used as client assertion with client-id, e.g. api://AzureADTokenExchangeUSGov on azure us government"),
+		federatedTokenFile:    command.String("federated-token-file", "", "file holding an oidc token of an external identity provider (e.g. github actions) trusted by a federated credential of client-id, used instead of managed identity and the default credential chain, requires client-id and tenant-id"),
+		tokenCache:            command.String("token-cache", "", "file access tokens are shared through across invocations, encrypted with the passphrase of the "+config.TokenCacheKeyEnvVar()+" environment variable, so frequent invocations don't request new tokens"),
+		blobHost:              command.String("blob-host", "", "host used instead of the one of the blob endpoint (e.g. mystorageaccount.privatelink.blob.core.windows.net), for storage accounts behind private endpoints on networks whose dns does not resolve the public name to the private address"),
+		privateEndpoint:       command.Bool("private-endpoint", false, "validates that the blob host resolves to a private address before any storage request, failing with a distinct exit code when public dns is used by mistake"),
+		privateEndpointRanges: command.String("private-endpoint-ranges", config.DefaultPrivateEndpointRanges(), "comma separated address ranges, in cidr notation, the blob host must resolve to when private-endpoint is set, e.g. the subnet of the private endpoints"),
+	}
+}
+
+// replacesCloudConfigFile returns true when the flags are sufficient to operate without a custom cloud config file
+func (c *connectionFlags) replacesCloudConfigFile() bool {
+	return *c.storageEndpointSuffix != "" && *c.authorityHost != ""
+}
+
+// apply sets the connection flags values on the global configuration, without a cloud config file
+// azure resource manager endpoints are unknown so blob endpoints are always built locally. Returns
+// the error name to exit with or empty when the configuration is valid.
+func (c *connectionFlags) apply(cloudConfigFile string) string {
+	config.SetSkipARM(*c.skipARM || (cloudConfigFile == "" && c.replacesCloudConfigFile()))
+	config.SetStorageEndpointSuffixOverride(*c.storageEndpointSuffix)
+	config.SetAuthorityHost(*c.authorityHost)
+	config.SetADFS(*c.adfs)
+	config.SetStorageAudience(*c.storageAudience)
+	config.SetCABundle(*c.caBundle)
+	config.SetInsecureSkipVerify(*c.insecureSkipVerify)
+	config.SetMaxRetries(int32(*c.maxRetries))
+	config.SetRetryDelay(*c.retryDelay)
+	config.SetMaxRetryDelay(*c.maxRetryDelay)
+	config.SetConnectTimeout(*c.connectTimeout)
+	config.SetResponseHeaderTimeout(*c.responseHeaderTimeout)
+	config.SetUserAgentSuffix(*c.userAgentSuffix)
+
+	if strings.ContainsAny(*c.userAgentSuffix, "\r\n") {
+		utils.ConsoleOutput("user-agent-suffix cannot contain line breaks", config.Stderr())
+		return "ErrInvalidArgument"
+	}
+
+	if *c.correlationID == "" {
+		*c.correlationID = uuid.New().String()
+	}
+	config.SetCorrelationID(*c.correlationID)
+
+	// Storage records client request ids of up to 1024 characters in its logs
+	if strings.ContainsAny(*c.correlationID, "\r\n") || len(*c.correlationID) > 1024 {
+		utils.ConsoleOutput("correlation-id cannot contain line breaks nor be longer than 1024 characters", config.Stderr())
+		return "ErrInvalidArgument"
+	}
+
+	config.SetIMDSProbeTimeout(*c.imdsProbeTimeout)
+	config.SetIMDSProbeRetries(*c.imdsProbeRetries)
+	config.SetDisableIMDSProbe(*c.disableIMDSProbe)
+
+	if *c.imdsProbeTimeout < 0 || *c.imdsProbeRetries < 0 {
+		utils.ConsoleOutput("imds-probe-timeout and imds-probe-retries cannot be negative", config.Stderr())
+		return "ErrInvalidArgumentIMDSProbe"
+	}
+
+	config.SetTenantID(*c.tenantID)
+	config.SetClientID(*c.clientID)
+	config.SetTokenExchangeAudience(*c.tokenExchangeAudience)
+
+	if (*c.clientID != "" && *c.tenantID == "") || (*c.adfs && *c.tenantID != "") {
+		utils.ConsoleOutput("client-id requires tenant-id and tenant-id is not supported with adfs", config.Stderr())
+		return "ErrInvalidArgumentTenant"
+	}
+
+	config.SetFederatedTokenFile(*c.federatedTokenFile)
+
+	if *c.federatedTokenFile != "" {
+		if *c.clientID == "" || *c.tenantID == "" {
+			utils.ConsoleOutput("federated-token-file requires client-id and tenant-id", config.Stderr())
+			return "ErrInvalidArgumentFederatedTokenFile"
+		}
+
+		if _, err := os.Stat(*c.federatedTokenFile); err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while reading federated token file: %v", err), config.Stderr())
+			return "ErrInvalidArgumentFederatedTokenFile"
+		}
+	}
+
+	config.SetBlobHost(*c.blobHost)
+
+	if strings.ContainsAny(*c.blobHost, "/?#@ ") {
+		utils.ConsoleOutput("blob-host must be a host name or address, optionally with a port, without scheme nor path", config.Stderr())
+		return "ErrInvalidArgumentPrivateEndpoint"
+	}
+
+	config.SetPrivateEndpointRanges(nil)
+	if *c.privateEndpoint {
+		ranges, err := common.ParseAddressRanges(*c.privateEndpointRanges)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while parsing private-endpoint-ranges: %v", err), config.Stderr())
+			return "ErrInvalidArgumentPrivateEndpoint"
+		}
+		config.SetPrivateEndpointRanges(ranges)
+	}
+
+	config.SetTokenCacheFile(*c.tokenCache)
+
+	if *c.tokenCache != "" && os.Getenv(config.TokenCacheKeyEnvVar()) == "" {
+		utils.ConsoleOutput(fmt.Sprintf("token-cache requires the %v environment variable", config.TokenCacheKeyEnvVar()), config.Stderr())
+		return "ErrInvalidArgumentTokenCache"
+	}
+
+	if *c.maxRetries < -1 || *c.retryDelay < 0 || *c.maxRetryDelay < 0 || *c.connectTimeout < 0 || *c.responseHeaderTimeout < 0 {
+		utils.ConsoleOutput("max-retries cannot be less than -1 and retry delays and timeouts cannot be negative", config.Stderr())
+		return "ErrInvalidArgumentHTTPTuning"
+	}
+
+	if *c.insecureSkipVerify {
+		utils.Warn("tls certificate verification is disabled")
+	}
+
+	err := common.ConfigureTransport()
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while configuring http transport: %v", err), config.Stderr())
+		return "ErrInvalidArgumentCABundle"
+	}
+
+	// Secrets are deliberately not exposed as flags, command lines are visible to other users in process listings
+	config.SetAccountKey(os.Getenv(config.AccountKeyEnvVar()))
+	config.SetSASToken(os.Getenv(config.SASTokenEnvVar()))
+
+	if config.AccountKey() != "" && config.SASToken() != "" {
+		utils.ConsoleOutput(fmt.Sprintf("%v and %v cannot be both set", config.AccountKeyEnvVar(), config.SASTokenEnvVar()), config.Stderr())
+		return "ErrInvalidArgumentSharedKeyAuth"
+	}
+
+	// Azure resource manager only accepts azure ad tokens, blob endpoints are built locally instead
+	if config.AccountKey() != "" || config.SASToken() != "" {
+		config.SetSkipARM(true)
+		utils.Warn("using storage account key or sas token from environment, azure resource manager is skipped")
+	}
+
+	// Fault injection is deliberately not exposed as a flag, it is only meant for integration tests
+	if chaosSpec := os.Getenv(config.ChaosEnvVar()); chaosSpec != "" {
+		err = common.ConfigureChaos(chaosSpec)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while configuring fault injection: %v", err), config.Stderr())
+			return "ErrInvalidArgumentChaos"
+		}
+		utils.Warn(fmt.Sprintf("fault injection is enabled by %v", config.ChaosEnvVar()))
+	}
+
+	return ""
+}
+
+// statsDFlags holds the flags of the subcommands that report lease operations to a StatsD server
+type statsDFlags struct {
+	address   *string
+	dogStatsD *bool
+}
+
+// addStatsDFlags defines the StatsD flags on a subcommand
+func addStatsDFlags(command *flag.FlagSet) *statsDFlags {
+	return &statsDFlags{
+		address:   command.String("statsd-addr", "", "StatsD server (host:port) acquire and renew counters and timings are sent to over udp, e.g. 127.0.0.1:8125"),
+		dogStatsD: command.Bool("statsd-dogstatsd", false, "adds storage account, container and blob name as DogStatsD tags to the metrics sent to statsd-addr"),
+	}
+}
+
+// apply enables reporting to the StatsD server, returns the error name to exit with or empty when the address is valid
+func (s *statsDFlags) apply() string {
+	err := common.ConfigureStatsD(*s.address, *s.dogStatsD)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while configuring statsd: %v", err), config.Stderr())
+		return "ErrInvalidArgumentStatsD"
+	}
+	return ""
+}
+
+// addLogTargetFlag defines the flag choosing where diagnostics of long running subcommands are sent
+func addLogTargetFlag(command *flag.FlagSet) *string {
+	return command.String("log-target", "stderr", "Where diagnostic messages are sent, valid values are stderr, syslog (not on windows) and eventlog (windows only, application log), the json result is always written to stdout")
+}
+
+// applyLogTarget sends diagnostic messages to the log target, returns the error name to exit with or empty
+// when the target is available
+func applyLogTarget(target string) string {
+	writer, err := common.OpenLogTarget(target)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while opening log target: %v", err), config.Stderr())
+		return "ErrInvalidArgumentLogTarget"
+	}
+
+	if writer != nil {
+		config.SetStderrWriter(writer)
+	}
+	return ""
+}
+
+// addPrefixFlag defines the blob namespacing flag on a subcommand
+func addPrefixFlag(command *flag.FlagSet) *string {
+	return command.String("prefix", "", "Namespace prepended to blob names (e.g. locks/production/), so several applications can share one container without collisions")
+}
+
+// addStartupJitterFlag defines the startup jitter flag on a subcommand
+func addStartupJitterFlag(command *flag.FlagSet) *time.Duration {
+	return command.Duration("startup-jitter", 0, "Delays the first request by a random time up to this duration (e.g. 30s), so when a whole fleet restarts at once, e.g. on patch night, azure resource manager and storage endpoints are not hit by synchronized first attempts")
+}
+
+// addOutputFlag defines the output format flag on a subcommand
+func addOutputFlag(command *flag.FlagSet) *string {
+	return command.String("output", "json", fmt.Sprintf("Output format, currently supported ones are: %v, gha also writes the result as github actions step outputs to $GITHUB_OUTPUT and emits error annotations, table and csv print the blobs of list and purge and the lease of status as aligned columns or csv records and other results as json", config.ValidOutputFormats()))
+}
+
+// applyOutputFormat sets the output format on the global configuration, returning the error name
+// to exit with or empty when it is valid
+func applyOutputFormat(outputFormat string) string {
+	outputFormat = strings.ToLower(outputFormat)
+	if _, found := utils.FindInSlice(config.ValidOutputFormats(), outputFormat); !found {
+		return "ErrInvalidArgumentOutput"
+	}
+
+	// Table and csv are not json, in strict output mode they would end up in stderr
+	if config.StrictOutput() && (outputFormat == "table" || outputFormat == "csv") {
+		return "ErrInvalidArgumentOutput"
+	}
+
+	config.SetOutputFormat(outputFormat)
+	return ""
+}
+
+// cloudConfigFromEnvironment returns the cloud config source referencing the inline json
+// environment variable, or empty when it is not set
+func cloudConfigFromEnvironment() string {
+	if os.Getenv(config.CloudConfigEnvVar()) == "" {
+		return ""
+	}
+	return config.CloudConfigEnvSource()
+}
+
+// loadCloudConfig imports and validates the custom cloud config before authenticating, returning
+// the error name to exit with or empty when it is valid. With adfs, the authority host and storage
+// audience not informed as flags are taken from the custom cloud config.
+func loadCloudConfig(cloudConfigFile string) string {
+	if cloudConfigFile == "" {
+		return ""
+	}
+
+	cloudInfo, err := utils.ImportCloudConfigJson(cloudConfigFile)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while importing custom cloud config: %v", err), config.Stderr())
+		return "ErrCloudConfigInvalidJSON"
+	}
+
+	err = utils.ValidateCloudConfig(cloudInfo)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("invalid custom cloud config, %v", err), config.Stderr())
+		return "ErrCloudConfigInvalidField"
+	}
+
+	if config.ADFS() {
+		if config.AuthorityHost() == "" {
+			config.SetAuthorityHost(cloudInfo.Endpoints.ActiveDirectoryAuthorityHost)
+		}
+		if config.StorageAudience() == "" {
+			config.SetStorageAudience(cloudInfo.Endpoints.ResourceManagerAudience)
+		}
+	}
+
+	return ""
+}
+
+// readParams returns the subcommand and flags of an operation passed as a json document, inline, from
+// stdin (-) or from a file
+func readParams(params, paramsFile string) ([]string, error) {
+	if params != "" && paramsFile != "" {
+		return nil, fmt.Errorf("params and params-file are mutually exclusive")
+	}
+
+	var paramsJSON []byte
+	var err error
+	switch {
+	case params == "-":
+		paramsJSON, err = utils.ReadContent("-")
+	case params != "":
+		paramsJSON = []byte(params)
+	default:
+		paramsJSON, err = utils.ReadContent(paramsFile)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.ParamsToArgs(paramsJSON)
+}
+
+// defaultHolder returns the hostname to be used as lease holder identity
+func defaultHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
+// errorCode translates an error name into the exit code the process ends with, an unknown name is reported
+// and ends the process with InvalidErrorCode once deferred cleanup ran
+func errorCode(errorName string) int {
+	code, err := config.ErrorCode(errorName)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while translating error into exit code: %v", err), config.Stderr())
+	}
+	return code
+}
+
+// flagParseExitCode returns the exit code of invalid flags, the flag package already printed the error and the
+// usage, asking for help is not a failure
+func flagParseExitCode(err error) int {
+	if err == flag.ErrHelp {
+		return 0
+	}
+	return errorCode("ErrInvalidArgument")
+}
+
+// resultExitCode returns the exit code of a failed operation based on its classified error, a lease held by
+// someone else has a dedicated exit code so scripts can tell a lost election from a failure, other failures
+// return a generic failure code and successful operations return 0
+func resultExitCode(result models.ResponseInfo) int {
+	if result.Status == nil || (*result.Status != config.Fail() && *result.Status != config.Contended() && *result.Status != config.PartialSuccess()) {
+		return 0
+	}
+
+	if *result.Status == config.Contended() || (result.ErrorCode != nil && *result.ErrorCode == string(bloberror.LeaseAlreadyPresent)) {
+		return errorCode("ErrLeaseAlreadyPresent")
+	}
+
+	if result.ErrorCategory == nil {
+		return errorCode("ErrOperationFailed")
+	}
+
+	switch *result.ErrorCategory {
+	case common.ErrorCategoryAuthorization:
+		return errorCode("ErrDataPlaneAuthorization")
+	case common.ErrorCategoryNotFound:
+		return errorCode("ErrDataPlaneNotFound")
+	case common.ErrorCategoryConflict:
+		return errorCode("ErrDataPlaneConflict")
+	case common.ErrorCategoryTimeout:
+		return errorCode("ErrDataPlaneTimeout")
+	case common.ErrorCategoryLeaseNotHeld:
+		return errorCode("ErrLeaseNotHeld")
+	case common.ErrorCategoryImmutable:
+		return errorCode("ErrBlobImmutable")
+	case common.ErrorCategoryWaitTimeTooLong:
+		return errorCode("ErrInvalidArgumentWaitTime")
+	case common.ErrorCategoryRenewThresholdTooLong:
+		return errorCode("ErrInvalidArgumentRenewThreshold")
+	case common.ErrorCategoryRetryBudgetExhausted:
+		return errorCode("ErrRetryBudgetExhausted")
+	case common.ErrorCategoryEndpointUnreachable:
+		return errorCode("ErrEndpointUnreachable")
+	case common.ErrorCategoryPrivateEndpointNotResolved:
+		return errorCode("ErrPrivateEndpointNotResolved")
+	}
+	return errorCode("ErrOperationFailed")
+}
+
+// batchExitCode returns the exit code of an operation on several blobs, a failure other than a lease held by
+// someone else takes precedence over a lost election, which takes precedence over success
+func batchExitCode(results []models.ResponseInfo) int {
+	code := 0
+	for _, result := range results {
+		resultCode := resultExitCode(result)
+		if resultCode != 0 && resultCode != errorCode("ErrLeaseAlreadyPresent") {
+			return resultCode
+		}
+		if resultCode != 0 {
+			code = resultCode
+		}
+	}
+	return code
+}
+
+// quorumExitCode returns the exit code of a quorum operation, when the quorum was not reached it is the one of
+// the failures of its members, e.g. the lease held by someone else on a majority of storage accounts
+func quorumExitCode(result models.ResponseInfo) int {
+	code := resultExitCode(result)
+	if code != errorCode("ErrOperationFailed") || result.QuorumMembers == nil {
+		return code
+	}
+	if membersCode := batchExitCode(*result.QuorumMembers); membersCode != 0 {
+		return membersCode
+	}
+	return code
+}
+
+// outputResult outputs the result with the exit code the process ends with, for log collectors that only
+// capture stdout, and returns that exit code
+func outputResult(result models.ResponseInfo, code int) int {
+	warnRetries()
+	if skew, exceeded := common.ClockSkewExceeded(); exceeded {
+		result.ClockSkewMs = to.Int64Ptr(skew.Milliseconds())
+	}
+	result.ExitCode = to.IntPtr(utils.ExitStatus(code))
+	utils.OutputResult(result)
+	return code
+}
+
+// warnRetries records a warning when azure requests had to be retried, a sign of throttling or an unhealthy network
+func warnRetries() {
+	if retries := common.Retries(); retries > 0 {
+		utils.Warn(fmt.Sprintf("azure requests were retried %v time(s)", retries))
+	}
+}
+
+// runResultHook runs the lifecycle hook of a successful operation, a failing hook is only logged since the
+// lease operation itself succeeded
+func runResultHook(cntx context.Context, path, event string, result models.ResponseInfo, holder string, leaseDuration int) {
+	if path == "" || result.Status == nil || *result.Status != config.Success() {
+		return
+	}
+
+	state := resultLeadershipState(result, event != common.HookEventRelease, holder, leaseDuration)
+	err := common.RunHook(cntx, path, event, state)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while running %v hook %v: %v", event, path, err), config.Stderr())
+	}
+}
+
+// startupJitter waits a random time up to max before the first request, a cancelled wait is reported by the
+// operation itself
+func startupJitter(cntx context.Context, max time.Duration) {
+	delay := utils.RandomDelay(max)
+	if delay <= 0 {
+		return
+	}
+
+	utils.ConsoleOutput(fmt.Sprintf("startup jitter, delaying first request by %v", delay.Round(time.Millisecond)), config.Stderr())
+	utils.Sleep(cntx, delay)
+}
+
+// runLeaderChangeHook runs the leader change hook when a new leader is observed, a failing hook is only logged so
+// watching goes on
+func runLeaderChangeHook(cntx context.Context, path string, event models.LeadershipEvent) {
+	if path == "" || (event.Event != subcommands.WatchEventElected && event.Event != subcommands.WatchEventChanged) {
+		return
+	}
+
+	err := common.RunLeaderChangeHook(cntx, path, event)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while running %v hook %v: %v", common.HookEventLeaderChange, path, err), config.Stderr())
+	}
+}
+
+// writeResultState persists the lease acquired by a successful operation to a local state file, so resume can
+// re-attach to it, a failure is only logged since the lease itself was acquired
+func writeResultState(path string, result models.ResponseInfo, holder string, leaseDuration int) {
+	if path == "" || result.Status == nil || *result.Status != config.Success() {
+		return
+	}
+
+	err := common.WriteStateFile(path, resultLeadershipState(result, true, holder, leaseDuration))
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while writing state file %v: %v", path, err), config.Stderr())
+	}
+}
+
+// resultLeadershipState returns the leadership state described by an operation result
+func resultLeadershipState(result models.ResponseInfo, leader bool, holder string, leaseDuration int) models.LeadershipState {
+	state := models.LeadershipState{
+		Leader:               leader,
+		StorageAccountName:   *result.StorageAccountName,
+		ContainerName:        *result.ContainerName,
+		BlobName:             *result.BlobName,
+		Holder:               holder,
+		LeaseDurationSeconds: leaseDuration,
+	}
+	if result.SubscriptionID != nil {
+		state.SubscriptionID = *result.SubscriptionID
+	}
+	if result.ResourceGroupName != nil {
+		state.ResourceGroupName = *result.ResourceGroupName
+	}
+	if result.LeaseID != nil {
+		state.LeaseID = *result.LeaseID
+	}
+	if result.BlobURL != nil {
+		state.BlobURL = *result.BlobURL
+	}
+	if leader && leaseDuration > 0 {
+		state.LeaseExpiresAt = utils.Timestamp(time.Now().Add(time.Duration(leaseDuration) * time.Second))
 	}
+	return state
 }
@@ -0,0 +1,245 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// runMainEnvVar makes the test binary run main with its arguments instead of the tests, so the output of every
+// subcommand is checked as the process writes it to its standard streams
+const runMainEnvVar = "AZBLOBLEASE_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnvVar) == "1" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs main in a child process with args, authenticated with a shared key against a blob host refusing
+// connections, so every operation fails fast without azure, and returns its stdout, stderr and exit code
+func runMain(t *testing.T, args ...string) ([]byte, []byte, int) {
+	t.Helper()
+
+	cntx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Azure credentials of the environment, the azure cli on the path and github actions outputs are left out
+	env := []string{
+		runMainEnvVar + "=1",
+		config.AccountKeyEnvVar() + "=" + base64.StdEncoding.EncodeToString([]byte("key")),
+		"GITHUB_OUTPUT=" + filepath.Join(t.TempDir(), "github_output"),
+		"PATH=",
+	}
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if name != "PATH" && name != "GITHUB_OUTPUT" && !strings.HasPrefix(name, "AZURE_") && !strings.HasPrefix(name, "AZBLOBLEASE_") {
+			env = append(env, variable)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(cntx, os.Args[0], args...)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Fatalf("running %v failed: %v", args, err)
+		}
+	}
+
+	return stdout.Bytes(), stderr.Bytes(), cmd.ProcessState.ExitCode()
+}
+
+// decodeOnly decodes stdout into value, failing when it holds anything other than that single json document
+func decodeOnly(t *testing.T, stdout []byte, value interface{}) {
+	t.Helper()
+
+	decoder := json.NewDecoder(bytes.NewReader(stdout))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		t.Fatalf("stdout is not a json %T: %v\n%s", value, err, stdout)
+	}
+
+	var extra interface{}
+	if err := decoder.Decode(&extra); err != io.EOF {
+		t.Fatalf("stdout holds more than the json %T: %s", value, stdout)
+	}
+}
+
+func TestOutputStreams(t *testing.T) {
+	connection := []string{
+		"-subscriptionid", "00000000-0000-0000-0000-000000000000",
+		"-resourcegroupname", "rg",
+		"-accountname", "account",
+		"-container", "container",
+		"-skip-arm",
+		"-blob-host", "127.0.0.1:1",
+		"-max-retries", "-1",
+	}
+	authentication := []string{"-authority-host", "http://127.0.0.1:1/", "-disable-imds-probe"}
+	leaseID := "00000000-0000-0000-0000-000000000001"
+	missingFile := filepath.Join(t.TempDir(), "missing.json")
+
+	tests := []struct {
+		name       string
+		args       []string
+		strictOnly bool   // output outside strict mode is not json only, e.g. plain version or usage
+		output     string // result, batch or none
+		diagnostic string // expected on stderr
+	}{
+		{"version", []string{"version"}, true, "result", ""},
+		{"createleaseblob", append([]string{"createleaseblob", "-blobname", "blob"}, connection...), false, "result", "connection refused"},
+		{"acquire", append([]string{"acquire", "-blobname", "blob", "-retries", "1"}, connection...), false, "result", "connection refused"},
+		{"acquire batch", append([]string{"acquire", "-blobname", "blob1", "-blobname", "blob2", "-retries", "1"}, connection...), false, "batch", "connection refused"},
+		{"acquire gha", append([]string{"acquire", "-blobname", "blob", "-retries", "1", "-output", "gha"}, connection...), true, "result", "::error"},
+		{"renew", append([]string{"renew", "-blobname", "blob", "-leaseid", leaseID, "-iterations", "1"}, connection...), false, "result", "connection refused"},
+		{"release", append([]string{"release", "-blobname", "blob", "-leaseid", leaseID}, connection...), false, "result", "connection refused"},
+		{"resume", []string{"resume", "-state-file", missingFile}, false, "result", "state file"},
+		{"status", append([]string{"status", "-blobname", "blob"}, connection...), false, "result", "connection refused"},
+		{"list", append([]string{"list"}, connection...), false, "result", "connection refused"},
+		{"purge", append([]string{"purge"}, connection...), false, "result", "connection refused"},
+		{"doctor", append(append([]string{"doctor", "-blobname", "blob"}, connection...), authentication...), false, "result", "check token failed"},
+		{"bench", append([]string{"bench", "-cycles", "1"}, connection...), false, "result", "connection refused"},
+		{"test-auth", append([]string{"test-auth"}, authentication...), false, "result", "obtaining token"},
+		{"healthcheck", []string{"healthcheck", "-state-file", missingFile}, false, "result", ""},
+		{"handoff", append([]string{"handoff", "-blobname", "blob", "-leaseid", leaseID, "-successor", "successor"}, connection...), false, "result", "connection refused"},
+		{"semaphore", append([]string{"semaphore", "-name", "semaphore", "-slots", "1", "-retries", "1"}, connection...), false, "result", "connection refused"},
+		{"semaphore usage", []string{"semaphore"}, true, "none", "Storage Account Name"},
+		{"rwlock", append([]string{"rwlock", "-blobname", "blob", "-mode", "read", "-retries", "1"}, connection...), false, "result", "connection refused"},
+		{"watch", append([]string{"watch", "-blobname", "blob", "-count", "1", "-interval", "1s"}, connection...), false, "result", "connection refused"},
+		{"serve usage", []string{"serve"}, true, "none", "Storage Account Name"},
+		{"agent", []string{"agent", "-config", missingFile}, false, "none", "agent config"},
+	}
+
+	for _, test := range tests {
+		for _, strict := range []bool{false, true} {
+			if test.strictOnly && !strict {
+				continue
+			}
+
+			args := test.args
+			name := test.name
+			if strict {
+				args = append([]string{"-strict-output"}, args...)
+				name += " strict"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				stdout, stderr, code := runMain(t, args...)
+
+				switch test.output {
+				case "result":
+					var result models.ResponseInfo
+					decodeOnly(t, stdout, &result)
+					if result.ExitCode == nil || *result.ExitCode != code {
+						t.Errorf("result exit code %v does not match the process exit code %v", result.ExitCode, code)
+					}
+				case "batch":
+					var batchResponse models.BatchResponse
+					decodeOnly(t, stdout, &batchResponse)
+					if batchResponse.ExitCode == nil || *batchResponse.ExitCode != code {
+						t.Errorf("batch exit code %v does not match the process exit code %v", batchResponse.ExitCode, code)
+					}
+				case "none":
+					if len(stdout) != 0 {
+						t.Errorf("expected nothing on stdout, got: %s", stdout)
+					}
+				}
+
+				if !bytes.Contains(stderr, []byte(test.diagnostic)) {
+					t.Errorf("expected %q on stderr, got: %s", test.diagnostic, stderr)
+				}
+				if bytes.Contains(stderr, []byte(`"operation"`)) {
+					t.Errorf("json result written to stderr: %s", stderr)
+				}
+			})
+		}
+	}
+}
+
+func TestFailedOperationExitCode(t *testing.T) {
+	connection := []string{
+		"-subscriptionid", "00000000-0000-0000-0000-000000000000",
+		"-resourcegroupname", "rg",
+		"-accountname", "account",
+		"-container", "container",
+		"-skip-arm",
+		"-blob-host", "127.0.0.1:1",
+		"-max-retries", "-1",
+	}
+	authentication := []string{"-authority-host", "http://127.0.0.1:1/", "-disable-imds-probe"}
+
+	tests := []struct {
+		name string
+		args []string
+		code string
+	}{
+		{"acquire", append([]string{"acquire", "-blobname", "blob", "-retries", "1"}, connection...), "ErrOperationFailed"},
+		{"acquire batch", append([]string{"acquire", "-blobname", "blob1", "-blobname", "blob2", "-retries", "1"}, connection...), "ErrOperationFailed"},
+		{"release", append([]string{"release", "-blobname", "blob", "-leaseid", "00000000-0000-0000-0000-000000000001"}, connection...), "ErrOperationFailed"},
+		{"release quorum", append([]string{"release", "-blobname", "blob", "-leaseid", "00000000-0000-0000-0000-000000000001", "-quorum-accounts", "rg/account2,rg/account3"}, connection...), "ErrOperationFailed"},
+		{"list", append([]string{"list"}, connection...), "ErrOperationFailed"},
+		{"list tags", append([]string{"list", "-tags", "role=leader"}, connection...), "ErrOperationFailed"},
+		{"list invalid tags", append([]string{"list", "-tags", "role=leader!"}, connection...), "ErrInvalidArgumentTags"},
+		{"createleaseblob reader metadata", append([]string{"createleaseblob", "-blobname", "blob", "-metadata", "azbloblease_reader_node1=1"}, connection...), "ErrInvalidArgumentBlobMetadata"},
+		{"createleaseblob reader like metadata", append([]string{"createleaseblob", "-blobname", "blob", "-metadata", "readerTeam=batch"}, connection...), "ErrOperationFailed"},
+		{"watch", append([]string{"watch", "-blobname", "blob", "-count", "2", "-interval", "10ms"}, connection...), "ErrOperationFailed"},
+		{"watch table", append([]string{"-output", "table", "watch", "-blobname", "blob", "-count", "1"}, connection...), "ErrInvalidArgumentOutput"},
+		{"doctor", append(append([]string{"doctor", "-blobname", "blob"}, connection...), authentication...), "ErrOperationFailed"},
+		{"test-auth", append([]string{"test-auth"}, authentication...), "ErrAuthentication"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, code := runMain(t, test.args...)
+			// POSIX shells only see exit codes modulo 256
+			if want, _ := config.ErrorCode(test.code); code != want%256 {
+				t.Errorf("exit code = %v, want %v (%v)", code, want%256, test.code)
+			}
+		})
+	}
+}
+
+func TestInvalidFlagExitCode(t *testing.T) {
+	invalidArgument, _ := config.ErrorCode("ErrInvalidArgument")
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"unknown global flag", []string{"-unknown", "version"}, invalidArgument},
+		{"unknown subcommand flag", []string{"acquire", "-unknown"}, invalidArgument},
+		{"invalid flag value", []string{"acquire", "-retries", "many"}, invalidArgument},
+		{"global help", []string{"-h"}, 0},
+		{"subcommand help", []string{"acquire", "-h"}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, code := runMain(t, test.args...); code != test.code {
+				t.Errorf("exit code = %v, want %v", code, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// response returns a result with the status and, when not empty, the error category and storage error code
+func response(status, category, storageErrorCode string) models.ResponseInfo {
+	result := models.ResponseInfo{Status: to.StringPtr(status)}
+	if category != "" {
+		result.ErrorCategory = to.StringPtr(category)
+	}
+	if storageErrorCode != "" {
+		result.ErrorCode = to.StringPtr(storageErrorCode)
+	}
+	return result
+}
+
+func TestResultExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		result models.ResponseInfo
+		code   string // empty for exit code 0
+	}{
+		{"no status", models.ResponseInfo{}, ""},
+		{"success", response(config.Success(), "", ""), ""},
+		{"success with stale category", response(config.Success(), common.ErrorCategoryTimeout, ""), ""},
+		{"contended", response(config.Contended(), "", ""), "ErrLeaseAlreadyPresent"},
+		{"lease already present", response(config.Fail(), common.ErrorCategoryConflict, string(bloberror.LeaseAlreadyPresent)), "ErrLeaseAlreadyPresent"},
+		{"no category", response(config.Fail(), "", ""), "ErrOperationFailed"},
+		{"unknown category", response(config.Fail(), "unknown", ""), "ErrOperationFailed"},
+		{"partial success", response(config.PartialSuccess(), "", ""), "ErrOperationFailed"},
+		{"authorization", response(config.Fail(), common.ErrorCategoryAuthorization, ""), "ErrDataPlaneAuthorization"},
+		{"not found", response(config.Fail(), common.ErrorCategoryNotFound, ""), "ErrDataPlaneNotFound"},
+		{"conflict", response(config.Fail(), common.ErrorCategoryConflict, string(bloberror.LeaseIDMismatchWithLeaseOperation)), "ErrDataPlaneConflict"},
+		{"timeout", response(config.Fail(), common.ErrorCategoryTimeout, ""), "ErrDataPlaneTimeout"},
+		{"lease not held", response(config.Fail(), common.ErrorCategoryLeaseNotHeld, ""), "ErrLeaseNotHeld"},
+		{"immutable", response(config.Fail(), common.ErrorCategoryImmutable, ""), "ErrBlobImmutable"},
+		{"retry budget exhausted", response(config.Fail(), common.ErrorCategoryRetryBudgetExhausted, ""), "ErrRetryBudgetExhausted"},
+		{"endpoint unreachable", response(config.Fail(), common.ErrorCategoryEndpointUnreachable, ""), "ErrEndpointUnreachable"},
+	}
+
+	for _, test := range tests {
+		want := 0
+		if test.code != "" {
+			want = errorCode(test.code)
+		}
+		if code := resultExitCode(test.result); code != want {
+			t.Errorf("%v: resultExitCode() = %v, want %v (%v)", test.name, code, want, test.code)
+		}
+	}
+}
+
+func TestBatchExitCode(t *testing.T) {
+	success := response(config.Success(), "", "")
+	contended := response(config.Contended(), "", "")
+	notFound := response(config.Fail(), common.ErrorCategoryNotFound, "")
+
+	tests := []struct {
+		name    string
+		results []models.ResponseInfo
+		code    string // empty for exit code 0
+	}{
+		{"no results", nil, ""},
+		{"all succeeded", []models.ResponseInfo{success, success}, ""},
+		{"lost election", []models.ResponseInfo{success, contended}, "ErrLeaseAlreadyPresent"},
+		{"failure over lost election", []models.ResponseInfo{contended, notFound, success}, "ErrDataPlaneNotFound"},
+	}
+
+	for _, test := range tests {
+		want := 0
+		if test.code != "" {
+			want = errorCode(test.code)
+		}
+		if code := batchExitCode(test.results); code != want {
+			t.Errorf("%v: batchExitCode() = %v, want %v (%v)", test.name, code, want, test.code)
+		}
+	}
+}
+
+func TestQuorumExitCode(t *testing.T) {
+	quorum := func(status string, members ...models.ResponseInfo) models.ResponseInfo {
+		result := response(status, "", "")
+		if members != nil {
+			result.QuorumMembers = &members
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		result models.ResponseInfo
+		code   string // empty for exit code 0
+	}{
+		{"quorum reached", quorum(config.Success(), response(config.Success(), "", ""), response(config.Contended(), "", "")), ""},
+		{"held by someone else", quorum(config.Fail(), response(config.Contended(), "", ""), response(config.Contended(), "", "")), "ErrLeaseAlreadyPresent"},
+		{"member failure", quorum(config.Fail(), response(config.Contended(), "", ""), response(config.Fail(), common.ErrorCategoryAuthorization, "")), "ErrDataPlaneAuthorization"},
+		{"members without failure code", quorum(config.Fail(), response(config.Success(), "", "")), "ErrOperationFailed"},
+		{"no members", quorum(config.Fail()), "ErrOperationFailed"},
+	}
+
+	for _, test := range tests {
+		want := 0
+		if test.code != "" {
+			want = errorCode(test.code)
+		}
+		if code := quorumExitCode(test.result); code != want {
+			t.Errorf("%v: quorumExitCode() = %v, want %v (%v)", test.name, code, want, test.code)
+		}
+	}
+}
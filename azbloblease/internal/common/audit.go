@@ -0,0 +1,51 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// AppendAuditLog appends a json line describing a lease operation to the audit log append blob, the append
+// blob is created on first use and the entry timestamp is set to the current time
+func AppendAuditLog(cntx context.Context, auditBlobURL, accountName string, cred azcore.TokenCredential, entry models.AuditEntry) error {
+	appendBlobClient, err := NewAppendBlobClient(auditBlobURL, accountName, cred)
+	if err != nil {
+		return err
+	}
+
+	etagAny := azcore.ETagAny
+	_, err = appendBlobClient.Create(cntx, &appendblob.CreateOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfNoneMatch: &etagAny,
+			},
+		},
+	})
+
+	if err != nil && !strings.Contains(err.Error(), "BlobAlreadyExists") && !strings.Contains(err.Error(), "ConditionNotMet") {
+		return err
+	}
+
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = appendBlobClient.AppendBlock(cntx, streaming.NopCloser(bytes.NewReader(append(line, '\n'))), nil)
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// chaos is the fault injection policy added to data plane and management clients, nil when disabled
+var chaos *chaosPolicy
+
+// chaosPolicy injects simulated failures into requests so retry and re-acquire logic can be exercised
+// without real outages, every probability is evaluated independently on each try
+type chaosPolicy struct {
+	failProbability      float64
+	delayProbability     float64
+	delay                time.Duration
+	dropRenewProbability float64
+
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// ConfigureChaos enables fault injection from a spec formatted as key=value,key=value, where fail is the
+// probability of a simulated transport failure, delay the probability of delaying a request by
+// delay-duration (default 2s) and drop-renew the probability of a renewal being answered as if the lease
+// was lost. An empty spec disables fault injection.
+func ConfigureChaos(spec string) error {
+	chaos = nil
+	if spec == "" {
+		return nil
+	}
+
+	p := &chaosPolicy{
+		delay:  2 * time.Second,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, setting := range strings.Split(spec, ",") {
+		keyValue := strings.SplitN(strings.TrimSpace(setting), "=", 2)
+		if len(keyValue) != 2 {
+			return fmt.Errorf("invalid fault injection setting %v, expected format is key=value", setting)
+		}
+
+		var err error
+		switch keyValue[0] {
+		case "fail":
+			p.failProbability, err = parseProbability(keyValue[1])
+		case "delay":
+			p.delayProbability, err = parseProbability(keyValue[1])
+		case "delay-duration":
+			p.delay, err = time.ParseDuration(keyValue[1])
+		case "drop-renew":
+			p.dropRenewProbability, err = parseProbability(keyValue[1])
+		default:
+			err = fmt.Errorf("unknown key, valid keys are fail, delay, delay-duration and drop-renew")
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid fault injection setting %v: %v", setting, err)
+		}
+	}
+
+	chaos = p
+	return nil
+}
+
+// parseProbability parses a probability between 0 and 1
+func parseProbability(value string) (float64, error) {
+	probability, err := strconv.ParseFloat(value, 64)
+	if err != nil || probability < 0 || probability > 1 {
+		return 0, fmt.Errorf("probability must be a number between 0 and 1")
+	}
+	return probability, nil
+}
+
+// happens returns true with the given probability
+func (p *chaosPolicy) happens(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.random.Float64() < probability
+}
+
+// Do injects the configured failures before sending the request
+func (p *chaosPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if p.happens(p.delayProbability) {
+		select {
+		case <-time.After(p.delay):
+		case <-req.Raw().Context().Done():
+			return nil, req.Raw().Context().Err()
+		}
+	}
+
+	if p.happens(p.failProbability) {
+		return nil, fmt.Errorf("chaos: simulated transport failure")
+	}
+
+	if req.Raw().Header.Get("x-ms-lease-action") == "renew" && p.happens(p.dropRenewProbability) {
+		return &http.Response{
+			Status:     "409 Conflict",
+			StatusCode: http.StatusConflict,
+			Header: http.Header{
+				"X-Ms-Error-Code": []string{"LeaseIdMismatchWithLeaseOperation"},
+			},
+			Body:    ioutil.NopCloser(strings.NewReader("")),
+			Request: req.Raw(),
+		}, nil
+	}
+
+	return req.Next()
+}
@@ -0,0 +1,193 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/pageblob"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+)
+
+// userAgentSuffixPolicy appends the configured suffix to the user agent set by the sdk telemetry policy
+type userAgentSuffixPolicy struct {
+	suffix string
+}
+
+// Do appends the suffix to the user agent header of the request
+func (p userAgentSuffixPolicy) Do(req *policy.Request) (*http.Response, error) {
+	userAgent := req.Raw().Header.Get("User-Agent")
+	req.Raw().Header.Set("User-Agent", strings.TrimSpace(userAgent+" "+p.suffix))
+	return req.Next()
+}
+
+// correlationIDPolicy sends the same client request id on every request so a logical operation can be traced
+// across azure resource manager and storage diagnostic logs
+type correlationIDPolicy struct {
+	correlationID string
+}
+
+// Do sets the client request id header of the request
+func (p correlationIDPolicy) Do(req *policy.Request) (*http.Response, error) {
+	req.Raw().Header.Set("x-ms-client-request-id", p.correlationID)
+	return req.Next()
+}
+
+// Requests sent by all clients and tries made for them, the difference being the retries of the sdk retry policy
+var requestCount, tryCount int64
+
+// requestCountPolicy counts the requests sent, it runs once per request before the retry policy
+type requestCountPolicy struct{}
+
+// Do counts the request
+func (p requestCountPolicy) Do(req *policy.Request) (*http.Response, error) {
+	atomic.AddInt64(&requestCount, 1)
+	return req.Next()
+}
+
+// tryCountPolicy counts the tries made, it runs once per try after the retry policy
+type tryCountPolicy struct{}
+
+// Do counts the try
+func (p tryCountPolicy) Do(req *policy.Request) (*http.Response, error) {
+	atomic.AddInt64(&tryCount, 1)
+	return req.Next()
+}
+
+// Retries returns the number of retries made by all clients so far
+func Retries() int64 {
+	return atomic.LoadInt64(&tryCount) - atomic.LoadInt64(&requestCount)
+}
+
+// ClientOptions returns the options shared by management, data plane and credential clients
+func ClientOptions() azcore.ClientOptions {
+	options := azcore.ClientOptions{
+		Retry: RetryOptions(),
+		Telemetry: policy.TelemetryOptions{
+			ApplicationID: config.UserAgent() + "/" + config.Version(),
+		},
+		Transport:        Transport(),
+		PerCallPolicies:  []policy.Policy{requestCountPolicy{}, retryStatePolicy{}},
+		PerRetryPolicies: []policy.Policy{tryCountPolicy{}, retryBudgetPolicy{}},
+	}
+
+	if config.UserAgentSuffix() != "" {
+		options.PerCallPolicies = append(options.PerCallPolicies, userAgentSuffixPolicy{suffix: config.UserAgentSuffix()})
+	}
+
+	if config.CorrelationID() != "" {
+		options.PerCallPolicies = append(options.PerCallPolicies, correlationIDPolicy{correlationID: config.CorrelationID()})
+	}
+
+	if chaos != nil {
+		options.PerRetryPolicies = append(options.PerRetryPolicies, chaos)
+	}
+
+	return options
+}
+
+// BlobClientOptions returns the options shared by all storage data plane clients
+func BlobClientOptions() *blob.ClientOptions {
+	options := ClientOptions()
+	options.PerRetryPolicies = append(options.PerRetryPolicies, clockSkewPolicy{})
+
+	return &blob.ClientOptions{
+		ClientOptions: options,
+		Audience:      config.StorageAudience(),
+	}
+}
+
+// sharedKeyCredential returns the shared key credential of a storage account, the account name is passed
+// explicitly since secondary endpoints, blob host overrides and custom domains do not start with it
+func sharedKeyCredential(accountName string) (*azblob.SharedKeyCredential, error) {
+	if accountName == "" {
+		return nil, fmt.Errorf("storage account name is required to authenticate with the account key")
+	}
+	return azblob.NewSharedKeyCredential(accountName, config.AccountKey())
+}
+
+// withSASToken appends the shared access signature to the query of the url
+func withSASToken(rawURL string) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + strings.TrimPrefix(config.SASToken(), "?")
+}
+
+// NewServiceClient creates a storage data plane client for a blob endpoint of a storage account, authenticated
+// with the account key or sas token when one is configured, otherwise with the azure ad token credential
+func NewServiceClient(blobEndpoint, accountName string, cred azcore.TokenCredential) (*azblob.Client, error) {
+	options := (*azblob.ClientOptions)(BlobClientOptions())
+	switch {
+	case config.AccountKey() != "":
+		keyCred, err := sharedKeyCredential(accountName)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(blobEndpoint, keyCred, options)
+	case config.SASToken() != "":
+		return azblob.NewClientWithNoCredential(withSASToken(blobEndpoint), options)
+	}
+	return azblob.NewClient(blobEndpoint, cred, options)
+}
+
+// NewBlockBlobClient creates a block blob client for a blob url
+func NewBlockBlobClient(blobURL, accountName string, cred azcore.TokenCredential) (*blockblob.Client, error) {
+	options := (*blockblob.ClientOptions)(BlobClientOptions())
+	switch {
+	case config.AccountKey() != "":
+		keyCred, err := sharedKeyCredential(accountName)
+		if err != nil {
+			return nil, err
+		}
+		return blockblob.NewClientWithSharedKeyCredential(blobURL, keyCred, options)
+	case config.SASToken() != "":
+		return blockblob.NewClientWithNoCredential(withSASToken(blobURL), options)
+	}
+	return blockblob.NewClient(blobURL, cred, options)
+}
+
+// NewPageBlobClient creates a page blob client for a blob url
+func NewPageBlobClient(blobURL, accountName string, cred azcore.TokenCredential) (*pageblob.Client, error) {
+	options := (*pageblob.ClientOptions)(BlobClientOptions())
+	switch {
+	case config.AccountKey() != "":
+		keyCred, err := sharedKeyCredential(accountName)
+		if err != nil {
+			return nil, err
+		}
+		return pageblob.NewClientWithSharedKeyCredential(blobURL, keyCred, options)
+	case config.SASToken() != "":
+		return pageblob.NewClientWithNoCredential(withSASToken(blobURL), options)
+	}
+	return pageblob.NewClient(blobURL, cred, options)
+}
+
+// NewAppendBlobClient creates an append blob client for a blob url
+func NewAppendBlobClient(blobURL, accountName string, cred azcore.TokenCredential) (*appendblob.Client, error) {
+	options := (*appendblob.ClientOptions)(BlobClientOptions())
+	switch {
+	case config.AccountKey() != "":
+		keyCred, err := sharedKeyCredential(accountName)
+		if err != nil {
+			return nil, err
+		}
+		return appendblob.NewClientWithSharedKeyCredential(blobURL, keyCred, options)
+	case config.SASToken() != "":
+		return appendblob.NewClientWithNoCredential(withSASToken(blobURL), options)
+	}
+	return appendblob.NewClient(blobURL, cred, options)
+}
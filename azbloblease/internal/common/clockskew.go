@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// Largest clock skew observed against storage, in nanoseconds, positive when the local clock is ahead, and
+// whether it was already reported as exceeding the threshold
+var clockSkew, clockSkewReported int64
+
+// clockSkewPolicy compares the local clock with the Date header of storage responses, the header has a resolution
+// of one second so only skew beyond the time the request was in flight, plus that second, is measured
+type clockSkewPolicy struct{}
+
+// Do records the clock skew of the response
+func (p clockSkewPolicy) Do(req *policy.Request) (*http.Response, error) {
+	sentAt := time.Now()
+	resp, err := req.Next()
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	receivedAt := time.Now()
+
+	serverDate, parseErr := http.ParseTime(resp.Header.Get("Date"))
+	if parseErr != nil {
+		return resp, err
+	}
+
+	switch {
+	case serverDate.After(receivedAt):
+		recordClockSkew(receivedAt.Sub(serverDate))
+	case serverDate.Before(sentAt.Add(-time.Second)):
+		recordClockSkew(sentAt.Add(-time.Second).Sub(serverDate))
+	}
+
+	return resp, err
+}
+
+// recordClockSkew keeps the largest clock skew observed and warns the first time it exceeds the threshold
+func recordClockSkew(skew time.Duration) {
+	for {
+		current := atomic.LoadInt64(&clockSkew)
+		if absDuration(skew) <= absDuration(time.Duration(current)) {
+			break
+		}
+		if atomic.CompareAndSwapInt64(&clockSkew, current, int64(skew)) {
+			break
+		}
+	}
+
+	if exceeded, found := ClockSkewExceeded(); found && atomic.CompareAndSwapInt64(&clockSkewReported, 0, 1) {
+		utils.Warn(DescribeClockSkew(exceeded))
+	}
+}
+
+// ClockSkew returns the largest clock skew observed against storage so far, positive when the local clock is ahead
+func ClockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&clockSkew))
+}
+
+// ClockSkewExceeded returns the largest clock skew observed against storage, true when it exceeds the configured
+// threshold, a threshold of 0 disables the check
+func ClockSkewExceeded() (time.Duration, bool) {
+	skew := ClockSkew()
+	return skew, config.ClockSkewThreshold() > 0 && absDuration(skew) > config.ClockSkewThreshold()
+}
+
+// DescribeClockSkew explains the clock skew, lease expiry is tracked with the local clock
+func DescribeClockSkew(skew time.Duration) string {
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	return fmt.Sprintf("local clock is %v %v storage, lease expiry estimates and renewal scheduling are unreliable, the clock should be synchronized, e.g. with ntp", absDuration(skew).Round(time.Second), direction)
+}
+
+// absDuration returns the absolute value of a duration
+func absDuration(value time.Duration) time.Duration {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
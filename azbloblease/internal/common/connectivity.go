@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointUnreachableError is returned by the connectivity pre-check when the blob endpoint cannot be reached,
+// naming the stage that failed
+type endpointUnreachableError struct {
+	endpoint string
+	stage    string
+	err      error
+}
+
+// Error describes the stage that failed
+func (e *endpointUnreachableError) Error() string {
+	return fmt.Sprintf("endpoint %v unreachable, %v failed: %v", e.endpoint, e.stage, e.err)
+}
+
+// Unwrap returns the error of the failed stage
+func (e *endpointUnreachableError) Unwrap() error {
+	return e.err
+}
+
+// IsEndpointUnreachable returns true when err is a failed connectivity pre-check
+func IsEndpointUnreachable(err error) bool {
+	var unreachableErr *endpointUnreachableError
+	return errors.As(err, &unreachableErr)
+}
+
+// CheckConnectivity sends an anonymous HEAD request to url, through the shared transport, within timeout. Any
+// http response, including 401, 403 or 404, proves the endpoint is reachable, otherwise the error names the stage
+// that failed: dns lookup, tcp connect, tls handshake or http request.
+func CheckConnectivity(cntx context.Context, url string, timeout time.Duration) error {
+	probeCntx, cancel := context.WithTimeout(cntx, timeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	stage := "dns lookup"
+	reached := func(next string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		stage = next
+	}
+	trace := &httptrace.ClientTrace{
+		// Hosts given as ip addresses skip the dns lookup
+		ConnectStart: func(network, addr string) {
+			reached("tcp connect")
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil && strings.HasPrefix(strings.ToLower(url), "https") {
+				reached("tls handshake")
+			} else if err == nil {
+				reached("http request")
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				reached("http request")
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			reached("http request")
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(probeCntx, trace), http.MethodHead, url, nil)
+	if err != nil {
+		return &endpointUnreachableError{endpoint: url, stage: "http request", err: err}
+	}
+
+	client := http.DefaultClient
+	if httpClient, ok := Transport().(*http.Client); ok {
+		client = httpClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if errors.Is(probeCntx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("no answer within %v: %v", timeout, err)
+		}
+		return &endpointUnreachableError{endpoint: url, stage: stage, err: err}
+	}
+	resp.Body.Close()
+
+	return nil
+}
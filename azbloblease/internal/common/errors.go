@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Error categories of failed requests, used to pick the exit code
+const (
+	ErrorCategoryAuthorization = "authorization"
+	ErrorCategoryNotFound      = "notFound"
+	ErrorCategoryConflict      = "conflict"
+	ErrorCategoryTimeout       = "timeout"
+
+	// ErrorCategoryImmutable the blob is protected by an immutability policy or a legal hold of its container
+	ErrorCategoryImmutable = "immutable"
+
+	// ErrorCategoryLeaseNotHeld is not a request failure, renew pre-flight found the lease inactive or held by another holder
+	ErrorCategoryLeaseNotHeld = "leaseNotHeld"
+
+	// ErrorCategoryWaitTimeTooLong is not a request failure, the time between renewals is not below the lease duration
+	ErrorCategoryWaitTimeTooLong = "waitTimeTooLong"
+
+	// ErrorCategoryRenewThresholdTooLong is not a request failure, the renew threshold is not below the lease duration
+	ErrorCategoryRenewThresholdTooLong = "renewThresholdTooLong"
+
+	// ErrorCategoryRetryBudgetExhausted the retry budget of the invocation was exhausted, the request was not retried
+	ErrorCategoryRetryBudgetExhausted = "retryBudgetExhausted"
+
+	// ErrorCategoryEndpointUnreachable the connectivity pre-check could not reach the blob endpoint
+	ErrorCategoryEndpointUnreachable = "endpointUnreachable"
+
+	// ErrorCategoryPrivateEndpointNotResolved the blob host resolved outside the expected private endpoint ranges
+	ErrorCategoryPrivateEndpointNotResolved = "privateEndpointNotResolved"
+)
+
+// StorageErrorCode returns the storage service error code (e.g. LeaseAlreadyPresent) of err, or an empty
+// string when err is not a service response error
+func StorageErrorCode(err error) string {
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.ErrorCode
+	}
+	return ""
+}
+
+// ErrorCategory classifies err as a retry budget exhaustion, an unreachable or not private endpoint or an
+// authorization, not found, conflict, immutable or timeout failure, returning an empty string for any other error
+func ErrorCategory(err error) string {
+	if IsRetryBudgetExhausted(err) {
+		return ErrorCategoryRetryBudgetExhausted
+	}
+
+	if IsEndpointUnreachable(err) {
+		return ErrorCategoryEndpointUnreachable
+	}
+
+	if IsPrivateEndpointNotResolved(err) {
+		return ErrorCategoryPrivateEndpointNotResolved
+	}
+
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		if IsImmutabilityError(err) {
+			return ErrorCategoryImmutable
+		}
+
+		switch responseErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return ErrorCategoryAuthorization
+		case http.StatusNotFound:
+			return ErrorCategoryNotFound
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			return ErrorCategoryConflict
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return ErrorCategoryTimeout
+		}
+		if responseErr.ErrorCode == "OperationTimedOut" {
+			return ErrorCategoryTimeout
+		}
+		return ""
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorCategoryTimeout
+	}
+	return ""
+}
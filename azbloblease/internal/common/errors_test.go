@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		category string
+	}{
+		{"unauthorized", &azcore.ResponseError{StatusCode: http.StatusUnauthorized}, ErrorCategoryAuthorization},
+		{"forbidden", &azcore.ResponseError{StatusCode: http.StatusForbidden}, ErrorCategoryAuthorization},
+		{"not found", &azcore.ResponseError{StatusCode: http.StatusNotFound}, ErrorCategoryNotFound},
+		{"conflict", &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: string(bloberror.LeaseAlreadyPresent)}, ErrorCategoryConflict},
+		{"precondition failed", &azcore.ResponseError{StatusCode: http.StatusPreconditionFailed}, ErrorCategoryConflict},
+		{"immutable", &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: string(bloberror.BlobImmutableDueToPolicy)}, ErrorCategoryImmutable},
+		{"request timeout", &azcore.ResponseError{StatusCode: http.StatusRequestTimeout}, ErrorCategoryTimeout},
+		{"gateway timeout", &azcore.ResponseError{StatusCode: http.StatusGatewayTimeout}, ErrorCategoryTimeout},
+		{"operation timed out", &azcore.ResponseError{StatusCode: http.StatusInternalServerError, ErrorCode: "OperationTimedOut"}, ErrorCategoryTimeout},
+		{"server error", &azcore.ResponseError{StatusCode: http.StatusInternalServerError}, ""},
+		{"wrapped response error", fmt.Errorf("an error ocurred: %w", &azcore.ResponseError{StatusCode: http.StatusNotFound}), ErrorCategoryNotFound},
+		{"deadline exceeded", context.DeadlineExceeded, ErrorCategoryTimeout},
+		{"retry budget exhausted", &retryBudgetError{}, ErrorCategoryRetryBudgetExhausted},
+		{"endpoint unreachable", &endpointUnreachableError{endpoint: "https://account.blob.core.windows.net", stage: "tcp connect", err: errors.New("connection refused")}, ErrorCategoryEndpointUnreachable},
+		{"private endpoint not resolved", &privateEndpointError{host: "account.blob.core.windows.net", address: "20.60.0.1"}, ErrorCategoryPrivateEndpointNotResolved},
+		{"other error", errors.New("connection refused"), ""},
+	}
+
+	for _, test := range tests {
+		if category := ErrorCategory(test.err); category != test.category {
+			t.Errorf("%v: ErrorCategory() = %q, want %q", test.name, category, test.category)
+		}
+	}
+}
+
+func TestIsEndpointUnavailable(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		unavailable bool
+	}{
+		{"no error", nil, false},
+		{"server error", &azcore.ResponseError{StatusCode: http.StatusInternalServerError}, true},
+		{"service unavailable", &azcore.ResponseError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"not found", &azcore.ResponseError{StatusCode: http.StatusNotFound}, false},
+		{"dns failure", &url.Error{Op: "Get", URL: "https://account.blob.core.windows.net", Err: &net.DNSError{Err: "no such host", Name: "account.blob.core.windows.net", IsNotFound: true}}, true},
+		{"connection refused", &url.Error{Op: "Get", URL: "https://account.blob.core.windows.net", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, true},
+		{"endpoint unreachable", &endpointUnreachableError{endpoint: "https://account.blob.core.windows.net", stage: "tcp connect", err: errors.New("connection refused")}, true},
+		{"context canceled", &url.Error{Op: "Get", URL: "https://account.blob.core.windows.net", Err: context.Canceled}, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"credential error", errors.New("ManagedIdentityCredential: no managed identity endpoint is available"), false},
+	}
+
+	for _, test := range tests {
+		if unavailable := IsEndpointUnavailable(test.err); unavailable != test.unavailable {
+			t.Errorf("%v: IsEndpointUnavailable() = %v, want %v", test.name, unavailable, test.unavailable)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// Lease lifecycle events passed to hook scripts
+const (
+	HookEventAcquire = "acquire"
+	HookEventRenew   = "renew"
+	HookEventLost    = "lost"
+	HookEventRelease = "release"
+
+	// HookEventLeaderChange is observed by watch subcommand, the lease of another node changed hands
+	HookEventLeaderChange = "leaderChange"
+)
+
+// RunHook runs a local script reacting to a lease lifecycle event, the event details are passed as
+// AZBLOBLEASE_* environment variables and the script output is forwarded to stderr so it does not mix
+// with the json result, or discarded in silent mode. Without colors, the script is asked not to use them either.
+func RunHook(cntx context.Context, path, event string, state models.LeadershipState) error {
+	return runHookScript(cntx, path, hookEnvironment(event, state))
+}
+
+// RunLeaderChangeHook runs a local script reacting to a leadership transition observed by watch subcommand, e.g.
+// to reconfigure a follower as standby of the new leader, with the same environment and output handling as RunHook
+func RunLeaderChangeHook(cntx context.Context, path string, event models.LeadershipEvent) error {
+	return runHookScript(cntx, path, []string{
+		"AZBLOBLEASE_EVENT=" + HookEventLeaderChange,
+		"AZBLOBLEASE_WATCH_EVENT=" + event.Event,
+		"AZBLOBLEASE_ACCOUNT_NAME=" + event.StorageAccountName,
+		"AZBLOBLEASE_CONTAINER_NAME=" + event.ContainerName,
+		"AZBLOBLEASE_BLOB_NAME=" + event.BlobName,
+		"AZBLOBLEASE_LEASE_STATE=" + event.LeaseState,
+		"AZBLOBLEASE_PREVIOUS_HOLDER=" + event.PreviousHolder,
+		"AZBLOBLEASE_HOLDER=" + event.NewHolder,
+		"AZBLOBLEASE_EPOCH=" + strconv.FormatInt(event.Epoch, 10),
+	})
+}
+
+// runHookScript runs the script with the event environment variables added to the process environment
+func runHookScript(cntx context.Context, path string, environment []string) error {
+	hook := exec.CommandContext(cntx, path)
+	hook.Env = append(os.Environ(), environment...)
+	if config.NoColor() {
+		hook.Env = append(hook.Env, "NO_COLOR=1")
+	}
+
+	if !config.Silent() {
+		hook.Stdout = os.Stderr
+		hook.Stderr = os.Stderr
+	}
+	return hook.Run()
+}
+
+// hookEnvironment returns the environment variables describing the event
+func hookEnvironment(event string, state models.LeadershipState) []string {
+	return []string{
+		"AZBLOBLEASE_EVENT=" + event,
+		"AZBLOBLEASE_LEADER=" + strconv.FormatBool(state.Leader),
+		"AZBLOBLEASE_LEASE_ID=" + state.LeaseID,
+		"AZBLOBLEASE_ACCOUNT_NAME=" + state.StorageAccountName,
+		"AZBLOBLEASE_CONTAINER_NAME=" + state.ContainerName,
+		"AZBLOBLEASE_BLOB_NAME=" + state.BlobName,
+		"AZBLOBLEASE_HOLDER=" + state.Holder,
+		"AZBLOBLEASE_LEASE_DURATION=" + strconv.Itoa(state.LeaseDurationSeconds),
+		"AZBLOBLEASE_LEASE_EXPIRES_AT=" + state.LeaseExpiresAt,
+		"AZBLOBLEASE_ERROR_MESSAGE=" + state.ErrorMessage,
+	}
+}
+
+// HookObserver runs local scripts when the lease is renewed or lost
+type HookObserver struct {
+	OnRenew string
+	OnLost  string
+}
+
+// Name identifies the observer in diagnostic messages
+func (o *HookObserver) Name() string {
+	return "lifecycle hook"
+}
+
+// Update runs the renew hook while the lease is held and the lost hook once it is not
+func (o *HookObserver) Update(cntx context.Context, state models.LeadershipState) error {
+	if state.Leader {
+		if o.OnRenew == "" {
+			return nil
+		}
+		return RunHook(cntx, o.OnRenew, HookEventRenew, state)
+	}
+
+	if o.OnLost == "" {
+		return nil
+	}
+	return RunHook(cntx, o.OnLost, HookEventLost, state)
+}
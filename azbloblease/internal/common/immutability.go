@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// IsImmutabilityError returns true when err is the storage service refusing to modify or delete a blob protected
+// by an immutability policy or a legal hold
+func IsImmutabilityError(err error) bool {
+	return StorageErrorCode(err) == string(bloberror.BlobImmutableDueToPolicy)
+}
+
+// ContainerImmutability describes the immutable storage settings of the container, e.g. "a legal hold", an
+// empty string when blobs of the container can be modified and deleted
+func ContainerImmutability(cntx context.Context, containerClient *container.Client) (string, error) {
+	containerProps, err := containerClient.GetProperties(cntx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	settings := []string{}
+	if containerProps.HasImmutabilityPolicy != nil && *containerProps.HasImmutabilityPolicy {
+		settings = append(settings, "a time-based retention policy")
+	}
+	if containerProps.HasLegalHold != nil && *containerProps.HasLegalHold {
+		settings = append(settings, "a legal hold")
+	}
+	if containerProps.IsImmutableStorageWithVersioningEnabled != nil && *containerProps.IsImmutableStorageWithVersioningEnabled {
+		settings = append(settings, "version-level immutability support")
+	}
+
+	return strings.Join(settings, " and "), nil
+}
+
+// DescribeImmutabilityError explains an immutability failure of a blob of the container with its immutable storage
+// settings, the service error alone doesn't tell that lease blobs must not live in an immutable container
+func DescribeImmutabilityError(cntx context.Context, containerClient *container.Client, containerName string, err error) string {
+	immutability, propsErr := ContainerImmutability(cntx, containerClient)
+	if propsErr != nil || immutability == "" {
+		immutability = "an immutability policy or a legal hold"
+	}
+
+	return fmt.Sprintf("container %v has %v, lease blob metadata cannot be updated nor the blob deleted while it applies, lease blobs must be kept in a container without immutable storage: %v", containerName, immutability, err)
+}
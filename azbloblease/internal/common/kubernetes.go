@@ -0,0 +1,220 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+const (
+	serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+	microTimeFormat    = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// kubernetesLease holds the coordination.k8s.io/v1 Lease fields managed by the mirror
+type kubernetesLease struct {
+	APIVersion string                  `json:"apiVersion"`
+	Kind       string                  `json:"kind"`
+	Metadata   kubernetesLeaseMetadata `json:"metadata"`
+	Spec       kubernetesLeaseSpec     `json:"spec"`
+}
+
+type kubernetesLeaseMetadata struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type kubernetesLeaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity"`
+	LeaseDurationSeconds *int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int    `json:"leaseTransitions,omitempty"`
+}
+
+// KubernetesLeaseObserver mirrors the blob leadership into a kubernetes coordination.k8s.io/v1 Lease,
+// using the in-cluster service account, so in-cluster components can observe the blob elected leader
+type KubernetesLeaseObserver struct {
+	namespace string
+	name      string
+	baseURL   string
+	token     string
+	client    *http.Client
+}
+
+// NewKubernetesLeaseObserver creates a kubernetes lease mirror from a [namespace/]name reference, the
+// namespace defaults to the one of the pod service account
+func NewKubernetesLeaseObserver(leaseRef string) (*KubernetesLeaseObserver, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes lease mirroring requires running in a kubernetes pod, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountPath + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("an error ocurred while reading service account token: %v", err)
+	}
+
+	caCert, err := ioutil.ReadFile(serviceAccountPath + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("an error ocurred while reading service account ca certificate: %v", err)
+	}
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no pem encoded certificate found in service account ca certificate")
+	}
+
+	namespace, name := "", leaseRef
+	if parts := strings.SplitN(leaseRef, "/", 2); len(parts) == 2 {
+		namespace, name = parts[0], parts[1]
+	}
+
+	if namespace == "" {
+		namespaceBytes, err := ioutil.ReadFile(serviceAccountPath + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("an error ocurred while reading service account namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(namespaceBytes))
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("kubernetes lease name is required")
+	}
+
+	return &KubernetesLeaseObserver{
+		namespace: namespace,
+		name:      name,
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		token:     strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs},
+			},
+		},
+	}, nil
+}
+
+// Name identifies the observer in diagnostic messages
+func (o *KubernetesLeaseObserver) Name() string {
+	return fmt.Sprintf("kubernetes lease %v/%v", o.namespace, o.name)
+}
+
+// Update creates or updates the kubernetes lease, while leader the holder identity and renew time are
+// refreshed, once leadership is lost the holder identity is cleared
+func (o *KubernetesLeaseObserver) Update(cntx context.Context, state models.LeadershipState) error {
+	leaseURL := fmt.Sprintf("%v/apis/coordination.k8s.io/v1/namespaces/%v/leases/%v", o.baseURL, o.namespace, o.name)
+
+	current := kubernetesLease{}
+	status, err := o.do(cntx, http.MethodGet, leaseURL, nil, &current)
+	if err != nil && status != http.StatusNotFound {
+		return err
+	}
+	exists := status != http.StatusNotFound
+
+	// Only the managed fields are sent, as a merge patch when the lease exists, so labels and other
+	// fields set by kubernetes or other tools are preserved
+	now := time.Now().UTC().Format(microTimeFormat)
+	lease := kubernetesLease{
+		APIVersion: "coordination.k8s.io/v1",
+		Kind:       "Lease",
+		Metadata: kubernetesLeaseMetadata{
+			Name:      o.name,
+			Namespace: o.namespace,
+			Annotations: map[string]string{
+				"azbloblease/blob": fmt.Sprintf("%v/%v/%v", state.StorageAccountName, state.ContainerName, state.BlobName),
+			},
+		},
+	}
+
+	if state.Leader {
+		holder := state.Holder
+		if holder == "" {
+			holder = state.LeaseID
+		}
+
+		if current.Spec.HolderIdentity == nil || *current.Spec.HolderIdentity != holder {
+			transitions := 0
+			if current.Spec.LeaseTransitions != nil {
+				transitions = *current.Spec.LeaseTransitions + 1
+			}
+			lease.Spec.LeaseTransitions = &transitions
+			lease.Spec.AcquireTime = &now
+		}
+
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.RenewTime = &now
+		if state.LeaseDurationSeconds > 0 {
+			lease.Spec.LeaseDurationSeconds = &state.LeaseDurationSeconds
+		}
+	} else {
+		lease.Spec.HolderIdentity = nil
+	}
+
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		_, err = o.do(cntx, http.MethodPatch, leaseURL, body, nil)
+	} else {
+		_, err = o.do(cntx, http.MethodPost, fmt.Sprintf("%v/apis/coordination.k8s.io/v1/namespaces/%v/leases", o.baseURL, o.namespace), body, nil)
+	}
+
+	return err
+}
+
+// do sends a request to the kubernetes api server, decoding the response into result when informed
+func (o *KubernetesLeaseObserver) do(cntx context.Context, method, url string, body []byte, result interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(cntx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.token)
+	req.Header.Set("Accept", "application/json")
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("kubernetes api server returned %v: %v", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	if result != nil {
+		err = json.Unmarshal(respBody, result)
+	}
+
+	return resp.StatusCode, err
+}
@@ -0,0 +1,28 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"fmt"
+	"io"
+)
+
+// logSource identifies the tool in syslog and in the Windows event log
+const logSource = "azbloblease"
+
+// OpenLogTarget returns the writer diagnostics are sent to instead of stderr, syslog or the Windows event log,
+// nil for stderr. Lines are written without timestamp since both targets record their own.
+func OpenLogTarget(target string) (io.Writer, error) {
+	switch target {
+	case "", "stderr":
+		return nil, nil
+	case "syslog":
+		return openSyslog()
+	case "eventlog":
+		return openEventLog()
+	}
+	return nil, fmt.Errorf("unknown log target %v, valid values are stderr, syslog and eventlog", target)
+}
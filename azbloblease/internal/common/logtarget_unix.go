@@ -0,0 +1,24 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build !windows
+
+package common
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+)
+
+// openSyslog connects to the local syslog daemon, messages are logged with daemon facility and info severity
+func openSyslog() (io.Writer, error) {
+	return syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, logSource)
+}
+
+// openEventLog fails, the event log only exists on Windows
+func openEventLog() (io.Writer, error) {
+	return nil, fmt.Errorf("eventlog log target is only available on windows")
+}
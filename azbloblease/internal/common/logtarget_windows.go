@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build windows
+
+package common
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// eventlogInformationType is the EVENTLOG_INFORMATION_TYPE event type
+const eventlogInformationType = 4
+
+var (
+	advapi32                 = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSourceW = advapi32.NewProc("RegisterEventSourceW")
+	procReportEventW         = advapi32.NewProc("ReportEventW")
+)
+
+// eventLogWriter reports every line written as an information event of the Application log
+type eventLogWriter struct {
+	handle uintptr
+}
+
+// openSyslog fails, there is no syslog daemon on Windows
+func openSyslog() (io.Writer, error) {
+	return nil, fmt.Errorf("syslog log target is not available on windows, use eventlog")
+}
+
+// openEventLog opens the Application event log with azbloblease as source, the source does not need to be
+// registered, without message file Event Viewer shows the message as the event insertion string
+func openEventLog() (io.Writer, error) {
+	source, err := syscall.UTF16PtrFromString(logSource)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(source)))
+	if handle == 0 {
+		return nil, err
+	}
+	return &eventLogWriter{handle: handle}, nil
+}
+
+// Write reports the line as an event
+func (w *eventLogWriter) Write(line []byte) (int, error) {
+	message, err := syscall.UTF16PtrFromString(strings.TrimRight(strings.Replace(string(line), "\x00", "", -1), "\n"))
+	if err != nil {
+		return 0, err
+	}
+
+	insertionStrings := []*uint16{message}
+	reported, _, err := procReportEventW.Call(w.handle, eventlogInformationType, 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&insertionStrings[0])), 0)
+	if reported == 0 {
+		return 0, err
+	}
+	return len(line), nil
+}
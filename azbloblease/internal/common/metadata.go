@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// SetHolderMetadata records the lease holder information in the blob metadata, existing
+// metadata values are preserved and the update is performed under the lease condition. A handoff
+// successor hint is cleared, the handoff is over once someone holds the lease.
+func SetHolderMetadata(cntx context.Context, blockBlobClient *blockblob.Client, existing map[string]*string, leaseID, holder string, leaseDuration int, epoch int64) error {
+	metadata := utils.MergeMetadata(withoutSuccessor(existing), map[string]string{
+		config.MetadataHolder():        holder,
+		config.MetadataAcquiredAt():    time.Now().UTC().Format(time.RFC3339),
+		config.MetadataLeaseDuration(): strconv.Itoa(leaseDuration),
+		config.MetadataEpoch():         strconv.FormatInt(epoch, 10),
+	})
+
+	_, err := blockBlobClient.SetMetadata(cntx, metadata, &blob.SetMetadataOptions{
+		AccessConditions: &blob.AccessConditions{
+			LeaseAccessConditions: &blob.LeaseAccessConditions{
+				LeaseID: &leaseID,
+			},
+		},
+	})
+
+	return err
+}
+
+// SetRenewalMetadata records the lease renewal time in the blob metadata, existing metadata values are
+// preserved and the update is performed under the lease condition. The updated metadata is returned so
+// it can be used as existing metadata on the next renewal.
+func SetRenewalMetadata(cntx context.Context, blockBlobClient *blockblob.Client, existing map[string]*string, leaseID string) (map[string]*string, error) {
+	metadata := utils.MergeMetadata(existing, map[string]string{
+		config.MetadataLastRenewedAt(): time.Now().UTC().Format(time.RFC3339),
+	})
+
+	_, err := blockBlobClient.SetMetadata(cntx, metadata, &blob.SetMetadataOptions{
+		AccessConditions: &blob.AccessConditions{
+			LeaseAccessConditions: &blob.LeaseAccessConditions{
+				LeaseID: &leaseID,
+			},
+		},
+	})
+	if err != nil {
+		return existing, err
+	}
+
+	return metadata, nil
+}
+
+// SetSuccessorMetadata records the holder designated by a planned handoff and the end of its preference window
+// in the blob metadata, existing metadata values are preserved and the update is performed under the lease condition
+func SetSuccessorMetadata(cntx context.Context, blockBlobClient *blockblob.Client, existing map[string]*string, leaseID, successor string, until time.Time) error {
+	metadata := utils.MergeMetadata(existing, map[string]string{
+		config.MetadataSuccessor():      successor,
+		config.MetadataSuccessorUntil(): until.UTC().Format(time.RFC3339),
+	})
+
+	_, err := blockBlobClient.SetMetadata(cntx, metadata, &blob.SetMetadataOptions{
+		AccessConditions: &blob.AccessConditions{
+			LeaseAccessConditions: &blob.LeaseAccessConditions{
+				LeaseID: &leaseID,
+			},
+		},
+	})
+
+	return err
+}
+
+// DesignatedSuccessor returns the holder designated by a planned handoff and the end of its preference window,
+// false when no hint is recorded in blob metadata or its preference window is over
+func DesignatedSuccessor(metadata map[string]*string) (string, time.Time, bool) {
+	successor := utils.MetadataValue(metadata, config.MetadataSuccessor())
+	until, err := time.Parse(time.RFC3339, utils.MetadataValue(metadata, config.MetadataSuccessorUntil()))
+	if successor == "" || err != nil || !time.Now().Before(until) {
+		return "", time.Time{}, false
+	}
+	return successor, until, true
+}
+
+// withoutSuccessor returns a copy of the blob metadata without the handoff successor hint
+func withoutSuccessor(metadata map[string]*string) map[string]*string {
+	result := map[string]*string{}
+	for k, v := range metadata {
+		if strings.EqualFold(k, config.MetadataSuccessor()) || strings.EqualFold(k, config.MetadataSuccessorUntil()) {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// LastLeaseActivity returns the most recent of the acquisition and renewal times recorded in blob metadata,
+// false when none is present
+func LastLeaseActivity(metadata map[string]*string) (time.Time, bool) {
+	var lastActivity time.Time
+	found := false
+	for _, key := range []string{config.MetadataAcquiredAt(), config.MetadataLastRenewedAt()} {
+		value, err := time.Parse(time.RFC3339, utils.MetadataValue(metadata, key))
+		if err == nil && value.After(lastActivity) {
+			lastActivity = value
+			found = true
+		}
+	}
+	return lastActivity, found
+}
+
+// MetadataEpoch returns the leadership epoch recorded in blob metadata, 0 when not present
+func MetadataEpoch(metadata map[string]*string) int64 {
+	epoch, err := strconv.ParseInt(utils.MetadataValue(metadata, config.MetadataEpoch()), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return epoch
+}
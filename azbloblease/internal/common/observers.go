@@ -0,0 +1,36 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// LeadershipObserver is notified of the leadership state after every renewal attempt
+type LeadershipObserver interface {
+	// Name identifies the observer in diagnostic messages
+	Name() string
+
+	// Update publishes the leadership state
+	Update(cntx context.Context, state models.LeadershipState) error
+}
+
+// StateFileObserver publishes the leadership state to a local file
+type StateFileObserver struct {
+	Path string
+}
+
+// Name identifies the observer in diagnostic messages
+func (o *StateFileObserver) Name() string {
+	return "state file " + o.Path
+}
+
+// Update atomically replaces the state file
+func (o *StateFileObserver) Update(cntx context.Context, state models.LeadershipState) error {
+	return WriteStateFile(o.Path, state)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+)
+
+// privateEndpointError is returned when the blob host resolves outside the expected private endpoint ranges
+type privateEndpointError struct {
+	host    string
+	address string
+}
+
+// Error explains the likely cause, the private dns zone not being used by the resolver of this network
+func (e *privateEndpointError) Error() string {
+	return fmt.Sprintf("blob host %v resolves to %v, outside the expected private endpoint ranges %v, public dns is being used instead of the private dns zone of the endpoint (e.g. privatelink.blob.core.windows.net), check that the zone is linked to this network or use blob-host", e.host, e.address, describeRanges(config.PrivateEndpointRanges()))
+}
+
+// IsPrivateEndpointNotResolved returns true when err is a blob host resolved outside the expected private ranges
+func IsPrivateEndpointNotResolved(err error) bool {
+	var privateErr *privateEndpointError
+	return errors.As(err, &privateErr)
+}
+
+// applyBlobHost replaces the host of the blob endpoint with the configured blob host, keeping scheme and path
+func applyBlobHost(endpoint *url.URL) {
+	if config.BlobHost() != "" {
+		endpoint.Host = config.BlobHost()
+	}
+}
+
+// ValidatePrivateEndpoint checks that every address the host resolves to is within the expected private endpoint
+// ranges, nothing is checked when no ranges are configured. A host that does not resolve is reported as an
+// unreachable endpoint.
+func ValidatePrivateEndpoint(cntx context.Context, host string) error {
+	ranges := config.PrivateEndpointRanges()
+	if ranges == nil {
+		return nil
+	}
+
+	hostname := host
+	if splitHost, _, err := net.SplitHostPort(host); err == nil {
+		hostname = splitHost
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(cntx, hostname)
+	if err != nil {
+		return &endpointUnreachableError{endpoint: hostname, stage: "dns lookup", err: err}
+	}
+
+	for _, address := range addresses {
+		if !inRanges(address.IP, ranges) {
+			return &privateEndpointError{host: hostname, address: address.IP.String()}
+		}
+	}
+	return nil
+}
+
+// ParseAddressRanges parses a comma separated list of address ranges in cidr notation
+func ParseAddressRanges(value string) ([]*net.IPNet, error) {
+	ranges := []*net.IPNet{}
+	for _, cidr := range strings.Split(value, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
+
+// inRanges returns true when ip is within one of the ranges
+func inRanges(ip net.IP, ranges []*net.IPNet) bool {
+	for _, ipNet := range ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRanges returns the ranges comma separated
+func describeRanges(ranges []*net.IPNet) string {
+	values := make([]string, 0, len(ranges))
+	for _, ipNet := range ranges {
+		values = append(values, ipNet.String())
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// armRateLimit limits the requests sent to azure resource manager, nil when unlimited
+var armRateLimit *tokenBucket
+
+// ConfigureARMRateLimit limits the requests sent to azure resource manager to qps requests per second, with
+// bursts of up to qps requests, so a process maintaining many leases does not trip subscription level
+// throttling. 0 removes the limit.
+func ConfigureARMRateLimit(qps float64) {
+	armRateLimit = nil
+	if qps <= 0 {
+		return
+	}
+
+	burst := math.Max(1, math.Ceil(qps))
+	armRateLimit = &tokenBucket{
+		rate:   qps,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// tokenBucket is a token bucket refilled at rate tokens per second up to burst tokens
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Do waits for a token before sending the request, every retry takes its own token
+func (b *tokenBucket) Do(req *policy.Request) (*http.Response, error) {
+	for {
+		b.mutex.Lock()
+		now := time.Now()
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mutex.Unlock()
+			return req.Next()
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Raw().Context().Done():
+			timer.Stop()
+			return nil, req.Raw().Context().Err()
+		}
+	}
+}
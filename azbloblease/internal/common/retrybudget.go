@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// Time spent retrying failed requests by all clients, in nanoseconds, and whether the retry budget was exhausted,
+// i.e. the circuit is open
+var retryTime, retryBudgetOpen int64
+
+// retryState tracks the tries of a request across the sdk retry policy
+type retryState struct {
+	tries       int
+	lastTriedAt time.Time
+}
+
+// retryBudgetError is returned instead of retrying a request once the retry budget is exhausted, the sdk retry
+// policy does not retry it
+type retryBudgetError struct{}
+
+// Error describes the exhausted retry budget
+func (e *retryBudgetError) Error() string {
+	return fmt.Sprintf("retry budget exhausted (%v), failing fast instead of retrying the request", describeRetryBudget())
+}
+
+// NonRetriable marks the error as not retriable by the sdk retry policy
+func (e *retryBudgetError) NonRetriable() {}
+
+// retryStatePolicy attaches the retry state to the request, it runs once per request before the retry policy
+type retryStatePolicy struct{}
+
+// Do attaches a new retry state to the request
+func (p retryStatePolicy) Do(req *policy.Request) (*http.Response, error) {
+	req.SetOperationValue(&retryState{})
+	return req.Next()
+}
+
+// retryBudgetPolicy accounts the retries of a request against the retry budget of the invocation, it runs once
+// per try after the retry policy. The first try of a request is always sent, so held leases can still be
+// released once the circuit is open.
+type retryBudgetPolicy struct{}
+
+// Do fails the try when it is a retry and the retry budget is exhausted, otherwise it sends it and accounts the
+// time since the previous try ended, backoff included
+func (p retryBudgetPolicy) Do(req *policy.Request) (*http.Response, error) {
+	var state *retryState
+	if !req.OperationValue(&state) || state == nil {
+		return req.Next()
+	}
+
+	state.tries++
+	if state.tries > 1 && retryBudgetExhausted() {
+		if atomic.CompareAndSwapInt64(&retryBudgetOpen, 0, 1) {
+			utils.Warn(fmt.Sprintf("retry budget exhausted (%v), failing fast instead of retrying requests", describeRetryBudget()))
+		}
+		return nil, &retryBudgetError{}
+	}
+
+	resp, err := req.Next()
+	if state.tries > 1 {
+		atomic.AddInt64(&retryTime, int64(time.Since(state.lastTriedAt)))
+	}
+	state.lastTriedAt = time.Now()
+	return resp, err
+}
+
+// retryBudgetExhausted returns true when the retries made, or the time spent on them, reached the configured
+// retry budget, a budget of 0 is unbounded
+func retryBudgetExhausted() bool {
+	if RetryBudgetOpen() {
+		return true
+	}
+
+	// The try being accounted was already counted as a retry
+	if config.RetryBudgetAttempts() > 0 && Retries() > int64(config.RetryBudgetAttempts()) {
+		return true
+	}
+	return config.RetryBudget() > 0 && time.Duration(atomic.LoadInt64(&retryTime)) >= config.RetryBudget()
+}
+
+// RetryBudgetOpen returns true once a retry was refused due to the exhausted retry budget, further attempts of an
+// operation would fail fast as well
+func RetryBudgetOpen() bool {
+	return atomic.LoadInt64(&retryBudgetOpen) == 1
+}
+
+// IsRetryBudgetExhausted returns true when err is a request that was not retried due to the exhausted retry budget
+func IsRetryBudgetExhausted(err error) bool {
+	var budgetErr *retryBudgetError
+	return errors.As(err, &budgetErr)
+}
+
+// describeRetryBudget returns the retries made and the time spent on them
+func describeRetryBudget() string {
+	return fmt.Sprintf("%v retries in %v", Retries(), time.Duration(atomic.LoadInt64(&retryTime)).Round(time.Millisecond))
+}
@@ -0,0 +1,35 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// SoftDeleted returns true when a soft-deleted blob with the exact name is retained in the container, it is
+// invisible to blob properties until restored or permanently deleted after the retention period
+func SoftDeleted(cntx context.Context, containerClient *container.Client, blobName string) (bool, error) {
+	pager := containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &blobName,
+		Include: container.ListBlobsInclude{Deleted: true},
+	})
+	for pager.More() {
+		page, err := pager.NextPage(cntx)
+		if err != nil {
+			return false, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			if item.Name != nil && *item.Name == blobName && item.Deleted != nil && *item.Deleted {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// WriteStateFile atomically replaces the local leadership state file, the state is written to a
+// temporary file in the same directory and renamed so readers never observe a partial document
+func WriteStateFile(path string, state models.LeadershipState) error {
+	state.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	stateJSON, err := json.MarshalIndent(state, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.Write(stateJSON)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tempFile.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
+
+// ReadStateFile reads the local leadership state file
+func ReadStateFile(path string) (models.LeadershipState, error) {
+	state := models.LeadershipState{}
+
+	stateJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(stateJSON, &state)
+	return state, err
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// statsd is the client lease operations are reported to, nil when disabled
+var statsd *statsdClient
+
+// statsdClient sends metrics over udp, losing them when nobody listens rather than failing the lease operation
+type statsdClient struct {
+	conn      net.Conn
+	dogStatsD bool
+}
+
+// ConfigureStatsD enables reporting lease operations to the StatsD server at address (host:port), with
+// dogStatsD the storage account, container and blob name are sent as DogStatsD tags. An empty address
+// disables reporting.
+func ConfigureStatsD(address string, dogStatsD bool) error {
+	statsd = nil
+	if address == "" {
+		return nil
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+
+	statsd = &statsdClient{
+		conn:      conn,
+		dogStatsD: dogStatsD,
+	}
+	return nil
+}
+
+// ReportLeaseOperation reports the outcome and duration of a lease operation attempt as the
+// azbloblease.<operation>.success or azbloblease.<operation>.failure counter and the
+// azbloblease.<operation>.duration timing in milliseconds
+func ReportLeaseOperation(operation string, succeeded bool, duration time.Duration, accountName, container, blobName string) {
+	if statsd == nil {
+		return
+	}
+
+	outcome := "success"
+	if !succeeded {
+		outcome = "failure"
+	}
+
+	tags := ""
+	if statsd.dogStatsD {
+		tags = fmt.Sprintf("|#account:%v,container:%v,blob:%v", statsdTag(accountName), statsdTag(container), statsdTag(blobName))
+	}
+
+	// Both metrics in one datagram, separated by a line break as StatsD servers accept
+	fmt.Fprintf(statsd.conn, "azbloblease.%v.%v:1|c%v\nazbloblease.%v.duration:%v|ms%v", operation, outcome, tags, operation, duration.Milliseconds(), tags)
+}
+
+// statsdTag removes the characters that delimit DogStatsD tags from a tag value
+func statsdTag(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_").Replace(value)
+}
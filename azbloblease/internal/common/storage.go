@@ -7,14 +7,18 @@ package common
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
@@ -39,7 +43,7 @@ func GetAccountProperties(cntx context.Context, accountsClient armstorage.Accoun
 }
 
 // GetAccountBlobEndpoint gets the url of the blobendpoint, needed by azblob package
-func GetAccountBlobEndpoint(cntx context.Context, accountsClient *armstorage.AccountsClient, resourceGroupName, accountName string) string {
+func GetAccountBlobEndpoint(cntx context.Context, accountsClient *armstorage.AccountsClient, resourceGroupName, accountName string) (string, error) {
 	// Getting Storage Account Properties to identify the blob endpoint
 	storageAccountProps, err := GetAccountProperties(
 		cntx,
@@ -49,11 +53,42 @@ func GetAccountBlobEndpoint(cntx context.Context, accountsClient *armstorage.Acc
 	)
 
 	if err != nil {
-		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining account properties: %v.", err), config.Stderr())
-		return ""
+		return "", fmt.Errorf("an error ocurred while obtaining account properties: %w", err)
 	}
 
-	return *storageAccountProps.Properties.PrimaryEndpoints.Blob
+	return *storageAccountProps.Properties.PrimaryEndpoints.Blob, nil
+}
+
+// GetAccountSecondaryBlobEndpoint gets the url of the secondary blob endpoint, only available on RA-GRS and RA-GZRS accounts
+func GetAccountSecondaryBlobEndpoint(cntx context.Context, accountsClient armstorage.AccountsClient, resourceGroupName, accountName string) (string, error) {
+	storageAccountProps, err := GetAccountProperties(cntx, accountsClient, resourceGroupName, accountName)
+	if err != nil {
+		return "", err
+	}
+
+	if storageAccountProps.Properties.SecondaryEndpoints == nil || storageAccountProps.Properties.SecondaryEndpoints.Blob == nil {
+		return "", fmt.Errorf("storage account %v has no secondary blob endpoint, read access geo-redundant replication is required", accountName)
+	}
+
+	return *storageAccountProps.Properties.SecondaryEndpoints.Blob, nil
+}
+
+// IsEndpointUnavailable returns true when an error means the endpoint could not serve the request, either
+// because it was not reachable (dns or network failure) or because it answered with a server error. Cancelled
+// or timed out contexts, credential and other client side errors are not endpoint failures.
+func IsEndpointUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var responseErr *azcore.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return IsEndpointUnreachable(err) || errors.As(err, &dnsErr) || errors.As(err, &opErr)
 }
 
 // GetStorageClient gets a storage client
@@ -81,12 +116,19 @@ func GetStorageClient(subscriptionID, environment, cloudConfigFile string, cred
 				return armstorage.AccountsClient{}, fmt.Errorf("an error ocurred while importing cloud config information from json file: %v", err)
 			}
 
+			// ADFS issues tokens for the resource id registered for azure resource manager, which on
+			// azure stack hub differs from the resource manager endpoint
+			audience := cloudInfo.Endpoints.ResourceManagerEndpoint
+			if config.ADFS() && cloudInfo.Endpoints.ResourceManagerAudience != "" {
+				audience = cloudInfo.Endpoints.ResourceManagerAudience
+			}
+
 			cloudConfig = cloud.Configuration{
 				ActiveDirectoryAuthorityHost: cloudInfo.Endpoints.ActiveDirectoryAuthorityHost,
 				Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
 					cloud.ResourceManager: {
 						Endpoint: cloudInfo.Endpoints.ResourceManagerEndpoint,
-						Audience: cloudInfo.Endpoints.ResourceManagerEndpoint,
+						Audience: audience,
 					},
 				},
 			}
@@ -96,10 +138,16 @@ func GetStorageClient(subscriptionID, environment, cloudConfigFile string, cred
 		cloudConfig = cloud.AzurePublic
 	}
 
+	clientOptions := ClientOptions()
+	clientOptions.Cloud = cloudConfig
+
+	// Only management requests are rate limited, data plane requests are not subject to arm throttling
+	if armRateLimit != nil {
+		clientOptions.PerRetryPolicies = append(clientOptions.PerRetryPolicies, armRateLimit)
+	}
+
 	options := arm.ClientOptions{
-		ClientOptions: azcore.ClientOptions{
-			Cloud: cloudConfig,
-		},
+		ClientOptions: clientOptions,
 	}
 
 	storageClientFactory, err := armstorage.NewClientFactory(subscriptionID, cred, &options)
@@ -110,26 +158,97 @@ func GetStorageClient(subscriptionID, environment, cloudConfigFile string, cred
 	return *storageClientFactory.NewAccountsClient(), nil
 }
 
-// GetBlobClient gets a blob client
-func GetBlobClient(cntx context.Context, storageAccountClient armstorage.AccountsClient, accountName, resourceGroupName string, cred azcore.TokenCredential) (models.AzBlobClient, error) {
+// GetStorageEndpointSuffix returns the storage endpoint suffix of a cloud environment, for custom clouds
+// it comes from suffixes.storageEndpoint of the cloud config file, unless it is overridden
+func GetStorageEndpointSuffix(environment, cloudConfigFile string) (string, error) {
+	if config.StorageEndpointSuffixOverride() != "" {
+		return config.StorageEndpointSuffixOverride(), nil
+	}
+
+	if environment != "CUSTOMCLOUD" {
+		return config.StorageEndpointSuffix(environment), nil
+	}
+
+	cloudInfo, err := utils.ImportCloudConfigJson(cloudConfigFile)
+	if err != nil {
+		return "", fmt.Errorf("an error ocurred while importing cloud config information from json file: %v", err)
+	}
+
+	if cloudInfo.Suffixes.StorageEndpoint == "" {
+		return "", fmt.Errorf("suffixes.storageEndpoint is required in cloud config file to build the blob endpoint without azure resource manager")
+	}
+
+	return cloudInfo.Suffixes.StorageEndpoint, nil
+}
+
+// BuildBlobEndpoint returns the blob endpoint of a storage account from its name and the storage endpoint suffix
+func BuildBlobEndpoint(accountName, storageEndpointSuffix string) string {
+	return fmt.Sprintf("https://%v.blob.%v/", accountName, strings.TrimPrefix(storageEndpointSuffix, "."))
+}
+
+// GetBlobClient gets a blob client, the blob endpoint is obtained from azure resource manager
+// unless config.SkipARM() is set, in which case it is built from the storage endpoint suffix
+func GetBlobClient(cntx context.Context, storageAccountClient armstorage.AccountsClient, accountName, resourceGroupName, environment, cloudConfigFile string, cred azcore.TokenCredential) (models.AzBlobClient, error) {
 	result := models.AzBlobClient{}
 
 	// Getting blob endpoint
-	blobEndppointURL, err := url.Parse(
-		GetAccountBlobEndpoint(cntx, &storageAccountClient, resourceGroupName, accountName),
-	)
+	blobEndpoint := ""
+	if config.SkipARM() {
+		storageEndpointSuffix, err := GetStorageEndpointSuffix(environment, cloudConfigFile)
+		if err != nil {
+			return result, err
+		}
+		blobEndpoint = BuildBlobEndpoint(accountName, storageEndpointSuffix)
+	} else {
+		var err error
+		blobEndpoint, err = GetAccountBlobEndpoint(cntx, &storageAccountClient, resourceGroupName, accountName)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	blobEndppointURL, err := url.Parse(blobEndpoint)
 
 	if err != nil {
 		return result, fmt.Errorf("an error ocurred while obtaining blob endpoint url: %v", err)
 	}
 
+	// Private endpoints are reached through the blob host override or the private dns zone of the network
+	applyBlobHost(blobEndppointURL)
+	err = ValidatePrivateEndpoint(cntx, blobEndppointURL.Host)
+	if err != nil {
+		return result, err
+	}
+
 	url := blobEndppointURL.String()
 
 	// Getting a blob client to be used in container operations
-	blobClient, err := azblob.NewClient(url, cred, nil)
+	blobClient, err := NewServiceClient(url, accountName, cred)
 	if err != nil {
 		return result, fmt.Errorf("an error ocurred while obtaining az blob client: %v", err)
 	}
 
 	return models.AzBlobClient{Client: blobClient, URL: url}, nil
 }
+
+// GetBlockBlobClient gets a block blob client for a blob of a storage account
+func GetBlockBlobClient(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, cred azcore.TokenCredential) (*blockblob.Client, error) {
+	storageAccountClient, err := GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		return nil, fmt.Errorf("an error ocurred while getting storage account client: %v", err)
+	}
+
+	azBlobClient, err := GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		return nil, err
+	}
+
+	blobURL := fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, blobName)
+
+	blockBlobClient, err := NewBlockBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err)
+	}
+
+	return blockBlobClient, nil
+}
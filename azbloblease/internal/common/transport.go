@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+)
+
+// transport is the http transport shared by management, data plane and credential clients, nil uses the sdk default
+var transport policy.Transporter
+
+// ConfigureTransport builds the shared http transport from the tls and timeout settings of the global
+// configuration, the sdk default transport is kept when no setting differs from the defaults
+func ConfigureTransport() error {
+	if config.CABundle() == "" && !config.InsecureSkipVerify() && config.ConnectTimeout() == 0 && config.ResponseHeaderTimeout() == 0 {
+		transport = nil
+		return nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.InsecureSkipVerify(),
+	}
+
+	if config.CABundle() != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		pem, err := ioutil.ReadFile(config.CABundle())
+		if err != nil {
+			return fmt.Errorf("an error ocurred while reading ca bundle: %v", err)
+		}
+
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no pem encoded certificate found in ca bundle %v", config.CABundle())
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.TLSClientConfig = tlsConfig
+	httpTransport.ResponseHeaderTimeout = config.ResponseHeaderTimeout()
+
+	if config.ConnectTimeout() > 0 {
+		httpTransport.DialContext = (&net.Dialer{
+			Timeout:   config.ConnectTimeout(),
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		httpTransport.TLSHandshakeTimeout = config.ConnectTimeout()
+	}
+
+	transport = &http.Client{Transport: httpTransport}
+	return nil
+}
+
+// RetryOptions returns the retry policy shared by management, data plane and credential clients,
+// zero values keep the sdk defaults
+func RetryOptions() policy.RetryOptions {
+	return policy.RetryOptions{
+		MaxRetries:    config.MaxRetries(),
+		RetryDelay:    config.RetryDelay(),
+		MaxRetryDelay: config.MaxRetryDelay(),
+	}
+}
+
+// Transport returns the shared http transport, nil when the sdk default must be used
+func Transport() policy.Transporter {
+	return transport
+}
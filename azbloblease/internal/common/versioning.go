@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package common
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// VersioningEnabled returns true when the version id of the blob properties is set, the storage service only
+// returns it on accounts with blob versioning enabled
+func VersioningEnabled(versionID *string) bool {
+	return versionID != nil && *versionID != ""
+}
+
+// PreviousVersions returns the version ids of the versions of the blob other than the current one, every
+// metadata update of the lock blob leaves one behind on accounts with blob versioning enabled
+func PreviousVersions(cntx context.Context, containerClient *container.Client, blobName string) ([]string, error) {
+	versionIDs := []string{}
+
+	pager := containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &blobName,
+		Include: container.ListBlobsInclude{Versions: true},
+	})
+	for pager.More() {
+		page, err := pager.NextPage(cntx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			// The prefix also matches longer blob names
+			if item.Name == nil || *item.Name != blobName || item.VersionID == nil {
+				continue
+			}
+			if item.IsCurrentVersion != nil && *item.IsCurrentVersion {
+				continue
+			}
+			versionIDs = append(versionIDs, *item.VersionID)
+		}
+	}
+
+	return versionIDs, nil
+}
+
+// DeletePreviousVersions deletes the versions of the blob other than the current one and returns how many were
+// deleted, the lease only protects the current version so no lease condition is needed
+func DeletePreviousVersions(cntx context.Context, containerClient *container.Client, blockBlobClient *blockblob.Client, blobName string) (int, error) {
+	versionIDs, err := PreviousVersions(cntx, containerClient, blobName)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, versionID := range versionIDs {
+		versionClient, err := blockBlobClient.WithVersionID(versionID)
+		if err != nil {
+			return deleted, err
+		}
+
+		_, err = versionClient.Delete(cntx, nil)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
@@ -6,30 +6,166 @@
 package config
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
+	"time"
 )
 
 // Constants
 const (
 	version              = "2.0.2"
 	blobName             = "azblobleaseblob"
+	cloudConfigEnvVar    = "AZBLOBLEASE_CLOUD_CONFIG"
+	chaosEnvVar          = "AZBLOBLEASE_CHAOS"
+	apiKeyEnvVar         = "AZBLOBLEASE_API_KEY"
+	accountKeyEnvVar     = "AZBLOBLEASE_ACCOUNT_KEY"
+	sasTokenEnvVar       = "AZBLOBLEASE_SAS_TOKEN"
+	tokenCacheKeyEnvVar  = "AZBLOBLEASE_TOKEN_CACHE_KEY"
 	success              = "Success"
 	fail                 = "Fail"
 	successAlreadyExists = "SuccessAlreadyExists"
 	successRenew         = "SuccessOnRenew"
+	contended            = "Contended"
+	partialSuccess       = "PartialSuccess"
+
+	// Longest initial acquire delay, taken by priority 1 candidates, higher priorities wait proportionally less
+	priorityBaseDelay = 10 * time.Second
+
+	// Blob metadata keys used to record lease holder information
+	metadataHolder         = "holder"
+	metadataAcquiredAt     = "acquiredAt"
+	metadataLeaseDuration  = "leaseDuration"
+	metadataEpoch          = "epoch"
+	metadataLastRenewedAt  = "lastRenewedAt"
+	metadataSuccessor      = "successor"
+	metadataSuccessorUntil = "successorUntil"
+	metadataReader         = "azbloblease_reader_"
 )
 
 // Variables locally and globally scoped
 var (
-	userAgent         = "azblobleaseclient"                                                                      // UserAgent - add identification to clients
-	stdout            = log.New(os.Stdout, "", log.LstdFlags)                                                    // Stdout - standard stream output for logs
-	stdoutJSON        = log.New(os.Stdout, "", 0)                                                                // stdoutJSON - standard output without adding prefixes
-	stderr            = log.New(os.Stderr, "", log.LstdFlags)                                                    // StdErr - Error stream output for logs
-	validEnvironments = []string{"AZUREPUBLICCLOUD", "AZUREUSGOVERNMENTCLOUD", "AZURECHINACLOUD", "CUSTOMCLOUD"} // validEnvironments supported Azure cloud types
+	userAgent          = "azblobleaseclient"                                                                      // UserAgent - add identification to clients
+	stdout             = log.New(utcTimestampWriter{os.Stdout}, "", 0)                                            // Stdout - standard stream output for logs
+	stdoutJSON         = log.New(os.Stdout, "", 0)                                                                // stdoutJSON - standard output without adding prefixes
+	stderr             = log.New(utcTimestampWriter{os.Stderr}, "", 0)                                            // StdErr - Error stream output for logs, prefixed with RFC3339 UTC timestamps
+	validEnvironments  = []string{"AZUREPUBLICCLOUD", "AZUREUSGOVERNMENTCLOUD", "AZURECHINACLOUD", "CUSTOMCLOUD"} // validEnvironments supported Azure cloud types
+	skipARM            = false                                                                                    // skipARM builds blob endpoints locally instead of querying azure resource manager
+	authorityHost      = ""                                                                                       // authorityHost azure active directory authority host override
+	adfs               = false                                                                                    // adfs authenticates against active directory federation services (azure stack hub)
+	storageAudience    = ""                                                                                       // storageAudience token audience of the storage data plane, empty uses the sdk default
+	caBundle           = ""                                                                                       // caBundle pem file with additional certificate authorities trusted by the http transport
+	jitterPercent      = 0                                                                                        // jitterPercent random spread, in percent, applied to acquire and renew wait intervals
+	skipExistsCheck    = false                                                                                    // skipExistsCheck acquires leases without reading blob properties first
+	precheckTimeout    = time.Duration(0)                                                                         // precheckTimeout maximum time of the connectivity pre-check of the blob endpoint before acquiring, 0 skips it
+	undeleteBlob       = false                                                                                    // undeleteBlob restores a soft-deleted lease blob instead of creating a new one
+	deleteOldVersions  = false                                                                                    // deleteOldVersions deletes the versions metadata updates leave behind on accounts with blob versioning enabled
+	silent             = false                                                                                    // silent discards diagnostics, only the result is written to stdout
+	noColor            = false                                                                                    // noColor disables ansi colors, also asked to hook scripts
+	debug              = false                                                                                    // debug writes debug diagnostics, e.g. the credential used to authenticate
+	outputFormat       = "json"                                                                                   // outputFormat json or gha (github actions step outputs in addition to json)
+	diagFormat         = "text"                                                                                   // diagFormat text or json, format of the diagnostics written to stderr
+	userAgentSuffix    = ""                                                                                       // userAgentSuffix appended to the user agent of all requests, e.g. workload name
+	correlationID      = ""                                                                                       // correlationID sent as client request id of all requests
+	accountKey         = ""                                                                                       // accountKey storage account shared key used instead of azure ad tokens on the data plane
+	sasToken           = ""                                                                                       // sasToken shared access signature used instead of azure ad tokens on the data plane
+	insecureSkipVerify = false                                                                                    // insecureSkipVerify disables tls certificate verification, for lab use only
+
+	maxRetries            int32         // maxRetries maximum retries of failed requests, 0 uses the sdk default and -1 disables retries
+	retryDelay            time.Duration // retryDelay initial delay between retries, 0 uses the sdk default
+	maxRetryDelay         time.Duration // maxRetryDelay maximum delay between retries, 0 uses the sdk default
+	connectTimeout        time.Duration // connectTimeout maximum time to establish a connection, 0 uses the transport default
+	responseHeaderTimeout time.Duration // responseHeaderTimeout maximum time to wait for response headers, 0 waits indefinitely
+	imdsProbeTimeout      time.Duration // imdsProbeTimeout maximum time to wait for the managed identity endpoint probe, 0 uses the sdk default
+	imdsProbeRetries      int           // imdsProbeRetries additional managed identity endpoint probe attempts before moving on in the credential chain
+	disableIMDSProbe      bool          // disableIMDSProbe excludes managed identity from the default credential chain
+
+	shutdownTimeout = 10 * time.Second // shutdownTimeout maximum time spent releasing leases and draining requests once interrupted
+
+	strictOutput = false // strictOutput only the json result is written to stdout, everything else goes to stderr
+
+	clockSkewThreshold = 5 * time.Second // clockSkewThreshold clock skew against storage above which a warning is emitted, 0 disables it
+
+	retryBudget         time.Duration // retryBudget maximum time spent retrying failed requests per invocation, 0 is unbounded
+	retryBudgetAttempts int           // retryBudgetAttempts maximum retries of failed requests per invocation, 0 is unbounded
+
+	tenantID              = ""                           // tenantID tenant tokens are requested from, e.g. the customer tenant owning the storage account
+	clientID              = ""                           // clientID multi-tenant application tokens are requested for, with a client assertion from the current identity
+	tokenExchangeAudience = "api://AzureADTokenExchange" // tokenExchangeAudience audience of the current identity token used as client assertion
+	federatedTokenFile    = ""                           // federatedTokenFile file holding an oidc token of an external identity provider used as client assertion
+	tokenCacheFile        = ""                           // tokenCacheFile encrypted file access tokens are shared through across invocations
+
+	storageEndpointSuffixOverride = "" // storageEndpointSuffixOverride storage endpoint suffix used instead of the cloud one
+
+	blobHost                     = ""                                                 // blobHost host used instead of the one of the blob endpoint, e.g. the privatelink name of a private endpoint
+	privateEndpointRanges        []*net.IPNet                                         // privateEndpointRanges address ranges the blob host must resolve to, nil skips the check
+	defaultPrivateEndpointRanges = "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7" // defaultPrivateEndpointRanges private address ranges, rfc 1918 and unique local ipv6
+
+	// storageEndpointSuffixes storage endpoint suffixes of well known Azure cloud types
+	storageEndpointSuffixes = map[string]string{
+		"AZUREPUBLICCLOUD":       "core.windows.net",
+		"AZUREUSGOVERNMENTCLOUD": "core.usgovcloudapi.net",
+		"AZURECHINACLOUD":        "core.chinacloudapi.cn",
+	}
 
 	errorCodes = map[string]int{
-		"InvalidErrorCode":                           10,  // Used when an error name passed to GetErrorCode is invalid
+		"ErrUnhealthy":                               1,   // Lease not held or not renewed recently, docker health checks only accept 1 as unhealthy
+		"ErrLeaseAlreadyPresent":                     2,   // Lease currently held by someone else, an expected outcome of opportunistic acquisition
+		"ErrOperationFailed":                         3,   // Operation failed for a reason without a dedicated exit code, e.g. the storage endpoint could not be reached
+		"InvalidErrorCode":                           10,  // Used when an error name passed to ErrorCode is invalid
+		"ErrInvalidArgumentTags":                     20,  // Invalid blob index tags, expected format is key=value,key=value with letters, digits, spaces and + - . / : = _
+		"ErrInvalidArgumentBlobSize":                 21,  // Blob size cannot be negative
+		"ErrInvalidArgumentContentFile":              22,  // Content file could not be read
+		"ErrInvalidArgumentBatch":                    23,  // Invalid batch, lease ids must be one per blob or a single one and batch cannot be combined with quorum mode
+		"ErrInvalidArgumentShards":                   24,  // Invalid shards, either a list of blob names or a prefix with a count greater than 0 must be informed
+		"ErrInvalidArgumentQuorumAccounts":           25,  // Invalid quorum accounts, expected format is [subscriptionid/]resourcegroup/account and at least 3 accounts in total
+		"ErrInvalidArgumentCABundle":                 26,  // CA bundle could not be read or has no pem encoded certificate
+		"ErrInvalidArgumentHTTPTuning":               27,  // Invalid retry or timeout settings, max retries cannot be less than -1 and durations cannot be negative
+		"ErrInvalidArgumentParams":                   28,  // Invalid params json document, expected format is {"subcommand": "<name>", "options": {"<flag>": <value>}}
+		"ErrInvalidArgumentOutput":                   29,  // Invalid output format
+		"ErrInvalidArgumentStateFile":                30,  // State file is required by healthcheck and resume, must identify a lease for release and is only supported when acquiring or renewing a single blob outside quorum mode
+		"ErrInvalidArgumentKubernetesLease":          31,  // Kubernetes lease mirroring requires running in a pod and is only supported when renewing a single blob outside quorum mode
+		"ErrInvalidArgumentRenewAtFraction":          32,  // Renew at fraction must be between 0 and 1 and is not supported in quorum mode
+		"ErrInvalidArgumentJitter":                   33,  // Jitter must be between 0 and 50 percent
+		"ErrInvalidArgumentBlobType":                 34,  // Invalid blob type, valid values are block, page and append
+		"ErrInvalidArgumentContainerMetadata":        35,  // Invalid container metadata, expected format is key=value,key=value
+		"ErrInvalidArgumentOlderThan":                36,  // Older than threshold of purge must be greater than 0
+		"ErrInvalidArgumentSteal":                    37,  // Steal is not supported in sharded or quorum mode
+		"ErrInvalidArgumentMaxWait":                  38,  // Max wait cannot be negative and requires waittimesec greater than 0
+		"ErrInvalidArgumentHooks":                    39,  // Renew and lost hooks are only supported when renewing a single blob outside quorum mode
+		"ErrInvalidArgumentParallelism":              40,  // Parallelism must be greater than 0
+		"ErrInvalidArgumentCycles":                   41,  // Bench cycles must be greater than 0
+		"ErrInvalidArgumentChaos":                    42,  // Invalid fault injection settings, expected format is fail=<probability>,delay=<probability>,delay-duration=<duration>,drop-renew=<probability>
+		"ErrInvalidArgumentAPIKey":                   43,  // An api key is required to serve the lease api on a tcp address, only unix sockets can go without one
+		"ErrInvalidArgumentAgentConfig":              45,  // Agent config file is required and must describe at least one valid lease
+		"ErrInvalidArgumentStatsD":                   46,  // Invalid StatsD server address, expected format is host:port
+		"ErrInvalidArgumentLogTarget":                47,  // Invalid or unavailable log target, valid values are stderr, syslog (not on windows) and eventlog (windows only)
+		"ErrInvalidArgumentARMQPS":                   48,  // Azure resource manager requests per second cannot be negative
+		"ErrInvalidArgumentSharedKeyAuth":            49,  // Account key and sas token environment variables cannot be both set
+		"ErrInvalidArgumentIMDSProbe":                50,  // Managed identity endpoint probe timeout and retries cannot be negative
+		"ErrInvalidArgumentTenant":                   51,  // Client id requires a tenant id and neither is supported with adfs
+		"ErrInvalidArgumentFederatedTokenFile":       52,  // Federated token file requires client id and tenant id and must be readable
+		"ErrInvalidArgumentTokenCache":               53,  // Token cache requires the token cache key environment variable
+		"ErrInvalidArgumentCreateIfMissing":          54,  // Create if missing is only supported when acquiring a single blob
+		"ErrInvalidArgumentLeaseIDFormat":            55,  // Lease ID is not a GUID
+		"ErrInvalidArgumentAccessTier":               56,  // Invalid access tier, valid values are Hot, Cool and Cold, only supported with block blobs
+		"ErrInvalidArgumentBlobMetadata":             57,  // Invalid blob metadata, expected format is key=value,key=value with keys not used for lease holder information or readers
+		"ErrInvalidArgumentStaleAfter":               58,  // Stale after cannot be negative
+		"ErrInvalidArgumentWatchInterval":            59,  // Watch interval must be greater than 0 and count cannot be negative
+		"ErrInvalidArgumentHandoff":                  60,  // Handoff requires a successor and a preference window greater than 0
+		"ErrInvalidArgumentPriority":                 61,  // Priority must be between 0 and 100
+		"ErrInvalidArgumentStartupJitter":            62,  // Startup jitter cannot be negative
+		"ErrInvalidArgumentShutdownTimeout":          63,  // Shutdown timeout must be greater than 0
+		"ErrInvalidArgumentDiagFormat":               64,  // Invalid diagnostics format, valid values are text and json
+		"ErrInvalidArgumentSemaphore":                65,  // Invalid semaphore name, slots, action or slot
+		"ErrInvalidArgumentRWLock":                   66,  // Invalid read/write lock mode, action or read ttl, or missing reader id
+		"ErrInvalidArgumentRenewThreshold":           67,  // Invalid renew threshold, it must be below the lease duration and cannot be combined with renew-at-fraction
+		"ErrInvalidArgumentClockSkewThreshold":       68,  // Invalid clock skew threshold, it cannot be negative
+		"ErrInvalidArgumentRetryBudget":              69,  // Invalid retry budget, neither its time nor its attempts can be negative
+		"ErrInvalidArgumentPrecheck":                 70,  // Invalid pre-check timeout, it cannot be negative nor used in quorum mode
+		"ErrInvalidArgumentPrivateEndpoint":          71,  // Invalid blob host or private endpoint address range
 		"ErrInvalidArgument":                         100, // Generic invalid argument return code
 		"ErrInvalidArgumentMissingResourceGroupName": 110, // Missing resource group name
 		"ErrInvalidArgumentMissingAccountName":       120, // Missing storage account name
@@ -41,21 +177,51 @@ var (
 		"ErrCloudConfigFileOnlyForCustomCloud":       180, // Cloud config file is only supported for custom cloud
 		"ErrCloudConfigFileNotFound":                 181, // Cloud config file not found
 		"ErrCloudConfigFileRequiredForCustomCloud":   182, // Cloud config file is required for custom cloud
+		"ErrCloudConfigInvalidJSON":                  183, // Cloud config could not be read or is not valid json
+		"ErrCloudConfigInvalidField":                 184, // Cloud config has a missing or invalid field
+		"ErrDataPlaneAuthorization":                  200, // Request not authorized (401/403), e.g. missing Storage Blob Data Contributor role
+		"ErrDataPlaneNotFound":                       201, // Storage account, container or blob not found (404)
+		"ErrDataPlaneConflict":                       202, // Lease conflict (409/412), e.g. lease id mismatch or lease lost
+		"ErrDataPlaneTimeout":                        203, // Request timed out
+		"ErrLeaseNotHeld":                            204, // Renew pre-flight found the lease not active or recorded for another holder in blob metadata
+		"ErrBlobImmutable":                           205, // Blob could not be modified or deleted due to an immutability policy or legal hold of its container
+		"ErrRetryBudgetExhausted":                    206, // Retry budget of the invocation exhausted, failing fast instead of retrying
+		"ErrEndpointUnreachable":                     207, // Connectivity pre-check could not reach the blob endpoint, e.g. dns, firewall or proxy issue
+		"ErrPrivateEndpointNotResolved":              208, // Blob host resolved outside the expected private endpoint range, e.g. public dns used instead of the private dns zone
 		"ErrAuthentication":                          300, // Error code related to issues getting authenticated
 		"ErrInvalidArgumentIterationsCount":          500, // Iterations cannot be less then 1
 		"ErrInvalidArgumentRetryCount":               510, // Retry count on acquire cannot be less then 1
-		"ErrInvalidArgumentWaitTime":                 520, // Invalid wait time between renew iteration, valid values are between 1 and 59 seconds
-		"ErrInvalidArgumentWaitTimeAcquire":          530, // Invalid wait time between acquire retry attempt, valid values are between 0 and 59 seconds
+		"ErrInvalidArgumentWaitTime":                 520, // Invalid wait time between renew iteration, it cannot be negative and must be below the lease duration, 0 derives it from the lease duration
+		"ErrInvalidArgumentWaitTimeAcquire":          530, // Invalid wait time between acquire retry attempt, it cannot be negative
 	}
 )
 
-// ErrorCode returns error code based on error name
-func ErrorCode(errorName string) int {
-	if _, validChoice := errorCodes[errorName]; !validChoice {
-		os.Exit(errorCodes["InvalidErrorCode"])
+// utcTimestampWriter prefixes every log line with an RFC3339 UTC timestamp, the log package only supports
+// local time or UTC without zone designator
+type utcTimestampWriter struct {
+	writer io.Writer
+}
+
+// Write writes the line prefixed with the current time, json diagnostics carry their own time
+func (w utcTimestampWriter) Write(line []byte) (int, error) {
+	if diagFormat == "json" {
+		return w.writer.Write(line)
 	}
 
-	return errorCodes[errorName]
+	if _, err := fmt.Fprintf(w.writer, "%v %s", time.Now().UTC().Format(time.RFC3339), line); err != nil {
+		return 0, err
+	}
+	return len(line), nil
+}
+
+// ErrorCode returns error code based on error name, InvalidErrorCode and an error when the name is unknown
+func ErrorCode(errorName string) (int, error) {
+	code, validChoice := errorCodes[errorName]
+	if !validChoice {
+		return errorCodes["InvalidErrorCode"], fmt.Errorf("unknown error name %v", errorName)
+	}
+
+	return code, nil
 }
 
 // UserAgent returns the user agent string
@@ -63,11 +229,250 @@ func UserAgent() string {
 	return userAgent
 }
 
+// JitterPercent returns the random spread, in percent, applied to acquire and renew wait intervals
+func JitterPercent() int {
+	return jitterPercent
+}
+
+// SetJitterPercent sets the random spread, in percent, applied to acquire and renew wait intervals
+func SetJitterPercent(value int) {
+	jitterPercent = value
+}
+
+// ShutdownTimeout returns the maximum time spent releasing leases and draining requests once interrupted, so
+// the process ends within the termination grace period of its supervisor
+func ShutdownTimeout() time.Duration {
+	return shutdownTimeout
+}
+
+// SetShutdownTimeout sets the maximum time spent releasing leases and draining requests once interrupted
+func SetShutdownTimeout(value time.Duration) {
+	shutdownTimeout = value
+}
+
+// ClockSkewThreshold returns the clock skew against storage above which a warning is emitted, 0 when disabled
+func ClockSkewThreshold() time.Duration {
+	return clockSkewThreshold
+}
+
+// SetClockSkewThreshold sets the clock skew against storage above which a warning is emitted
+func SetClockSkewThreshold(value time.Duration) {
+	clockSkewThreshold = value
+}
+
+// RetryBudget returns the maximum time spent retrying failed requests per invocation, 0 when unbounded
+func RetryBudget() time.Duration {
+	return retryBudget
+}
+
+// SetRetryBudget sets the maximum time spent retrying failed requests per invocation
+func SetRetryBudget(value time.Duration) {
+	retryBudget = value
+}
+
+// RetryBudgetAttempts returns the maximum retries of failed requests per invocation, 0 when unbounded
+func RetryBudgetAttempts() int {
+	return retryBudgetAttempts
+}
+
+// SetRetryBudgetAttempts sets the maximum retries of failed requests per invocation
+func SetRetryBudgetAttempts(value int) {
+	retryBudgetAttempts = value
+}
+
+// StrictOutput returns true when only the json result is written to stdout
+func StrictOutput() bool {
+	return strictOutput
+}
+
+// SetStrictOutput sets whether only the json result is written to stdout
+func SetStrictOutput(value bool) {
+	strictOutput = value
+}
+
+// PriorityBaseDelay returns the longest initial acquire delay, taken by priority 1 candidates
+func PriorityBaseDelay() time.Duration {
+	return priorityBaseDelay
+}
+
+// SkipExistsCheck returns true when leases are acquired without reading blob properties first
+func SkipExistsCheck() bool {
+	return skipExistsCheck
+}
+
+// SetSkipExistsCheck sets whether leases are acquired without reading blob properties first
+func SetSkipExistsCheck(value bool) {
+	skipExistsCheck = value
+}
+
+// PrecheckTimeout returns the maximum time of the connectivity pre-check of the blob endpoint, 0 when skipped
+func PrecheckTimeout() time.Duration {
+	return precheckTimeout
+}
+
+// SetPrecheckTimeout sets the maximum time of the connectivity pre-check of the blob endpoint
+func SetPrecheckTimeout(value time.Duration) {
+	precheckTimeout = value
+}
+
+// UndeleteBlob returns true when a soft-deleted lease blob is restored instead of creating a new one
+func UndeleteBlob() bool {
+	return undeleteBlob
+}
+
+// SetUndeleteBlob sets whether a soft-deleted lease blob is restored instead of creating a new one
+func SetUndeleteBlob(value bool) {
+	undeleteBlob = value
+}
+
+// DeleteOldVersions returns true when the versions left behind by metadata updates of the lock blob are deleted
+func DeleteOldVersions() bool {
+	return deleteOldVersions
+}
+
+// SetDeleteOldVersions sets whether the versions left behind by metadata updates of the lock blob are deleted
+func SetDeleteOldVersions(value bool) {
+	deleteOldVersions = value
+}
+
+// ValidBlobTypes returns the blob types the lease blob can be created with
+func ValidBlobTypes() []string {
+	return []string{"block", "page", "append"}
+}
+
+// ValidAccessTiers returns the access tiers a block lease blob can be created with, archived blobs cannot be leased
+func ValidAccessTiers() []string {
+	return []string{"Hot", "Cool", "Cold"}
+}
+
+// ValidOutputFormats returns the supported output formats
+func ValidOutputFormats() []string {
+	return []string{"json", "gha", "table", "csv"}
+}
+
+// ValidSemaphoreActions returns the supported actions of the semaphore subcommand
+func ValidSemaphoreActions() []string {
+	return []string{"acquire", "renew", "release"}
+}
+
+// ValidRWLockModes returns the supported modes of the rwlock subcommand
+func ValidRWLockModes() []string {
+	return []string{"read", "write"}
+}
+
+// ValidRWLockActions returns the supported actions of the rwlock subcommand
+func ValidRWLockActions() []string {
+	return []string{"acquire", "release"}
+}
+
+// ValidDiagFormats returns the supported formats of the diagnostics written to stderr
+func ValidDiagFormats() []string {
+	return []string{"text", "json"}
+}
+
+// DiagFormat returns the format of the diagnostics written to stderr, text or json
+func DiagFormat() string {
+	return diagFormat
+}
+
+// SetDiagFormat sets the format of the diagnostics written to stderr
+func SetDiagFormat(value string) {
+	diagFormat = value
+}
+
+// OutputFormat returns the output format, json, gha, table or csv
+func OutputFormat() string {
+	return outputFormat
+}
+
+// SetOutputFormat sets the output format
+func SetOutputFormat(value string) {
+	outputFormat = value
+}
+
+// UserAgentSuffix returns the suffix appended to the user agent of all requests
+func UserAgentSuffix() string {
+	return userAgentSuffix
+}
+
+// SetUserAgentSuffix sets the suffix appended to the user agent of all requests
+func SetUserAgentSuffix(value string) {
+	userAgentSuffix = value
+}
+
+// CorrelationID returns the id sent as x-ms-client-request-id of all requests, empty when none is sent
+func CorrelationID() string {
+	return correlationID
+}
+
+// SetCorrelationID sets the id sent as x-ms-client-request-id of all requests
+func SetCorrelationID(value string) {
+	correlationID = value
+}
+
+// AccountKey returns the storage account shared key used on the data plane, empty when azure ad tokens are used
+func AccountKey() string {
+	return accountKey
+}
+
+// SetAccountKey sets the storage account shared key used on the data plane
+func SetAccountKey(value string) {
+	accountKey = value
+}
+
+// SASToken returns the shared access signature used on the data plane, empty when azure ad tokens are used
+func SASToken() string {
+	return sasToken
+}
+
+// SetSASToken sets the shared access signature used on the data plane
+func SetSASToken(value string) {
+	sasToken = value
+}
+
 // Stderr returns error stream logger
 func Stderr() *log.Logger {
 	return stderr
 }
 
+// SetStderrWriter sends the error stream logger output to writer, e.g. syslog, instead of stderr
+func SetStderrWriter(writer io.Writer) {
+	stderr = log.New(writer, "", 0)
+}
+
+// Silent returns true when diagnostics are discarded
+func Silent() bool {
+	return silent
+}
+
+// SetSilent discards diagnostics, unless a log target is set afterwards
+func SetSilent(value bool) {
+	silent = value
+	if value {
+		SetStderrWriter(io.Discard)
+	}
+}
+
+// Debug returns true when debug diagnostics are written
+func Debug() bool {
+	return debug
+}
+
+// SetDebug sets whether debug diagnostics are written
+func SetDebug(value bool) {
+	debug = value
+}
+
+// NoColor returns true when ansi colors are disabled
+func NoColor() bool {
+	return noColor
+}
+
+// SetNoColor sets whether ansi colors are disabled
+func SetNoColor(value bool) {
+	noColor = value
+}
+
 // Stdout returns error stream logger
 func Stdout() *log.Logger {
 	return stderr
@@ -88,11 +493,284 @@ func ValidEnvironments() []string {
 	return validEnvironments
 }
 
+// CloudConfigEnvVar returns the environment variable name that can hold the custom cloud config inline json
+func CloudConfigEnvVar() string {
+	return cloudConfigEnvVar
+}
+
+// ChaosEnvVar returns the environment variable name that enables fault injection, for integration tests only
+func ChaosEnvVar() string {
+	return chaosEnvVar
+}
+
+// APIKeyEnvVar returns the environment variable name holding the api key required by the serve subcommand api
+func APIKeyEnvVar() string {
+	return apiKeyEnvVar
+}
+
+// AccountKeyEnvVar returns the environment variable name holding the storage account shared key
+func AccountKeyEnvVar() string {
+	return accountKeyEnvVar
+}
+
+// SASTokenEnvVar returns the environment variable name holding the shared access signature
+func SASTokenEnvVar() string {
+	return sasTokenEnvVar
+}
+
+// TokenCacheKeyEnvVar returns the environment variable name holding the passphrase the token cache is encrypted with
+func TokenCacheKeyEnvVar() string {
+	return tokenCacheKeyEnvVar
+}
+
+// CloudConfigEnvSource returns the custom cloud config source that refers to the inline json environment variable
+func CloudConfigEnvSource() string {
+	return "env:" + cloudConfigEnvVar
+}
+
+// StorageEndpointSuffix returns the storage endpoint suffix of a well known Azure cloud type, defaults to public cloud
+func StorageEndpointSuffix(environment string) string {
+	if suffix, found := storageEndpointSuffixes[environment]; found {
+		return suffix
+	}
+	return storageEndpointSuffixes["AZUREPUBLICCLOUD"]
+}
+
+// StorageEndpointSuffixOverride returns the storage endpoint suffix to be used instead of the cloud one
+func StorageEndpointSuffixOverride() string {
+	return storageEndpointSuffixOverride
+}
+
+// SetStorageEndpointSuffixOverride sets the storage endpoint suffix to be used instead of the cloud one
+func SetStorageEndpointSuffixOverride(value string) {
+	storageEndpointSuffixOverride = value
+}
+
+// BlobHost returns the host used instead of the one of the blob endpoint, empty when not overridden
+func BlobHost() string {
+	return blobHost
+}
+
+// SetBlobHost sets the host used instead of the one of the blob endpoint
+func SetBlobHost(value string) {
+	blobHost = value
+}
+
+// PrivateEndpointRanges returns the address ranges the blob host must resolve to, nil when not checked
+func PrivateEndpointRanges() []*net.IPNet {
+	return privateEndpointRanges
+}
+
+// SetPrivateEndpointRanges sets the address ranges the blob host must resolve to
+func SetPrivateEndpointRanges(value []*net.IPNet) {
+	privateEndpointRanges = value
+}
+
+// DefaultPrivateEndpointRanges returns the private address ranges, comma separated, expected by default
+func DefaultPrivateEndpointRanges() string {
+	return defaultPrivateEndpointRanges
+}
+
+// AuthorityHost returns the azure active directory authority host override
+func AuthorityHost() string {
+	return authorityHost
+}
+
+// SetAuthorityHost sets the azure active directory authority host override
+func SetAuthorityHost(value string) {
+	authorityHost = value
+}
+
+// ADFS returns true when authentication is performed against active directory federation services
+func ADFS() bool {
+	return adfs
+}
+
+// SetADFS sets whether authentication is performed against active directory federation services
+func SetADFS(value bool) {
+	adfs = value
+}
+
+// StorageAudience returns the token audience of the storage data plane, empty when the sdk default is used
+func StorageAudience() string {
+	return storageAudience
+}
+
+// SetStorageAudience sets the token audience of the storage data plane
+func SetStorageAudience(value string) {
+	storageAudience = value
+}
+
+// CABundle returns the pem file with additional certificate authorities trusted by the http transport
+func CABundle() string {
+	return caBundle
+}
+
+// SetCABundle sets the pem file with additional certificate authorities trusted by the http transport
+func SetCABundle(value string) {
+	caBundle = value
+}
+
+// InsecureSkipVerify returns true when tls certificate verification is disabled
+func InsecureSkipVerify() bool {
+	return insecureSkipVerify
+}
+
+// SetInsecureSkipVerify sets whether tls certificate verification is disabled
+func SetInsecureSkipVerify(value bool) {
+	insecureSkipVerify = value
+}
+
+// MaxRetries returns the maximum retries of failed requests, 0 uses the sdk default and -1 disables retries
+func MaxRetries() int32 {
+	return maxRetries
+}
+
+// SetMaxRetries sets the maximum retries of failed requests
+func SetMaxRetries(value int32) {
+	maxRetries = value
+}
+
+// RetryDelay returns the initial delay between retries, 0 uses the sdk default
+func RetryDelay() time.Duration {
+	return retryDelay
+}
+
+// SetRetryDelay sets the initial delay between retries
+func SetRetryDelay(value time.Duration) {
+	retryDelay = value
+}
+
+// MaxRetryDelay returns the maximum delay between retries, 0 uses the sdk default
+func MaxRetryDelay() time.Duration {
+	return maxRetryDelay
+}
+
+// SetMaxRetryDelay sets the maximum delay between retries
+func SetMaxRetryDelay(value time.Duration) {
+	maxRetryDelay = value
+}
+
+// ConnectTimeout returns the maximum time to establish a connection, 0 uses the transport default
+func ConnectTimeout() time.Duration {
+	return connectTimeout
+}
+
+// SetConnectTimeout sets the maximum time to establish a connection
+func SetConnectTimeout(value time.Duration) {
+	connectTimeout = value
+}
+
+// ResponseHeaderTimeout returns the maximum time to wait for response headers, 0 waits indefinitely
+func ResponseHeaderTimeout() time.Duration {
+	return responseHeaderTimeout
+}
+
+// SetResponseHeaderTimeout sets the maximum time to wait for response headers
+func SetResponseHeaderTimeout(value time.Duration) {
+	responseHeaderTimeout = value
+}
+
+// IMDSProbeTimeout returns the maximum time to wait for the managed identity endpoint probe, 0 uses the sdk default
+func IMDSProbeTimeout() time.Duration {
+	return imdsProbeTimeout
+}
+
+// SetIMDSProbeTimeout sets the maximum time to wait for the managed identity endpoint probe
+func SetIMDSProbeTimeout(value time.Duration) {
+	imdsProbeTimeout = value
+}
+
+// IMDSProbeRetries returns the additional managed identity endpoint probe attempts
+func IMDSProbeRetries() int {
+	return imdsProbeRetries
+}
+
+// SetIMDSProbeRetries sets the additional managed identity endpoint probe attempts
+func SetIMDSProbeRetries(value int) {
+	imdsProbeRetries = value
+}
+
+// DisableIMDSProbe returns true when managed identity is excluded from the default credential chain
+func DisableIMDSProbe() bool {
+	return disableIMDSProbe
+}
+
+// SetDisableIMDSProbe sets whether managed identity is excluded from the default credential chain
+func SetDisableIMDSProbe(value bool) {
+	disableIMDSProbe = value
+}
+
+// TenantID returns the tenant tokens are requested from, empty uses the tenant of the credential
+func TenantID() string {
+	return tenantID
+}
+
+// SetTenantID sets the tenant tokens are requested from
+func SetTenantID(value string) {
+	tenantID = value
+}
+
+// ClientID returns the application tokens are requested for with a client assertion, empty when tokens are requested directly
+func ClientID() string {
+	return clientID
+}
+
+// SetClientID sets the application tokens are requested for with a client assertion
+func SetClientID(value string) {
+	clientID = value
+}
+
+// TokenExchangeAudience returns the audience of the token used as client assertion
+func TokenExchangeAudience() string {
+	return tokenExchangeAudience
+}
+
+// SetTokenExchangeAudience sets the audience of the token used as client assertion
+func SetTokenExchangeAudience(value string) {
+	tokenExchangeAudience = value
+}
+
+// FederatedTokenFile returns the file holding the oidc token used as client assertion, empty when none is used
+func FederatedTokenFile() string {
+	return federatedTokenFile
+}
+
+// SetFederatedTokenFile sets the file holding the oidc token used as client assertion
+func SetFederatedTokenFile(value string) {
+	federatedTokenFile = value
+}
+
+// TokenCacheFile returns the encrypted file access tokens are shared through, empty when tokens are not persisted
+func TokenCacheFile() string {
+	return tokenCacheFile
+}
+
+// SetTokenCacheFile sets the encrypted file access tokens are shared through
+func SetTokenCacheFile(value string) {
+	tokenCacheFile = value
+}
+
+// SkipARM returns true when blob endpoints must be built locally instead of queried from azure resource manager
+func SkipARM() bool {
+	return skipARM
+}
+
+// SetSkipARM sets whether blob endpoints must be built locally instead of queried from azure resource manager
+func SetSkipARM(value bool) {
+	skipARM = value
+}
+
 // BlobName returns the blob name to be used when acquiring lease
 func BlobName() string {
 	return blobName
 }
 
+// Contended returns the status of an acquisition that spent its maximum wait without obtaining the lease
+func Contended() string {
+	return contended
+}
+
 // Success returns success string
 func Success() string {
 	return success
@@ -108,7 +786,67 @@ func SuccessOnRenew() string {
 	return successRenew
 }
 
+// PartialSuccess returns the status of a renew loop that lost the lease after some successful renewals
+func PartialSuccess() string {
+	return partialSuccess
+}
+
 // Fail returns fail string
 func Fail() string {
 	return fail
 }
+
+// MetadataHolder returns the blob metadata key that stores the lease holder identity
+func MetadataHolder() string {
+	return metadataHolder
+}
+
+// MetadataAcquiredAt returns the blob metadata key that stores the lease acquisition time
+func MetadataAcquiredAt() string {
+	return metadataAcquiredAt
+}
+
+// MetadataEpoch returns the blob metadata key that stores the leadership epoch, incremented on every acquisition
+func MetadataEpoch() string {
+	return metadataEpoch
+}
+
+// MetadataLastRenewedAt returns the blob metadata key that stores the last lease renewal time, recorded by every
+// successful renewal unless record-renewals is turned off
+func MetadataLastRenewedAt() string {
+	return metadataLastRenewedAt
+}
+
+// MetadataLeaseDuration returns the blob metadata key that stores the lease duration in seconds
+func MetadataLeaseDuration() string {
+	return metadataLeaseDuration
+}
+
+// MetadataSuccessor returns the blob metadata key that stores the holder designated by a planned handoff
+func MetadataSuccessor() string {
+	return metadataSuccessor
+}
+
+// MetadataSuccessorUntil returns the blob metadata key that stores the end of the preference window given to the
+// designated successor
+func MetadataSuccessorUntil() string {
+	return metadataSuccessorUntil
+}
+
+// MetadataReader returns the prefix of the blob metadata keys that register the readers of a read/write lock,
+// followed by the reader id
+func MetadataReader() string {
+	return metadataReader
+}
+
+// ReservedMetadataKeys returns the blob metadata keys used to record lease holder information, they cannot be
+// set when creating the lease blob
+func ReservedMetadataKeys() []string {
+	return []string{metadataHolder, metadataAcquiredAt, metadataLeaseDuration, metadataEpoch, metadataLastRenewedAt, metadataSuccessor, metadataSuccessorUntil}
+}
+
+// ReservedMetadataPrefixes returns the prefixes of the blob metadata keys used to register read/write lock
+// readers, keys starting with them cannot be set when creating the lease blob
+func ReservedMetadataPrefixes() []string {
+	return []string{metadataReader}
+}
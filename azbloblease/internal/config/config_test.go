@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import "testing"
+
+func TestErrorCodesExitStatus(t *testing.T) {
+	// POSIX shells only see exit codes modulo 256
+	names := map[int]string{}
+	for name, code := range errorCodes {
+		if other, found := names[code%256]; found {
+			t.Errorf("error codes %v and %v share exit status %v", name, other, code%256)
+		}
+		names[code%256] = name
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		wantErr bool
+	}{
+		{"ErrLeaseAlreadyPresent", 2, false},
+		{"ErrInvalidArgument", 100, false},
+		{"ErrDataPlaneAuthorization", 200, false},
+		{"ErrDataPlaneTimeout", 203, false},
+		{"ErrUnknown", 10, true},
+	}
+
+	for _, test := range tests {
+		code, err := ErrorCode(test.name)
+		if code != test.code || (err != nil) != test.wantErr {
+			t.Errorf("ErrorCode(%v) = %v, %v, want %v and error %v", test.name, code, err, test.code, test.wantErr)
+		}
+	}
+}
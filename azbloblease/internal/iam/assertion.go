@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package iam
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+)
+
+// newClientAssertionCredential gets tokens from the tenant id for the multi-tenant application client id, using a
+// token of the current identity as client assertion. The application must have a federated identity credential
+// trusting the current identity, typically a managed identity of the provider tenant, so no secret is stored.
+func newClientAssertionCredential(assertionCred azcore.TokenCredential, clientOptions azcore.ClientOptions) (azcore.TokenCredential, error) {
+	getAssertion := func(cntx context.Context) (string, error) {
+		token, err := assertionCred.GetToken(cntx, policy.TokenRequestOptions{
+			Scopes: []string{config.TokenExchangeAudience() + "/.default"},
+		})
+		return token.Token, err
+	}
+
+	return azidentity.NewClientAssertionCredential(config.TenantID(), config.ClientID(), getAssertion, &azidentity.ClientAssertionCredentialOptions{
+		ClientOptions: clientOptions,
+	})
+}
@@ -12,32 +12,98 @@ package iam
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/log"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
 )
 
+const adfsTenant = "adfs"
+
+// adfsAuthorityHost removes the /adfs path from azure stack hub authority hosts (e.g. https://adfs.local.azurestack.external/adfs/)
+// since the sdk appends the tenant, which is adfs, to the authority host
+func adfsAuthorityHost(authorityHost string) string {
+	if !config.ADFS() {
+		return authorityHost
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(authorityHost, "/"), "/"+adfsTenant) + "/"
+}
+
+// GetTokenCredentials returns the credential tokens are requested with, the credential type selected is logged as a
+// debug diagnostic together with, for credential chains, the one that ultimately authenticated
 func GetTokenCredentials(managedIdentityId string, useSystemManagedIdentity bool) (azcore.TokenCredential, error) {
 	var cred azcore.TokenCredential
+	var credentialType string
 	var err error
 
-	if managedIdentityId == "" && !useSystemManagedIdentity {
-		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	if config.Debug() {
+		logAuthenticationEvents()
+	}
+
+	// Authority host override, used by non-public clouds without a cloud config file and by adfs
+	clientOptions := common.ClientOptions()
+	if config.AuthorityHost() != "" {
+		clientOptions.Cloud = cloud.Configuration{
+			ActiveDirectoryAuthorityHost: adfsAuthorityHost(config.AuthorityHost()),
+		}
+	}
+
+	if config.FederatedTokenFile() != "" {
+		// OIDC federation (GitHub Actions, Kubernetes, other identity providers), the file is read again when the token expires
+		credentialType = fmt.Sprintf("WorkloadIdentityCredential with federated token file %v", config.FederatedTokenFile())
+		cred, err = azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			ClientID:      config.ClientID(),
+			TenantID:      config.TenantID(),
+			TokenFilePath: config.FederatedTokenFile(),
+		})
+	} else if managedIdentityId == "" && !useSystemManagedIdentity {
+		defaultOptions := azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: clientOptions,
+		}
+
+		// ADFS has no tenants and no instance discovery endpoint, tokens are requested from <authority host>/adfs
+		if config.ADFS() {
+			defaultOptions.TenantID = adfsTenant
+			defaultOptions.DisableInstanceDiscovery = true
+		} else if config.ClientID() == "" {
+			defaultOptions.TenantID = config.TenantID()
+		}
+
+		// The sdk probe of the instance metadata service is not configurable, the chain is rebuilt when it is tuned
+		if imdsTuned() {
+			credentialType = "DefaultAzureCredential chain with tuned managed identity probe"
+			cred, err = newTunedDefaultCredential(defaultOptions)
+		} else {
+			credentialType = "DefaultAzureCredential chain"
+			cred, err = azidentity.NewDefaultAzureCredential(&defaultOptions)
+		}
 	} else if useSystemManagedIdentity {
-		fmt.Println("Using NewManagedIdentityCredential")
-		cred, err = azidentity.NewManagedIdentityCredential(nil)
+		credentialType = "ManagedIdentityCredential for system assigned managed identity"
+		cred, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+		})
 	} else if managedIdentityId != "" {
-		fmt.Println("Using NewManagedIdentityCredential for user assigned managed identity")
+		credentialType = fmt.Sprintf("ManagedIdentityCredential for user assigned managed identity %v", managedIdentityId)
 		opts := azidentity.ManagedIdentityCredentialOptions{}
 
 		if strings.Contains(managedIdentityId, "/") {
 			opts = azidentity.ManagedIdentityCredentialOptions{
-				ID: azidentity.ResourceID(managedIdentityId),
+				ClientOptions: clientOptions,
+				ID:            azidentity.ResourceID(managedIdentityId),
 			}
 		} else {
 			opts = azidentity.ManagedIdentityCredentialOptions{
-				ID: azidentity.ClientID(managedIdentityId),
+				ClientOptions: clientOptions,
+				ID:            azidentity.ClientID(managedIdentityId),
 			}
 		}
 
@@ -50,5 +116,38 @@ func GetTokenCredentials(managedIdentityId string, useSystemManagedIdentity bool
 		return nil, fmt.Errorf("an error ocurred: %v", err)
 	}
 
+	// Cross-tenant access, the token of the identity above is exchanged for one of the resource tenant
+	if config.ClientID() != "" && config.FederatedTokenFile() == "" {
+		cred, err = newClientAssertionCredential(cred, clientOptions)
+		if err != nil {
+			return nil, fmt.Errorf("an error ocurred: %v", err)
+		}
+		credentialType = fmt.Sprintf("ClientAssertionCredential for client id %v, asserted by %v", config.ClientID(), credentialType)
+	}
+
+	// Tokens shared across invocations, partitioned by the settings selecting the identity
+	if config.TokenCacheFile() != "" {
+		partition := strings.Join([]string{
+			managedIdentityId, strconv.FormatBool(useSystemManagedIdentity), config.AuthorityHost(), config.TenantID(), config.ClientID(),
+			config.FederatedTokenFile(), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_TENANT_ID"),
+		}, "|")
+
+		cred, err = newPersistentTokenCache(cred, config.TokenCacheFile(), os.Getenv(config.TokenCacheKeyEnvVar()), partition)
+		if err != nil {
+			return nil, fmt.Errorf("an error ocurred: %v", err)
+		}
+		credentialType = fmt.Sprintf("%v, tokens cached in %v", credentialType, config.TokenCacheFile())
+	}
+
+	utils.Debug(fmt.Sprintf("using %v", credentialType))
 	return cred, nil
 }
+
+// logAuthenticationEvents writes the authentication events of the sdk as debug diagnostics, credential chains
+// only tell which credential authenticated once the first token is requested
+func logAuthenticationEvents() {
+	log.SetEvents(azidentity.EventAuthentication)
+	log.SetListener(func(event log.Event, message string) {
+		utils.Debug(message)
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package iam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+)
+
+const (
+	imdsEndpoint            = "http://169.254.169.254/metadata/identity/oauth2/token"
+	defaultIMDSProbeTimeout = time.Second // same as the sdk probe of DefaultAzureCredential
+)
+
+// imdsTuned returns true when the default credential chain must be built locally to honor the imds probe settings
+func imdsTuned() bool {
+	return config.IMDSProbeTimeout() != 0 || config.IMDSProbeRetries() != 0 || config.DisableIMDSProbe()
+}
+
+// probedManagedIdentityCredential gets tokens from managed identity only after the instance metadata service
+// answered a probe, so machines without it fall through the credential chain after the probe timeout
+type probedManagedIdentityCredential struct {
+	cred    azcore.TokenCredential
+	timeout time.Duration
+	retries int
+
+	mu     sync.Mutex
+	probed bool
+}
+
+// GetToken probes the instance metadata service once, then requests the token from managed identity
+func (c *probedManagedIdentityCredential) GetToken(cntx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.mu.Lock()
+	if !c.probed {
+		if err := c.probe(cntx); err != nil {
+			c.mu.Unlock()
+			return azcore.AccessToken{}, azidentity.NewCredentialUnavailableError(fmt.Sprintf("ManagedIdentityCredential: %v", err))
+		}
+		c.probed = true
+	}
+	c.mu.Unlock()
+
+	return c.cred.GetToken(cntx, options)
+}
+
+// probe sends unauthenticated requests to the instance metadata service until one gets a json answer, app service,
+// arc and cloud shell managed identity endpoints are not probed
+func (c *probedManagedIdentityCredential) probe(cntx context.Context) error {
+	if os.Getenv("IDENTITY_ENDPOINT") != "" || os.Getenv("MSI_ENDPOINT") != "" {
+		return nil
+	}
+
+	// The instance metadata service is link local, it is never reached through a proxy
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.Proxy = nil
+	client := &http.Client{Transport: httpTransport, Timeout: c.timeout}
+
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		err = probeIMDS(cntx, client)
+		if err == nil || cntx.Err() != nil {
+			break
+		}
+	}
+	return err
+}
+
+// probeIMDS sends a single probe, anything but a json answer, e.g. from a proxy, means there is no instance metadata service
+func probeIMDS(cntx context.Context, client *http.Client) error {
+	req, err := http.NewRequestWithContext(cntx, http.MethodGet, imdsEndpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("instance metadata service probe failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("an error ocurred while reading instance metadata service probe response: %v", err)
+	}
+
+	if !json.Valid(body) {
+		return fmt.Errorf("unexpected response to instance metadata service probe")
+	}
+	return nil
+}
+
+// newTunedDefaultCredential chains the same credentials as DefaultAzureCredential, with managed identity behind the
+// configured probe or left out. Credentials that cannot be created, e.g. for missing environment variables, are skipped.
+func newTunedDefaultCredential(options azidentity.DefaultAzureCredentialOptions) (azcore.TokenCredential, error) {
+	var creds []azcore.TokenCredential
+
+	envCred, err := azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{
+		ClientOptions:            options.ClientOptions,
+		DisableInstanceDiscovery: options.DisableInstanceDiscovery,
+	})
+	if err == nil {
+		creds = append(creds, envCred)
+	}
+
+	workloadCred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+		ClientOptions:            options.ClientOptions,
+		DisableInstanceDiscovery: options.DisableInstanceDiscovery,
+		TenantID:                 options.TenantID,
+	})
+	if err == nil {
+		creds = append(creds, workloadCred)
+	}
+
+	if !config.DisableIMDSProbe() {
+		miOptions := azidentity.ManagedIdentityCredentialOptions{ClientOptions: options.ClientOptions}
+		if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+			miOptions.ID = azidentity.ClientID(clientID)
+		}
+
+		miCred, err := azidentity.NewManagedIdentityCredential(&miOptions)
+		if err == nil {
+			timeout := config.IMDSProbeTimeout()
+			if timeout == 0 {
+				timeout = defaultIMDSProbeTimeout
+			}
+			creds = append(creds, &probedManagedIdentityCredential{cred: miCred, timeout: timeout, retries: config.IMDSProbeRetries()})
+		}
+	}
+
+	cliCred, err := azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: options.TenantID})
+	if err == nil {
+		creds = append(creds, cliCred)
+	}
+
+	azdCred, err := azidentity.NewAzureDeveloperCLICredential(&azidentity.AzureDeveloperCLICredentialOptions{TenantID: options.TenantID})
+	if err == nil {
+		creds = append(creds, azdCred)
+	}
+
+	return azidentity.NewChainedTokenCredential(creds, nil)
+}
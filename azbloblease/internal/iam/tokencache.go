@@ -0,0 +1,200 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package iam
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"golang.org/x/crypto/scrypt"
+)
+
+// tokenCacheRefreshMargin cached tokens expiring sooner than this are refreshed, leaving room for a renew loop iteration
+const tokenCacheRefreshMargin = 5 * time.Minute
+
+// Scrypt parameters deriving the token cache key from the passphrase, the random salt is stored in the file header
+const (
+	tokenCacheSaltSize = 16
+	tokenCacheScryptN  = 1 << 15
+	tokenCacheScryptR  = 8
+	tokenCacheScryptP  = 1
+)
+
+// cachedToken access token persisted in the token cache file
+type cachedToken struct {
+	Token     string    `json:"token"`
+	ExpiresOn time.Time `json:"expiresOn"`
+}
+
+// persistentTokenCache shares the access tokens of a credential across invocations through a file encrypted with
+// aes-gcm, so cron driven acquire and renew don't go through the whole token flow every time. The file holds the
+// scrypt salt followed by the nonce and the sealed tokens
+type persistentTokenCache struct {
+	cred       azcore.TokenCredential
+	path       string
+	partition  string // partition identifies the credential, tokens of other identities sharing the file are never returned
+	passphrase []byte
+	salt       []byte // salt the gcm key was derived with
+	gcm        cipher.AEAD
+
+	mu sync.Mutex
+}
+
+// newPersistentTokenCache wraps the credential with a token cache file encrypted with a key derived from the passphrase,
+// the salt of an existing file is reused so its tokens can be decrypted, otherwise a random one is generated
+func newPersistentTokenCache(cred azcore.TokenCredential, path, passphrase, partition string) (azcore.TokenCredential, error) {
+	salt := make([]byte, tokenCacheSaltSize)
+	if data, err := ioutil.ReadFile(path); err == nil && len(data) >= tokenCacheSaltSize {
+		copy(salt, data[:tokenCacheSaltSize])
+	} else if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	cache := &persistentTokenCache{cred: cred, path: path, partition: partition, passphrase: []byte(passphrase)}
+	if err := cache.deriveKey(salt); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// deriveKey derives the gcm key from the passphrase and the salt with scrypt, slow on purpose so a stolen cache file
+// can't be brute forced cheaply
+func (c *persistentTokenCache) deriveKey(salt []byte) error {
+	key, err := scrypt.Key(c.passphrase, salt, tokenCacheScryptN, tokenCacheScryptR, tokenCacheScryptP, 32)
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	c.salt = append([]byte(nil), salt...)
+	c.gcm = gcm
+	return nil
+}
+
+// GetToken returns the cached token of the scopes when still valid, otherwise gets a new one and persists it
+func (c *persistentTokenCache) GetToken(cntx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	// Claims challenges require a new token, e.g. continuous access evaluation revoking the cached one
+	if options.Claims != "" {
+		return c.cred.GetToken(cntx, options)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entryKey := c.entryKey(options)
+	tokens := c.load()
+	if cached, ok := tokens[entryKey]; ok && time.Until(cached.ExpiresOn) > tokenCacheRefreshMargin {
+		return azcore.AccessToken{Token: cached.Token, ExpiresOn: cached.ExpiresOn}, nil
+	}
+
+	token, err := c.cred.GetToken(cntx, options)
+	if err != nil {
+		return token, err
+	}
+
+	// Expired tokens of any identity are dropped so the file doesn't grow forever
+	for key, cached := range tokens {
+		if time.Now().After(cached.ExpiresOn) {
+			delete(tokens, key)
+		}
+	}
+	tokens[entryKey] = cachedToken{Token: token.Token, ExpiresOn: token.ExpiresOn}
+
+	// The token was obtained, failing to persist it only costs a token request on the next invocation
+	c.save(tokens)
+	return token, nil
+}
+
+// entryKey identifies the token of the credential for the requested scopes and tenant
+func (c *persistentTokenCache) entryKey(options policy.TokenRequestOptions) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{c.partition, options.TenantID, strings.Join(options.Scopes, " ")}, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// load decrypts the token cache file, a missing, corrupted or differently encrypted file is an empty cache, as are
+// files written before the key was salted
+func (c *persistentTokenCache) load() map[string]cachedToken {
+	tokens := map[string]cachedToken{}
+
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil || len(data) < tokenCacheSaltSize+c.gcm.NonceSize() {
+		return tokens
+	}
+
+	// Another invocation may have recreated the file with a new salt since the key was derived
+	salt, data := data[:tokenCacheSaltSize], data[tokenCacheSaltSize:]
+	if !bytes.Equal(salt, c.salt) {
+		if err := c.deriveKey(salt); err != nil {
+			return tokens
+		}
+	}
+
+	plaintext, err := c.gcm.Open(nil, data[:c.gcm.NonceSize()], data[c.gcm.NonceSize():], nil)
+	if err != nil {
+		return tokens
+	}
+
+	if err := json.Unmarshal(plaintext, &tokens); err != nil {
+		return map[string]cachedToken{}
+	}
+	return tokens
+}
+
+// save encrypts the tokens into a temporary file readable by the current user only and renames it over the cache
+// file, concurrent invocations never read a partially written cache
+func (c *persistentTokenCache) save(tokens map[string]cachedToken) error {
+	plaintext, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, c.gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	header := append(append([]byte(nil), c.salt...), nonce...)
+	_, err = tempFile.Write(c.gcm.Seal(header, nonce, plaintext, nil))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("an error ocurred while writing token cache: %v", err)
+	}
+
+	return os.Rename(tempFile.Name(), c.path)
+}
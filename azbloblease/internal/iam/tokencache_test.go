@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package iam
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// countingCredential returns a new token on every request and counts them
+type countingCredential struct {
+	requests int
+}
+
+func (c *countingCredential) GetToken(cntx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.requests++
+	return azcore.AccessToken{Token: fmt.Sprintf("token%v", c.requests), ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestPersistentTokenCache(t *testing.T) {
+	options := policy.TokenRequestOptions{Scopes: []string{"https://storage.azure.com/.default"}}
+
+	tests := []struct {
+		name         string
+		passphrase   string
+		partition    string
+		wantRequests int
+	}{
+		{"same passphrase and partition", "passphrase", "identity", 0},
+		{"other passphrase", "other passphrase", "identity", 1},
+		{"other partition", "passphrase", "other identity", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens")
+
+			first := &countingCredential{}
+			cache, err := newPersistentTokenCache(first, path, "passphrase", "identity")
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := cache.GetToken(context.Background(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte(token.Token)) {
+				t.Fatalf("token cache file holds the token in clear text")
+			}
+
+			second := &countingCredential{}
+			cache, err = newPersistentTokenCache(second, path, test.passphrase, test.partition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cache.GetToken(context.Background(), options); err != nil {
+				t.Fatal(err)
+			}
+			if second.requests != test.wantRequests {
+				t.Errorf("got %v token requests, want %v", second.requests, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestPersistentTokenCacheSalt(t *testing.T) {
+	options := policy.TokenRequestOptions{Scopes: []string{"https://storage.azure.com/.default"}}
+
+	var headers [][]byte
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(t.TempDir(), "tokens")
+		cache, err := newPersistentTokenCache(&countingCredential{}, path, "passphrase", "identity")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cache.GetToken(context.Background(), options); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, data[:tokenCacheSaltSize])
+	}
+
+	// The same passphrase must not derive the same key for different files
+	if bytes.Equal(headers[0], headers[1]) {
+		t.Errorf("token cache files share the salt %x", headers[0])
+	}
+}
@@ -18,6 +18,307 @@ type ResponseInfo struct {
 	LeaseID            *string `json:"leaseId"`
 	Status             *string `json:"status"`
 	ErrorMessage       *string `json:"errorMessage"`
+
+	// Storage service error code of the failure, e.g. LeaseAlreadyPresent
+	ErrorCode *string `json:"errorCode,omitempty"`
+
+	// Category of the failure, one of authorization, notFound, conflict or timeout
+	ErrorCategory *string `json:"errorCategory,omitempty"`
+
+	// Time the result was produced, RFC3339 in UTC
+	Timestamp *string `json:"timestamp,omitempty"`
+
+	// Client request id sent on every azure request of the operation
+	CorrelationID *string `json:"correlationId,omitempty"`
+
+	// Exit code the process ends with
+	ExitCode *int `json:"exitCode,omitempty"`
+
+	// Non-fatal conditions met by the operation, e.g. lease blob already existed or requests were retried
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Index of the shard obtained, only returned by acquire subcommand in sharded mode
+	ShardIndex *int `json:"shardIndex,omitempty"`
+
+	// Name of the shard obtained as informed, without the prefix of blobName, only returned by acquire subcommand in sharded mode
+	LockName *string `json:"lockName,omitempty"`
+
+	// Index of the semaphore slot held, returned by semaphore subcommand
+	Slot *int `json:"slot,omitempty"`
+
+	// Id of the reader holding a read lock, returned by rwlock subcommand in read mode and required to release it
+	ReaderID *string `json:"readerId,omitempty"`
+
+	// Number of readers holding the read lock after the operation, returned by rwlock subcommand
+	Readers *int `json:"readers,omitempty"`
+
+	// Clock skew against storage in milliseconds, positive when the local clock is ahead, only returned above the threshold
+	ClockSkewMs *int64 `json:"clockSkewMs,omitempty"`
+
+	// URL of the blob, returned by createleaseblob subcommand and by acquire subcommand once the lease is held
+	BlobURL *string `json:"blobUrl,omitempty"`
+
+	// Provisioning details, only returned by createleaseblob subcommand, container and blob creation are also
+	// returned by acquire subcommand with create-if-missing when the blob was missing
+	BlobType         *string `json:"blobType,omitempty"`
+	AccessTier       *string `json:"accessTier,omitempty"`
+	ETag             *string `json:"etag,omitempty"`
+	ContainerCreated *bool   `json:"containerCreated,omitempty"`
+	BlobCreated      *bool   `json:"blobCreated,omitempty"`
+	Undeleted        *bool   `json:"undeleted,omitempty"`
+
+	// Whether the account has hierarchical namespace (ADLS Gen2) enabled, only returned by createleaseblob subcommand
+	HierarchicalNamespace *bool `json:"hierarchicalNamespace,omitempty"`
+
+	// Whether a lease held by someone else was broken to acquire it, only returned by acquire subcommand with steal
+	Stolen *bool `json:"stolen,omitempty"`
+
+	// Audit snapshot timestamp, only returned by acquire subcommand when audit snapshots are enabled
+	Snapshot *string `json:"snapshot,omitempty"`
+
+	// Previous versions of the lease blob deleted, only returned by acquire and renew subcommands with delete-old-versions
+	DeletedVersions *int `json:"deletedVersions,omitempty"`
+
+	// Version of the tool, only returned by version subcommand when an output format is informed
+	Version *string `json:"version,omitempty"`
+
+	// Renew loop outcome, last renewal is the time the last successful renewal was sent, only returned by renew subcommand
+	RenewalsSucceeded *int    `json:"renewalsSucceeded,omitempty"`
+	RenewalsFailed    *int    `json:"renewalsFailed,omitempty"`
+	LastRenewal       *string `json:"lastRenewal,omitempty"`
+
+	// Lease state information, only returned by status subcommand
+	LeaseState            *string `json:"leaseState,omitempty"`
+	LeaseStatus           *string `json:"leaseStatus,omitempty"`
+	Holder                *string `json:"holder,omitempty"`
+	LeaseAcquiredAt       *string `json:"leaseAcquiredAt,omitempty"`
+	LeaseRenewedAt        *string `json:"leaseRenewedAt,omitempty"`
+	SecondsSinceLastRenew *int64  `json:"secondsSinceLastRenew,omitempty"`
+	Stale                 *bool   `json:"stale,omitempty"`
+	LeaseAgeSeconds       *int64  `json:"leaseAgeSeconds,omitempty"`
+	LeaseDurationSeconds  *int    `json:"leaseDurationSeconds,omitempty"`
+	LeaseExpiresAt        *string `json:"leaseExpiresAt,omitempty"`
+	ServedBy              *string `json:"servedBy,omitempty"`
+	VersioningEnabled     *bool   `json:"versioningEnabled,omitempty"`
+	VersionID             *string `json:"versionId,omitempty"`
+	PreviousVersions      *int    `json:"previousVersions,omitempty"`
+
+	// Holder designated by a planned handoff and end of its preference window, returned by handoff subcommand and, while
+	// the preference window lasts, by status subcommand
+	Successor               *string `json:"successor,omitempty"`
+	SuccessorPreferredUntil *string `json:"successorPreferredUntil,omitempty"`
+
+	// Blobs found, only returned by list subcommand
+	Blobs *[]BlobInfo `json:"blobs,omitempty"`
+
+	// Per storage account results, only returned when operating in quorum mode
+	QuorumMembers *[]ResponseInfo `json:"quorumMembers,omitempty"`
+
+	// Preflight check results, only returned by doctor subcommand
+	Checks *[]CheckResult `json:"checks,omitempty"`
+
+	// Latency percentiles per lease operation, only returned by bench subcommand
+	Latencies *[]OperationLatency `json:"latencies,omitempty"`
+
+	// Identity the token was issued to, only returned by test-auth subcommand
+	Identity *IdentityInfo `json:"identity,omitempty"`
+
+	// Operation that would be executed, only returned when dry-run is set
+	DryRun *OperationPlan `json:"dryRun,omitempty"`
+}
+
+// OperationPlan object definition, describes what a mutating subcommand would execute in dry-run mode
+type OperationPlan struct {
+	Operation            string            `json:"operation"`
+	Mode                 string            `json:"mode"`
+	BlobURLs             []string          `json:"blobUrls"`
+	LeaseDurationSeconds *int              `json:"leaseDurationSeconds,omitempty"`
+	ProposedLeaseID      *string           `json:"proposedLeaseId,omitempty"`
+	LeaseIDs             []string          `json:"leaseIds,omitempty"`
+	Retries              *int              `json:"retries,omitempty"`
+	Iterations           *int              `json:"iterations,omitempty"`
+	WaitTimeSec          *int              `json:"waitTimeSec,omitempty"`
+	BlobSize             *int              `json:"blobSize,omitempty"`
+	BlobType             *string           `json:"blobType,omitempty"`
+	AccessTier           *string           `json:"accessTier,omitempty"`
+	ContentType          *string           `json:"contentType,omitempty"`
+	Metadata             map[string]string `json:"metadata,omitempty"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	AuditLogBlobURL      *string           `json:"auditLogBlobUrl,omitempty"`
+}
+
+// IdentityInfo object definition, claims of the token obtained by test-auth subcommand
+type IdentityInfo struct {
+	ObjectID          string `json:"objectId"`
+	TenantID          string `json:"tenantId"`
+	ApplicationID     string `json:"applicationId"`
+	UserPrincipalName string `json:"userPrincipalName,omitempty"`
+	Issuer            string `json:"issuer"`
+	Scope             string `json:"scope"`
+	ExpiresOn         string `json:"expiresOn"`
+}
+
+// LeadershipState object definition, content of the local state file updated after every renewal
+type LeadershipState struct {
+	Leader               bool   `json:"leader"`
+	LeaseID              string `json:"leaseId"`
+	SubscriptionID       string `json:"subscriptionId,omitempty"`
+	ResourceGroupName    string `json:"resourceGroupName,omitempty"`
+	StorageAccountName   string `json:"storageAccountName"`
+	ContainerName        string `json:"containerName"`
+	BlobName             string `json:"blobName"`
+	BlobURL              string `json:"blobUrl,omitempty"`
+	Holder               string `json:"holder,omitempty"`
+	LeaseExpiresAt       string `json:"leaseExpiresAt,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	UpdatedAt            string `json:"updatedAt"`
+	ErrorMessage         string `json:"errorMessage,omitempty"`
+
+	// Duration of the last successful renewal request
+	RenewalLatencyMs float64 `json:"renewalLatencyMs,omitempty"`
+}
+
+// LeadershipEvent object definition, a leadership transition observed by watch subcommand, holders are the ones
+// recorded in blob metadata and only known while the blob is leased
+type LeadershipEvent struct {
+	Event              string `json:"event"`
+	StorageAccountName string `json:"storageAccountName"`
+	ContainerName      string `json:"containerName"`
+	BlobName           string `json:"blobName"`
+	LeaseState         string `json:"leaseState"`
+	PreviousLeaseState string `json:"previousLeaseState,omitempty"`
+	PreviousHolder     string `json:"previousHolder,omitempty"`
+	NewHolder          string `json:"newHolder,omitempty"`
+	Epoch              int64  `json:"epoch,omitempty"`
+	ObservedAt         string `json:"observedAt"`
+}
+
+// BatchResponse object definition, result of an operation on several blobs
+type BatchResponse struct {
+	Summary  BatchSummary   `json:"summary"`
+	Results  []ResponseInfo `json:"results"`
+	ExitCode *int           `json:"exitCode,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+// BatchSummary object definition, number of blobs of an operation on several blobs per outcome
+type BatchSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Contended int `json:"contended"`
+}
+
+// OperationLatency object definition, latencies measured for a lease operation by bench subcommand
+type OperationLatency struct {
+	Operation string   `json:"operation"`
+	Samples   int      `json:"samples"`
+	Failures  int      `json:"failures"`
+	MinMs     *float64 `json:"minMs,omitempty"`
+	P50Ms     *float64 `json:"p50Ms,omitempty"`
+	P95Ms     *float64 `json:"p95Ms,omitempty"`
+	P99Ms     *float64 `json:"p99Ms,omitempty"`
+	MaxMs     *float64 `json:"maxMs,omitempty"`
+}
+
+// LeaseRequest object definition, body of the lease operations of the serve subcommand api, fields not
+// informed fall back to the values the server was started with
+type LeaseRequest struct {
+	BlobName      string `json:"blobName"`
+	LeaseID       string `json:"leaseId,omitempty"`
+	Holder        string `json:"holder,omitempty"`
+	LeaseDuration int    `json:"leaseDuration,omitempty"`
+}
+
+// AgentConfig object definition, leases maintained by agent subcommand, settings a lease does not inform fall
+// back to the top level ones
+type AgentConfig struct {
+	SubscriptionID     string       `json:"subscriptionId"`
+	ResourceGroupName  string       `json:"resourceGroupName"`
+	AccountName        string       `json:"accountName"`
+	Container          string       `json:"container"`
+	Prefix             string       `json:"prefix,omitempty"`
+	Holder             string       `json:"holder,omitempty"`
+	LeaseDuration      int          `json:"leaseDuration,omitempty"`
+	WaitTimeSec        int          `json:"waitTimeSec,omitempty"`
+	SkipRecordRenewals bool         `json:"skipRecordRenewals,omitempty"`
+	Leases             []AgentLease `json:"leases"`
+}
+
+// AgentLease object definition, a lease maintained by agent subcommand, identified by its name
+type AgentLease struct {
+	Name               string  `json:"name"`
+	SubscriptionID     string  `json:"subscriptionId,omitempty"`
+	ResourceGroupName  string  `json:"resourceGroupName,omitempty"`
+	AccountName        string  `json:"accountName,omitempty"`
+	Container          string  `json:"container,omitempty"`
+	BlobName           string  `json:"blobName,omitempty"`
+	Holder             string  `json:"holder,omitempty"`
+	LeaseDuration      int     `json:"leaseDuration,omitempty"`
+	WaitTimeSec        int     `json:"waitTimeSec,omitempty"`
+	RenewAtFraction    float64 `json:"renewAtFraction,omitempty"`
+	SkipRecordRenewals bool    `json:"skipRecordRenewals,omitempty"`
+	StateFile          string  `json:"stateFile,omitempty"`
+	OnAcquireExec      string  `json:"onAcquireExec,omitempty"`
+	OnRenewExec        string  `json:"onRenewExec,omitempty"`
+	OnLostExec         string  `json:"onLostExec,omitempty"`
+	OnReleaseExec      string  `json:"onReleaseExec,omitempty"`
+	Schedule           string  `json:"schedule,omitempty"`
+	Window             string  `json:"window,omitempty"`
+	TimeZone           string  `json:"timeZone,omitempty"`
+}
+
+// AgentHealth object definition, health of agent subcommand and leadership state of each lease by name
+type AgentHealth struct {
+	Healthy bool                       `json:"healthy"`
+	Leases  map[string]LeadershipState `json:"leases"`
+}
+
+// CheckResult object definition, result of a doctor preflight check
+type CheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// StorageAccountRef object definition, identifies a storage account taking part of a quorum
+type StorageAccountRef struct {
+	SubscriptionID    string
+	ResourceGroupName string
+	AccountName       string
+}
+
+// BlobInfo object definition, describes a lease blob returned by list subcommand
+type BlobInfo struct {
+	ContainerName *string           `json:"containerName"`
+	BlobName      *string           `json:"blobName"`
+	LeaseState    *string           `json:"leaseState,omitempty"`
+	LeaseStatus   *string           `json:"leaseStatus,omitempty"`
+	Holder        *string           `json:"holder,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+
+	// Lease expiration details, only returned by list subcommand in report mode
+	LeaseAcquiredAt      *string `json:"leaseAcquiredAt,omitempty"`
+	LeaseRenewedAt       *string `json:"leaseRenewedAt,omitempty"`
+	LeaseDurationSeconds *int    `json:"leaseDurationSeconds,omitempty"`
+	LeaseExpiresAt       *string `json:"leaseExpiresAt,omitempty"`
+	SecondsUntilExpiry   *int64  `json:"secondsUntilExpiry,omitempty"`
+
+	// Purge details, only returned by purge subcommand
+	LastActivity *string `json:"lastActivity,omitempty"`
+	Deleted      *bool   `json:"deleted,omitempty"`
+	ErrorMessage *string `json:"errorMessage,omitempty"`
+}
+
+// AuditEntry object definition, one line of the audit log append blob
+type AuditEntry struct {
+	Timestamp string `json:"timestamp"`
+	Holder    string `json:"holder"`
+	Operation string `json:"operation"`
+	LeaseID   string `json:"leaseId"`
+	Epoch     int64  `json:"epoch"`
+	Error     string `json:"error,omitempty"`
 }
 
 // Endpoints object definition
@@ -27,9 +328,15 @@ type Endpoints struct {
 	ResourceManagerAudience      string `json:"activeDirectoryResourceId"`
 }
 
+// Suffixes object definition
+type Suffixes struct {
+	StorageEndpoint string `json:"storageEndpoint"`
+}
+
 // CloudConfigInfo object definition, used to map the output of az cloud show -n <cloud name> -o json
 type CloudConfigInfo struct {
 	Endpoints Endpoints `json:"endpoints"`
+	Suffixes  Suffixes  `json:"suffixes"`
 }
 
 // AzBlobClient object definition
@@ -12,7 +12,10 @@ import (
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/google/uuid"
@@ -22,8 +25,10 @@ import (
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
 )
 
-// AcquireLease - acquires an Azure blob storage lease
-func AcquireLease(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, leaseDuration, retries, waittimesec int, cred azcore.TokenCredential) models.ResponseInfo {
+// AcquireLease - acquires an Azure blob storage lease, attempting retries times or, when maxWait is greater
+// than 0, until maxWait is spent, with a Contended status when it is. With steal, a lease held by someone else is broken
+// and acquired right away, for controlled leadership takeover.
+func AcquireLease(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, holder string, leaseDuration, retries, waittimesec int, maxWait time.Duration, auditSnapshots bool, auditLogBlob string, steal bool, cred azcore.TokenCredential) models.ResponseInfo {
 
 	response := models.ResponseInfo{
 		SubscriptionID:     &subscriptionID,
@@ -39,39 +44,68 @@ func AcquireLease(cntx context.Context, subscriptionID, resourceGroupName, accou
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
 	// Getting blob client
-	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, cred)
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
 	blobRelativePath := fmt.Sprintf("%v/%v", container, blobName)
 	blobURL := fmt.Sprintf("%v%v", azBlobClient.URL, blobRelativePath)
 
-	blockBlobClient, err := blockblob.NewClient(blobURL, cred, nil)
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
-	_, err = blockBlobClient.GetProperties(cntx, nil)
-	if err != nil {
-		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to get blob %v, error: %v", blobURL, err), config.Stderr())
-		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+	if !precheckConnectivity(cntx, &response, blobURL) {
 		return response
 	}
 
+	auditBlobURL := ""
+	if auditLogBlob != "" {
+		auditBlobURL = fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, auditLogBlob)
+	}
+
+	// Without the exists check, a missing blob is reported by the lease acquisition itself
+	var blobProps blob.GetPropertiesResponse
+	if !config.SkipExistsCheck() {
+		blobProps, err = blockBlobClient.GetProperties(cntx, nil)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to get blob %v, error: %v", blobURL, err), config.Stderr())
+			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
+			return response
+		}
+	}
+
+	// A planned handoff designated another holder, it gets the lease alone until its preference window is over.
+	// Without the exists check the hint is not read and the lease is attempted right away.
+	if successor, preferredUntil, found := common.DesignatedSuccessor(blobProps.Metadata); found && !strings.EqualFold(successor, holder) {
+		utils.ConsoleOutput(fmt.Sprintf("lease handed off to %v, waiting until %v before attempting", successor, preferredUntil.UTC().Format(time.RFC3339)), config.Stderr())
+		if sleepErr := utils.Sleep(cntx, time.Until(preferredUntil)); sleepErr != nil {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("acquire cancelled: %v", sleepErr))
+			classifyError(&response, sleepErr)
+			return response
+		}
+	}
+
 	// AcquireLease
 
 	// Generating LeaseID
 	proposedLeaseID := uuid.New().String()
-	for i := 0; i < retries; i++ {
+	budget := newAcquireBudget(retries, maxWait)
+	for i := 0; budget.allows(i); i++ {
 
 		// Getting lease client
 		blobLeaseClient, err := lease.NewBlobClient(blockBlobClient, &lease.BlobClientOptions{
@@ -81,32 +115,219 @@ func AcquireLease(cntx context.Context, subscriptionID, resourceGroupName, accou
 		if err != nil {
 			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease client: %v", err), config.Stderr())
 			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
 		} else {
 
 			// Acquiring lease
+			attemptStart := time.Now()
 			_, err := blobLeaseClient.AcquireLease(
 				cntx,
 				int32(leaseDuration),
 				&lease.BlobAcquireOptions{},
 			)
 
+			// Taking over the lease, breaking it with no break period ends it right away so it can be
+			// acquired immediately, before the previous holder or another candidate gets it back
+			if err != nil && steal && bloberror.HasCode(err, bloberror.LeaseAlreadyPresent) {
+				_, breakErr := blobLeaseClient.BreakLease(cntx, &lease.BlobBreakOptions{BreakPeriod: to.Int32Ptr(0)})
+				if breakErr != nil {
+					utils.ConsoleOutput(fmt.Sprintf("an error ocurred while breaking lease: %v.", breakErr), config.Stderr())
+				} else {
+					utils.ConsoleOutput("lease held by another holder was broken", config.Stderr())
+					if warning := appendAuditEntry(cntx, auditBlobURL, accountName, models.AuditEntry{
+						Holder:    utils.MetadataValue(blobProps.Metadata, config.MetadataHolder()),
+						Operation: "break",
+						Epoch:     common.MetadataEpoch(blobProps.Metadata),
+					}, cred); warning != "" {
+						utils.AddWarning(&response, warning)
+					}
+					_, err = blobLeaseClient.AcquireLease(cntx, int32(leaseDuration), &lease.BlobAcquireOptions{})
+					if err == nil {
+						response.Stolen = to.BoolPtr(true)
+					}
+				}
+			}
+
+			common.ReportLeaseOperation("acquire", err == nil, time.Since(attemptStart), accountName, container, blobName)
+
+			// Retrying cannot make a missing blob appear
+			if err != nil && bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+				message := fmt.Sprintf("blob %v does not exist, it must be created with createleaseblob or acquire create-if-missing", blobURL)
+				utils.ConsoleOutput(message, config.Stderr())
+				response.ErrorMessage = to.StringPtr(message)
+				classifyError(&response, err)
+				break
+			}
+
 			if err != nil {
 				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease: %v.", err), config.Stderr())
 				response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&response, err)
 			} else {
-				response.ErrorMessage = nil
+				clearError(&response)
 				break
 			}
 
 		}
 
-		time.Sleep(time.Duration(waittimesec) * time.Second)
+		// Further attempts would fail fast as well
+		if common.RetryBudgetOpen() {
+			break
+		}
+
+		if sleepErr := utils.Sleep(cntx, budget.wait(utils.Jitter(time.Duration(waittimesec)*time.Second))); sleepErr != nil {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("acquire cancelled: %v", sleepErr))
+			classifyError(&response, sleepErr)
+			break
+		}
+	}
+
+	if response.ErrorMessage != nil && budget.contended(response) {
+		response.Status = to.StringPtr(config.Contended())
 	}
 
 	if response.ErrorMessage == nil {
 		response.Status = to.StringPtr(config.Success())
 		response.LeaseID = to.StringPtr(proposedLeaseID)
+		response.BlobURL = to.StringPtr(blobURL)
+
+		// Without the exists check, metadata is only read once the lease is held
+		var metadataErr error
+		if config.SkipExistsCheck() {
+			blobProps, metadataErr = blockBlobClient.GetProperties(cntx, nil)
+		}
+
+		// Recording holder information, a failure here does not invalidate the acquired lease. Existing metadata
+		// is preserved, so nothing is recorded when it could not be read.
+		epoch := common.MetadataEpoch(blobProps.Metadata) + 1
+		if metadataErr == nil {
+			metadataErr = common.SetHolderMetadata(cntx, blockBlobClient, blobProps.Metadata, proposedLeaseID, holder, leaseDuration, epoch)
+		}
+		if metadataErr != nil && common.IsImmutabilityError(metadataErr) {
+			utils.AddWarning(&response, fmt.Sprintf("lease holder metadata could not be recorded: %v", common.DescribeImmutabilityError(cntx, azBlobClient.Client.ServiceClient().NewContainerClient(container), container, metadataErr)))
+		} else if metadataErr != nil {
+			utils.AddWarning(&response, fmt.Sprintf("lease holder metadata could not be recorded: %v", metadataErr))
+		}
+
+		if config.DeleteOldVersions() {
+			deleteOldVersions(cntx, &response, azBlobClient.Client.ServiceClient().NewContainerClient(container), blockBlobClient, blobName)
+		}
+
+		auditOperation := "acquire"
+		if response.Stolen != nil {
+			auditOperation = "steal"
+		}
+
+		var auditWarnings []string
+		response.Snapshot, auditWarnings = recordAcquisitionAudit(cntx, blockBlobClient, accountName, proposedLeaseID, auditSnapshots, auditBlobURL, auditOperation, holder, epoch, cred)
+		for _, warning := range auditWarnings {
+			utils.AddWarning(&response, warning)
+		}
+	}
+
+	return response
+}
+
+// recordAcquisitionAudit creates the audit snapshot of an acquired lease blob, when auditSnapshots is set, and
+// appends the acquisition to the audit log blob, when auditBlobURL is not empty. Failures do not invalidate the
+// acquired lease so they are returned as warnings along with the snapshot created.
+func recordAcquisitionAudit(cntx context.Context, blockBlobClient *blockblob.Client, accountName, leaseID string, auditSnapshots bool, auditBlobURL, operation, holder string, epoch int64, cred azcore.TokenCredential) (*string, []string) {
+	var snapshot *string
+	warnings := []string{}
+
+	// Snapshot taken after the metadata update so it carries the new holder information
+	if auditSnapshots {
+		snapshotResponse, err := blockBlobClient.CreateSnapshot(cntx, &blob.CreateSnapshotOptions{
+			AccessConditions: &blob.AccessConditions{
+				LeaseAccessConditions: &blob.LeaseAccessConditions{
+					LeaseID: &leaseID,
+				},
+			},
+		})
+
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("audit snapshot could not be created: %v", err))
+		} else {
+			snapshot = snapshotResponse.Snapshot
+		}
+	}
+
+	warning := appendAuditEntry(cntx, auditBlobURL, accountName, models.AuditEntry{
+		Holder:    holder,
+		Operation: operation,
+		LeaseID:   leaseID,
+		Epoch:     epoch,
+	}, cred)
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+
+	return snapshot, warnings
+}
+
+// appendAuditEntry appends entry to the audit log blob when auditBlobURL is not empty. A failure does not affect
+// the lease operation, the warning to report is returned instead, empty when the entry was appended.
+func appendAuditEntry(cntx context.Context, auditBlobURL, accountName string, entry models.AuditEntry, cred azcore.TokenCredential) string {
+	if auditBlobURL == "" {
+		return ""
+	}
+
+	err := common.AppendAuditLog(cntx, auditBlobURL, accountName, cred, entry)
+	if err != nil {
+		return fmt.Sprintf("audit log blob %v could not be appended to: %v", auditBlobURL, err)
+	}
+	return ""
+}
+
+// AcquireLeaseCreatingBlob - acquires the lease like AcquireLease, creating the container and a block blob when the
+// blob is missing, with the conditional creation of createleaseblob so concurrent bootstraps don't overwrite each
+// other, then acquiring it. The response reports whether the container and the blob were created.
+func AcquireLeaseCreatingBlob(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, holder string, leaseDuration, retries, waittimesec int, maxWait time.Duration, auditSnapshots bool, auditLogBlob string, steal bool, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := AcquireLease(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, holder, leaseDuration, retries, waittimesec, maxWait, auditSnapshots, auditLogBlob, steal, cred)
+	if response.ErrorCategory == nil || *response.ErrorCategory != common.ErrorCategoryNotFound {
+		return response
 	}
 
+	utils.ConsoleOutput(fmt.Sprintf("blob %v/%v not found, creating it", container, blobName), config.Stderr())
+	createResponse := CreateLeaseBlob(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, nil, nil, 1024, nil, "block", "", "", nil, true, cred)
+	if *createResponse.Status == config.Fail() {
+		return createResponse
+	}
+
+	response = AcquireLease(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, holder, leaseDuration, retries, waittimesec, maxWait, auditSnapshots, auditLogBlob, steal, cred)
+	response.ContainerCreated = createResponse.ContainerCreated
+	response.BlobCreated = createResponse.BlobCreated
+	response.Warnings = append(createResponse.Warnings, response.Warnings...)
 	return response
 }
+
+// deleteOldVersions deletes the previous versions of the lease blob left behind by metadata updates, a failure does
+// not invalidate the lease so it is only reported as a warning
+func deleteOldVersions(cntx context.Context, response *models.ResponseInfo, containerClient *container.Client, blockBlobClient *blockblob.Client, blobName string) {
+	deleted, err := common.DeletePreviousVersions(cntx, containerClient, blockBlobClient, blobName)
+	if response.DeletedVersions != nil {
+		deleted += *response.DeletedVersions
+	}
+	response.DeletedVersions = to.IntPtr(deleted)
+	if err != nil {
+		utils.AddWarning(response, fmt.Sprintf("previous versions of the blob could not be deleted: %v", err))
+	}
+}
+
+// precheckConnectivity probes the blob endpoint when a pre-check timeout is configured, so a network or dns problem
+// fails the acquisition within seconds instead of spending all retries, returning false with the failure recorded
+// in the response
+func precheckConnectivity(cntx context.Context, response *models.ResponseInfo, url string) bool {
+	if config.PrecheckTimeout() == 0 {
+		return true
+	}
+
+	if err := common.CheckConnectivity(cntx, url, config.PrecheckTimeout()); err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("connectivity pre-check failed: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(response, err)
+		return false
+	}
+	return true
+}
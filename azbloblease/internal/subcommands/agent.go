@@ -0,0 +1,587 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// ReadAgentConfig reads the yaml or json configuration of agent subcommand, applying the top level settings to
+// the leases that do not inform them and checking every lease can be maintained
+func ReadAgentConfig(path, defaultHolder string) ([]models.AgentLease, error) {
+	configJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("an error ocurred while reading agent config: %v", err)
+	}
+
+	// Yaml files are converted to json so both formats share the field names and the unknown field checks
+	if extension := strings.ToLower(filepath.Ext(path)); extension == ".yaml" || extension == ".yml" {
+		configJSON, err = yamlToJSON(configJSON)
+		if err != nil {
+			return nil, fmt.Errorf("an error ocurred while parsing agent config %v: %v", path, err)
+		}
+	}
+
+	agentConfig := models.AgentConfig{}
+	decoder := json.NewDecoder(strings.NewReader(string(configJSON)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&agentConfig); err != nil {
+		return nil, fmt.Errorf("an error ocurred while parsing agent config %v: %v", path, err)
+	}
+
+	if len(agentConfig.Leases) == 0 {
+		return nil, fmt.Errorf("agent config %v has no leases", path)
+	}
+
+	leases := []models.AgentLease{}
+	names := map[string]bool{}
+	for _, agentLease := range agentConfig.Leases {
+		if agentLease.Name == "" {
+			agentLease.Name = agentLease.BlobName
+		}
+		if agentLease.BlobName == "" {
+			agentLease.BlobName = agentLease.Name
+		}
+		agentLease.BlobName = agentConfig.Prefix + agentLease.BlobName
+
+		agentLease.SubscriptionID = firstNonEmpty(agentLease.SubscriptionID, agentConfig.SubscriptionID)
+		agentLease.ResourceGroupName = firstNonEmpty(agentLease.ResourceGroupName, agentConfig.ResourceGroupName)
+		agentLease.AccountName = firstNonEmpty(agentLease.AccountName, agentConfig.AccountName)
+		agentLease.Container = strings.ToLower(firstNonEmpty(agentLease.Container, agentConfig.Container))
+		agentLease.Holder = firstNonEmpty(agentLease.Holder, agentConfig.Holder, defaultHolder)
+		agentLease.SkipRecordRenewals = agentLease.SkipRecordRenewals || agentConfig.SkipRecordRenewals
+
+		if agentLease.LeaseDuration == 0 {
+			agentLease.LeaseDuration = agentConfig.LeaseDuration
+		}
+		if agentLease.LeaseDuration == 0 {
+			agentLease.LeaseDuration = 60
+		}
+
+		// Renewing at half of the lease duration leaves room for a failed renewal before the lease expires
+		if agentLease.WaitTimeSec == 0 {
+			agentLease.WaitTimeSec = agentConfig.WaitTimeSec
+		}
+		if agentLease.WaitTimeSec == 0 {
+			agentLease.WaitTimeSec = agentLease.LeaseDuration / 2
+		}
+
+		if agentLease.Name == "" {
+			return nil, fmt.Errorf("agent config %v has a lease without name nor blobName", path)
+		}
+
+		if names[agentLease.Name] {
+			return nil, fmt.Errorf("agent config %v has more than one lease named %v", path, agentLease.Name)
+		}
+		names[agentLease.Name] = true
+
+		if agentLease.SubscriptionID == "" || agentLease.ResourceGroupName == "" || agentLease.AccountName == "" || agentLease.Container == "" {
+			return nil, fmt.Errorf("lease %v: subscriptionId, resourceGroupName, accountName and container are required", agentLease.Name)
+		}
+
+		if agentLease.LeaseDuration < 15 || agentLease.LeaseDuration > 60 {
+			return nil, fmt.Errorf("lease %v: leaseDuration must be between 15 and 60", agentLease.Name)
+		}
+
+		if agentLease.WaitTimeSec < 1 || agentLease.WaitTimeSec >= agentLease.LeaseDuration {
+			return nil, fmt.Errorf("lease %v: waitTimeSec must be between 1 and the lease duration", agentLease.Name)
+		}
+
+		if agentLease.RenewAtFraction < 0 || agentLease.RenewAtFraction >= 1 {
+			return nil, fmt.Errorf("lease %v: renewAtFraction must be between 0 and 1", agentLease.Name)
+		}
+
+		if agentLease.Schedule != "" || agentLease.Window != "" || agentLease.TimeZone != "" {
+			if _, _, err := agentLeaseWindow(agentLease, time.Now()); err != nil {
+				return nil, fmt.Errorf("lease %v: %v", agentLease.Name, err)
+			}
+		}
+
+		leases = append(leases, agentLease)
+	}
+
+	return leases, nil
+}
+
+// yamlToJSON converts a yaml document to json, mapping keys must be strings as json object keys are
+func yamlToJSON(document []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(document, &value); err != nil {
+		return nil, err
+	}
+
+	result, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("only mappings with string keys are supported: %v", err)
+	}
+	return result, nil
+}
+
+// firstNonEmpty returns the first value that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// leaseAgent maintains several independent leases concurrently, each one acquired, renewed and, once the agent
+// stops or the lease is removed from the configuration, released on its own
+type leaseAgent struct {
+	// Counters first, 64 bit atomic operations require 64 bit alignment on 32 bit platforms
+	acquisitions int64
+	renewals     int64
+	losses       int64
+	errors       int64
+	reloads      int64
+
+	environment     string
+	cloudConfigFile string
+	cred            azcore.TokenCredential
+
+	// Blob service clients shared by the workers, leases of the same storage account reuse them
+	clients *BlobClients
+
+	mutex   sync.Mutex
+	workers map[string]*agentWorker
+
+	// Renewal latencies by lease name, kept while the lease is in the configuration
+	latenciesMutex sync.Mutex
+	latencies      map[string]*latencyHistogram
+}
+
+// agentWorker maintains a single lease of the agent
+type agentWorker struct {
+	agent  *leaseAgent
+	lease  models.AgentLease
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mutex   sync.Mutex
+	state   models.LeadershipState
+	healthy bool
+}
+
+// RunAgent - maintains the leases until the context is cancelled, then releases them. On reload the
+// configuration file is read again, removed or changed leases are released and new ones started, an invalid
+// configuration is reported and the current leases are kept. With listen, health and metrics of all leases
+// are served on /healthz and /metrics.
+func RunAgent(cntx context.Context, configFile, defaultHolder string, leases []models.AgentLease, environment, cloudConfigFile, listen string, reload <-chan os.Signal, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		Status: to.StringPtr(config.Fail()),
+	}
+
+	agent := &leaseAgent{
+		environment:     environment,
+		cloudConfigFile: cloudConfigFile,
+		cred:            cred,
+		clients:         NewBlobClients(environment, cloudConfigFile, cred),
+		workers:         map[string]*agentWorker{},
+		latencies:       map[string]*latencyHistogram{},
+	}
+
+	if listen != "" {
+		listener, err := listenOn(listen)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while listening on %v: %v", listen, err), config.Stderr())
+			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			return response
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", agent.handleHealth)
+		mux.HandleFunc("/metrics", agent.handleMetrics)
+		httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go httpServer.Serve(listener)
+		defer httpServer.Close()
+		utils.ConsoleOutput(fmt.Sprintf("serving agent health and metrics on %v", listen), config.Stderr())
+	}
+
+	agent.apply(cntx, leases)
+
+	for {
+		select {
+		case <-cntx.Done():
+			agent.apply(cntx, nil)
+			response.Status = to.StringPtr(config.Success())
+			return response
+		case <-reload:
+			reloadedLeases, err := ReadAgentConfig(configFile, defaultHolder)
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("configuration not reloaded, current leases are kept: %v", err), config.Stderr())
+				continue
+			}
+			atomic.AddInt64(&agent.reloads, 1)
+			utils.ConsoleOutput(fmt.Sprintf("configuration reloaded from %v", configFile), config.Stderr())
+			agent.apply(cntx, reloadedLeases)
+		}
+	}
+}
+
+// apply stops the workers of leases that were removed or changed and then starts the ones of new or changed
+// leases, so a changed lease is released before being acquired again. The workers being stopped are no
+// longer reported while their leases are released.
+func (a *leaseAgent) apply(cntx context.Context, leases []models.AgentLease) {
+	wanted := map[string]models.AgentLease{}
+	for _, agentLease := range leases {
+		wanted[agentLease.Name] = agentLease
+	}
+
+	a.mutex.Lock()
+	stopping := []*agentWorker{}
+	for name, worker := range a.workers {
+		if agentLease, found := wanted[name]; found && agentLease == worker.lease {
+			continue
+		}
+		delete(a.workers, name)
+		stopping = append(stopping, worker)
+	}
+	a.mutex.Unlock()
+
+	for _, worker := range stopping {
+		worker.cancel()
+	}
+	for _, worker := range stopping {
+		<-worker.done
+	}
+
+	if cntx.Err() != nil {
+		return
+	}
+
+	a.latenciesMutex.Lock()
+	for name := range a.latencies {
+		if _, found := wanted[name]; !found {
+			delete(a.latencies, name)
+		}
+	}
+	a.latenciesMutex.Unlock()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for name, agentLease := range wanted {
+		if _, found := a.workers[name]; found {
+			continue
+		}
+
+		workerCntx, cancel := context.WithCancel(cntx)
+		worker := &agentWorker{
+			agent:   a,
+			lease:   agentLease,
+			cancel:  cancel,
+			done:    make(chan struct{}),
+			healthy: true,
+			state:   agentLeaseState(agentLease, false),
+		}
+		a.workers[name] = worker
+		go worker.run(workerCntx)
+	}
+}
+
+// agentLeaseState returns the leadership state of a lease of the agent
+func agentLeaseState(agentLease models.AgentLease, leader bool) models.LeadershipState {
+	return models.LeadershipState{
+		Leader:               leader,
+		SubscriptionID:       agentLease.SubscriptionID,
+		ResourceGroupName:    agentLease.ResourceGroupName,
+		StorageAccountName:   agentLease.AccountName,
+		ContainerName:        agentLease.Container,
+		BlobName:             agentLease.BlobName,
+		Holder:               agentLease.Holder,
+		LeaseDurationSeconds: agentLease.LeaseDuration,
+	}
+}
+
+// run acquires the lease, attempting every waitTimeSec while someone else holds it, and renews it until it is
+// lost, in which case it goes back to acquiring, or until the worker is stopped, in which case it is released
+func (w *agentWorker) run(cntx context.Context) {
+	defer close(w.done)
+
+	agentLease := w.lease
+	observers := []common.LeadershipObserver{w, &common.HookObserver{OnRenew: agentLease.OnRenewExec, OnLost: agentLease.OnLostExec}}
+	if agentLease.StateFile != "" {
+		observers = append(observers, &common.StateFileObserver{Path: agentLease.StateFile})
+	}
+
+	for cntx.Err() == nil {
+		// Scheduled leases are only held during their windows, the lease is released when the window ends
+		windowCntx, cancelWindow := context.WithCancel(cntx)
+		if agentLease.Schedule != "" {
+			inWindow, at, _ := agentLeaseWindow(agentLease, time.Now())
+			if !inWindow {
+				cancelWindow()
+				w.update(agentLeaseState(agentLease, false), true)
+				utils.ConsoleOutput(fmt.Sprintf("lease %v is outside its schedule, next window starts at %v", agentLease.Name, utils.Timestamp(at)), config.Stderr())
+				utils.Sleep(cntx, time.Until(at))
+				continue
+			}
+			cancelWindow()
+			windowCntx, cancelWindow = context.WithDeadline(cntx, at)
+		}
+
+		result := AcquireLease(windowCntx, agentLease.SubscriptionID, agentLease.ResourceGroupName, agentLease.AccountName, agentLease.Container, agentLease.BlobName, w.agent.environment, w.agent.cloudConfigFile, agentLease.Holder, agentLease.LeaseDuration, 1, 0, 0, false, "", false, w.agent.cred)
+
+		if *result.Status == config.Success() {
+			atomic.AddInt64(&w.agent.acquisitions, 1)
+			utils.ConsoleOutput(fmt.Sprintf("lease %v acquired, lease id %v", agentLease.Name, *result.LeaseID), config.Stderr())
+
+			state := agentLeaseState(agentLease, true)
+			state.LeaseID = *result.LeaseID
+			state.BlobURL = *result.BlobURL
+			state.LeaseExpiresAt = utils.Timestamp(time.Now().Add(time.Duration(agentLease.LeaseDuration) * time.Second))
+			w.update(state, true)
+			w.writeState(state)
+			w.runHook(cntx, agentLease.OnAcquireExec, common.HookEventAcquire, state)
+
+			RenewLeaseWithClients(windowCntx, w.agent.clients, agentLease.SubscriptionID, agentLease.ResourceGroupName, agentLease.AccountName, agentLease.Container, agentLease.BlobName, state.LeaseID, "", math.MaxInt32, agentLease.WaitTimeSec, agentLease.RenewAtFraction, 0, "", !agentLease.SkipRecordRenewals, false, observers, w.agent.cred)
+
+			if cntx.Err() != nil {
+				cancelWindow()
+				w.release(state)
+				return
+			}
+			if windowCntx.Err() != nil {
+				utils.ConsoleOutput(fmt.Sprintf("window of lease %v is over", agentLease.Name), config.Stderr())
+				cancelWindow()
+				w.release(state)
+				continue
+			}
+		} else if result.ErrorCategory != nil && *result.ErrorCategory == common.ErrorCategoryConflict {
+			w.update(agentLeaseState(agentLease, false), true)
+		} else if cntx.Err() == nil {
+			atomic.AddInt64(&w.agent.errors, 1)
+			state := agentLeaseState(agentLease, false)
+			if result.ErrorMessage != nil {
+				state.ErrorMessage = *result.ErrorMessage
+			}
+			w.update(state, false)
+		}
+		cancelWindow()
+
+		utils.Sleep(cntx, utils.Jitter(time.Duration(agentLease.WaitTimeSec)*time.Second))
+	}
+}
+
+// agentLeaseWindow returns whether the lease is within a window of its schedule and either when that window ends or,
+// outside the windows, when the next one starts. Windows start on every start of the cron schedule, evaluated in
+// the time zone of the lease or in UTC, and last for the window duration.
+func agentLeaseWindow(agentLease models.AgentLease, now time.Time) (bool, time.Time, error) {
+	if agentLease.Schedule == "" || agentLease.Window == "" {
+		return false, time.Time{}, fmt.Errorf("schedule and window are informed together")
+	}
+
+	schedule, err := utils.ParseSchedule(agentLease.Schedule)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	window, err := time.ParseDuration(agentLease.Window)
+	if err != nil || window <= 0 {
+		return false, time.Time{}, fmt.Errorf("window %v must be a duration greater than 0, e.g. 2h", agentLease.Window)
+	}
+
+	location, err := time.LoadLocation(agentLease.TimeZone)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid time zone %v: %v", agentLease.TimeZone, err)
+	}
+
+	now = now.In(location)
+	if windowEnd, found := schedule.WindowEnd(now, window); found {
+		return true, windowEnd, nil
+	}
+
+	nextStart, found := schedule.Next(now)
+	if !found {
+		return false, time.Time{}, fmt.Errorf("schedule %v has no start within a year", agentLease.Schedule)
+	}
+	return false, nextStart, nil
+}
+
+// release releases the lease held when the worker is stopped, with its own timeout since the agent context
+// is already cancelled
+func (w *agentWorker) release(state models.LeadershipState) {
+	releaseCntx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+
+	result := ReleaseLease(releaseCntx, state.SubscriptionID, state.ResourceGroupName, state.StorageAccountName, state.ContainerName, state.BlobName, state.LeaseID, w.agent.environment, w.agent.cloudConfigFile, "", w.agent.cred)
+	if *result.Status != config.Success() {
+		return
+	}
+	utils.ConsoleOutput(fmt.Sprintf("lease %v released", w.lease.Name), config.Stderr())
+
+	state.Leader = false
+	state.LeaseExpiresAt = ""
+	state.ErrorMessage = "lease released"
+	w.update(state, true)
+	w.writeState(state)
+	w.runHook(releaseCntx, w.lease.OnReleaseExec, common.HookEventRelease, state)
+}
+
+// writeState persists the leadership state to the state file of the lease, when it has one, a failure is only logged
+func (w *agentWorker) writeState(state models.LeadershipState) {
+	if w.lease.StateFile == "" {
+		return
+	}
+
+	if err := common.WriteStateFile(w.lease.StateFile, state); err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while writing state file %v: %v", w.lease.StateFile, err), config.Stderr())
+	}
+}
+
+// runHook runs a lifecycle hook of the lease, a failing hook is only logged
+func (w *agentWorker) runHook(cntx context.Context, path, event string, state models.LeadershipState) {
+	if path == "" {
+		return
+	}
+
+	if err := common.RunHook(cntx, path, event, state); err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while running %v hook %v of lease %v: %v", event, path, w.lease.Name, err), config.Stderr())
+	}
+}
+
+// update records the leadership state of the lease, healthy is false when it could not be attempted
+func (w *agentWorker) update(state models.LeadershipState, healthy bool) {
+	state.UpdatedAt = utils.Timestamp(time.Now())
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.state = state
+	w.healthy = healthy
+}
+
+// snapshot returns the leadership state of the lease and whether it is healthy
+func (w *agentWorker) snapshot() (models.LeadershipState, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.state, w.healthy
+}
+
+// Name identifies the observer in diagnostic messages
+func (w *agentWorker) Name() string {
+	return "agent lease " + w.lease.Name
+}
+
+// Update records the outcome of every renewal of the lease
+func (w *agentWorker) Update(cntx context.Context, state models.LeadershipState) error {
+	if state.Leader {
+		atomic.AddInt64(&w.agent.renewals, 1)
+		w.agent.observeRenewal(w.lease.Name, state.RenewalLatencyMs/1000)
+	} else if cntx.Err() == nil {
+		atomic.AddInt64(&w.agent.losses, 1)
+		utils.ConsoleOutput(fmt.Sprintf("lease %v lost: %v", w.lease.Name, state.ErrorMessage), config.Stderr())
+	}
+
+	w.update(state, true)
+	return nil
+}
+
+// Health returns the leadership state of every lease, the agent is healthy when every lease is either held or
+// held by someone else, a lease that could not be attempted, e.g. for lack of access, makes it unhealthy
+func (a *leaseAgent) Health() models.AgentHealth {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	health := models.AgentHealth{
+		Healthy: true,
+		Leases:  map[string]models.LeadershipState{},
+	}
+
+	for name, worker := range a.workers {
+		state, healthy := worker.snapshot()
+		health.Leases[name] = state
+		health.Healthy = health.Healthy && healthy
+	}
+	return health
+}
+
+// handleHealth serves the health of the agent, with 503 status code when unhealthy
+func (a *leaseAgent) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := a.Health()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !health.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// observeRenewal records the duration in seconds of a renewal of the lease
+func (a *leaseAgent) observeRenewal(name string, seconds float64) {
+	a.latenciesMutex.Lock()
+	defer a.latenciesMutex.Unlock()
+
+	histogram, found := a.latencies[name]
+	if !found {
+		histogram = newLatencyHistogram()
+		a.latencies[name] = histogram
+	}
+	histogram.observe(seconds)
+}
+
+// handleMetrics serves the agent counters, whether each lease is held and renewal latencies in prometheus
+// text format, leases sorted by name
+func (a *leaseAgent) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	health := a.Health()
+	names := []string{}
+	leaders := 0
+	for name, state := range health.Leases {
+		names = append(names, name)
+		if state.Leader {
+			leaders++
+		}
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "azbloblease_agent_leases", "gauge", "Number of leases maintained by the agent", int64(len(health.Leases)))
+	writeMetric(w, "azbloblease_agent_leaders", "gauge", "Number of leases currently held by the agent", int64(leaders))
+	writeMetric(w, "azbloblease_agent_acquisitions_total", "counter", "Leases acquired", atomic.LoadInt64(&a.acquisitions))
+	writeMetric(w, "azbloblease_agent_renewals_total", "counter", "Leases renewed", atomic.LoadInt64(&a.renewals))
+	writeMetric(w, "azbloblease_agent_losses_total", "counter", "Leases lost while renewing", atomic.LoadInt64(&a.losses))
+	writeMetric(w, "azbloblease_agent_errors_total", "counter", "Acquire attempts failed for a reason other than the lease being held", atomic.LoadInt64(&a.errors))
+	writeMetric(w, "azbloblease_agent_reloads_total", "counter", "Configuration reloads", atomic.LoadInt64(&a.reloads))
+
+	writeMetricHeader(w, "azbloblease_is_leader", "gauge", "Whether the agent holds the lease, 1 when it does")
+	for _, name := range names {
+		isLeader := 0
+		if health.Leases[name].Leader {
+			isLeader = 1
+		}
+		fmt.Fprintf(w, "azbloblease_is_leader{lease=\"%v\"} %v\n", escapeLabel(name), isLeader)
+	}
+
+	writeMetricHeader(w, "azbloblease_renewal_duration_seconds", "histogram", "Duration of lease renewal requests")
+	a.latenciesMutex.Lock()
+	defer a.latenciesMutex.Unlock()
+	for _, name := range names {
+		if histogram, found := a.latencies[name]; found {
+			histogram.write(w, "azbloblease_renewal_duration_seconds", fmt.Sprintf("lease=\"%v\"", escapeLabel(name)))
+		}
+	}
+}
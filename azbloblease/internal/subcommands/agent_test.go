@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadAgentConfig(t *testing.T) {
+	yamlConfig := `subscriptionId: "00000000-0000-0000-0000-000000000000"
+resourceGroupName: rg
+accountName: account
+container: AzBlobLease
+leaseDuration: 30
+leases:
+  - name: scheduler
+    onAcquireExec: /usr/local/bin/start-scheduler.sh
+  - name: compactor
+    blobName: compactor-lock
+    leaseDuration: 60
+    renewAtFraction: 0.5
+  - name: maintenance
+    schedule: "0 2 * * 6"
+    window: 3h
+`
+	jsonConfig := `{
+    "subscriptionId": "00000000-0000-0000-0000-000000000000",
+    "resourceGroupName": "rg",
+    "accountName": "account",
+    "container": "AzBlobLease",
+    "leaseDuration": 30,
+    "leases": [
+        { "name": "scheduler", "onAcquireExec": "/usr/local/bin/start-scheduler.sh" },
+        { "name": "compactor", "blobName": "compactor-lock", "leaseDuration": 60, "renewAtFraction": 0.5 },
+        { "name": "maintenance", "schedule": "0 2 * * 6", "window": "3h" }
+    ]
+}`
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr bool
+	}{
+		{"yaml", "leases.yaml", yamlConfig, false},
+		{"yml", "leases.yml", yamlConfig, false},
+		{"json", "leases.json", jsonConfig, false},
+		{"yaml unknown field", "leases.yaml", yamlConfig + "unknown: true\n", true},
+		{"yaml syntax error", "leases.yaml", "leases: [\n", true},
+		{"yaml without leases", "leases.yaml", "accountName: account\n", true},
+	}
+
+	var leasesByFormat [][]string
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), test.file)
+		if err := ioutil.WriteFile(path, []byte(test.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		leases, err := ReadAgentConfig(path, "node1")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: ReadAgentConfig() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+
+		if len(leases) != 3 {
+			t.Errorf("%v: ReadAgentConfig() = %v leases, want 3", test.name, len(leases))
+			continue
+		}
+
+		summary := []string{}
+		for _, lease := range leases {
+			summary = append(summary, lease.Name, lease.BlobName, lease.Container, lease.Holder, lease.OnAcquireExec, lease.Schedule, lease.Window)
+		}
+		leasesByFormat = append(leasesByFormat, summary)
+
+		if leases[0].LeaseDuration != 30 || leases[1].LeaseDuration != 60 || leases[1].RenewAtFraction != 0.5 || leases[1].WaitTimeSec != 30 {
+			t.Errorf("%v: ReadAgentConfig() = %+v, want top level lease duration and the compactor overrides applied", test.name, leases)
+		}
+	}
+
+	// Every format describes the same leases
+	for i := 1; i < len(leasesByFormat); i++ {
+		if !reflect.DeepEqual(leasesByFormat[i], leasesByFormat[0]) {
+			t.Errorf("leases %v differ from %v", leasesByFormat[i], leasesByFormat[0])
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// AcquireLeaseBatch - acquires independent leases on several blobs concurrently, at most parallelism
+// at a time, results are returned in the same order as blobNames
+func AcquireLeaseBatch(cntx context.Context, subscriptionID, resourceGroupName, accountName, container string, blobNames []string, environment, cloudConfigFile, holder string, leaseDuration, retries, waittimesec int, maxWait time.Duration, auditSnapshots bool, auditLogBlob string, steal bool, parallelism int, cred azcore.TokenCredential) []models.ResponseInfo {
+	results := make([]models.ResponseInfo, len(blobNames))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, parallelism)
+	for i, blobName := range blobNames {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, blobName string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			results[i] = AcquireLease(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, holder, leaseDuration, retries, waittimesec, maxWait, auditSnapshots, auditLogBlob, steal, cred)
+		}(i, blobName)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// RenewLeaseBatch - renews leases of several blobs concurrently, each one on its own schedule with the blob
+// service client shared by all of them, leaseIDs must either have one lease id per blob, in the same order as
+// blobNames, or a single lease id shared by all blobs. Results are returned in the same order as blobNames.
+func RenewLeaseBatch(cntx context.Context, subscriptionID, resourceGroupName, accountName, container string, blobNames, leaseIDs []string, holder, environment, cloudConfigFile string, iterations, waittimesec int, renewAtFraction float64, renewThreshold time.Duration, auditLogBlob string, recordRenewals, preflight bool, cred azcore.TokenCredential) []models.ResponseInfo {
+	results := make([]models.ResponseInfo, len(blobNames))
+	clients := NewBlobClients(environment, cloudConfigFile, cred)
+
+	var wg sync.WaitGroup
+	for i, blobName := range blobNames {
+		leaseID := leaseIDs[0]
+		if len(leaseIDs) == len(blobNames) {
+			leaseID = leaseIDs[i]
+		}
+
+		wg.Add(1)
+		go func(i int, blobName, leaseID string) {
+			defer wg.Done()
+			results[i] = RenewLeaseWithClients(cntx, clients, subscriptionID, resourceGroupName, accountName, container, blobName, leaseID, holder, iterations, waittimesec, renewAtFraction, renewThreshold, auditLogBlob, recordRenewals, preflight, nil, cred)
+		}(i, blobName, leaseID)
+	}
+	wg.Wait()
+
+	return results
+}
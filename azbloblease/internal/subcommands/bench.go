@@ -0,0 +1,132 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/google/uuid"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// benchOperations are the lease operations measured, in the order they are performed on every cycle
+var benchOperations = []string{"acquire", "renew", "release"}
+
+// Benchmark - performs cycles of acquire, renew and release on a blob and reports the latency percentiles
+// of each operation. Cycles whose acquisition fails, e.g. because the blob is leased by someone else, are
+// counted as failures and skip renew and release, a dedicated blob should be used.
+func Benchmark(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, cycles, leaseDuration int, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	blockBlobClient, err := common.GetBlockBlobClient(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	samples := map[string][]time.Duration{}
+	failures := map[string]int{}
+	for i := 0; i < cycles && cntx.Err() == nil; i++ {
+		leaseID := uuid.New().String()
+		blobLeaseClient, err := lease.NewBlobClient(blockBlobClient, &lease.BlobClientOptions{
+			LeaseID: &leaseID,
+		})
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease client: %v", err), config.Stderr())
+			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
+			return response
+		}
+
+		for _, operation := range benchOperations {
+			start := time.Now()
+			switch operation {
+			case "acquire":
+				_, err = blobLeaseClient.AcquireLease(cntx, int32(leaseDuration), &lease.BlobAcquireOptions{})
+			case "renew":
+				_, err = blobLeaseClient.RenewLease(cntx, &lease.BlobRenewOptions{})
+			case "release":
+				_, err = blobLeaseClient.ReleaseLease(cntx, &lease.BlobReleaseOptions{})
+			}
+			elapsed := time.Since(start)
+
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("%v failed on cycle %v: %v", operation, i, err), config.Stderr())
+				response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&response, err)
+				failures[operation]++
+				break
+			}
+			samples[operation] = append(samples[operation], elapsed)
+		}
+	}
+
+	latencies := []models.OperationLatency{}
+	for _, operation := range benchOperations {
+		latencies = append(latencies, operationLatency(operation, samples[operation], failures[operation]))
+	}
+	response.Latencies = &latencies
+
+	if len(samples["acquire"]) == 0 {
+		return response
+	}
+
+	clearError(&response)
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// operationLatency summarizes the latencies measured for an operation, percentiles use the nearest rank method
+func operationLatency(operation string, samples []time.Duration, failures int) models.OperationLatency {
+	latency := models.OperationLatency{
+		Operation: operation,
+		Samples:   len(samples),
+		Failures:  failures,
+	}
+
+	if len(samples) == 0 {
+		return latency
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(samples))))
+		return milliseconds(samples[rank-1])
+	}
+
+	latency.MinMs = to.Float64Ptr(milliseconds(samples[0]))
+	latency.P50Ms = to.Float64Ptr(percentile(50))
+	latency.P95Ms = to.Float64Ptr(percentile(95))
+	latency.P99Ms = to.Float64Ptr(percentile(99))
+	latency.MaxMs = to.Float64Ptr(milliseconds(samples[len(samples)-1]))
+	return latency
+}
+
+// milliseconds returns a duration in milliseconds with microsecond precision
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
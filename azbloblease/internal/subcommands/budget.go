@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// acquireBudget bounds the attempts of an acquisition by the retry count or, when a maximum wait is
+// informed, by the total time spent regardless of the retry count
+type acquireBudget struct {
+	retries  int
+	deadline time.Time
+}
+
+// newAcquireBudget creates the budget of an acquisition, maxWait of 0 means only retries are considered
+func newAcquireBudget(retries int, maxWait time.Duration) acquireBudget {
+	budget := acquireBudget{retries: retries}
+	if maxWait > 0 {
+		budget.deadline = time.Now().Add(maxWait)
+	}
+	return budget
+}
+
+// allows returns true when the attempt, starting at 0, fits in the budget, the first attempt is always allowed
+func (budget acquireBudget) allows(attempt int) bool {
+	if budget.deadline.IsZero() {
+		return attempt < budget.retries
+	}
+	return attempt == 0 || time.Now().Before(budget.deadline)
+}
+
+// wait returns the interval to wait before the next attempt, shortened so it does not go past the deadline
+func (budget acquireBudget) wait(interval time.Duration) time.Duration {
+	if budget.deadline.IsZero() {
+		return interval
+	}
+
+	remaining := time.Until(budget.deadline)
+	if remaining < 0 {
+		return 0
+	}
+	if interval > remaining {
+		return remaining
+	}
+	return interval
+}
+
+// exhausted returns true when a maximum wait was informed and it has been spent
+func (budget acquireBudget) exhausted() bool {
+	return !budget.deadline.IsZero() && !time.Now().Before(budget.deadline)
+}
+
+// contended returns true when the maximum wait was spent and every failure left is the lease being held by someone
+// else, other failures, e.g. authorization or timeout, keep their status and classification
+func (budget acquireBudget) contended(failures ...models.ResponseInfo) bool {
+	if !budget.exhausted() || len(failures) == 0 {
+		return false
+	}
+
+	for _, failure := range failures {
+		if failure.ErrorCode == nil || *failure.ErrorCode != string(bloberror.LeaseAlreadyPresent) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// BlobClients - blob service clients shared by the leases maintained by a process, the blob endpoint of each
+// storage account is looked up once instead of once per lease
+type BlobClients struct {
+	environment     string
+	cloudConfigFile string
+	cred            azcore.TokenCredential
+
+	mutex   sync.Mutex
+	clients map[string]models.AzBlobClient
+}
+
+// NewBlobClients returns an empty set of shared blob service clients
+func NewBlobClients(environment, cloudConfigFile string, cred azcore.TokenCredential) *BlobClients {
+	return &BlobClients{
+		environment:     environment,
+		cloudConfigFile: cloudConfigFile,
+		cred:            cred,
+		clients:         map[string]models.AzBlobClient{},
+	}
+}
+
+// Get returns the blob service client of the storage account, creating it on first use. Failures are not
+// cached, the next lease of the account tries again.
+func (c *BlobClients) Get(cntx context.Context, subscriptionID, resourceGroupName, accountName string) (models.AzBlobClient, error) {
+	key := fmt.Sprintf("%v/%v/%v", subscriptionID, resourceGroupName, accountName)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if azBlobClient, found := c.clients[key]; found {
+		return azBlobClient, nil
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, c.environment, c.cloudConfigFile, c.cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		return models.AzBlobClient{}, err
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, c.environment, c.cloudConfigFile, c.cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		return models.AzBlobClient{}, err
+	}
+
+	c.clients[key] = azBlobClient
+	return azBlobClient, nil
+}
@@ -13,8 +13,12 @@ import (
 	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/pageblob"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
@@ -22,8 +26,15 @@ import (
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
 )
 
-// CreateLeaseBlob - creates a blob to be used for storage lease process
-func CreateLeaseBlob(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, cred azcore.TokenCredential) models.ResponseInfo {
+// maxUploadChunkBytes is the largest content uploaded by a single page or append blob request
+const maxUploadChunkBytes = 4 * 1024 * 1024
+
+// CreateLeaseBlob - creates a blob of blobType (block, page or append) to be used for storage lease process, content
+// is uploaded as is when informed, otherwise the blob is filled with blobSize random bytes, padded with zeros to a
+// multiple of 512 bytes on page blobs. Block blobs get accessTier when informed, otherwise the account default tier.
+// The blob is created with metadata and, when informed, contentType. A missing container is created with
+// containerMetadata and no public access unless createContainer is false, in which case it is reported as an error.
+func CreateLeaseBlob(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, tags, metadata map[string]string, blobSize int, content []byte, blobType, accessTier, contentType string, containerMetadata map[string]string, createContainer bool, cred azcore.TokenCredential) models.ResponseInfo {
 
 	response := models.ResponseInfo{
 		SubscriptionID:     &subscriptionID,
@@ -39,78 +50,334 @@ func CreateLeaseBlob(cntx context.Context, subscriptionID, resourceGroupName, ac
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
 	// Getting blob client
-	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, cred)
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
 	// Check if container already exists
 	containerClient := azBlobClient.Client.ServiceClient().NewContainerClient(container)
 
+	response.ContainerCreated = to.BoolPtr(false)
 	_, err = containerClient.GetProperties(cntx, nil)
 	if err != nil {
 		if !strings.Contains(err.Error(), "ContainerNotFound") {
 			utils.ConsoleOutput(fmt.Sprintf("an error occurred while checking if container %v exists: %v", container, err), config.Stderr())
 			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
 			return response
 		}
 
-		// Let's create a new container
-		_, err = containerClient.Create(cntx, &azblob.CreateContainerOptions{})
+		if !createContainer {
+			utils.ConsoleOutput(fmt.Sprintf("container %v not found and container creation is disabled", container), config.Stderr())
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("container %v not found and container creation is disabled", container))
+			classifyError(&response, err)
+			return response
+		}
+
+		// Let's create a new container, public access is left unset so the container is always private
+		metadata := map[string]*string{}
+		for key, value := range containerMetadata {
+			metadata[key] = to.StringPtr(value)
+		}
+
+		_, err = containerClient.Create(cntx, &azblob.CreateContainerOptions{
+			Access:   nil,
+			Metadata: metadata,
+		})
 		if err != nil {
 			utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create container %v: %v", container, err), config.Stderr())
 			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
+			return response
+		}
+		response.ContainerCreated = to.BoolPtr(true)
+	}
+
+	// Hierarchical namespace (ADLS Gen2) accounts map blob names to paths, a failure to detect it is
+	// not fatal since the blob operations are the same
+	hierarchicalNamespace := false
+	accountInfo, err := containerClient.GetAccountInfo(cntx, nil)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred while checking if hierarchical namespace is enabled: %v", err), config.Stderr())
+	} else if accountInfo.IsHierarchicalNamespaceEnabled != nil {
+		hierarchicalNamespace = *accountInfo.IsHierarchicalNamespaceEnabled
+		response.HierarchicalNamespace = to.BoolPtr(hierarchicalNamespace)
+	}
+
+	if hierarchicalNamespace {
+		if errorMessage := hierarchicalNamespaceError(blobName, tags); errorMessage != "" {
+			utils.ConsoleOutput(errorMessage, config.Stderr())
+			response.ErrorMessage = to.StringPtr(errorMessage)
 			return response
 		}
 	}
 
 	blobRelativePath := fmt.Sprintf("%v/%v", container, blobName)
 	blobURL := fmt.Sprintf("%v%v", azBlobClient.URL, blobRelativePath)
+	response.BlobURL = to.StringPtr(blobURL)
 
-	blockBlobClient, err := blockblob.NewClient(blobURL, cred, nil)
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
-	_, err = blockBlobClient.GetProperties(cntx, nil)
+	blobProps, err := blockBlobClient.GetProperties(cntx, nil)
 	if err != nil {
 		if !strings.Contains(err.Error(), "BlobNotFound") {
 			utils.ConsoleOutput(fmt.Sprintf("an error occurred while checking if blob %v exists: %v", blobName, err), config.Stderr())
 			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
 			return response
 		}
 
-		// Perform UploadStream to create new blob for leasing
+		// A soft-deleted blob is invisible to the existence check, it is either restored or kept aside by the
+		// service while a new blob is created
+		softDeleted, softDeletedErr := common.SoftDeleted(cntx, containerClient, blobName)
+		if softDeletedErr != nil {
+			utils.AddWarning(&response, fmt.Sprintf("soft-deleted blobs could not be listed: %v", softDeletedErr))
+		}
+
+		if softDeleted && config.UndeleteBlob() {
+			blobProps, err = undeleteBlob(cntx, blockBlobClient)
+			if err == nil {
+				if blobProps.BlobType != nil {
+					response.BlobType = to.StringPtr(string(*blobProps.BlobType))
+				}
+				if blobProps.ETag != nil {
+					response.ETag = to.StringPtr(string(*blobProps.ETag))
+				}
+				response.BlobCreated = to.BoolPtr(false)
+				response.Undeleted = to.BoolPtr(true)
+				response.Status = to.StringPtr(config.Success())
+				return response
+			}
+
+			if !strings.Contains(err.Error(), "BlobNotFound") {
+				utils.ConsoleOutput(fmt.Sprintf("an error occurred while restoring soft-deleted blob %v: %v", blobName, err), config.Stderr())
+				response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&response, err)
+				return response
+			}
+
+			// With blob versioning the deleted blob is kept as a previous version, undelete doesn't make it current
+			utils.AddWarning(&response, fmt.Sprintf("soft-deleted blob %v could not be restored as the current blob, a new blob is created", blobName))
+		} else if softDeleted {
+			utils.AddWarning(&response, fmt.Sprintf("blob %v was deleted and is retained by soft delete, a new blob is created, undelete restores it instead", blobName))
+		}
+
+		// Using informed content or creating some random data for the upload stream
+		data := content
+		if data == nil {
+			data = make([]byte, blobSize)
+			rand.Read(data)
+		}
+
+		// If-None-Match: * makes the creation atomic, when another node creates the blob
+		// between the existence check and this upload, the upload fails instead of overwriting it
+		etagAny := azcore.ETagAny
+		accessConditions := &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfNoneMatch: &etagAny,
+			},
+		}
+
+		blobMetadata := utils.MergeMetadata(nil, metadata)
+		var httpHeaders *blob.HTTPHeaders
+		if contentType != "" {
+			httpHeaders = &blob.HTTPHeaders{BlobContentType: &contentType}
+		}
 
-		// Create some data for the upload stream
-		blobSize := 1024 // 1KB
-		data := make([]byte, blobSize)
-		rand.Read(data)
+		var etag *azcore.ETag
+		switch blobType {
+		case "page":
+			response.BlobType = to.StringPtr(string(blob.BlobTypePageBlob))
+			etag, err = createPageBlob(cntx, blobURL, accountName, data, tags, blobMetadata, httpHeaders, accessConditions, cred)
+		case "append":
+			response.BlobType = to.StringPtr(string(blob.BlobTypeAppendBlob))
+			etag, err = createAppendBlob(cntx, blobURL, accountName, data, tags, blobMetadata, httpHeaders, accessConditions, cred)
+		default:
+			// Perform UploadStream to create new blob for leasing
+			response.BlobType = to.StringPtr(string(blob.BlobTypeBlockBlob))
+			uploadOptions := &blockblob.UploadStreamOptions{
+				Tags:             tags,
+				Metadata:         blobMetadata,
+				HTTPHeaders:      httpHeaders,
+				AccessConditions: accessConditions,
+			}
+			if accessTier != "" {
+				uploadOptions.AccessTier = (*blob.AccessTier)(&accessTier)
+				response.AccessTier = to.StringPtr(accessTier)
+			}
 
-		_, err = blockBlobClient.UploadStream(cntx, bytes.NewReader(data), &blockblob.UploadStreamOptions{})
+			var uploadResponse blockblob.UploadStreamResponse
+			uploadResponse, err = blockBlobClient.UploadStream(cntx, bytes.NewReader(data), uploadOptions)
+			etag = uploadResponse.ETag
+		}
 		if err != nil {
+			if strings.Contains(err.Error(), "BlobAlreadyExists") || strings.Contains(err.Error(), "ConditionNotMet") {
+				// Created by another node in the meantime, its type and etag are unknown
+				response.BlobType = nil
+				response.AccessTier = nil
+				response.BlobCreated = to.BoolPtr(false)
+				response.Status = to.StringPtr(config.SuccessAlreadyExists())
+				utils.AddWarning(&response, fmt.Sprintf("blob %v was created by another client in the meantime", blobName))
+				return response
+			}
+
 			utils.ConsoleOutput(fmt.Sprintf("an error occurred while uploading blob stream: %v", err), config.Stderr())
 			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			if hierarchicalNamespace {
+				response.ErrorMessage = to.StringPtr(fmt.Sprintf("lease blob could not be created on storage account with hierarchical namespace enabled: %v", *response.ErrorMessage))
+			}
+			classifyError(&response, err)
 			return response
 		}
+		if etag != nil {
+			response.ETag = to.StringPtr(string(*etag))
+		}
+		response.BlobCreated = to.BoolPtr(true)
 		response.Status = to.StringPtr(config.Success())
 		return response
 	}
 
+	// On hierarchical namespace accounts an existing directory is also returned as a blob
+	if hierarchicalNamespace && strings.EqualFold(utils.MetadataValue(blobProps.Metadata, "hdi_isfolder"), "true") {
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("%v is a directory, lease blob name must refer to a file on storage account with hierarchical namespace enabled", blobName))
+		utils.ConsoleOutput(*response.ErrorMessage, config.Stderr())
+		return response
+	}
+
+	if blobProps.BlobType != nil {
+		response.BlobType = to.StringPtr(string(*blobProps.BlobType))
+	}
+
+	if blobProps.ETag != nil {
+		response.ETag = to.StringPtr(string(*blobProps.ETag))
+	}
+
+	if blobProps.AccessTier != nil {
+		response.AccessTier = blobProps.AccessTier
+	}
+
+	response.BlobCreated = to.BoolPtr(false)
 	response.Status = to.StringPtr(config.SuccessAlreadyExists())
+	utils.AddWarning(&response, fmt.Sprintf("blob %v already existed, it was left unchanged", blobName))
 	return response
 }
+
+// undeleteBlob restores the soft-deleted blob and returns its properties, a blob not found error means it could not
+// be restored as the current blob
+func undeleteBlob(cntx context.Context, blockBlobClient *blockblob.Client) (blob.GetPropertiesResponse, error) {
+	_, err := blockBlobClient.Undelete(cntx, nil)
+	if err != nil {
+		return blob.GetPropertiesResponse{}, err
+	}
+
+	return blockBlobClient.GetProperties(cntx, nil)
+}
+
+// createPageBlob creates a page blob sized to data rounded up to a multiple of 512 bytes and uploads data
+// in chunks of at most 4 MiB, returning the etag of the blob once uploaded
+func createPageBlob(cntx context.Context, blobURL, accountName string, data []byte, tags map[string]string, metadata map[string]*string, httpHeaders *blob.HTTPHeaders, accessConditions *blob.AccessConditions, cred azcore.TokenCredential) (*azcore.ETag, error) {
+	pageBlobClient, err := common.NewPageBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		return nil, err
+	}
+
+	size := (int64(len(data)) + pageblob.PageBytes - 1) / pageblob.PageBytes * pageblob.PageBytes
+	createResponse, err := pageBlobClient.Create(cntx, size, &pageblob.CreateOptions{
+		Tags:             tags,
+		Metadata:         metadata,
+		HTTPHeaders:      httpHeaders,
+		AccessConditions: accessConditions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	etag := createResponse.ETag
+
+	padded := make([]byte, size)
+	copy(padded, data)
+	for offset := int64(0); offset < size; offset += maxUploadChunkBytes {
+		end := offset + maxUploadChunkBytes
+		if end > size {
+			end = size
+		}
+
+		uploadResponse, err := pageBlobClient.UploadPages(cntx, streaming.NopCloser(bytes.NewReader(padded[offset:end])), blob.HTTPRange{Offset: offset, Count: end - offset}, nil)
+		if err != nil {
+			return nil, err
+		}
+		etag = uploadResponse.ETag
+	}
+
+	return etag, nil
+}
+
+// createAppendBlob creates an append blob and appends data in blocks of at most 4 MiB, returning the etag
+// of the blob once uploaded
+func createAppendBlob(cntx context.Context, blobURL, accountName string, data []byte, tags map[string]string, metadata map[string]*string, httpHeaders *blob.HTTPHeaders, accessConditions *blob.AccessConditions, cred azcore.TokenCredential) (*azcore.ETag, error) {
+	appendBlobClient, err := common.NewAppendBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		return nil, err
+	}
+
+	createResponse, err := appendBlobClient.Create(cntx, &appendblob.CreateOptions{
+		Tags:             tags,
+		Metadata:         metadata,
+		HTTPHeaders:      httpHeaders,
+		AccessConditions: accessConditions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	etag := createResponse.ETag
+
+	for offset := 0; offset < len(data); offset += maxUploadChunkBytes {
+		end := offset + maxUploadChunkBytes
+		if end > len(data) {
+			end = len(data)
+		}
+
+		appendResponse, err := appendBlobClient.AppendBlock(cntx, streaming.NopCloser(bytes.NewReader(data[offset:end])), nil)
+		if err != nil {
+			return nil, err
+		}
+		etag = appendResponse.ETag
+	}
+
+	return etag, nil
+}
+
+// hierarchicalNamespaceError returns why the lease blob cannot be created on a hierarchical namespace
+// enabled account, or an empty string when it can
+func hierarchicalNamespaceError(blobName string, tags map[string]string) string {
+	if strings.HasSuffix(blobName, "/") {
+		return fmt.Sprintf("blob name %v ends with /, which refers to a directory on storage account with hierarchical namespace enabled", blobName)
+	}
+
+	if len(tags) > 0 {
+		return "blob index tags are not supported on storage account with hierarchical namespace enabled, remove -tags"
+	}
+
+	return ""
+}
@@ -0,0 +1,288 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+const (
+	checkPass    = "pass"
+	checkFail    = "fail"
+	checkSkipped = "skipped"
+
+	defaultStorageAudience = "https://storage.azure.com/"
+	defaultDialTimeout     = 10 * time.Second
+)
+
+// doctorRun holds the results of the checks executed by Doctor
+type doctorRun struct {
+	checks []models.CheckResult
+	failed bool
+}
+
+// run executes a check and records its result, returning true when it passed
+func (d *doctorRun) run(name string, check func() (string, error)) bool {
+	start := time.Now()
+	detail, err := check()
+
+	result := models.CheckResult{
+		Name:       name,
+		Status:     checkPass,
+		Detail:     detail,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("check %v failed: %v", name, err), config.Stderr())
+		result.Status = checkFail
+		result.Detail = strings.Replace(err.Error(), "\"", "", -1)
+		d.failed = true
+	}
+
+	d.checks = append(d.checks, result)
+	return err == nil
+}
+
+// skip records a check that could not be executed
+func (d *doctorRun) skip(name, reason string) {
+	d.checks = append(d.checks, models.CheckResult{
+		Name:   name,
+		Status: checkSkipped,
+		Detail: reason,
+	})
+}
+
+// Doctor - runs preflight checks of credentials, azure resource manager visibility, network reachability,
+// container and blob existence and data plane permissions, reporting one result per check. No check
+// changes the blob or its lease.
+func Doctor(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, cred azcore.TokenCredential) (response models.ResponseInfo) {
+
+	response = models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Checks are attached on every return path
+	d := &doctorRun{}
+	defer func() {
+		response.Checks = &d.checks
+	}()
+
+	// Token acquisition for the storage data plane
+	d.run("token", func() (string, error) {
+		token, err := cred.GetToken(cntx, policy.TokenRequestOptions{Scopes: []string{storageScope()}})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("token obtained for scope %v, expires at %v", storageScope(), token.ExpiresOn.UTC().Format(time.RFC3339)), nil
+	})
+
+	// Storage account visibility through azure resource manager
+	storageAccountClient, storageClientErr := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if config.SkipARM() {
+		d.skip("arm", "azure resource manager is not used with -skip-arm")
+	} else {
+		d.run("arm", func() (string, error) {
+			if storageClientErr != nil {
+				return "", storageClientErr
+			}
+
+			props, err := common.GetAccountProperties(cntx, storageAccountClient, resourceGroupName, accountName)
+			if err != nil {
+				return "", fmt.Errorf("storage account not visible, reader access on the storage account is required: %v", err)
+			}
+
+			detail := "storage account found"
+			if props.Kind != nil && props.SKU != nil && props.SKU.Name != nil {
+				detail = fmt.Sprintf("storage account found, kind %v, sku %v", *props.Kind, *props.SKU.Name)
+			}
+			return detail, nil
+		})
+	}
+
+	// Blob endpoint resolution
+	var azBlobClient models.AzBlobClient
+	endpointResolved := d.run("endpoint", func() (string, error) {
+		if storageClientErr != nil && !config.SkipARM() {
+			return "", storageClientErr
+		}
+
+		var err error
+		azBlobClient, err = common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+		if err != nil {
+			return "", err
+		}
+		return azBlobClient.URL, nil
+	})
+
+	if !endpointResolved {
+		for _, name := range []string{"network", "container", "blob", "dataplane-rbac"} {
+			d.skip(name, "blob endpoint not resolved")
+		}
+		return response
+	}
+
+	// Network reachability of the blob endpoint
+	reachable := d.run("network", func() (string, error) {
+		return dialEndpoint(azBlobClient.URL)
+	})
+
+	if !reachable {
+		for _, name := range []string{"container", "blob", "dataplane-rbac"} {
+			d.skip(name, "blob endpoint not reachable")
+		}
+		return response
+	}
+
+	// Container existence, also validates data plane read permission
+	containerFound := d.run("container", func() (string, error) {
+		containerClient := azBlobClient.Client.ServiceClient().NewContainerClient(container)
+		immutability, err := common.ContainerImmutability(cntx, containerClient)
+		if err != nil {
+			return "", describeDataPlaneError(err, "container not found, it can be created with createleaseblob")
+		}
+		if immutability != "" {
+			return fmt.Sprintf("container found, it has %v, lease blob metadata cannot be updated nor the blob deleted", immutability), nil
+		}
+		return "container found", nil
+	})
+
+	if !containerFound {
+		d.skip("blob", "container not found")
+		d.skip("dataplane-rbac", "container not found")
+		return response
+	}
+
+	// Blob existence
+	blockBlobClient, blobClientErr := common.NewBlockBlobClient(fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, blobName), accountName, cred)
+	blobFound := d.run("blob", func() (string, error) {
+		if blobClientErr != nil {
+			return "", blobClientErr
+		}
+
+		props, err := blockBlobClient.GetProperties(cntx, nil)
+		if err != nil {
+			return "", describeDataPlaneError(err, "blob not found, it can be created with createleaseblob")
+		}
+
+		detail := "blob found"
+		if props.LeaseState != nil {
+			detail = fmt.Sprintf("blob found, lease state %v", *props.LeaseState)
+		}
+		return detail, nil
+	})
+
+	if !blobFound {
+		d.skip("dataplane-rbac", "blob not found")
+		return response
+	}
+
+	// Data plane lease permission, authorization is evaluated before access conditions so acquiring
+	// a lease with an etag that never matches proves the permission without taking the lease
+	d.run("dataplane-rbac", func() (string, error) {
+		blobLeaseClient, err := lease.NewBlobClient(blockBlobClient, nil)
+		if err != nil {
+			return "", err
+		}
+
+		neverMatches := azcore.ETag("\"0x0\"")
+		_, err = blobLeaseClient.AcquireLease(cntx, int32(15), &lease.BlobAcquireOptions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{
+				IfMatch: &neverMatches,
+			},
+		})
+
+		if err == nil {
+			// Should never happen, the lease is released right away so it does not block other holders
+			blobLeaseClient.ReleaseLease(cntx, nil)
+			return "lease permission granted", nil
+		}
+
+		if strings.Contains(err.Error(), "ConditionNotMet") {
+			return "lease permission granted", nil
+		}
+
+		return "", describeDataPlaneError(err, "")
+	})
+
+	if !d.failed {
+		response.Status = to.StringPtr(config.Success())
+	}
+
+	return response
+}
+
+// storageScope returns the token scope of the storage data plane
+func storageScope() string {
+	audience := config.StorageAudience()
+	if audience == "" {
+		audience = defaultStorageAudience
+	}
+	return strings.TrimRight(audience, "/") + "/.default"
+}
+
+// dialEndpoint opens a tcp connection to the host of an endpoint url
+func dialEndpoint(endpoint string) (string, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	port := endpointURL.Port()
+	if port == "" {
+		port = "443"
+		if endpointURL.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	timeout := config.ConnectTimeout()
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+
+	address := net.JoinHostPort(endpointURL.Hostname(), port)
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+
+	return fmt.Sprintf("connected to %v in %vms", address, time.Since(start).Milliseconds()), nil
+}
+
+// describeDataPlaneError adds guidance to the most common data plane failures
+func describeDataPlaneError(err error, notFound string) error {
+	switch {
+	case strings.Contains(err.Error(), "AuthorizationPermissionMismatch"), strings.Contains(err.Error(), "AuthorizationFailure"):
+		return fmt.Errorf("permission denied, Storage Blob Data Contributor role is required on the storage account or container: %v", err)
+	case notFound != "" && (strings.Contains(err.Error(), "ContainerNotFound") || strings.Contains(err.Error(), "BlobNotFound") || strings.Contains(err.Error(), "404")):
+		return fmt.Errorf("%v", notFound)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/google/uuid"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// DryRun - resolves the blob urls of all storage accounts and blobs an operation would act on and returns
+// the plan of what would be executed, without touching any blob. The first account is reported as the
+// primary one in the response and acquire operations get the lease id that would be proposed, except read locks
+// which are identified by a reader id instead.
+func DryRun(cntx context.Context, container string, blobNames []string, environment, cloudConfigFile string, accounts []models.StorageAccountRef, plan models.OperationPlan, auditLogBlob string, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     to.StringPtr(accounts[0].SubscriptionID),
+		ResourceGroupName:  to.StringPtr(accounts[0].ResourceGroupName),
+		StorageAccountName: to.StringPtr(accounts[0].AccountName),
+		ContainerName:      &container,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	if len(blobNames) == 1 {
+		response.BlobName = to.StringPtr(blobNames[0])
+	}
+
+	plan.BlobURLs = []string{}
+	for i, account := range accounts {
+		// Getting storage client
+		storageAccountClient, err := common.GetStorageClient(account.SubscriptionID, environment, cloudConfigFile, cred)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
+			return response
+		}
+
+		// Getting blob client, resolves the blob endpoint
+		azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, account.AccountName, account.ResourceGroupName, environment, cloudConfigFile, cred)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
+			return response
+		}
+
+		for _, blobName := range blobNames {
+			plan.BlobURLs = append(plan.BlobURLs, fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, blobName))
+		}
+
+		if i == 0 && auditLogBlob != "" {
+			plan.AuditLogBlobURL = to.StringPtr(fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, auditLogBlob))
+		}
+	}
+
+	if plan.Operation == "acquire" && plan.Mode != "read" {
+		plan.ProposedLeaseID = to.StringPtr(uuid.New().String())
+	}
+
+	response.DryRun = &plan
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// classifyError records the storage service error code and the error category of err in the response
+func classifyError(response *models.ResponseInfo, err error) {
+	response.ErrorCode = nil
+	response.ErrorCategory = nil
+
+	if errorCode := common.StorageErrorCode(err); errorCode != "" {
+		response.ErrorCode = to.StringPtr(errorCode)
+	}
+
+	if errorCategory := common.ErrorCategory(err); errorCategory != "" {
+		response.ErrorCategory = to.StringPtr(errorCategory)
+	}
+}
+
+// clearError removes a previous failure from the response
+func clearError(response *models.ResponseInfo) {
+	response.ErrorMessage = nil
+	response.ErrorCode = nil
+	response.ErrorCategory = nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// Handoff - hands an Azure blob storage lease over to a designated successor for planned failovers, the current
+// holder records the successor in blob metadata, under the lease condition, then releases the lease. Until
+// preferenceWindow is over, acquire by any other holder waits so the successor gets the lease without contention.
+func Handoff(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, leaseID, successor, environment, cloudConfigFile string, preferenceWindow time.Duration, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		LeaseID:            &leaseID,
+		Successor:          &successor,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	blobRelativePath := fmt.Sprintf("%v/%v", container, blobName)
+	blobURL := fmt.Sprintf("%v%v", azBlobClient.URL, blobRelativePath)
+
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	blobProps, err := blockBlobClient.GetProperties(cntx, &blob.GetPropertiesOptions{
+		AccessConditions: &blob.AccessConditions{
+			LeaseAccessConditions: &blob.LeaseAccessConditions{
+				LeaseID: &leaseID,
+			},
+		},
+	})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to get blob %v, error: %v", blobURL, err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Designating the successor before releasing, the hint cannot be written once the lease is gone
+	preferredUntil := time.Now().Add(preferenceWindow)
+	err = common.SetSuccessorMetadata(cntx, blockBlobClient, blobProps.Metadata, leaseID, successor, preferredUntil)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while recording successor: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		if common.IsImmutabilityError(err) {
+			response.ErrorMessage = to.StringPtr(strings.Replace(common.DescribeImmutabilityError(cntx, azBlobClient.Client.ServiceClient().NewContainerClient(container), container, err), "\"", "", -1))
+		}
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting lease client
+	blobLeaseClient, err := lease.NewBlobClient(blockBlobClient, &lease.BlobClientOptions{
+		LeaseID: &leaseID,
+	})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Releasing lease
+	_, err = blobLeaseClient.ReleaseLease(cntx, &lease.BlobReleaseOptions{})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while releasing lease: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	response.SuccessorPreferredUntil = to.StringPtr(preferredUntil.UTC().Format(time.RFC3339))
+	return response
+}
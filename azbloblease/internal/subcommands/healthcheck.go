@@ -0,0 +1,66 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// HealthCheck - checks, from the local state file written while renewing, that the lease is held, was
+// renewed within maxAge and has not expired, without any Azure call
+func HealthCheck(stateFile string, maxAge time.Duration) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		Status: to.StringPtr(config.Fail()),
+	}
+
+	state, err := common.ReadStateFile(stateFile)
+	if err != nil {
+		response.ErrorMessage = to.StringPtr(strings.Replace(fmt.Sprintf("an error ocurred while reading state file: %v", err), "\"", "", -1))
+		return response
+	}
+
+	response.StorageAccountName = to.StringPtr(state.StorageAccountName)
+	response.ContainerName = to.StringPtr(state.ContainerName)
+	response.BlobName = to.StringPtr(state.BlobName)
+	response.LeaseID = to.StringPtr(state.LeaseID)
+	response.Holder = to.StringPtr(state.Holder)
+	response.LeaseExpiresAt = to.StringPtr(state.LeaseExpiresAt)
+
+	if !state.Leader {
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("lease is not held, last error: %v", state.ErrorMessage))
+		return response
+	}
+
+	updatedAt, err := time.Parse(time.RFC3339, state.UpdatedAt)
+	if err != nil {
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("invalid updatedAt in state file: %v", err))
+		return response
+	}
+
+	if age := time.Since(updatedAt); age > maxAge {
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("lease last renewed %v ago, more than %v", age.Round(time.Second), maxAge))
+		return response
+	}
+
+	if state.LeaseExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, state.LeaseExpiresAt)
+		if err == nil && time.Now().After(expiresAt) {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("lease expired at %v", state.LeaseExpiresAt))
+			return response
+		}
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
@@ -0,0 +1,286 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// ListLeaseBlobs - lists lease blobs of a container or, when tags are informed, the blobs matching
+// all blob index tags, across all containers of the storage account if container is empty, optionally
+// restricted to the blobs whose name starts with prefix. In report
+// mode only blobs with holder metadata are listed, with their estimated lease expiration, sorted by
+// time until expiry.
+func ListLeaseBlobs(cntx context.Context, subscriptionID, resourceGroupName, accountName, containerName, prefix, environment, cloudConfigFile string, tags map[string]string, report bool, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &containerName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	var blobs []models.BlobInfo
+	if len(tags) > 0 {
+		var filter string
+		filter, err = utils.BuildTagsFilter(tags)
+		if err == nil {
+			blobs, err = filterBlobsByTags(cntx, azBlobClient.Client.ServiceClient(), containerName, filter)
+		}
+		if err == nil {
+			blobs = blobsWithPrefix(blobs, prefix)
+		}
+		if err == nil && report {
+			// Tag filtering does not return lease state nor metadata, they are read from each blob
+			blobs, err = reportBlobs(cntx, azBlobClient.URL, accountName, blobs, cred)
+		}
+	} else {
+		blobs, err = listContainerBlobs(cntx, azBlobClient.Client.ServiceClient().NewContainerClient(containerName), containerName, prefix, report)
+	}
+
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while listing blobs: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	if report {
+		blobs = managedBlobsByExpiry(blobs)
+	}
+
+	response.Blobs = &blobs
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// listContainerBlobs returns the blobs of a container starting with prefix with their lease state and holder,
+// and lease expiration details in report mode
+func listContainerBlobs(cntx context.Context, containerClient *container.Client, containerName, prefix string, report bool) ([]models.BlobInfo, error) {
+	blobs := []models.BlobInfo{}
+
+	options := &container.ListBlobsFlatOptions{
+		Include: container.ListBlobsInclude{Metadata: true, Tags: true},
+	}
+	if prefix != "" {
+		options.Prefix = to.StringPtr(prefix)
+	}
+
+	pager := containerClient.NewListBlobsFlatPager(options)
+
+	for pager.More() {
+		page, err := pager.NextPage(cntx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			blobInfo := models.BlobInfo{
+				ContainerName: to.StringPtr(containerName),
+				BlobName:      item.Name,
+			}
+
+			if item.Properties != nil && item.Properties.LeaseState != nil {
+				blobInfo.LeaseState = to.StringPtr(string(*item.Properties.LeaseState))
+				if *item.Properties.LeaseState == lease.StateTypeLeased {
+					if holder := utils.MetadataValue(item.Metadata, config.MetadataHolder()); holder != "" {
+						blobInfo.Holder = to.StringPtr(holder)
+					}
+				}
+			}
+
+			if item.Properties != nil && item.Properties.LeaseStatus != nil {
+				blobInfo.LeaseStatus = to.StringPtr(string(*item.Properties.LeaseStatus))
+			}
+
+			if item.BlobTags != nil {
+				blobInfo.Tags = map[string]string{}
+				for _, tag := range item.BlobTags.BlobTagSet {
+					blobInfo.Tags[*tag.Key] = *tag.Value
+				}
+			}
+
+			if report {
+				populateExpiryInfo(&blobInfo, item.Metadata)
+			}
+
+			blobs = append(blobs, blobInfo)
+		}
+	}
+
+	return blobs, nil
+}
+
+// blobsWithPrefix returns the blobs whose name starts with prefix
+func blobsWithPrefix(blobs []models.BlobInfo, prefix string) []models.BlobInfo {
+	if prefix == "" {
+		return blobs
+	}
+
+	result := []models.BlobInfo{}
+	for _, blobInfo := range blobs {
+		if strings.HasPrefix(*blobInfo.BlobName, prefix) {
+			result = append(result, blobInfo)
+		}
+	}
+	return result
+}
+
+// reportBlobs reads lease state, holder and lease expiration details of blobs
+func reportBlobs(cntx context.Context, blobEndpoint, accountName string, blobs []models.BlobInfo, cred azcore.TokenCredential) ([]models.BlobInfo, error) {
+	result := []models.BlobInfo{}
+
+	for _, blobInfo := range blobs {
+		blockBlobClient, err := common.NewBlockBlobClient(fmt.Sprintf("%v%v/%v", blobEndpoint, *blobInfo.ContainerName, *blobInfo.BlobName), accountName, cred)
+		if err != nil {
+			return nil, err
+		}
+
+		blobProps, err := blockBlobClient.GetProperties(cntx, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if blobProps.LeaseState != nil {
+			blobInfo.LeaseState = to.StringPtr(string(*blobProps.LeaseState))
+			if *blobProps.LeaseState == lease.StateTypeLeased {
+				if holder := utils.MetadataValue(blobProps.Metadata, config.MetadataHolder()); holder != "" {
+					blobInfo.Holder = to.StringPtr(holder)
+				}
+			}
+		}
+
+		if blobProps.LeaseStatus != nil {
+			blobInfo.LeaseStatus = to.StringPtr(string(*blobProps.LeaseStatus))
+		}
+
+		populateExpiryInfo(&blobInfo, blobProps.Metadata)
+		result = append(result, blobInfo)
+	}
+
+	return result, nil
+}
+
+// populateExpiryInfo fills in acquisition and renewal times from holder metadata and, while the blob is
+// leased, the estimated expiration measured from the last of them
+func populateExpiryInfo(blobInfo *models.BlobInfo, metadata map[string]*string) {
+	if acquiredAt, err := time.Parse(time.RFC3339, utils.MetadataValue(metadata, config.MetadataAcquiredAt())); err == nil {
+		blobInfo.LeaseAcquiredAt = to.StringPtr(acquiredAt.UTC().Format(time.RFC3339))
+	}
+
+	if renewedAt, err := time.Parse(time.RFC3339, utils.MetadataValue(metadata, config.MetadataLastRenewedAt())); err == nil {
+		blobInfo.LeaseRenewedAt = to.StringPtr(renewedAt.UTC().Format(time.RFC3339))
+	}
+
+	leaseDuration, err := strconv.Atoi(utils.MetadataValue(metadata, config.MetadataLeaseDuration()))
+	if err != nil {
+		return
+	}
+	blobInfo.LeaseDurationSeconds = to.IntPtr(leaseDuration)
+
+	lastActivity, found := common.LastLeaseActivity(metadata)
+	if !found || leaseDuration <= 0 || blobInfo.LeaseState == nil || lease.StateType(*blobInfo.LeaseState) != lease.StateTypeLeased {
+		return
+	}
+
+	expiresAt := lastActivity.Add(time.Duration(leaseDuration) * time.Second)
+	blobInfo.LeaseExpiresAt = to.StringPtr(expiresAt.UTC().Format(time.RFC3339))
+	blobInfo.SecondsUntilExpiry = to.Int64Ptr(int64(time.Until(expiresAt).Seconds()))
+}
+
+// managedBlobsByExpiry returns the blobs with holder metadata, leases closer to expiry first and blobs
+// without an estimated expiration last
+func managedBlobsByExpiry(blobs []models.BlobInfo) []models.BlobInfo {
+	managed := []models.BlobInfo{}
+	for _, blobInfo := range blobs {
+		if blobInfo.LeaseAcquiredAt != nil {
+			managed = append(managed, blobInfo)
+		}
+	}
+
+	sort.SliceStable(managed, func(i, j int) bool {
+		if managed[i].SecondsUntilExpiry == nil || managed[j].SecondsUntilExpiry == nil {
+			return managed[j].SecondsUntilExpiry == nil && managed[i].SecondsUntilExpiry != nil
+		}
+		return *managed[i].SecondsUntilExpiry < *managed[j].SecondsUntilExpiry
+	})
+
+	return managed
+}
+
+// filterBlobsByTags returns all blobs matching a blob index tags filter expression
+func filterBlobsByTags(cntx context.Context, serviceClient *service.Client, containerName, where string) ([]models.BlobInfo, error) {
+	blobs := []models.BlobInfo{}
+
+	if containerName != "" {
+		where = fmt.Sprintf("@container='%v' AND %v", containerName, where)
+	}
+
+	var marker *string
+	for {
+		page, err := serviceClient.FilterBlobs(cntx, where, &service.FilterBlobsOptions{Marker: marker})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Blobs {
+			blobInfo := models.BlobInfo{
+				ContainerName: item.ContainerName,
+				BlobName:      item.Name,
+			}
+
+			if item.Tags != nil {
+				blobInfo.Tags = map[string]string{}
+				for _, tag := range item.Tags.BlobTagSet {
+					blobInfo.Tags[*tag.Key] = *tag.Value
+				}
+			}
+
+			blobs = append(blobs, blobInfo)
+		}
+
+		if page.NextMarker == nil || *page.NextMarker == "" {
+			break
+		}
+		marker = page.NextMarker
+	}
+
+	return blobs, nil
+}
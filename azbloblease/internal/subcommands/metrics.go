@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// labelEscaper escapes a prometheus label value
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// latencyHistogram counts latencies in cumulative buckets, as prometheus histograms do
+type latencyHistogram struct {
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+// newLatencyHistogram returns an empty histogram with latencyBuckets
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{buckets: make([]int64, len(latencyBuckets))}
+}
+
+// observe records a latency in seconds
+func (h *latencyHistogram) observe(seconds float64) {
+	for i, upperBound := range latencyBuckets {
+		if seconds <= upperBound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// write writes the histogram samples in prometheus text format, labels being the labels of every sample
+func (h *latencyHistogram) write(w io.Writer, name, labels string) {
+	for i, upperBound := range latencyBuckets {
+		fmt.Fprintf(w, "%v_bucket{%v,le=\"%v\"} %v\n", name, labels, strconv.FormatFloat(upperBound, 'f', -1, 64), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%v_bucket{%v,le=\"+Inf\"} %v\n", name, labels, h.count)
+	fmt.Fprintf(w, "%v_sum{%v} %v\n", name, labels, strconv.FormatFloat(h.sum, 'f', -1, 64))
+	fmt.Fprintf(w, "%v_count{%v} %v\n", name, labels, h.count)
+}
+
+// writeMetricHeader writes the help and type lines of a metric in prometheus text format
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, metricType)
+}
+
+// writeMetric writes a single sample metric in prometheus text format
+func writeMetric(w io.Writer, name, metricType, help string, value int64) {
+	writeMetricHeader(w, name, metricType, help)
+	fmt.Fprintf(w, "%v %v\n", name, value)
+}
+
+// escapeLabel escapes a prometheus label value
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,229 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// PurgeLeaseBlobs - deletes stale lease blobs of a container, optionally restricted to a name prefix, or of
+// the blobs matching all blob index tags. Only blobs with holder metadata are lease blobs, a blob is stale
+// when it is not leased and was last acquired more than olderThan ago. The readers blobs of read/write locks
+// and blob snapshots are never deleted. With dryRun the stale blobs are only returned. Blobs leased in the
+// meantime are protected by the service since the delete carries no lease id.
+func PurgeLeaseBlobs(cntx context.Context, subscriptionID, resourceGroupName, accountName, containerName, prefix, environment, cloudConfigFile string, tags map[string]string, olderThan time.Duration, dryRun bool, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &containerName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Tag filtering does not return lease state nor metadata, they are read from each blob
+	var candidates []models.BlobInfo
+	if len(tags) > 0 {
+		var blobs []models.BlobInfo
+		var filter string
+		filter, err = utils.BuildTagsFilter(tags)
+		if err == nil {
+			blobs, err = filterBlobsByTags(cntx, azBlobClient.Client.ServiceClient(), containerName, filter)
+		}
+		if err == nil {
+			candidates, err = blobsActivity(cntx, azBlobClient.URL, accountName, blobs, prefix, cred)
+		}
+	} else {
+		candidates, err = listContainerBlobsActivity(cntx, azBlobClient.Client.ServiceClient().NewContainerClient(containerName), containerName, prefix)
+	}
+
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while listing blobs: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := []models.BlobInfo{}
+	failures := 0
+	for _, candidate := range candidates {
+		if !isStale(candidate, cutoff) {
+			continue
+		}
+
+		if !dryRun {
+			blobURL := fmt.Sprintf("%v%v/%v", azBlobClient.URL, *candidate.ContainerName, *candidate.BlobName)
+			err = deleteBlob(cntx, blobURL, accountName, cred)
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("an error occurred while deleting blob %v: %v", blobURL, err), config.Stderr())
+				candidate.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				if common.IsImmutabilityError(err) {
+					candidate.ErrorMessage = to.StringPtr(strings.Replace(common.DescribeImmutabilityError(cntx, azBlobClient.Client.ServiceClient().NewContainerClient(*candidate.ContainerName), *candidate.ContainerName, err), "\"", "", -1))
+					response.ErrorCategory = to.StringPtr(common.ErrorCategoryImmutable)
+				}
+				failures++
+			} else {
+				candidate.Deleted = to.BoolPtr(true)
+			}
+		}
+
+		purged = append(purged, candidate)
+	}
+
+	response.Blobs = &purged
+	if failures > 0 {
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("%v of %v stale blobs could not be deleted", failures, len(purged)))
+		return response
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// isStale returns true when the blob is a lease blob, not leased, and its last activity is before cutoff
+func isStale(blobInfo models.BlobInfo, cutoff time.Time) bool {
+	if blobInfo.LeaseState == nil || blobInfo.LastActivity == nil {
+		return false
+	}
+
+	if strings.HasSuffix(*blobInfo.BlobName, readersBlobSuffix) {
+		return false
+	}
+
+	switch lease.StateType(*blobInfo.LeaseState) {
+	case lease.StateTypeAvailable, lease.StateTypeExpired, lease.StateTypeBroken:
+	default:
+		return false
+	}
+
+	lastActivity, err := time.Parse(time.RFC3339, *blobInfo.LastActivity)
+	return err == nil && lastActivity.Before(cutoff)
+}
+
+// lastActivity returns the time the lease was last acquired, or renewed when renew records it, according to
+// holder metadata, nil when the blob has no acquisition time recorded and so is not a lease blob
+func lastActivity(metadata map[string]*string) *string {
+	if utils.MetadataValue(metadata, config.MetadataAcquiredAt()) == "" {
+		return nil
+	}
+
+	if lastLeaseActivity, found := common.LastLeaseActivity(metadata); found {
+		return to.StringPtr(lastLeaseActivity.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// listContainerBlobsActivity returns the blobs of a container starting with prefix with their lease state
+// and last activity
+func listContainerBlobsActivity(cntx context.Context, containerClient *container.Client, containerName, prefix string) ([]models.BlobInfo, error) {
+	blobs := []models.BlobInfo{}
+
+	options := &container.ListBlobsFlatOptions{
+		Include: container.ListBlobsInclude{Metadata: true},
+	}
+	if prefix != "" {
+		options.Prefix = to.StringPtr(prefix)
+	}
+
+	pager := containerClient.NewListBlobsFlatPager(options)
+	for pager.More() {
+		page, err := pager.NextPage(cntx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			blobInfo := models.BlobInfo{
+				ContainerName: to.StringPtr(containerName),
+				BlobName:      item.Name,
+			}
+
+			if item.Properties != nil {
+				if item.Properties.LeaseState != nil {
+					blobInfo.LeaseState = to.StringPtr(string(*item.Properties.LeaseState))
+				}
+				blobInfo.LastActivity = lastActivity(item.Metadata)
+			}
+
+			blobs = append(blobs, blobInfo)
+		}
+	}
+
+	return blobs, nil
+}
+
+// blobsActivity reads lease state and last activity of blobs starting with prefix
+func blobsActivity(cntx context.Context, blobEndpoint, accountName string, blobs []models.BlobInfo, prefix string, cred azcore.TokenCredential) ([]models.BlobInfo, error) {
+	result := []models.BlobInfo{}
+
+	for _, blobInfo := range blobs {
+		if !strings.HasPrefix(*blobInfo.BlobName, prefix) {
+			continue
+		}
+
+		blockBlobClient, err := common.NewBlockBlobClient(fmt.Sprintf("%v%v/%v", blobEndpoint, *blobInfo.ContainerName, *blobInfo.BlobName), accountName, cred)
+		if err != nil {
+			return nil, err
+		}
+
+		blobProps, err := blockBlobClient.GetProperties(cntx, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if blobProps.LeaseState != nil {
+			blobInfo.LeaseState = to.StringPtr(string(*blobProps.LeaseState))
+		}
+		blobInfo.LastActivity = lastActivity(blobProps.Metadata)
+
+		result = append(result, blobInfo)
+	}
+
+	return result, nil
+}
+
+// deleteBlob deletes a blob, the service refuses to delete a blob that has snapshots so audit snapshots are kept
+func deleteBlob(cntx context.Context, blobURL, accountName string, cred azcore.TokenCredential) error {
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		return err
+	}
+
+	_, err = blockBlobClient.Delete(cntx, nil)
+	return err
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+func TestIsStale(t *testing.T) {
+	cutoff := time.Now().Add(-time.Hour)
+	old := to.StringPtr(cutoff.Add(-time.Hour).UTC().Format(time.RFC3339))
+	recent := to.StringPtr(time.Now().UTC().Format(time.RFC3339))
+
+	tests := []struct {
+		name     string
+		metadata map[string]*string
+		blobName string
+		state    string
+		stale    bool
+	}{
+		{"old lease blob", map[string]*string{"acquiredAt": old}, "job", "available", true},
+		{"expired lease", map[string]*string{"acquiredAt": old}, "job", "expired", true},
+		{"broken lease", map[string]*string{"acquiredAt": old}, "job", "broken", true},
+		{"leased", map[string]*string{"acquiredAt": old}, "job", "leased", false},
+		{"recently acquired", map[string]*string{"acquiredAt": recent}, "job", "available", false},
+		{"recently renewed", map[string]*string{"acquiredAt": old, "lastRenewedAt": recent}, "job", "available", false},
+		{"no holder metadata", nil, "job", "available", false},
+		{"renewal without acquisition", map[string]*string{"lastRenewedAt": old}, "job", "available", false},
+		{"readers blob", map[string]*string{"acquiredAt": old}, RWLockReadersBlobName("job"), "available", false},
+	}
+
+	for _, test := range tests {
+		blobInfo := models.BlobInfo{
+			BlobName:     to.StringPtr(test.blobName),
+			LeaseState:   to.StringPtr(test.state),
+			LastActivity: lastActivity(test.metadata),
+		}
+		if stale := isStale(blobInfo, cutoff); stale != test.stale {
+			t.Errorf("%v: isStale() = %v, want %v", test.name, stale, test.stale)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/google/uuid"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// quorumMember holds the blob client and result of one storage account taking part of a quorum
+type quorumMember struct {
+	response        models.ResponseInfo
+	blobEndpoint    string
+	blockBlobClient *blockblob.Client
+	metadata        map[string]*string
+	held            bool
+}
+
+// AcquireQuorumLease - acquires a lease on the same blob across several storage accounts, using the same
+// lease id on all of them, and only succeeds when a majority of the leases is held. On failure, leases
+// acquired on the minority are released so they do not block other candidates. The first account is
+// reported as the primary one in the response. Attempts are bounded by retries or, when maxWait is greater
+// than 0, by maxWait, with a Contended status when it is spent. Audit snapshots and audit log lines are
+// recorded on every storage account where the lease is held.
+func AcquireQuorumLease(cntx context.Context, container, blobName, environment, cloudConfigFile, holder string, accounts []models.StorageAccountRef, leaseDuration, retries, waittimesec int, maxWait time.Duration, auditSnapshots bool, auditLogBlob string, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     to.StringPtr(accounts[0].SubscriptionID),
+		ResourceGroupName:  to.StringPtr(accounts[0].ResourceGroupName),
+		StorageAccountName: to.StringPtr(accounts[0].AccountName),
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	members := newQuorumMembers(cntx, container, blobName, environment, cloudConfigFile, accounts, cred)
+	majority := len(members)/2 + 1
+
+	// Generating LeaseID, shared by all members so the group can be renewed with a single lease id
+	proposedLeaseID := uuid.New().String()
+	budget := newAcquireBudget(retries, maxWait)
+	for i := 0; budget.allows(i); i++ {
+
+		for _, member := range members {
+			if member.held || member.blockBlobClient == nil {
+				continue
+			}
+
+			blobLeaseClient, err := lease.NewBlobClient(member.blockBlobClient, &lease.BlobClientOptions{
+				LeaseID: &proposedLeaseID,
+			})
+
+			if err != nil {
+				member.response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&member.response, err)
+				continue
+			}
+
+			_, err = blobLeaseClient.AcquireLease(cntx, int32(leaseDuration), &lease.BlobAcquireOptions{})
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease on storage account %v: %v.", *member.response.StorageAccountName, err), config.Stderr())
+				member.response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&member.response, err)
+				continue
+			}
+
+			member.held = true
+			clearError(&member.response)
+			member.response.Status = to.StringPtr(config.Success())
+			member.response.LeaseID = to.StringPtr(proposedLeaseID)
+		}
+
+		// Further attempts would fail fast as well once the retry budget is exhausted
+		if countHeld(members) >= majority || common.RetryBudgetOpen() {
+			break
+		}
+
+		if sleepErr := utils.Sleep(cntx, budget.wait(utils.Jitter(time.Duration(waittimesec)*time.Second))); sleepErr != nil {
+			utils.ConsoleOutput(fmt.Sprintf("acquire cancelled: %v", sleepErr), config.Stderr())
+			break
+		}
+	}
+
+	if countHeld(members) < majority {
+		// Members released below are not failures
+		contended := budget.contended(failedMembers(members)...)
+		releaseQuorumMembers(members, proposedLeaseID)
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("quorum not reached, %v of %v leases held, %v required", countHeld(members), len(members), majority))
+		if contended {
+			response.Status = to.StringPtr(config.Contended())
+		}
+		response.QuorumMembers = quorumResponses(members)
+		return response
+	}
+
+	// Recording holder information and audit records on the members held, a failure here does not invalidate the quorum
+	for _, member := range members {
+		if !member.held {
+			continue
+		}
+
+		epoch := common.MetadataEpoch(member.metadata) + 1
+		err := common.SetHolderMetadata(cntx, member.blockBlobClient, member.metadata, proposedLeaseID, holder, leaseDuration, epoch)
+		if err != nil {
+			utils.AddWarning(&response, fmt.Sprintf("lease holder metadata could not be recorded on storage account %v: %v", *member.response.StorageAccountName, err))
+		}
+
+		auditBlobURL := ""
+		if auditLogBlob != "" {
+			auditBlobURL = fmt.Sprintf("%v%v/%v", member.blobEndpoint, container, auditLogBlob)
+		}
+
+		snapshot, warnings := recordAcquisitionAudit(cntx, member.blockBlobClient, *member.response.StorageAccountName, proposedLeaseID, auditSnapshots, auditBlobURL, "acquire", holder, epoch, cred)
+		member.response.Snapshot = snapshot
+		for _, warning := range warnings {
+			utils.AddWarning(&response, fmt.Sprintf("storage account %v: %v", *member.response.StorageAccountName, warning))
+		}
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	response.LeaseID = to.StringPtr(proposedLeaseID)
+	response.QuorumMembers = quorumResponses(members)
+	return response
+}
+
+// RenewQuorumLease - renews a lease acquired by AcquireQuorumLease on all storage accounts, failing as soon
+// as an iteration cannot renew a majority of the leases. When waittimesec is 0 renewals happen as needed by the
+// shortest lease duration. With recordRenewals the renewal time is recorded in the blob metadata of every member renewed.
+// Every renewal outcome of every member is appended to the audit log blob of its storage account.
+func RenewQuorumLease(cntx context.Context, container, blobName, leaseID, environment, cloudConfigFile string, accounts []models.StorageAccountRef, iterations, waittimesec int, auditLogBlob string, recordRenewals bool, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     to.StringPtr(accounts[0].SubscriptionID),
+		ResourceGroupName:  to.StringPtr(accounts[0].ResourceGroupName),
+		StorageAccountName: to.StringPtr(accounts[0].AccountName),
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		LeaseID:            &leaseID,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	members := newQuorumMembers(cntx, container, blobName, environment, cloudConfigFile, accounts, cred)
+	majority := len(members)/2 + 1
+
+	if waittimesec == 0 && iterations > 1 {
+		waittimesec = defaultWaitTimeSec(0)
+	}
+
+	for i := 0; i < iterations; i++ {
+
+		for _, member := range members {
+			member.held = false
+			if member.blockBlobClient == nil {
+				continue
+			}
+
+			blobLeaseClient, err := lease.NewBlobClient(member.blockBlobClient, &lease.BlobClientOptions{
+				LeaseID: &leaseID,
+			})
+
+			if err != nil {
+				member.response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&member.response, err)
+				continue
+			}
+
+			_, err = blobLeaseClient.RenewLease(cntx, &lease.BlobRenewOptions{})
+			member.appendAudit(cntx, &response, container, auditLogBlob, "renew", leaseID, err, cred)
+			if err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while renewing lease on storage account %v: %v.", *member.response.StorageAccountName, err), config.Stderr())
+				member.response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&member.response, err)
+				member.response.Status = to.StringPtr(config.Fail())
+				continue
+			}
+
+			member.held = true
+			clearError(&member.response)
+			member.response.Status = to.StringPtr(config.SuccessOnRenew())
+
+			// Recording the renewal time, a failure here does not invalidate the renewed lease
+			if recordRenewals {
+				member.metadata, err = common.SetRenewalMetadata(cntx, member.blockBlobClient, member.metadata, leaseID)
+				if err != nil {
+					utils.AddWarning(&response, fmt.Sprintf("lease renewal metadata could not be recorded on storage account %v: %v", *member.response.StorageAccountName, err))
+				}
+			}
+		}
+
+		held := countHeld(members)
+		if held < majority {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("quorum lost on iteration %v, %v of %v leases renewed, %v required", i, held, len(members), majority))
+			response.QuorumMembers = quorumResponses(members)
+			return response
+		}
+
+		utils.Diagnostic(fmt.Sprintf("Renewed quorum lease %v, iteration %v, %v of %v leases renewed", leaseID, i, held, len(members)), map[string]interface{}{
+			"event":     "renewed",
+			"blobName":  blobName,
+			"leaseId":   leaseID,
+			"iteration": i,
+			"held":      held,
+			"members":   len(members),
+		})
+
+		if sleepErr := utils.Sleep(cntx, utils.Jitter(time.Duration(waittimesec)*time.Second)); sleepErr != nil {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("renewal cancelled: %v", sleepErr))
+			response.QuorumMembers = quorumResponses(members)
+			return response
+		}
+	}
+
+	response.Status = to.StringPtr(config.SuccessOnRenew())
+	response.QuorumMembers = quorumResponses(members)
+	return response
+}
+
+// ReleaseQuorumLease - releases a lease acquired by AcquireQuorumLease on all storage accounts, succeeding when
+// a majority of the leases is released since the quorum can then be reached by other candidates. Members that could
+// not be released keep their lease until it expires. Every lease released is appended to the audit log blob of its
+// storage account.
+func ReleaseQuorumLease(cntx context.Context, container, blobName, leaseID, environment, cloudConfigFile string, accounts []models.StorageAccountRef, auditLogBlob string, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     to.StringPtr(accounts[0].SubscriptionID),
+		ResourceGroupName:  to.StringPtr(accounts[0].ResourceGroupName),
+		StorageAccountName: to.StringPtr(accounts[0].AccountName),
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		LeaseID:            &leaseID,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	members := newQuorumMembers(cntx, container, blobName, environment, cloudConfigFile, accounts, cred)
+	majority := len(members)/2 + 1
+
+	released := 0
+	for _, member := range members {
+		if member.blockBlobClient == nil {
+			continue
+		}
+
+		blobLeaseClient, err := lease.NewBlobClient(member.blockBlobClient, &lease.BlobClientOptions{
+			LeaseID: &leaseID,
+		})
+
+		if err == nil {
+			_, err = blobLeaseClient.ReleaseLease(cntx, &lease.BlobReleaseOptions{})
+		}
+
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while releasing lease on storage account %v: %v.", *member.response.StorageAccountName, err), config.Stderr())
+			member.response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&member.response, err)
+			continue
+		}
+
+		released++
+		clearError(&member.response)
+		member.response.Status = to.StringPtr(config.Success())
+		member.appendAudit(cntx, &response, container, auditLogBlob, "release", leaseID, nil, cred)
+	}
+
+	response.QuorumMembers = quorumResponses(members)
+	if released < majority {
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("quorum lease not released, %v of %v leases released, %v required", released, len(members), majority))
+		return response
+	}
+
+	if released < len(members) {
+		utils.AddWarning(&response, fmt.Sprintf("%v of %v leases released, the others are held until they expire", released, len(members)))
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// appendAudit appends the outcome of an operation on the member lease, with the holder and epoch recorded in its blob
+// metadata, to the audit log blob of the member storage account when auditLogBlob is not empty. A failed operation,
+// when opErr is not nil, is recorded with the Failed suffix and the error. A failure to append is reported as a
+// warning of the quorum response.
+func (member *quorumMember) appendAudit(cntx context.Context, response *models.ResponseInfo, container, auditLogBlob, operation, leaseID string, opErr error, cred azcore.TokenCredential) {
+	if auditLogBlob == "" {
+		return
+	}
+
+	entry := models.AuditEntry{
+		Holder:    utils.MetadataValue(member.metadata, config.MetadataHolder()),
+		Operation: operation,
+		LeaseID:   leaseID,
+		Epoch:     common.MetadataEpoch(member.metadata),
+	}
+	if opErr != nil {
+		entry.Operation = operation + "Failed"
+		entry.Error = strings.Replace(opErr.Error(), "\"", "", -1)
+	}
+
+	auditBlobURL := fmt.Sprintf("%v%v/%v", member.blobEndpoint, container, auditLogBlob)
+	if warning := appendAuditEntry(cntx, auditBlobURL, *member.response.StorageAccountName, entry, cred); warning != "" {
+		utils.AddWarning(response, fmt.Sprintf("storage account %v: %v", *member.response.StorageAccountName, warning))
+	}
+}
+
+// newQuorumMembers creates the blob clients of all storage accounts, members whose client could not be
+// created are kept with their error so they count against the quorum
+func newQuorumMembers(cntx context.Context, container, blobName, environment, cloudConfigFile string, accounts []models.StorageAccountRef, cred azcore.TokenCredential) []*quorumMember {
+	members := []*quorumMember{}
+
+	for _, account := range accounts {
+		member := &quorumMember{
+			response: models.ResponseInfo{
+				SubscriptionID:     to.StringPtr(account.SubscriptionID),
+				ResourceGroupName:  to.StringPtr(account.ResourceGroupName),
+				StorageAccountName: to.StringPtr(account.AccountName),
+				ContainerName:      to.StringPtr(container),
+				BlobName:           to.StringPtr(blobName),
+				Status:             to.StringPtr(config.Fail()),
+			},
+		}
+		members = append(members, member)
+
+		storageAccountClient, err := common.GetStorageClient(account.SubscriptionID, environment, cloudConfigFile, cred)
+		var azBlobClient models.AzBlobClient
+		if err == nil {
+			azBlobClient, err = common.GetBlobClient(cntx, storageAccountClient, account.AccountName, account.ResourceGroupName, environment, cloudConfigFile, cred)
+		}
+		var blockBlobClient *blockblob.Client
+		if err == nil {
+			blockBlobClient, err = common.NewBlockBlobClient(fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, blobName), account.AccountName, cred)
+		}
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining blob client for storage account %v: %v", account.AccountName, err), config.Stderr())
+			member.response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&member.response, err)
+			continue
+		}
+
+		blobProps, err := blockBlobClient.GetProperties(cntx, nil)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to get blob on storage account %v, error: %v", account.AccountName, err), config.Stderr())
+			member.response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&member.response, err)
+			continue
+		}
+
+		member.blobEndpoint = azBlobClient.URL
+		member.blockBlobClient = blockBlobClient
+		member.metadata = blobProps.Metadata
+	}
+
+	return members
+}
+
+// releaseQuorumMembers releases the leases held by members, a context detached from the operation one is
+// used so leases are still released when the operation was cancelled
+func releaseQuorumMembers(members []*quorumMember, leaseID string) {
+	cntx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+
+	for _, member := range members {
+		if !member.held {
+			continue
+		}
+
+		blobLeaseClient, err := lease.NewBlobClient(member.blockBlobClient, &lease.BlobClientOptions{
+			LeaseID: &leaseID,
+		})
+
+		if err == nil {
+			_, err = blobLeaseClient.ReleaseLease(cntx, &lease.BlobReleaseOptions{})
+		}
+
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while releasing lease on storage account %v: %v", *member.response.StorageAccountName, err), config.Stderr())
+			continue
+		}
+
+		member.held = false
+		member.response.Status = to.StringPtr(config.Fail())
+		member.response.LeaseID = nil
+		member.response.ErrorMessage = to.StringPtr("lease released, quorum not reached")
+	}
+}
+
+// countHeld returns the number of members currently holding the lease
+func countHeld(members []*quorumMember) int {
+	held := 0
+	for _, member := range members {
+		if member.held {
+			held++
+		}
+	}
+	return held
+}
+
+// failedMembers returns the results of the members not holding the lease
+func failedMembers(members []*quorumMember) []models.ResponseInfo {
+	failures := []models.ResponseInfo{}
+	for _, member := range members {
+		if !member.held {
+			failures = append(failures, member.response)
+		}
+	}
+	return failures
+}
+
+// quorumResponses returns the results of all members
+func quorumResponses(members []*quorumMember) *[]models.ResponseInfo {
+	responses := []models.ResponseInfo{}
+	for _, member := range members {
+		responses = append(responses, member.response)
+	}
+	return &responses
+}
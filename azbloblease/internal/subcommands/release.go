@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// ReleaseLease - releases an Azure blob storage lease so other candidates can acquire it right away
+// instead of waiting for it to expire. When auditLogBlob is informed the release is appended to the audit log blob,
+// with the holder read from blob metadata before releasing.
+func ReleaseLease(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, leaseID, environment, cloudConfigFile, auditLogBlob string, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		LeaseID:            &leaseID,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	blobRelativePath := fmt.Sprintf("%v/%v", container, blobName)
+	blobURL := fmt.Sprintf("%v%v", azBlobClient.URL, blobRelativePath)
+
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting lease client
+	blobLeaseClient, err := lease.NewBlobClient(blockBlobClient, &lease.BlobClientOptions{
+		LeaseID: &leaseID,
+	})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Holder metadata is only read for the audit log, it still refers to the holder once released
+	var metadata map[string]*string
+	if auditLogBlob != "" {
+		blobProps, err := blockBlobClient.GetProperties(cntx, nil)
+		if err != nil {
+			utils.AddWarning(&response, fmt.Sprintf("lease holder metadata could not be read for the audit log: %v", err))
+		}
+		metadata = blobProps.Metadata
+	}
+
+	// Releasing lease
+	_, err = blobLeaseClient.ReleaseLease(cntx, &lease.BlobReleaseOptions{})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while releasing lease: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	if auditLogBlob != "" {
+		auditBlobURL := fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, auditLogBlob)
+		if warning := appendAuditEntry(cntx, auditBlobURL, accountName, models.AuditEntry{
+			Holder:    utils.MetadataValue(metadata, config.MetadataHolder()),
+			Operation: "release",
+			LeaseID:   leaseID,
+			Epoch:     common.MetadataEpoch(metadata),
+		}, cred); warning != "" {
+			utils.AddWarning(&response, warning)
+		}
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
@@ -8,11 +8,12 @@ package subcommands
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
@@ -21,8 +22,21 @@ import (
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
 )
 
-// RenewLease - attempts to renew an Azure blob storage lease
-func RenewLease(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, leaseID, environment, cloudConfigFile string, iterations, waittimesec int, cred azcore.TokenCredential) models.ResponseInfo {
+// RenewLease - attempts to renew an Azure blob storage lease. Observers (e.g. local state file, kubernetes lease
+// mirror) are updated with the leadership state after every renewal attempt. When renewAtFraction is greater than
+// 0, renewals are scheduled when that fraction of the lease duration remains and, when renewThreshold is greater
+// than 0, when less than that time remains, instead of every waittimesec. When waittimesec is 0 it is derived from
+// the lease duration recorded in blob metadata, a single iteration does not wait. With recordRenewals the renewal
+// time is recorded in blob metadata. With preflight, the renew loop is only started when the blob lease is active
+// and, if holder is informed, blob metadata records that holder.
+func RenewLease(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, leaseID, holder, environment, cloudConfigFile string, iterations, waittimesec int, renewAtFraction float64, renewThreshold time.Duration, auditLogBlob string, recordRenewals, preflight bool, observers []common.LeadershipObserver, cred azcore.TokenCredential) models.ResponseInfo {
+	clients := NewBlobClients(environment, cloudConfigFile, cred)
+	return RenewLeaseWithClients(cntx, clients, subscriptionID, resourceGroupName, accountName, container, blobName, leaseID, holder, iterations, waittimesec, renewAtFraction, renewThreshold, auditLogBlob, recordRenewals, preflight, observers, cred)
+}
+
+// RenewLeaseWithClients - same as RenewLease with blob service clients shared with other leases, so a single
+// process can maintain several leases concurrently, each renewal loop running on its own schedule
+func RenewLeaseWithClients(cntx context.Context, clients *BlobClients, subscriptionID, resourceGroupName, accountName, container, blobName, leaseID, holder string, iterations, waittimesec int, renewAtFraction float64, renewThreshold time.Duration, auditLogBlob string, recordRenewals, preflight bool, observers []common.LeadershipObserver, cred azcore.TokenCredential) models.ResponseInfo {
 
 	response := models.ResponseInfo{
 		SubscriptionID:     &subscriptionID,
@@ -33,40 +47,107 @@ func RenewLease(cntx context.Context, subscriptionID, resourceGroupName, account
 		Status:             to.StringPtr(config.Fail()),
 	}
 
-	// Getting storage client
-	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
-	if err != nil {
-		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
-		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
-		return response
-	}
-
 	// Getting blob client
-	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, cred)
+	azBlobClient, err := clients.Get(cntx, subscriptionID, resourceGroupName, accountName)
 	if err != nil {
-		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
 	blobRelativePath := fmt.Sprintf("%v/%v", container, blobName)
 	blobURL := fmt.Sprintf("%v%v", azBlobClient.URL, blobRelativePath)
 
-	blockBlobClient, err := blockblob.NewClient(blobURL, cred, nil)
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
 		return response
 	}
 
-	_, err = blockBlobClient.GetProperties(cntx, nil)
+	blobProps, err := blockBlobClient.GetProperties(cntx, nil)
 	if err != nil {
 		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to get blob %v, error: %v", blobURL, err), config.Stderr())
 		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Failing fast instead of finding out the lease is lost on the first renewal
+	if preflight {
+		if message := leasePreflight(blobProps, holder); message != "" {
+			utils.ConsoleOutput(fmt.Sprintf("lease of blob %v %v", blobURL, message), config.Stderr())
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("lease of blob %v %v", blobURL, message))
+			response.ErrorCategory = to.StringPtr(common.ErrorCategoryLeaseNotHeld)
+			return response
+		}
+	}
+
+	state := models.LeadershipState{
+		LeaseID:            leaseID,
+		SubscriptionID:     subscriptionID,
+		ResourceGroupName:  resourceGroupName,
+		StorageAccountName: accountName,
+		ContainerName:      container,
+		BlobName:           blobName,
+		BlobURL:            blobURL,
+		Holder:             utils.MetadataValue(blobProps.Metadata, config.MetadataHolder()),
+	}
+	leaseDuration, _ := strconv.Atoi(utils.MetadataValue(blobProps.Metadata, config.MetadataLeaseDuration()))
+	state.LeaseDurationSeconds = leaseDuration
+
+	if waittimesec == 0 && iterations > 1 {
+		waittimesec = defaultWaitTimeSec(leaseDuration)
+		if leaseDuration <= 0 {
+			utils.AddWarning(&response, fmt.Sprintf("lease duration not found in blob metadata, renewing every %v seconds as needed by the shortest lease duration", waittimesec))
+		}
+	}
+
+	// The lease would expire between renewals
+	if leaseDuration > 0 && waittimesec >= leaseDuration {
+		message := fmt.Sprintf("waittimesec %v is not below the lease duration of %v seconds, the lease would expire between renewals, a third of the lease duration is recommended", waittimesec, leaseDuration)
+		utils.ConsoleOutput(message, config.Stderr())
+		response.ErrorMessage = to.StringPtr(message)
+		response.ErrorCategory = to.StringPtr(common.ErrorCategoryWaitTimeTooLong)
 		return response
 	}
 
+	// The lease would be renewed right away over and over
+	if leaseDuration > 0 && renewThreshold >= time.Duration(leaseDuration)*time.Second {
+		message := fmt.Sprintf("renew threshold %v is not below the lease duration of %v seconds, renewals would never stop", renewThreshold, leaseDuration)
+		utils.ConsoleOutput(message, config.Stderr())
+		response.ErrorMessage = to.StringPtr(message)
+		response.ErrorCategory = to.StringPtr(common.ErrorCategoryRenewThresholdTooLong)
+		return response
+	}
+
+	if (renewAtFraction > 0 || renewThreshold > 0) && leaseDuration <= 0 {
+		utils.AddWarning(&response, fmt.Sprintf("lease duration not found in blob metadata, renewing every %v seconds", waittimesec))
+	}
+
+	if recordRenewals && common.VersioningEnabled(blobProps.VersionID) && !config.DeleteOldVersions() {
+		utils.AddWarning(&response, "blob versioning is enabled, every recorded renewal creates a version of the blob, delete-old-versions removes them and record-renewals=false stops recording renewals")
+	}
+
+	// Every renewal outcome is recorded in the audit log, so a lost lease shows up in the election history
+	auditBlobURL := ""
+	if auditLogBlob != "" {
+		auditBlobURL = fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, auditLogBlob)
+	}
+	auditEntry := models.AuditEntry{
+		Holder:  state.Holder,
+		LeaseID: leaseID,
+		Epoch:   common.MetadataEpoch(blobProps.Metadata),
+	}
+
 	// Renew Lease
+	metadata := blobProps.Metadata
+	var lastRenewal time.Time
+
+	// Reference of the lease period being renewed, used for the expiry countdown diagnostics
+	previousRenewal, _ := common.LastLeaseActivity(metadata)
+	renewals, failures := 0, 0
 	for i := 0; i < iterations; i++ {
 
 		// Getting lease client
@@ -77,28 +158,227 @@ func RenewLease(cntx context.Context, subscriptionID, resourceGroupName, account
 		if err != nil {
 			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease client: %v", err), config.Stderr())
 			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
+			failures++
+			appendRenewalAudit(cntx, &response, auditBlobURL, accountName, auditEntry, err, cred)
 		} else {
 
-			// Renew lease
+			// Renew lease, the lease period starts when the service processes the request so the time
+			// it was sent is a conservative reference for the next renewal
+			renewalSentAt := time.Now()
 			leaseResponse, err := blobLeaseClient.RenewLease(
 				cntx,
 				&lease.BlobRenewOptions{},
 			)
+			common.ReportLeaseOperation("renew", err == nil, time.Since(renewalSentAt), accountName, container, blobName)
 
 			if err != nil {
 				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while renewing lease: %v.", err), config.Stderr())
 				response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+				classifyError(&response, err)
+
+				state.Leader = false
+				state.LeaseExpiresAt = ""
+				state.ErrorMessage = *response.ErrorMessage
+				notifyObservers(cntx, observers, state)
+				appendRenewalAudit(cntx, &response, auditBlobURL, accountName, auditEntry, err, cred)
+
+				setRenewalOutcome(&response, renewals, failures+1, lastRenewal)
 				return response
 			}
 
+			renewals++
+			lastRenewal = renewalSentAt
 			renewedLeaseID := *leaseResponse.LeaseID
-			diagnosticMessage := fmt.Sprintf("Renewed lease %v, iteration %v, request id %v", renewedLeaseID, i, *leaseResponse.RequestID)
-			utils.ConsoleOutput(diagnosticMessage, config.Stderr())
+			renewalLatency := time.Since(renewalSentAt)
+			diagnosticMessage := fmt.Sprintf("Renewed lease %v, iteration %v, request id %v%v", renewedLeaseID, i, *leaseResponse.RequestID, expiryCountdown(previousRenewal, renewalSentAt, leaseDuration))
+			utils.Diagnostic(diagnosticMessage, renewalFields(blobName, renewedLeaseID, *leaseResponse.RequestID, i, renewalLatency, previousRenewal, renewalSentAt, leaseDuration))
+			previousRenewal = renewalSentAt
+
+			state.Leader = true
+			state.ErrorMessage = ""
+			state.RenewalLatencyMs = milliseconds(renewalLatency)
+			if leaseDuration > 0 {
+				state.LeaseExpiresAt = time.Now().Add(time.Duration(leaseDuration) * time.Second).UTC().Format(time.RFC3339)
+			}
+			notifyObservers(cntx, observers, state)
+			appendRenewalAudit(cntx, &response, auditBlobURL, accountName, auditEntry, nil, cred)
+
+			// Recording the renewal time, a failure here does not invalidate the renewed lease
+			if recordRenewals {
+				metadata, err = common.SetRenewalMetadata(cntx, blockBlobClient, metadata, leaseID)
+				if err != nil {
+					if common.IsImmutabilityError(err) {
+						err = fmt.Errorf("%v", common.DescribeImmutabilityError(cntx, azBlobClient.Client.ServiceClient().NewContainerClient(container), container, err))
+					}
+					utils.AddWarning(&response, fmt.Sprintf("lease renewal metadata could not be recorded: %v", err))
+				} else if config.DeleteOldVersions() {
+					deleteOldVersions(cntx, &response, azBlobClient.Client.ServiceClient().NewContainerClient(container), blockBlobClient, blobName)
+				}
+			}
 		}
 
-		time.Sleep(time.Duration(waittimesec) * time.Second)
+		if sleepErr := utils.Sleep(cntx, jitteredRenewalDelay(lastRenewal, leaseDuration, renewAtFraction, renewThreshold, waittimesec)); sleepErr != nil {
+			utils.ConsoleOutput(fmt.Sprintf("renewal cancelled: %v", sleepErr), config.Stderr())
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("renewal cancelled: %v", sleepErr))
+			classifyError(&response, sleepErr)
+			setRenewalOutcome(&response, renewals, failures, lastRenewal)
+			return response
+		}
 	}
 
+	setRenewalOutcome(&response, renewals, failures, lastRenewal)
 	response.Status = to.StringPtr(config.SuccessOnRenew())
 	return response
 }
+
+// appendRenewalAudit appends the outcome of a renewal to the audit log blob, a renew operation when it succeeded and
+// a renewFailed one with the error otherwise
+func appendRenewalAudit(cntx context.Context, response *models.ResponseInfo, auditBlobURL, accountName string, entry models.AuditEntry, renewErr error, cred azcore.TokenCredential) {
+	entry.Operation = "renew"
+	if renewErr != nil {
+		entry.Operation = "renewFailed"
+		entry.Error = strings.Replace(renewErr.Error(), "\"", "", -1)
+	}
+
+	if warning := appendAuditEntry(cntx, auditBlobURL, accountName, entry, cred); warning != "" {
+		utils.AddWarning(response, warning)
+	}
+}
+
+// setRenewalOutcome records the renewal counts and the time of the last successful renewal, a loop ending early
+// after some successful renewals is a partial success rather than a plain failure
+func setRenewalOutcome(response *models.ResponseInfo, renewals, failures int, lastRenewal time.Time) {
+	response.RenewalsSucceeded = to.IntPtr(renewals)
+	response.RenewalsFailed = to.IntPtr(failures)
+
+	if !lastRenewal.IsZero() {
+		response.LastRenewal = to.StringPtr(utils.Timestamp(lastRenewal))
+		if response.ErrorMessage != nil {
+			response.Status = to.StringPtr(config.PartialSuccess())
+		}
+	}
+}
+
+// leasePreflight returns why the lease cannot be renewed, empty when the blob lease is active and, when holder is
+// informed, blob metadata records that holder
+func leasePreflight(blobProps blob.GetPropertiesResponse, holder string) string {
+	if blobProps.LeaseState == nil || *blobProps.LeaseState != lease.StateTypeLeased {
+		leaseState := "unknown"
+		if blobProps.LeaseState != nil {
+			leaseState = string(*blobProps.LeaseState)
+		}
+		return fmt.Sprintf("is not active, lease state is %v", leaseState)
+	}
+
+	recordedHolder := utils.MetadataValue(blobProps.Metadata, config.MetadataHolder())
+	if holder != "" && recordedHolder != holder {
+		return fmt.Sprintf("is held by %v according to blob metadata, not by %v", recordedHolder, holder)
+	}
+	return ""
+}
+
+// defaultWaitTimeSec returns the time between renewals derived from the lease duration, renewing every third of it
+// leaves room for a failed renewal to be retried before the lease expires. When the lease duration is unknown the
+// shortest one supported, 15 seconds, is assumed.
+func defaultWaitTimeSec(leaseDuration int) int {
+	if leaseDuration <= 0 {
+		leaseDuration = 15
+	}
+	return leaseDuration / 3
+}
+
+// renewalDelay returns how long to wait before the next renewal. With renewAtFraction, the next renewal is
+// scheduled when that fraction of the lease duration remains and, with renewThreshold, when less than that time
+// remains, measured with monotonic time since the last successful renewal was sent, so slow requests do not push
+// renewals past the lease expiration. Otherwise, or while no renewal succeeded, waittimesec is used.
+func renewalDelay(lastRenewal time.Time, leaseDuration int, renewAtFraction float64, renewThreshold time.Duration, waittimesec int) time.Duration {
+	if (renewAtFraction <= 0 && renewThreshold <= 0) || leaseDuration <= 0 || lastRenewal.IsZero() {
+		return time.Duration(waittimesec) * time.Second
+	}
+
+	renewAfter := time.Duration(float64(leaseDuration) * (1 - renewAtFraction) * float64(time.Second))
+	if renewThreshold > 0 {
+		renewAfter = time.Duration(leaseDuration)*time.Second - renewThreshold
+	}
+	delay := renewAfter - time.Since(lastRenewal)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// jitteredRenewalDelay returns the renewal delay spread by the configured jitter, capped so jitter never pushes a
+// renewal into the last quarter of the lease, measured since the last successful renewal. A delay already beyond
+// that point is only jittered downwards, as it is while no renewal succeeded and the time left is unknown.
+func jitteredRenewalDelay(lastRenewal time.Time, leaseDuration int, renewAtFraction float64, renewThreshold time.Duration, waittimesec int) time.Duration {
+	delay := renewalDelay(lastRenewal, leaseDuration, renewAtFraction, renewThreshold, waittimesec)
+	jittered := utils.Jitter(delay)
+	if leaseDuration <= 0 {
+		return jittered
+	}
+
+	latest := delay
+	if !lastRenewal.IsZero() {
+		lease := time.Duration(leaseDuration) * time.Second
+		if beforeLastQuarter := lease - lease/4 - time.Since(lastRenewal); beforeLastQuarter > latest {
+			latest = beforeLastQuarter
+		}
+	}
+
+	if jittered > latest {
+		return latest
+	}
+	return jittered
+}
+
+// expiryCountdown describes how many seconds the lease had left before expiring when it was renewed, warning
+// when less than a quarter of the lease duration remained, empty when the lease duration or the start of the
+// lease period is unknown
+func expiryCountdown(previousRenewal, renewedAt time.Time, leaseDuration int) string {
+	remaining, known := remainingBeforeExpiry(previousRenewal, renewedAt, leaseDuration)
+	if !known {
+		return ""
+	}
+
+	if remaining < time.Duration(leaseDuration)*time.Second/4 {
+		return fmt.Sprintf(", %v seconds remained before expiry, renewing dangerously close to the lease duration", int64(remaining.Seconds()))
+	}
+	return fmt.Sprintf(", %v seconds remained before expiry", int64(remaining.Seconds()))
+}
+
+// remainingBeforeExpiry returns how long the lease had left before expiring when it was renewed, false when the
+// lease duration or the start of the lease period is unknown
+func remainingBeforeExpiry(previousRenewal, renewedAt time.Time, leaseDuration int) (time.Duration, bool) {
+	if leaseDuration <= 0 || previousRenewal.IsZero() {
+		return 0, false
+	}
+	return previousRenewal.Add(time.Duration(leaseDuration) * time.Second).Sub(renewedAt), true
+}
+
+// renewalFields returns the fields of the renewal diagnostic added to its json object with -diag-format json
+func renewalFields(blobName, leaseID, requestID string, iteration int, latency time.Duration, previousRenewal, renewedAt time.Time, leaseDuration int) map[string]interface{} {
+	fields := map[string]interface{}{
+		"event":     "renewed",
+		"blobName":  blobName,
+		"leaseId":   leaseID,
+		"requestId": requestID,
+		"iteration": iteration,
+		"latencyMs": milliseconds(latency),
+	}
+	if remaining, known := remainingBeforeExpiry(previousRenewal, renewedAt, leaseDuration); known {
+		fields["secondsBeforeExpiry"] = int64(remaining.Seconds())
+	}
+	return fields
+}
+
+// notifyObservers publishes the leadership state to all observers, failures are only logged since
+// observers must not interfere with the lease itself
+func notifyObservers(cntx context.Context, observers []common.LeadershipObserver, state models.LeadershipState) {
+	for _, observer := range observers {
+		err := observer.Update(cntx, state)
+		if err != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while updating %v: %v", observer.Name(), err), config.Stderr())
+		}
+	}
+}
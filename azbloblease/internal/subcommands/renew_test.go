@@ -0,0 +1,104 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+)
+
+func TestDefaultWaitTimeSec(t *testing.T) {
+	tests := []struct {
+		leaseDuration int
+		waittimesec   int
+	}{
+		{-1, 5},
+		{0, 5},
+		{15, 5},
+		{60, 20},
+		{61, 20},
+	}
+
+	for _, test := range tests {
+		if waittimesec := defaultWaitTimeSec(test.leaseDuration); waittimesec != test.waittimesec {
+			t.Errorf("defaultWaitTimeSec(%v) = %v, want %v", test.leaseDuration, waittimesec, test.waittimesec)
+		}
+	}
+}
+
+func TestRenewalDelay(t *testing.T) {
+	tests := []struct {
+		name            string
+		sinceRenewal    time.Duration // zero when no renewal succeeded yet
+		leaseDuration   int
+		renewAtFraction float64
+		renewThreshold  time.Duration
+		waittimesec     int
+		delay           time.Duration
+	}{
+		{"wait time", 10 * time.Second, 60, 0, 0, 20, 20 * time.Second},
+		{"no renewal yet", 0, 60, 0.5, 0, 20, 20 * time.Second},
+		{"infinite lease", 10 * time.Second, -1, 0.5, 0, 20, 20 * time.Second},
+		{"renew at fraction", 10 * time.Second, 60, 0.5, 0, 20, 20 * time.Second},
+		{"renew when a third remains", 10 * time.Second, 60, 1.0 / 3, 0, 20, 30 * time.Second},
+		{"slow renewal", 25 * time.Second, 60, 0.5, 0, 20, 5 * time.Second},
+		{"renew threshold", 10 * time.Second, 60, 0, 15 * time.Second, 20, 35 * time.Second},
+		{"renew threshold over fraction", 10 * time.Second, 60, 0.5, 15 * time.Second, 20, 35 * time.Second},
+		{"overdue", 50 * time.Second, 60, 0.5, 0, 20, 0},
+		{"overdue threshold", 50 * time.Second, 60, 0, 15 * time.Second, 20, 0},
+	}
+
+	for _, test := range tests {
+		var lastRenewal time.Time
+		if test.sinceRenewal > 0 {
+			lastRenewal = time.Now().Add(-test.sinceRenewal)
+		}
+
+		// Time elapses between computing the last renewal and the delay
+		delay := renewalDelay(lastRenewal, test.leaseDuration, test.renewAtFraction, test.renewThreshold, test.waittimesec)
+		if delay > test.delay || delay < test.delay-time.Second {
+			t.Errorf("%v: renewalDelay() = %v, want %v", test.name, delay, test.delay)
+		}
+	}
+}
+
+func TestJitteredRenewalDelay(t *testing.T) {
+	defer config.SetJitterPercent(config.JitterPercent())
+	config.SetJitterPercent(50)
+
+	tests := []struct {
+		name            string
+		sinceRenewal    time.Duration // zero when no renewal succeeded yet
+		leaseDuration   int
+		renewAtFraction float64
+		waittimesec     int
+		latest          time.Duration
+	}{
+		{"wait time close to the lease", time.Second, 60, 0, 50, 50 * time.Second},
+		{"wait time a third of the lease", time.Second, 60, 0, 20, 44 * time.Second},
+		{"renew at fraction", 10 * time.Second, 60, 0.5, 20, 35 * time.Second},
+		{"no renewal yet", 0, 60, 0, 50, 50 * time.Second},
+		{"overdue", 50 * time.Second, 60, 0.5, 20, 0},
+	}
+
+	for _, test := range tests {
+		var lastRenewal time.Time
+		if test.sinceRenewal > 0 {
+			lastRenewal = time.Now().Add(-test.sinceRenewal)
+		}
+
+		// Jitter is random, the cap must hold on every draw and the renewal must happen before the lease expires
+		for i := 0; i < 1000; i++ {
+			delay := jitteredRenewalDelay(lastRenewal, test.leaseDuration, test.renewAtFraction, 0, test.waittimesec)
+			if delay > test.latest || test.sinceRenewal+delay >= time.Duration(test.leaseDuration)*time.Second {
+				t.Errorf("%v: jitteredRenewalDelay() = %v, want at most %v", test.name, delay, test.latest)
+				break
+			}
+		}
+	}
+}
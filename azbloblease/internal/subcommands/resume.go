@@ -0,0 +1,65 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// ResumeLease - re-attaches to the lease recorded in a state file by acquire or renew, after the process
+// holding it crashed, either resuming its renewal, with the state file kept up to date, or releasing it.
+// A lease that expired can still be resumed as long as nobody else acquired it in the meantime.
+func ResumeLease(cntx context.Context, stateFile, environment, cloudConfigFile string, release bool, iterations, waittimesec int, renewAtFraction float64, renewThreshold time.Duration, recordRenewals bool, observers []common.LeadershipObserver, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		Status: to.StringPtr(config.Fail()),
+	}
+
+	state, err := ReadLeaseState(stateFile)
+	if err != nil {
+		utils.ConsoleOutput(err.Error(), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		return response
+	}
+
+	if release {
+		response = ReleaseLease(cntx, state.SubscriptionID, state.ResourceGroupName, state.StorageAccountName, state.ContainerName, state.BlobName, state.LeaseID, environment, cloudConfigFile, "", cred)
+		if response.ErrorMessage == nil {
+			state.Leader = false
+			state.LeaseExpiresAt = ""
+			state.ErrorMessage = "lease released"
+			notifyObservers(cntx, observers, state)
+		}
+		return response
+	}
+
+	return RenewLease(cntx, state.SubscriptionID, state.ResourceGroupName, state.StorageAccountName, state.ContainerName, state.BlobName, state.LeaseID, "", environment, cloudConfigFile, iterations, waittimesec, renewAtFraction, renewThreshold, "", recordRenewals, false, observers, cred)
+}
+
+// ReadLeaseState reads a state file and checks it identifies a lease, state files written before the
+// subscription and resource group were recorded cannot be used to re-attach to the lease
+func ReadLeaseState(stateFile string) (models.LeadershipState, error) {
+	state, err := common.ReadStateFile(stateFile)
+	if err != nil {
+		return state, fmt.Errorf("an error ocurred while reading state file: %v", err)
+	}
+
+	if state.LeaseID == "" || state.SubscriptionID == "" || state.ResourceGroupName == "" || state.StorageAccountName == "" || state.ContainerName == "" || state.BlobName == "" {
+		return state, fmt.Errorf("state file %v does not identify a lease, lease id, subscription id, resource group, storage account, container and blob name are required", stateFile)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,358 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/google/uuid"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// A read/write lock is made of a writer blob, whose lease is the write lock, and of its readers blob, whose
+// metadata registers the readers holding the read lock. Readers and writers take a short lease on the readers
+// blob while checking each other, so a reader and a writer never both get in.
+const (
+	readersBlobSuffix   = "-readers"
+	readersLeaseSeconds = 15
+)
+
+// errWriterHolds and errReadersHold are the conflicts of a read/write lock acquisition
+var (
+	errWriterHolds = errors.New("the write lock is held")
+	errReadersHold = errors.New("the read lock is held")
+)
+
+// rwLockClients are the clients of the writer and readers blobs of a read/write lock
+type rwLockClients struct {
+	writer  *blockblob.Client
+	readers *blockblob.Client
+}
+
+// RWLockReadersBlobName returns the name of the blob registering the readers of the read/write lock on blobName
+func RWLockReadersBlobName(blobName string) string {
+	return blobName + readersBlobSuffix
+}
+
+// AcquireReadLock - takes a shared read lock of the read/write lock on blobName, attempting retries times or,
+// when maxWait is greater than 0, until maxWait is spent while the write lock is held. The reader is registered
+// until readTTL, so the registration of a reader that never releases does not block writers forever.
+func AcquireReadLock(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, readTTL time.Duration, retries, waittimesec int, maxWait time.Duration, cred azcore.TokenCredential) models.ResponseInfo {
+	response := rwLockResponse(subscriptionID, resourceGroupName, accountName, container, blobName)
+
+	clients, err := getRWLockClients(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, cred)
+	if err != nil {
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	readerID := strings.Replace(uuid.New().String(), "-", "", -1)
+	budget := newAcquireBudget(retries, maxWait)
+	for i := 0; budget.allows(i); i++ {
+		readers := 0
+		err = withReadersLease(cntx, clients.readers, func(metadata map[string]*string, readersLeaseID string) error {
+			writerProps, err := clients.writer.GetProperties(cntx, nil)
+			if err != nil {
+				return err
+			}
+			if writerProps.LeaseState != nil && *writerProps.LeaseState == lease.StateTypeLeased {
+				return errWriterHolds
+			}
+
+			registered := activeReaders(metadata)
+			registered[readerID] = time.Now().Add(readTTL)
+			readers = len(registered)
+			return setReaders(cntx, clients.readers, metadata, registered, readersLeaseID)
+		})
+		if err == nil {
+			clearError(&response)
+			response.ReaderID = to.StringPtr(readerID)
+			response.Readers = to.IntPtr(readers)
+			response.Status = to.StringPtr(config.Success())
+			return response
+		}
+
+		utils.ConsoleOutput(fmt.Sprintf("read lock of blob %v not acquired: %v", blobName, err), config.Stderr())
+		setRWLockError(&response, err)
+		if !errors.Is(err, errWriterHolds) {
+			return response
+		}
+
+		if sleepErr := utils.Sleep(cntx, budget.wait(utils.Jitter(time.Duration(waittimesec)*time.Second))); sleepErr != nil {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("acquire cancelled: %v", sleepErr))
+			classifyError(&response, sleepErr)
+			return response
+		}
+	}
+
+	if budget.exhausted() {
+		response.Status = to.StringPtr(config.Contended())
+	}
+	return response
+}
+
+// ReleaseReadLock - releases the shared read lock of the read/write lock on blobName taken by readerID
+func ReleaseReadLock(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, readerID, environment, cloudConfigFile string, cred azcore.TokenCredential) models.ResponseInfo {
+	response := rwLockResponse(subscriptionID, resourceGroupName, accountName, container, blobName)
+	response.ReaderID = &readerID
+
+	clients, err := getRWLockClients(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, cred)
+	if err != nil {
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	readers := 0
+	found := false
+	err = withReadersLease(cntx, clients.readers, func(metadata map[string]*string, readersLeaseID string) error {
+		registered := activeReaders(metadata)
+		_, found = registered[strings.ToLower(readerID)]
+		delete(registered, strings.ToLower(readerID))
+		readers = len(registered)
+		return setReaders(cntx, clients.readers, metadata, registered, readersLeaseID)
+	})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("read lock of blob %v not released: %v", blobName, err), config.Stderr())
+		setRWLockError(&response, err)
+		return response
+	}
+
+	if !found {
+		utils.AddWarning(&response, fmt.Sprintf("reader %v was not registered, its registration may have expired", readerID))
+	}
+	response.Readers = to.IntPtr(readers)
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// AcquireWriteLock - takes the exclusive write lock of the read/write lock on blobName, the lease of the writer
+// blob, attempting retries times or, when maxWait is greater than 0, until maxWait is spent while the write lock
+// or read locks are held. It is renewed and released as any other lease of blobName.
+func AcquireWriteLock(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, holder string, leaseDuration, retries, waittimesec int, maxWait time.Duration, cred azcore.TokenCredential) models.ResponseInfo {
+	response := rwLockResponse(subscriptionID, resourceGroupName, accountName, container, blobName)
+
+	clients, err := getRWLockClients(cntx, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile, cred)
+	if err != nil {
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	proposedLeaseID := uuid.New().String()
+	writerLeaseClient, err := lease.NewBlobClient(clients.writer, &lease.BlobClientOptions{
+		LeaseID: &proposedLeaseID,
+	})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while acquiring lease client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	budget := newAcquireBudget(retries, maxWait)
+	for i := 0; budget.allows(i); i++ {
+		_, err = writerLeaseClient.AcquireLease(cntx, int32(leaseDuration), &lease.BlobAcquireOptions{})
+		if err == nil {
+			// Readers that got in before the writer blob was leased keep the write lock from being taken
+			readers := 0
+			err = withReadersLease(cntx, clients.readers, func(metadata map[string]*string, readersLeaseID string) error {
+				readers = len(activeReaders(metadata))
+				if readers > 0 {
+					return errReadersHold
+				}
+				return nil
+			})
+			if err == nil {
+				recordWriter(cntx, &response, clients.writer, proposedLeaseID, holder, leaseDuration)
+				clearError(&response)
+				response.LeaseID = to.StringPtr(proposedLeaseID)
+				response.Readers = to.IntPtr(0)
+				response.Status = to.StringPtr(config.Success())
+				return response
+			}
+
+			if _, releaseErr := writerLeaseClient.ReleaseLease(cntx, &lease.BlobReleaseOptions{}); releaseErr != nil {
+				utils.ConsoleOutput(fmt.Sprintf("an error ocurred while releasing lease of blob %v: %v", blobName, releaseErr), config.Stderr())
+			}
+			response.Readers = to.IntPtr(readers)
+		}
+
+		utils.ConsoleOutput(fmt.Sprintf("write lock of blob %v not acquired: %v", blobName, err), config.Stderr())
+		setRWLockError(&response, err)
+		if !errors.Is(err, errReadersHold) && !bloberror.HasCode(err, bloberror.LeaseAlreadyPresent) {
+			return response
+		}
+
+		if sleepErr := utils.Sleep(cntx, budget.wait(utils.Jitter(time.Duration(waittimesec)*time.Second))); sleepErr != nil {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("acquire cancelled: %v", sleepErr))
+			classifyError(&response, sleepErr)
+			return response
+		}
+	}
+
+	if budget.exhausted() {
+		response.Status = to.StringPtr(config.Contended())
+	}
+	return response
+}
+
+// rwLockResponse returns the failed response of an operation on the read/write lock on blobName
+func rwLockResponse(subscriptionID, resourceGroupName, accountName, container, blobName string) models.ResponseInfo {
+	return models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+}
+
+// setRWLockError records the failure of a read/write lock operation, a lock held by the other side is a conflict
+func setRWLockError(response *models.ResponseInfo, err error) {
+	response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+	classifyError(response, err)
+	if errors.Is(err, errWriterHolds) || errors.Is(err, errReadersHold) {
+		response.ErrorCategory = to.StringPtr(common.ErrorCategoryConflict)
+	}
+}
+
+// getRWLockClients returns the clients of the writer and readers blobs of the read/write lock on blobName
+func getRWLockClients(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, cred azcore.TokenCredential) (rwLockClients, error) {
+	azBlobClient, err := NewBlobClients(environment, cloudConfigFile, cred).Get(cntx, subscriptionID, resourceGroupName, accountName)
+	if err != nil {
+		return rwLockClients{}, err
+	}
+
+	writer, err := common.NewBlockBlobClient(fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, blobName), accountName, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobName, err), config.Stderr())
+		return rwLockClients{}, err
+	}
+
+	readers, err := common.NewBlockBlobClient(fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, RWLockReadersBlobName(blobName)), accountName, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", RWLockReadersBlobName(blobName), err), config.Stderr())
+		return rwLockClients{}, err
+	}
+
+	return rwLockClients{writer: writer, readers: readers}, nil
+}
+
+// withReadersLease runs update with the metadata of the readers blob while holding a short lease on it, waiting
+// for the lease while another reader or writer holds it
+func withReadersLease(cntx context.Context, readersClient *blockblob.Client, update func(metadata map[string]*string, readersLeaseID string) error) error {
+	readersLeaseID := uuid.New().String()
+	readersLeaseClient, err := lease.NewBlobClient(readersClient, &lease.BlobClientOptions{
+		LeaseID: &readersLeaseID,
+	})
+	if err != nil {
+		return err
+	}
+
+	// A lease left behind by a crashed process expires after readersLeaseSeconds
+	deadline := time.Now().Add(2 * readersLeaseSeconds * time.Second)
+	for {
+		_, err = readersLeaseClient.AcquireLease(cntx, readersLeaseSeconds, &lease.BlobAcquireOptions{})
+		if err == nil || !bloberror.HasCode(err, bloberror.LeaseAlreadyPresent) || time.Now().After(deadline) {
+			break
+		}
+		if sleepErr := utils.Sleep(cntx, utils.Jitter(500*time.Millisecond)); sleepErr != nil {
+			return sleepErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Released even when cntx is cancelled, other readers and writers would wait for it to expire
+		releaseCntx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+		defer cancel()
+		readersLeaseClient.ReleaseLease(releaseCntx, &lease.BlobReleaseOptions{})
+	}()
+
+	readersProps, err := readersClient.GetProperties(cntx, &blob.GetPropertiesOptions{
+		AccessConditions: &blob.AccessConditions{
+			LeaseAccessConditions: &blob.LeaseAccessConditions{
+				LeaseID: &readersLeaseID,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return update(readersProps.Metadata, readersLeaseID)
+}
+
+// activeReaders returns the readers registered in the metadata of the readers blob whose registration has not
+// expired, by reader id
+func activeReaders(metadata map[string]*string) map[string]time.Time {
+	readers := map[string]time.Time{}
+	for k, v := range metadata {
+		key := strings.ToLower(k)
+		if !strings.HasPrefix(key, strings.ToLower(config.MetadataReader())) || v == nil {
+			continue
+		}
+
+		expiresAt, err := time.Parse(time.RFC3339, *v)
+		if err != nil || !time.Now().Before(expiresAt) {
+			continue
+		}
+		readers[strings.TrimPrefix(key, strings.ToLower(config.MetadataReader()))] = expiresAt
+	}
+	return readers
+}
+
+// setReaders replaces the reader registrations in the metadata of the readers blob, under the lease condition,
+// other metadata values are preserved and expired registrations are dropped
+func setReaders(cntx context.Context, readersClient *blockblob.Client, existing map[string]*string, readers map[string]time.Time, readersLeaseID string) error {
+	metadata := map[string]*string{}
+	for k, v := range existing {
+		if !strings.HasPrefix(strings.ToLower(k), strings.ToLower(config.MetadataReader())) {
+			metadata[k] = v
+		}
+	}
+	for readerID, expiresAt := range readers {
+		metadata[config.MetadataReader()+readerID] = to.StringPtr(expiresAt.UTC().Format(time.RFC3339))
+	}
+
+	_, err := readersClient.SetMetadata(cntx, metadata, &blob.SetMetadataOptions{
+		AccessConditions: &blob.AccessConditions{
+			LeaseAccessConditions: &blob.LeaseAccessConditions{
+				LeaseID: &readersLeaseID,
+			},
+		},
+	})
+	return err
+}
+
+// recordWriter records the holder of the write lock in the writer blob metadata, a failure here does not
+// invalidate the acquired lease
+func recordWriter(cntx context.Context, response *models.ResponseInfo, writerClient *blockblob.Client, leaseID, holder string, leaseDuration int) {
+	writerProps, err := writerClient.GetProperties(cntx, nil)
+	if err == nil {
+		err = common.SetHolderMetadata(cntx, writerClient, writerProps.Metadata, leaseID, holder, leaseDuration, common.MetadataEpoch(writerProps.Metadata)+1)
+	}
+	if err != nil {
+		utils.AddWarning(response, fmt.Sprintf("lease holder metadata could not be recorded: %v", err))
+	}
+}
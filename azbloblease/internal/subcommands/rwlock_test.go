@@ -0,0 +1,45 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func TestActiveReaders(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	active := to.StringPtr(expiresAt.Format(time.RFC3339))
+	expired := to.StringPtr(time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+
+	tests := []struct {
+		name     string
+		metadata map[string]*string
+		readers  []string
+	}{
+		{"no metadata", nil, []string{}},
+		{"active reader", map[string]*string{"azbloblease_reader_node1": active}, []string{"node1"}},
+		{"reader prefix case insensitive", map[string]*string{"Azbloblease_Reader_Node1": active, "AZBLOBLEASE_READER_NODE2": active}, []string{"node1", "node2"}},
+		{"expired reader", map[string]*string{"azbloblease_reader_node1": active, "azbloblease_reader_node2": expired}, []string{"node1"}},
+		{"invalid expiration", map[string]*string{"azbloblease_reader_node1": to.StringPtr("soon"), "azbloblease_reader_node2": nil}, []string{}},
+		{"other metadata", map[string]*string{"holder": to.StringPtr("node1"), "readerTeam": active, "azbloblease_reader_node1": active}, []string{"node1"}},
+	}
+
+	for _, test := range tests {
+		readers := activeReaders(test.metadata)
+		if len(readers) != len(test.readers) {
+			t.Errorf("%v: activeReaders() = %v, want readers %v", test.name, readers, test.readers)
+			continue
+		}
+		for _, reader := range test.readers {
+			if readerExpiresAt, found := readers[reader]; !found || !readerExpiresAt.Equal(expiresAt) {
+				t.Errorf("%v: activeReaders() = %v, want reader %v expiring at %v", test.name, readers, reader, expiresAt)
+			}
+		}
+	}
+}
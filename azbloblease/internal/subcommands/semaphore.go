@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// AcquireSemaphoreSlot - acquires a free slot of a counting semaphore whose slots are the blobs slotNames, at most
+// len(slotNames) holders at a time, returning the slot index obtained. Slots are attempted like the shards of
+// AcquireShardLease.
+func AcquireSemaphoreSlot(cntx context.Context, subscriptionID, resourceGroupName, accountName, container string, slotNames []string, environment, cloudConfigFile, holder string, leaseDuration, retries, waittimesec int, maxWait time.Duration, parallelism int, cred azcore.TokenCredential) models.ResponseInfo {
+	response := AcquireShardLease(cntx, subscriptionID, resourceGroupName, accountName, container, slotNames, environment, cloudConfigFile, holder, leaseDuration, retries, waittimesec, maxWait, parallelism, false, "", cred)
+
+	response.Slot = response.ShardIndex
+	response.ShardIndex = nil
+	return response
+}
+
+// RenewSemaphoreSlot - renews the lease of the slot of a counting semaphore, keeping the slot held
+func RenewSemaphoreSlot(cntx context.Context, subscriptionID, resourceGroupName, accountName, container string, slotNames []string, slot int, leaseID, environment, cloudConfigFile string, iterations, waittimesec int, recordRenewals bool, cred azcore.TokenCredential) models.ResponseInfo {
+	response := RenewLease(cntx, subscriptionID, resourceGroupName, accountName, container, slotNames[slot], leaseID, "", environment, cloudConfigFile, iterations, waittimesec, 0, 0, "", recordRenewals, false, nil, cred)
+
+	response.Slot = to.IntPtr(slot)
+	return response
+}
+
+// ReleaseSemaphoreSlot - releases the lease of the slot of a counting semaphore, freeing the slot for another holder
+func ReleaseSemaphoreSlot(cntx context.Context, subscriptionID, resourceGroupName, accountName, container string, slotNames []string, slot int, leaseID, environment, cloudConfigFile string, cred azcore.TokenCredential) models.ResponseInfo {
+	response := ReleaseLease(cntx, subscriptionID, resourceGroupName, accountName, container, slotNames[slot], leaseID, environment, cloudConfigFile, "", cred)
+
+	response.Slot = to.IntPtr(slot)
+	return response
+}
@@ -0,0 +1,268 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// unixSocketPrefix identifies a listen address that is a local unix socket path
+const unixSocketPrefix = "unix:"
+
+// LeaseServer holds the storage account location and defaults used by every request of the serve subcommand api
+type LeaseServer struct {
+	SubscriptionID    string
+	ResourceGroupName string
+	AccountName       string
+	Container         string
+	Prefix            string
+	Environment       string
+	CloudConfigFile   string
+	Holder            string
+	LeaseDuration     int
+	RecordRenewals    bool
+	APIKey            string
+	Credential        azcore.TokenCredential
+}
+
+// IsUnixSocket returns true when the listen address is a local unix socket
+func IsUnixSocket(listen string) bool {
+	return strings.HasPrefix(listen, unixSocketPrefix)
+}
+
+// Serve - exposes acquire, renew, release and status as an http api until the context is cancelled. Requests
+// must carry the api key, as a bearer token or in the X-API-Key header, unless it is empty, which is only
+// expected when listening on a unix socket, created readable by the current user only.
+func Serve(cntx context.Context, server LeaseServer, listen string) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &server.SubscriptionID,
+		ResourceGroupName:  &server.ResourceGroupName,
+		StorageAccountName: &server.AccountName,
+		ContainerName:      &server.Container,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	listener, err := listenOn(listen)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while listening on %v: %v", listen, err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		return response
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/leases/acquire", server.handleOperation("acquire"))
+	mux.HandleFunc("/v1/leases/renew", server.handleOperation("renew"))
+	mux.HandleFunc("/v1/leases/release", server.handleOperation("release"))
+	mux.HandleFunc("/v1/leases/", server.handleStatus)
+
+	httpServer := &http.Server{
+		Handler:           server.authorize(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- httpServer.Serve(listener) }()
+	utils.ConsoleOutput(fmt.Sprintf("serving lease api on %v", listen), config.Stderr())
+
+	select {
+	case err = <-serveErr:
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while serving lease api: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		return response
+	case <-cntx.Done():
+	}
+
+	// In flight requests are given some time to complete, they are not cancelled by the shutdown
+	shutdownCntx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCntx); err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while shutting down lease api: %v", err), config.Stderr())
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// listenOn listens on a tcp address or, with the unix: prefix, on a unix socket replacing a stale one
+func listenOn(listen string) (net.Listener, error) {
+	if !IsUnixSocket(listen) {
+		return net.Listen("tcp", listen)
+	}
+
+	socketPath := strings.TrimPrefix(listen, unixSocketPrefix)
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// authorize rejects requests without the api key, when one is configured
+func (s LeaseServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.APIKey != "" {
+			key := r.Header.Get("X-API-Key")
+			if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+				key = strings.TrimPrefix(bearer, "Bearer ")
+			}
+
+			if subtle.ConstantTimeCompare([]byte(key), []byte(s.APIKey)) != 1 {
+				writeError(w, http.StatusUnauthorized, "missing or invalid api key")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleOperation handles the POST endpoints performing a single lease operation
+func (s LeaseServer) handleOperation(operation string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%v requires POST", r.URL.Path))
+			return
+		}
+
+		request := models.LeaseRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+
+		if request.BlobName == "" {
+			writeError(w, http.StatusBadRequest, "blobName is required")
+			return
+		}
+
+		if operation != "acquire" && request.LeaseID == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("leaseId is required to %v a lease", operation))
+			return
+		}
+
+		if request.Holder == "" {
+			request.Holder = s.Holder
+		}
+
+		if request.LeaseDuration == 0 {
+			request.LeaseDuration = s.LeaseDuration
+		}
+
+		if request.LeaseDuration < 15 || request.LeaseDuration > 60 {
+			writeError(w, http.StatusBadRequest, "leaseDuration must be between 15 and 60")
+			return
+		}
+
+		blobName := s.Prefix + request.BlobName
+
+		var result models.ResponseInfo
+		switch operation {
+		case "acquire":
+			result = AcquireLease(r.Context(), s.SubscriptionID, s.ResourceGroupName, s.AccountName, s.Container, blobName, s.Environment, s.CloudConfigFile, request.Holder, request.LeaseDuration, 1, 0, 0, false, "", false, s.Credential)
+		case "renew":
+			result = RenewLease(r.Context(), s.SubscriptionID, s.ResourceGroupName, s.AccountName, s.Container, blobName, request.LeaseID, "", s.Environment, s.CloudConfigFile, 1, 0, 0, 0, "", s.RecordRenewals, false, nil, s.Credential)
+			result.LeaseID = to.StringPtr(request.LeaseID)
+		case "release":
+			result = ReleaseLease(r.Context(), s.SubscriptionID, s.ResourceGroupName, s.AccountName, s.Container, blobName, request.LeaseID, s.Environment, s.CloudConfigFile, "", s.Credential)
+		}
+
+		result.Operation = to.StringPtr(operation)
+		utils.ConsoleOutput(fmt.Sprintf("%v %v: %v", operation, blobName, *result.Status), config.Stderr())
+		writeResult(w, result)
+	}
+}
+
+// handleStatus handles GET /v1/leases/{name}, name being the blob name without the prefix
+func (s LeaseServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%v requires GET", r.URL.Path))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/leases/")
+	if name == "" {
+		writeError(w, http.StatusNotFound, "lease name is required")
+		return
+	}
+
+	result := LeaseStatus(r.Context(), s.SubscriptionID, s.ResourceGroupName, s.AccountName, s.Container, s.Prefix+name, s.Environment, s.CloudConfigFile, false, 0, s.Credential)
+	result.Operation = to.StringPtr("status")
+	writeResult(w, result)
+}
+
+// writeResult writes the operation result with an http status code matching its outcome
+func writeResult(w http.ResponseWriter, result models.ResponseInfo) {
+	writeResponse(w, resultHTTPStatus(result), result)
+}
+
+// writeError writes a failure detected before any lease operation was attempted
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeResponse(w, statusCode, models.ResponseInfo{
+		Status:       to.StringPtr(config.Fail()),
+		ErrorMessage: to.StringPtr(message),
+	})
+}
+
+// writeResponse writes the json result
+func writeResponse(w http.ResponseWriter, statusCode int, result models.ResponseInfo) {
+	timestamp := utils.Timestamp(time.Now())
+	result.Timestamp = &timestamp
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(result)
+}
+
+// resultHTTPStatus maps the result status and error category to an http status code
+func resultHTTPStatus(result models.ResponseInfo) int {
+	if *result.Status == config.Success() || *result.Status == config.SuccessOnRenew() {
+		return http.StatusOK
+	}
+
+	if *result.Status == config.Contended() {
+		return http.StatusConflict
+	}
+
+	if result.ErrorCategory == nil {
+		return http.StatusInternalServerError
+	}
+
+	switch *result.ErrorCategory {
+	case common.ErrorCategoryNotFound:
+		return http.StatusNotFound
+	case common.ErrorCategoryConflict, common.ErrorCategoryLeaseNotHeld, common.ErrorCategoryImmutable:
+		return http.StatusConflict
+	case common.ErrorCategoryTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		// Authorization failures are the server identity lacking access, not the caller
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/google/uuid"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// AcquireShardLease - acquires a lease on the first free blob of a set of shards, returning which shard
+// was obtained, allowing at most len(shards) concurrent holders. Shards are attempted concurrently, at most
+// parallelism at a time. Attempts are bounded by retries or, when maxWait is greater than 0, by maxWait,
+// with a Contended status when it is spent. Audit snapshots and audit log lines are recorded for the shard obtained.
+func AcquireShardLease(cntx context.Context, subscriptionID, resourceGroupName, accountName, container string, shards []string, environment, cloudConfigFile, holder string, leaseDuration, retries, waittimesec int, maxWait time.Duration, parallelism int, auditSnapshots bool, auditLogBlob string, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	if !precheckConnectivity(cntx, &response, fmt.Sprintf("%v%v", azBlobClient.URL, container)) {
+		return response
+	}
+
+	// Generating LeaseID
+	proposedLeaseID := uuid.New().String()
+	budget := newAcquireBudget(retries, maxWait)
+	for i := 0; budget.allows(i); i++ {
+
+		acquired, err := acquireFirstFreeShard(cntx, azBlobClient.URL, accountName, container, shards, proposedLeaseID, leaseDuration, parallelism, cred)
+		if acquired != nil {
+			// Recording holder information and audit records, a failure here does not invalidate the acquired lease
+			epoch := common.MetadataEpoch(acquired.blobProps.Metadata) + 1
+			err = common.SetHolderMetadata(cntx, acquired.blockBlobClient, acquired.blobProps.Metadata, proposedLeaseID, holder, leaseDuration, epoch)
+			if err != nil {
+				utils.AddWarning(&response, fmt.Sprintf("lease holder metadata could not be recorded: %v", err))
+			}
+
+			auditBlobURL := ""
+			if auditLogBlob != "" {
+				auditBlobURL = fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, auditLogBlob)
+			}
+
+			var auditWarnings []string
+			response.Snapshot, auditWarnings = recordAcquisitionAudit(cntx, acquired.blockBlobClient, accountName, proposedLeaseID, auditSnapshots, auditBlobURL, "acquire", holder, epoch, cred)
+			for _, warning := range auditWarnings {
+				utils.AddWarning(&response, warning)
+			}
+
+			response.BlobName = to.StringPtr(shards[acquired.index])
+			response.ShardIndex = to.IntPtr(acquired.index)
+			response.BlobURL = to.StringPtr(fmt.Sprintf("%v%v/%v", azBlobClient.URL, container, shards[acquired.index]))
+			response.LeaseID = to.StringPtr(proposedLeaseID)
+			clearError(&response)
+			response.Status = to.StringPtr(config.Success())
+			return response
+		}
+
+		if err != nil {
+			response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+			classifyError(&response, err)
+		}
+
+		// Further attempts would fail fast as well
+		if common.RetryBudgetOpen() {
+			break
+		}
+
+		if sleepErr := utils.Sleep(cntx, budget.wait(utils.Jitter(time.Duration(waittimesec)*time.Second))); sleepErr != nil {
+			response.ErrorMessage = to.StringPtr(fmt.Sprintf("acquire cancelled: %v", sleepErr))
+			classifyError(&response, sleepErr)
+			return response
+		}
+	}
+
+	response.ErrorMessage = to.StringPtr(fmt.Sprintf("no free shard found among %v shards, last error: %v", len(shards), to.String(response.ErrorMessage)))
+	if budget.contended(response) {
+		response.Status = to.StringPtr(config.Contended())
+	}
+	return response
+}
+
+// shardAttempt is the outcome of an acquisition attempt on one shard
+type shardAttempt struct {
+	index           int
+	blockBlobClient *blockblob.Client
+	blobProps       blob.GetPropertiesResponse
+	err             error
+}
+
+// acquireFirstFreeShard attempts to acquire the shards concurrently, at most parallelism at a time, and returns
+// the first one acquired, or nil with the last error when all of them are held. Once a shard is acquired no
+// further attempt is started, and shards acquired by attempts already in flight are released right away so
+// a single shard is held.
+func acquireFirstFreeShard(cntx context.Context, blobEndpoint, accountName, container string, shards []string, proposedLeaseID string, leaseDuration, parallelism int, cred azcore.TokenCredential) (*shardAttempt, error) {
+	var acquired *shardAttempt
+	var lastErr error
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	semaphore := make(chan struct{}, parallelism)
+	for shardIndex, shard := range shards {
+		semaphore <- struct{}{}
+
+		mutex.Lock()
+		found := acquired != nil
+		mutex.Unlock()
+		if found || cntx.Err() != nil {
+			<-semaphore
+			break
+		}
+
+		wg.Add(1)
+		go func(shardIndex int, shard string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			attempt := &shardAttempt{index: shardIndex}
+			blobURL := fmt.Sprintf("%v%v/%v", blobEndpoint, container, shard)
+			attempt.blockBlobClient, attempt.err = common.NewBlockBlobClient(blobURL, accountName, cred)
+			if attempt.err == nil {
+				attempt.blobProps, attempt.err = tryAcquireLease(cntx, attempt.blockBlobClient, proposedLeaseID, leaseDuration)
+			}
+
+			if attempt.err != nil {
+				utils.ConsoleOutput(fmt.Sprintf("shard %v not acquired: %v", shard, attempt.err), config.Stderr())
+			}
+
+			mutex.Lock()
+			alreadyAcquired := acquired != nil
+			if attempt.err != nil {
+				lastErr = attempt.err
+			} else if !alreadyAcquired {
+				acquired = attempt
+			}
+			mutex.Unlock()
+
+			if attempt.err == nil && alreadyAcquired {
+				releaseShard(attempt.blockBlobClient, shard, proposedLeaseID)
+			}
+		}(shardIndex, shard)
+	}
+	wg.Wait()
+
+	return acquired, lastErr
+}
+
+// releaseShard releases a shard acquired after another one was obtained, a context detached from the
+// operation one is used so it is still released when the operation was cancelled
+func releaseShard(blockBlobClient *blockblob.Client, shard, leaseID string) {
+	cntx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+
+	blobLeaseClient, err := lease.NewBlobClient(blockBlobClient, &lease.BlobClientOptions{
+		LeaseID: &leaseID,
+	})
+
+	if err == nil {
+		_, err = blobLeaseClient.ReleaseLease(cntx, &lease.BlobReleaseOptions{})
+	}
+
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while releasing extra shard %v: %v", shard, err), config.Stderr())
+	}
+}
+
+// tryAcquireLease gets the blob properties and acquires its lease, returning the properties read before acquisition
+func tryAcquireLease(cntx context.Context, blockBlobClient *blockblob.Client, proposedLeaseID string, leaseDuration int) (blob.GetPropertiesResponse, error) {
+	blobProps, err := blockBlobClient.GetProperties(cntx, nil)
+	if err != nil {
+		return blobProps, err
+	}
+
+	blobLeaseClient, err := lease.NewBlobClient(blockBlobClient, &lease.BlobClientOptions{
+		LeaseID: &proposedLeaseID,
+	})
+
+	if err != nil {
+		return blobProps, err
+	}
+
+	_, err = blobLeaseClient.AcquireLease(cntx, int32(leaseDuration), &lease.BlobAcquireOptions{})
+	return blobProps, err
+}
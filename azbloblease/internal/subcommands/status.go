@@ -0,0 +1,195 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// LeaseStatus - returns the lease state of an Azure blob storage blob and, when leased, who holds it.
+// When allowSecondary is true and the primary endpoint is unavailable, the secondary endpoint of
+// read access geo-redundant accounts is used instead. A leased blob is flagged as stale when no renewal was
+// recorded for staleAfter, or for the lease duration recorded in blob metadata when staleAfter is 0.
+func LeaseStatus(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, allowSecondary bool, staleAfter time.Duration, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	blobRelativePath := fmt.Sprintf("%v/%v", container, blobName)
+	blobURL := fmt.Sprintf("%v%v", azBlobClient.URL, blobRelativePath)
+
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	response.ServedBy = to.StringPtr("primary")
+	blobProps, err := blockBlobClient.GetProperties(cntx, nil)
+	if err != nil && allowSecondary && common.IsEndpointUnavailable(err) {
+		utils.ConsoleOutput(fmt.Sprintf("primary endpoint unavailable, trying secondary endpoint, error: %v", err), config.Stderr())
+
+		secondaryBlobURL, secondaryBlockBlobClient, secondaryErr := secondaryBlobClient(cntx, storageAccountClient, resourceGroupName, accountName, blobRelativePath, environment, cloudConfigFile, cred)
+		if secondaryErr != nil {
+			utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining secondary blob endpoint: %v", secondaryErr), config.Stderr())
+		} else {
+			blobURL = secondaryBlobURL
+			blobProps, err = secondaryBlockBlobClient.GetProperties(cntx, nil)
+			response.ServedBy = to.StringPtr("secondary")
+			utils.AddWarning(&response, "primary endpoint unavailable, status was read from the secondary endpoint and may lag behind")
+		}
+	}
+
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to get blob %v, error: %v", blobURL, err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	if blobProps.LeaseState != nil {
+		response.LeaseState = to.StringPtr(string(*blobProps.LeaseState))
+	}
+
+	if blobProps.LeaseStatus != nil {
+		response.LeaseStatus = to.StringPtr(string(*blobProps.LeaseStatus))
+	}
+
+	// Holder information is only meaningful while the blob is leased, after a release or
+	// expiration the metadata still refers to the previous holder
+	if blobProps.LeaseState != nil && *blobProps.LeaseState == lease.StateTypeLeased {
+		populateHolderInfo(&response, blobProps.Metadata, staleAfter)
+	}
+
+	// Every metadata update of the lock blob leaves a version behind on accounts with blob versioning enabled
+	response.VersioningEnabled = to.BoolPtr(common.VersioningEnabled(blobProps.VersionID))
+	if common.VersioningEnabled(blobProps.VersionID) {
+		response.VersionID = blobProps.VersionID
+		if *response.ServedBy == "primary" {
+			versionIDs, err := common.PreviousVersions(cntx, azBlobClient.Client.ServiceClient().NewContainerClient(container), blobName)
+			if err != nil {
+				utils.AddWarning(&response, fmt.Sprintf("previous versions of the blob could not be counted: %v", err))
+			} else {
+				response.PreviousVersions = to.IntPtr(len(versionIDs))
+			}
+		}
+	}
+
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// secondaryBlobClient returns the url and a client of the blob on the secondary endpoint of read access
+// geo-redundant accounts
+func secondaryBlobClient(cntx context.Context, storageAccountClient armstorage.AccountsClient, resourceGroupName, accountName, blobRelativePath, environment, cloudConfigFile string, cred azcore.TokenCredential) (string, *blockblob.Client, error) {
+	var secondaryEndpointURL string
+	if config.SkipARM() {
+		storageEndpointSuffix, err := common.GetStorageEndpointSuffix(environment, cloudConfigFile)
+		if err != nil {
+			return "", nil, err
+		}
+		secondaryEndpointURL = common.BuildBlobEndpoint(accountName+"-secondary", storageEndpointSuffix)
+	} else {
+		var err error
+		secondaryEndpointURL, err = common.GetAccountSecondaryBlobEndpoint(cntx, storageAccountClient, resourceGroupName, accountName)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	blobURL := fmt.Sprintf("%v%v", secondaryEndpointURL, blobRelativePath)
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		return "", nil, err
+	}
+	return blobURL, blockBlobClient, nil
+}
+
+// populateHolderInfo fills in holder identity, lease age, renewal staleness and estimated expiration from blob metadata
+func populateHolderInfo(response *models.ResponseInfo, metadata map[string]*string, staleAfter time.Duration) {
+	if holder := utils.MetadataValue(metadata, config.MetadataHolder()); holder != "" {
+		response.Holder = to.StringPtr(holder)
+	}
+
+	if successor, preferredUntil, found := common.DesignatedSuccessor(metadata); found {
+		response.Successor = to.StringPtr(successor)
+		response.SuccessorPreferredUntil = to.StringPtr(preferredUntil.UTC().Format(time.RFC3339))
+	}
+
+	acquiredAt, err := time.Parse(time.RFC3339, utils.MetadataValue(metadata, config.MetadataAcquiredAt()))
+	if err != nil {
+		return
+	}
+
+	response.LeaseAcquiredAt = to.StringPtr(acquiredAt.UTC().Format(time.RFC3339))
+	response.LeaseAgeSeconds = to.Int64Ptr(int64(time.Since(acquiredAt).Seconds()))
+
+	if renewedAt, err := time.Parse(time.RFC3339, utils.MetadataValue(metadata, config.MetadataLastRenewedAt())); err == nil {
+		response.LeaseRenewedAt = to.StringPtr(renewedAt.UTC().Format(time.RFC3339))
+	}
+
+	// A leader alive but wedged keeps the lease while its renewal heartbeat gets older, measured from the
+	// acquisition until the first renewal is recorded
+	lastActivity, _ := common.LastLeaseActivity(metadata)
+	sinceLastRenew := time.Since(lastActivity)
+	response.SecondsSinceLastRenew = to.Int64Ptr(int64(sinceLastRenew.Seconds()))
+
+	leaseDuration, durationErr := strconv.Atoi(utils.MetadataValue(metadata, config.MetadataLeaseDuration()))
+	if staleAfter == 0 && durationErr == nil && leaseDuration > 0 {
+		staleAfter = time.Duration(leaseDuration) * time.Second
+	}
+	if staleAfter > 0 {
+		response.Stale = to.BoolPtr(sinceLastRenew > staleAfter)
+	}
+
+	if durationErr != nil {
+		return
+	}
+
+	// Expiration is measured from the last renewal when renew records it
+	response.LeaseDurationSeconds = to.IntPtr(leaseDuration)
+	response.LeaseExpiresAt = to.StringPtr(lastActivity.Add(time.Duration(leaseDuration) * time.Second).UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+func TestPopulateHolderInfo(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	timestamp := func(ago time.Duration) *string {
+		return to.StringPtr(now.Add(-ago).Format(time.RFC3339))
+	}
+
+	tests := []struct {
+		name           string
+		metadata       map[string]*string
+		staleAfter     time.Duration
+		holder         string
+		sinceLastRenew time.Duration // negative when not reported
+		stale          string        // true, false or empty when not reported
+		expiresAt      string        // empty when not reported
+	}{
+		{"no metadata", nil, 0, "", -1, "", ""},
+		{"holder only", map[string]*string{"holder": to.StringPtr("node1")}, 0, "node1", -1, "", ""},
+		{
+			"acquired",
+			map[string]*string{"holder": to.StringPtr("node1"), "acquiredAt": timestamp(20 * time.Second), "leaseDuration": to.StringPtr("60")},
+			0, "node1", 20 * time.Second, "false", now.Add(40 * time.Second).Format(time.RFC3339),
+		},
+		{
+			"renewed",
+			map[string]*string{"holder": to.StringPtr("node1"), "acquiredAt": timestamp(5 * time.Minute), "lastRenewedAt": timestamp(10 * time.Second), "leaseDuration": to.StringPtr("60")},
+			0, "node1", 10 * time.Second, "false", now.Add(50 * time.Second).Format(time.RFC3339),
+		},
+		{
+			"stale after lease duration",
+			map[string]*string{"holder": to.StringPtr("node1"), "acquiredAt": timestamp(5 * time.Minute), "lastRenewedAt": timestamp(90 * time.Second), "leaseDuration": to.StringPtr("60")},
+			0, "node1", 90 * time.Second, "true", now.Add(-30 * time.Second).Format(time.RFC3339),
+		},
+		{
+			"stale after",
+			map[string]*string{"holder": to.StringPtr("node1"), "acquiredAt": timestamp(5 * time.Minute), "lastRenewedAt": timestamp(30 * time.Second), "leaseDuration": to.StringPtr("60")},
+			20 * time.Second, "node1", 30 * time.Second, "true", now.Add(30 * time.Second).Format(time.RFC3339),
+		},
+		{
+			"within stale after",
+			map[string]*string{"holder": to.StringPtr("node1"), "acquiredAt": timestamp(5 * time.Minute), "lastRenewedAt": timestamp(10 * time.Second), "leaseDuration": to.StringPtr("60")},
+			20 * time.Second, "node1", 10 * time.Second, "false", now.Add(50 * time.Second).Format(time.RFC3339),
+		},
+		{
+			"invalid acquired at",
+			map[string]*string{"holder": to.StringPtr("node1"), "acquiredAt": to.StringPtr("yesterday"), "leaseDuration": to.StringPtr("60")},
+			0, "node1", -1, "", "",
+		},
+		{
+			"unknown lease duration",
+			map[string]*string{"holder": to.StringPtr("node1"), "acquiredAt": timestamp(20 * time.Second)},
+			0, "node1", 20 * time.Second, "", "",
+		},
+	}
+
+	for _, test := range tests {
+		response := models.ResponseInfo{}
+		populateHolderInfo(&response, test.metadata, test.staleAfter)
+
+		if holder := to.String(response.Holder); holder != test.holder {
+			t.Errorf("%v: holder = %q, want %q", test.name, holder, test.holder)
+		}
+
+		if test.sinceLastRenew < 0 {
+			if response.SecondsSinceLastRenew != nil {
+				t.Errorf("%v: secondsSinceLastRenew = %v, want none", test.name, *response.SecondsSinceLastRenew)
+			}
+		} else if response.SecondsSinceLastRenew == nil || *response.SecondsSinceLastRenew < int64(test.sinceLastRenew.Seconds()) || *response.SecondsSinceLastRenew > int64(test.sinceLastRenew.Seconds())+1 {
+			t.Errorf("%v: secondsSinceLastRenew = %v, want %v", test.name, to.Int64(response.SecondsSinceLastRenew), int64(test.sinceLastRenew.Seconds()))
+		}
+
+		stale := ""
+		if response.Stale != nil {
+			stale = strconv.FormatBool(*response.Stale)
+		}
+		if stale != test.stale {
+			t.Errorf("%v: stale = %q, want %q", test.name, stale, test.stale)
+		}
+
+		if expiresAt := to.String(response.LeaseExpiresAt); expiresAt != test.expiresAt {
+			t.Errorf("%v: leaseExpiresAt = %q, want %q", test.name, expiresAt, test.expiresAt)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// tokenClaims holds the access token claims used to identify who the token was issued to
+type tokenClaims struct {
+	ObjectID          string `json:"oid"`
+	TenantID          string `json:"tid"`
+	AppID             string `json:"appid"`
+	AuthorizedParty   string `json:"azp"`
+	UserPrincipalName string `json:"upn"`
+	UniqueName        string `json:"unique_name"`
+	Issuer            string `json:"iss"`
+}
+
+// TestAuth - obtains a single token for scope, or the storage data plane scope when empty, and returns
+// the identity it was issued to, allowing to verify which credential DefaultAzureCredential picked
+func TestAuth(cntx context.Context, scope string, cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		Status: to.StringPtr(config.Fail()),
+	}
+
+	if scope == "" {
+		scope = storageScope()
+	}
+
+	token, err := cred.GetToken(cntx, policy.TokenRequestOptions{Scopes: []string{scope}})
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining token: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		return response
+	}
+
+	identity := models.IdentityInfo{
+		Scope:     scope,
+		ExpiresOn: token.ExpiresOn.UTC().Format(time.RFC3339),
+	}
+
+	claims, err := decodeTokenClaims(token.Token)
+	if err != nil {
+		// Tokens are not required to be jwt, only the token exchange is reported in this case
+		utils.ConsoleOutput(fmt.Sprintf("token obtained but its claims could not be decoded: %v", err), config.Stderr())
+	} else {
+		identity.ObjectID = claims.ObjectID
+		identity.TenantID = claims.TenantID
+		identity.ApplicationID = claims.AppID
+		if identity.ApplicationID == "" {
+			identity.ApplicationID = claims.AuthorizedParty
+		}
+		identity.UserPrincipalName = claims.UserPrincipalName
+		if identity.UserPrincipalName == "" {
+			identity.UserPrincipalName = claims.UniqueName
+		}
+		identity.Issuer = claims.Issuer
+	}
+
+	response.Identity = &identity
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// decodeTokenClaims decodes the payload of a jwt access token, the signature is not verified
+func decodeTokenClaims(token string) (tokenClaims, error) {
+	claims := tokenClaims{}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("token is not a jwt")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return claims, err
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	return claims, err
+}
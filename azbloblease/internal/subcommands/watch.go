@@ -0,0 +1,197 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/common"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/utils"
+)
+
+// Leadership events emitted by watch subcommand
+const (
+	WatchEventObserved = "observed" // first poll, current leadership
+	WatchEventElected  = "elected"  // the blob became leased
+	WatchEventChanged  = "changed"  // the blob stayed leased by another holder or a new acquisition
+	WatchEventVacated  = "vacated"  // the lease was released, expired or broken
+)
+
+// watchedLeadership leadership observed by one poll
+type watchedLeadership struct {
+	leaseState string
+	holder     string
+	epoch      int64
+}
+
+// Watch - polls the lease of the blob every interval, count times or until cntx is done when count is 0, and calls
+// onEvent for the first observation and every leadership transition, with the previous and the new holder. Failed
+// polls are logged and retried on the next interval, the response fails with the last error when any poll failed.
+// When allowSecondary is true polls falling on an unavailable primary endpoint read the secondary endpoint instead.
+func Watch(cntx context.Context, subscriptionID, resourceGroupName, accountName, container, blobName, environment, cloudConfigFile string, allowSecondary bool, interval time.Duration, count int, onEvent func(models.LeadershipEvent), cred azcore.TokenCredential) models.ResponseInfo {
+
+	response := models.ResponseInfo{
+		SubscriptionID:     &subscriptionID,
+		ResourceGroupName:  &resourceGroupName,
+		StorageAccountName: &accountName,
+		ContainerName:      &container,
+		BlobName:           &blobName,
+		Status:             to.StringPtr(config.Fail()),
+	}
+
+	// Getting storage client
+	storageAccountClient, err := common.GetStorageClient(subscriptionID, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while getting storage account client: %v.", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	// Getting blob client
+	azBlobClient, err := common.GetBlobClient(cntx, storageAccountClient, accountName, resourceGroupName, environment, cloudConfigFile, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining az blob client: %v", err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	blobRelativePath := fmt.Sprintf("%v/%v", container, blobName)
+	blobURL := fmt.Sprintf("%v%v", azBlobClient.URL, blobRelativePath)
+	response.BlobURL = to.StringPtr(blobURL)
+
+	blockBlobClient, err := common.NewBlockBlobClient(blobURL, accountName, cred)
+	if err != nil {
+		utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to create blob client for blob %v, error: %v", blobURL, err), config.Stderr())
+		response.ErrorMessage = to.StringPtr(strings.Replace(err.Error(), "\"", "", -1))
+		classifyError(&response, err)
+		return response
+	}
+
+	response.ServedBy = to.StringPtr("primary")
+	var secondaryBlockBlobClient *blockblob.Client
+	var previous *watchedLeadership
+	var lastErr error
+	polls, failures := 0, 0
+	for i := 0; count == 0 || i < count; i++ {
+		if i > 0 {
+			if sleepErr := utils.Sleep(cntx, interval); sleepErr != nil {
+				break
+			}
+		}
+
+		blobProps, err := blockBlobClient.GetProperties(cntx, nil)
+		if err != nil && allowSecondary && common.IsEndpointUnavailable(err) {
+			utils.ConsoleOutput(fmt.Sprintf("primary endpoint unavailable, trying secondary endpoint, error: %v", err), config.Stderr())
+
+			if secondaryBlockBlobClient == nil {
+				var secondaryErr error
+				_, secondaryBlockBlobClient, secondaryErr = secondaryBlobClient(cntx, storageAccountClient, resourceGroupName, accountName, blobRelativePath, environment, cloudConfigFile, cred)
+				if secondaryErr != nil {
+					utils.ConsoleOutput(fmt.Sprintf("an error ocurred while obtaining secondary blob endpoint: %v", secondaryErr), config.Stderr())
+				}
+			}
+
+			if secondaryBlockBlobClient != nil {
+				blobProps, err = secondaryBlockBlobClient.GetProperties(cntx, nil)
+				if err == nil && *response.ServedBy != "secondary" {
+					response.ServedBy = to.StringPtr("secondary")
+					utils.AddWarning(&response, "primary endpoint unavailable, leadership was read from the secondary endpoint and may lag behind")
+				}
+			}
+		}
+
+		if err != nil {
+			if cntx.Err() != nil {
+				break
+			}
+			utils.ConsoleOutput(fmt.Sprintf("an error occurred trying to get blob %v, error: %v", blobURL, err), config.Stderr())
+			polls++
+			failures++
+			lastErr = err
+			continue
+		}
+		polls++
+
+		current := observedLeadership(blobProps)
+		if event := leadershipTransition(previous, current); event != nil {
+			event.StorageAccountName = accountName
+			event.ContainerName = container
+			event.BlobName = blobName
+			event.ObservedAt = time.Now().UTC().Format(time.RFC3339)
+			onEvent(*event)
+		}
+		previous = &current
+	}
+
+	if failures > 0 {
+		response.ErrorMessage = to.StringPtr(fmt.Sprintf("%v of %v polls failed, last error: %v", failures, polls, strings.Replace(lastErr.Error(), "\"", "", -1)))
+		classifyError(&response, lastErr)
+		return response
+	}
+
+	clearError(&response)
+	response.Status = to.StringPtr(config.Success())
+	return response
+}
+
+// observedLeadership returns the leadership recorded by the blob properties, holder and epoch are only
+// meaningful while the blob is leased, after a release or expiration the metadata still refers to the previous holder
+func observedLeadership(blobProps blob.GetPropertiesResponse) watchedLeadership {
+	current := watchedLeadership{leaseState: string(lease.StateTypeAvailable)}
+	if blobProps.LeaseState != nil {
+		current.leaseState = string(*blobProps.LeaseState)
+	}
+
+	if current.leaseState == string(lease.StateTypeLeased) {
+		current.holder = utils.MetadataValue(blobProps.Metadata, config.MetadataHolder())
+		current.epoch = common.MetadataEpoch(blobProps.Metadata)
+	}
+	return current
+}
+
+// leadershipTransition returns the event from the previous to the current leadership, nil when leadership did not
+// change. A new epoch with the same holder is a change too, the holder lost and acquired the lease again in between.
+func leadershipTransition(previous *watchedLeadership, current watchedLeadership) *models.LeadershipEvent {
+	leased := current.leaseState == string(lease.StateTypeLeased)
+	event := &models.LeadershipEvent{
+		LeaseState: current.leaseState,
+		NewHolder:  current.holder,
+		Epoch:      current.epoch,
+	}
+
+	if previous == nil {
+		event.Event = WatchEventObserved
+		return event
+	}
+
+	event.PreviousLeaseState = previous.leaseState
+	event.PreviousHolder = previous.holder
+	wasLeased := previous.leaseState == string(lease.StateTypeLeased)
+
+	switch {
+	case !wasLeased && leased:
+		event.Event = WatchEventElected
+	case wasLeased && !leased:
+		event.Event = WatchEventVacated
+	case leased && (previous.holder != current.holder || previous.epoch != current.epoch):
+		event.Event = WatchEventChanged
+	default:
+		return nil
+	}
+	return event
+}
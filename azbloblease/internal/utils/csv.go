@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// blobsCSVHeader are the columns of the csv output, every blob field so inventories can be loaded as is
+var blobsCSVHeader = []string{"containerName", "blobName", "leaseState", "leaseStatus", "holder", "leaseAcquiredAt", "leaseRenewedAt", "leaseDurationSeconds", "leaseExpiresAt", "secondsUntilExpiry", "tags", "lastActivity", "deleted", "errorMessage"}
+
+// writeBlobsCSV prints a header and one csv record per blob, missing values are empty and tags are
+// formatted as key=value;key=value sorted by key
+func writeBlobsCSV(writer io.Writer, blobs []models.BlobInfo) error {
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write(blobsCSVHeader); err != nil {
+		return err
+	}
+
+	for _, blobInfo := range blobs {
+		tags := []string{}
+		for key, value := range blobInfo.Tags {
+			tags = append(tags, fmt.Sprintf("%v=%v", key, value))
+		}
+		sort.Strings(tags)
+
+		record := []string{
+			stringValue(blobInfo.ContainerName),
+			stringValue(blobInfo.BlobName),
+			stringValue(blobInfo.LeaseState),
+			stringValue(blobInfo.LeaseStatus),
+			stringValue(blobInfo.Holder),
+			stringValue(blobInfo.LeaseAcquiredAt),
+			stringValue(blobInfo.LeaseRenewedAt),
+			"",
+			stringValue(blobInfo.LeaseExpiresAt),
+			"",
+			strings.Join(tags, ";"),
+			stringValue(blobInfo.LastActivity),
+			"",
+			stringValue(blobInfo.ErrorMessage),
+		}
+		if blobInfo.LeaseDurationSeconds != nil {
+			record[7] = strconv.Itoa(*blobInfo.LeaseDurationSeconds)
+		}
+		if blobInfo.SecondsUntilExpiry != nil {
+			record[9] = strconv.FormatInt(*blobInfo.SecondsUntilExpiry, 10)
+		}
+		if blobInfo.Deleted != nil {
+			record[12] = strconv.FormatBool(*blobInfo.Deleted)
+		}
+
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,14 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build !windows
+
+package utils
+
+// ExitStatus returns the exit status the process ends with when exiting with code, the exit status is a
+// single byte so only the code modulo 256 is seen, e.g. 44 for 300
+func ExitStatus(code int) int {
+	return code & 0xff
+}
@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build !windows
+
+package utils
+
+import "testing"
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		code   int
+		status int
+	}{
+		{0, 0},
+		{2, 2},
+		{255, 255},
+		{256, 0},
+		{300, 44},
+		{500, 244},
+	}
+
+	for _, test := range tests {
+		if status := ExitStatus(test.code); status != test.status {
+			t.Errorf("ExitStatus(%v) = %v, want %v", test.code, status, test.status)
+		}
+	}
+}
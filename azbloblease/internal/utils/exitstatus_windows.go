@@ -0,0 +1,14 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+//go:build windows
+
+package utils
+
+// ExitStatus returns the exit status the process ends with when exiting with code, windows keeps the whole
+// 32 bits exit code
+func ExitStatus(code int) int {
+	return code
+}
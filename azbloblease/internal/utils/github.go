@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// writeGitHubOutputs writes the top level scalar fields of a result, plus the whole result as json, to the
+// file referenced by GITHUB_OUTPUT, and emits an error annotation when the operation failed
+func writeGitHubOutputs(result interface{}, status, operation, errorMessage string) error {
+	if status == config.Fail() || status == config.PartialSuccess() {
+		fmt.Printf("::error title=%v::%v\n", escapeAnnotationProperty(fmt.Sprintf("azbloblease %v", operation)), escapeAnnotationData(errorMessage))
+	}
+
+	outputPath := os.Getenv("GITHUB_OUTPUT")
+	if outputPath == "" {
+		return fmt.Errorf("GITHUB_OUTPUT environment variable is not set, outputs are only available when running on github actions")
+	}
+
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	outputs := map[string]string{
+		"result": string(resultJSON),
+		"status": status,
+	}
+
+	// Scalar fields of single results become individual outputs (e.g. leaseId, blobName, holder)
+	fields := map[string]interface{}{}
+	if json.Unmarshal(resultJSON, &fields) == nil {
+		for name, value := range fields {
+			switch v := value.(type) {
+			case string:
+				outputs[name] = v
+			case float64, bool:
+				outputs[name] = fmt.Sprintf("%v", v)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	outputFile, err := os.OpenFile(outputPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	for _, name := range names {
+		// Multiline values need the heredoc syntax with a delimiter that cannot be part of the value
+		if strings.ContainsAny(outputs[name], "\r\n") {
+			delimiter := "ghadelimiter_" + uuid.New().String()
+			_, err = fmt.Fprintf(outputFile, "%v<<%v\n%v\n%v\n", name, delimiter, outputs[name], delimiter)
+		} else {
+			_, err = fmt.Fprintf(outputFile, "%v=%v\n", name, outputs[name])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escapeAnnotationData escapes workflow command data
+func escapeAnnotationData(value string) string {
+	value = strings.Replace(value, "%", "%25", -1)
+	value = strings.Replace(value, "\r", "%0D", -1)
+	return strings.Replace(value, "\n", "%0A", -1)
+}
+
+// escapeAnnotationProperty escapes workflow command property values
+func escapeAnnotationProperty(value string) string {
+	value = escapeAnnotationData(value)
+	value = strings.Replace(value, ":", "%3A", -1)
+	return strings.Replace(value, ",", "%2C", -1)
+}
+
+// gitHubResultsSummary returns the overall status, operation and error message of several results,
+// the status is fail when any result failed
+func gitHubResultsSummary(results []models.ResponseInfo) (string, string, string) {
+	status := config.Success()
+	operation := ""
+	errorMessages := []string{}
+
+	for _, result := range results {
+		if result.Operation != nil {
+			operation = *result.Operation
+		}
+
+		if result.Status != nil && (*result.Status == config.Fail() || *result.Status == config.PartialSuccess()) {
+			status = config.Fail()
+			if result.ErrorMessage != nil {
+				errorMessages = append(errorMessages, fmt.Sprintf("%v: %v", stringValue(result.BlobName), *result.ErrorMessage))
+			}
+		}
+	}
+
+	return status, operation, strings.Join(errorMessages, "\n")
+}
+
+// stringValue returns the value of a string pointer, empty when nil
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with the five fields minute, hour, day of month, month and day of week,
+// each one a *, a value, a range or a comma separated list of them, optionally with a /step
+type Schedule struct {
+	minutes    map[int]bool
+	hours      map[int]bool
+	days       map[int]bool
+	months     map[int]bool
+	weekdays   map[int]bool
+	anyDay     bool
+	anyWeekday bool
+}
+
+// maxScheduleLookahead bounds the search for the next start of a schedule, a year covers any valid expression
+const maxScheduleLookahead = 366 * 24 * time.Hour
+
+// ParseSchedule parses a five field cron expression, e.g. "0 2 * * 6" for saturdays at 02:00
+func ParseSchedule(expression string) (*Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %v must have 5 fields: minute hour day-of-month month day-of-week", expression)
+	}
+
+	schedule := &Schedule{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	bounds := []struct {
+		values   *map[int]bool
+		min, max int
+	}{
+		{&schedule.minutes, 0, 59},
+		{&schedule.hours, 0, 23},
+		{&schedule.days, 1, 31},
+		{&schedule.months, 1, 12},
+		{&schedule.weekdays, 0, 7},
+	}
+	for i, bound := range bounds {
+		values, err := parseScheduleField(fields[i], bound.min, bound.max)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %v: %v", expression, err)
+		}
+		*bound.values = values
+	}
+
+	// Sunday is either 0 or 7
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+
+	return schedule, nil
+}
+
+// parseScheduleField returns the values of a cron field between min and max
+func parseScheduleField(field string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			parsedStep, err := strconv.Atoi(part[i+1:])
+			if err != nil || parsedStep < 1 {
+				return nil, fmt.Errorf("invalid step in %v", part)
+			}
+			step = parsedStep
+			part = part[:i]
+		}
+
+		first, last := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %v", part)
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %v", part)
+				}
+			}
+		}
+
+		if first < min || last > max || first > last {
+			return nil, fmt.Errorf("%v is out of range %v-%v", part, min, max)
+		}
+		for value := first; value <= last; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// Matches returns true when the minute of t is a start of the schedule, when both day of month and day of week
+// are restricted either one matching is enough, as in cron
+func (s *Schedule) Matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+
+	dayMatches, weekdayMatches := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	if s.anyDay || s.anyWeekday {
+		return dayMatches && weekdayMatches
+	}
+	return dayMatches || weekdayMatches
+}
+
+// Next returns the first start of the schedule after t, false when there is none within a year, e.g. on
+// february 30th
+func (s *Schedule) Next(t time.Time) (time.Time, bool) {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(maxScheduleLookahead); next.Before(limit); next = next.Add(time.Minute) {
+		if s.Matches(next) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// WindowEnd returns the end of the window of the given length that t falls in, a window starting at every start
+// of the schedule, false when t is outside all windows
+func (s *Schedule) WindowEnd(t time.Time, window time.Duration) (time.Time, bool) {
+	start := t.Truncate(time.Minute)
+	for earliest := t.Add(-window); start.After(earliest); start = start.Add(-time.Minute) {
+		if s.Matches(start) {
+			return start.Add(window), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
+)
+
+// writeBlobsTable prints one row per blob with its lease state, holder and estimated expiration, with a
+// dashed line under the headers as az cli table output does
+func writeBlobsTable(writer io.Writer, blobs []models.BlobInfo) {
+	rows := [][]string{{"CONTAINER", "BLOB", "LEASE STATE", "HOLDER", "EXPIRES AT", "EXPIRES IN"}}
+	for _, blobInfo := range blobs {
+		expiresIn := "-"
+		if blobInfo.SecondsUntilExpiry != nil {
+			expiresIn = (time.Duration(*blobInfo.SecondsUntilExpiry) * time.Second).String()
+		}
+
+		rows = append(rows, []string{
+			tableValue(blobInfo.ContainerName),
+			tableValue(blobInfo.BlobName),
+			tableValue(blobInfo.LeaseState),
+			tableValue(blobInfo.Holder),
+			tableValue(blobInfo.LeaseExpiresAt),
+			expiresIn,
+		})
+	}
+
+	separator := make([]string, len(rows[0]))
+	for column := range separator {
+		width := 0
+		for _, row := range rows {
+			if len(row[column]) > width {
+				width = len(row[column])
+			}
+		}
+		separator[column] = strings.Repeat("-", width)
+	}
+	rows = append(rows[:1], append([][]string{separator}, rows[1:]...)...)
+
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	table.Flush()
+}
+
+// tableValue returns the value of a string pointer, or - when nil or empty
+func tableValue(value *string) string {
+	if value == nil || *value == "" {
+		return "-"
+	}
+	return *value
+}
+
+// leaseBlobInfo returns the lease described by a status result as a blob table row or csv record
+func leaseBlobInfo(result models.ResponseInfo) models.BlobInfo {
+	blobInfo := models.BlobInfo{
+		ContainerName:        result.ContainerName,
+		BlobName:             result.BlobName,
+		LeaseState:           result.LeaseState,
+		LeaseStatus:          result.LeaseStatus,
+		Holder:               result.Holder,
+		LeaseAcquiredAt:      result.LeaseAcquiredAt,
+		LeaseRenewedAt:       result.LeaseRenewedAt,
+		LeaseDurationSeconds: result.LeaseDurationSeconds,
+		LeaseExpiresAt:       result.LeaseExpiresAt,
+	}
+
+	if result.LeaseExpiresAt != nil {
+		if expiresAt, err := time.Parse(time.RFC3339, *result.LeaseExpiresAt); err == nil {
+			secondsUntilExpiry := int64(time.Until(expiresAt).Seconds())
+			blobInfo.SecondsUntilExpiry = &secondsUntilExpiry
+		}
+	}
+	return blobInfo
+}
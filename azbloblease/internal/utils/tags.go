@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseTags parses a comma separated list of key=value pairs into blob index tags
+func ParseTags(tags string) (map[string]string, error) {
+	result := map[string]string{}
+
+	if strings.TrimSpace(tags) == "" {
+		return result, nil
+	}
+
+	for _, pair := range strings.Split(tags, ",") {
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 || strings.TrimSpace(keyValue[0]) == "" {
+			return nil, fmt.Errorf("invalid tag %v, expected format is key=value", pair)
+		}
+		result[strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+	}
+
+	return result, nil
+}
+
+// ValidateTags checks that blob index tag keys, 1 to 128 characters, and values, up to 256 characters, only use
+// the characters allowed by storage, letters, digits, space and + - . / : = _, none of which needs quoting in a
+// tags filter expression
+func ValidateTags(tags map[string]string) error {
+	for k, v := range tags {
+		if len(k) < 1 || len(k) > 128 || !validTagText(k) {
+			return fmt.Errorf("invalid tag key %v, it must have 1 to 128 letters, digits, spaces or + - . / : = _", k)
+		}
+		if len(v) > 256 || !validTagText(v) {
+			return fmt.Errorf("invalid value of tag %v, it must have up to 256 letters, digits, spaces or + - . / : = _", k)
+		}
+	}
+	return nil
+}
+
+// validTagText returns true when text only has characters allowed in blob index tag keys and values
+func validTagText(text string) bool {
+	for _, c := range text {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(" +-./:=_", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildTagsFilter returns a blob index tags filter expression matching all tags, tags that cannot be represented
+// in the expression are rejected
+func BuildTagsFilter(tags map[string]string) (string, error) {
+	if err := ValidateTags(tags); err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	conditions := make([]string, 0, len(keys))
+	for _, k := range keys {
+		conditions = append(conditions, fmt.Sprintf("\"%v\"='%v'", k, tags[k]))
+	}
+
+	return strings.Join(conditions, " AND "), nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft and contributors.  All rights reserved.
+//
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		tags    string
+		result  map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"role=leader", map[string]string{"role": "leader"}, false},
+		{" role = leader , zone=1", map[string]string{"role": "leader", "zone": "1"}, false},
+		{"expression=a=b", map[string]string{"expression": "a=b"}, false},
+		{"role=", map[string]string{"role": ""}, false},
+		{"role", nil, true},
+		{"=leader", nil, true},
+		{"role=leader,", nil, true},
+	}
+
+	for _, test := range tests {
+		result, err := ParseTags(test.tags)
+		if !reflect.DeepEqual(result, test.result) || (err != nil) != test.wantErr {
+			t.Errorf("ParseTags(%q) = %v, %v, want %v and error %v", test.tags, result, err, test.result, test.wantErr)
+		}
+	}
+}
+
+func TestBuildTagsFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		filter  string
+		wantErr bool
+	}{
+		{"no tags", map[string]string{}, "", false},
+		{"one tag", map[string]string{"role": "leader"}, `"role"='leader'`, false},
+		{"sorted tags", map[string]string{"zone": "1", "role": "leader"}, `"role"='leader' AND "zone"='1'`, false},
+		{"allowed characters", map[string]string{"app/role": "leader+1 -.:=_"}, `"app/role"='leader+1 -.:=_'`, false},
+		{"empty value", map[string]string{"role": ""}, `"role"=''`, false},
+		{"quote in value", map[string]string{"role": "leader' OR 'a'='a"}, "", true},
+		{"quote in key", map[string]string{`role"`: "leader"}, "", true},
+		{"empty key", map[string]string{"": "leader"}, "", true},
+		{"key too long", map[string]string{strings.Repeat("k", 129): "leader"}, "", true},
+		{"value too long", map[string]string{"role": strings.Repeat("v", 257)}, "", true},
+	}
+
+	for _, test := range tests {
+		filter, err := BuildTagsFilter(test.tags)
+		if filter != test.filter || (err != nil) != test.wantErr {
+			t.Errorf("%v: BuildTagsFilter() = %q, %v, want %q and error %v", test.name, filter, err, test.filter, test.wantErr)
+		}
+	}
+}
@@ -8,24 +8,48 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/config"
 	"github.com/paulomarquesc/azbloblease/azbloblease/internal/models"
 )
 
+var (
+	jitterRandom      = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandomMutex sync.Mutex
+
+	stdinCloudConfigOnce sync.Once
+	stdinCloudConfig     []byte
+	stdinCloudConfigErr  error
+
+	processWarnings      []string
+	processWarningsMutex sync.Mutex
+)
+
+// maxProcessWarnings bounds the warnings kept by long running subcommands like serve and agent
+const maxProcessWarnings = 50
+
 // PrintHeader prints a header message
 func PrintHeader(header string) {
 	fmt.Println(header)
 	fmt.Println(strings.Repeat("-", len(header)))
 }
 
-func PrintUsage(createLeaseBlobCommand, acquireCommand, renewCommand, versionCommand *flag.FlagSet) {
+func PrintUsage(createLeaseBlobCommand, acquireCommand, renewCommand, releaseCommand, resumeCommand, statusCommand, listCommand, purgeCommand, doctorCommand, benchCommand, testAuthCommand, healthCheckCommand, serveCommand, agentCommand, watchCommand, handoffCommand, semaphoreCommand, rwLockCommand, versionCommand *flag.FlagSet) {
 	fmt.Println("")
 	fmt.Println("General usage")
 	fmt.Println("")
@@ -70,6 +94,186 @@ func PrintUsage(createLeaseBlobCommand, acquireCommand, renewCommand, versionCom
 	fmt.Println("\t\tstdout - json response after all renew iteration operations complete")
 	fmt.Println("\t\tstderr - diagnostic messages in every iteration and error messages")
 
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Releases a lease so other candidates can acquire it right away\n", releaseCommand.Name()))
+	fmt.Println("")
+	releaseCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease release -accountname \"mystorageaccount\" -container \"azbloblease\" -blobname \"myblob\" -leaseid \"d3d63201-153b-453b-85ef-6c3bee3082f0\" -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response after release process is executed")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Releases a lease designating a successor, which acquires it alone during a preference window, for planned failovers\n", handoffCommand.Name()))
+	fmt.Println("")
+	handoffCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease handoff -accountname \"mystorageaccount\" -container \"azbloblease\" -blobname \"myblob\" -leaseid \"d3d63201-153b-453b-85ef-6c3bee3082f0\" -successor \"node2\" -preference-window 30s -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with the successor and the end of its preference window")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Counting semaphore over the blobs <name>-0 to <name>-<slots-1>, acquire grabs any free slot, renew maintains and release frees it\n", semaphoreCommand.Name()))
+	fmt.Println("")
+	semaphoreCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease semaphore -action acquire -name \"workers\" -slots 5 -accountname \"mystorageaccount\" -container \"azbloblease\" -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with the slot index and the lease id of the slot held")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Experimental read/write lock, shared read locks registered in the metadata of <blobname>-readers and an exclusive write lock on the lease of blobname\n", rwLockCommand.Name()))
+	fmt.Println("")
+	rwLockCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease rwlock -mode read -action acquire -accountname \"mystorageaccount\" -container \"azbloblease\" -blobname \"dataset\" -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with the reader id in read mode or the lease id in write mode, and the number of readers")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Re-attaches to the lease recorded in a state file, resuming its renewal or releasing it\n", resumeCommand.Name()))
+	fmt.Println("")
+	resumeCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease resume -state-file \"/run/azbloblease/state.json\" -iterations 10 -waittimesec 30")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response after all renew iteration operations complete or the lease is released")
+	fmt.Println("\t\tstderr - diagnostic messages in every iteration and error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Shows the lease state of a blob, its holder, lease age and estimated expiration\n", statusCommand.Name()))
+	fmt.Println("")
+	statusCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease status -accountname \"mystorageaccount\" -container \"azbloblease\" -blobname \"myblob\" -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with lease state and holder information")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Lists lease blobs of a container or the ones matching blob index tags\n", listCommand.Name()))
+	fmt.Println("")
+	listCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease list -accountname \"mystorageaccount\" -tags \"app=myapp,env=prod\" -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with the list of blobs found")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Deletes lease blobs not leased and not acquired for a while\n", purgeCommand.Name()))
+	fmt.Println("")
+	purgeCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease purge -accountname \"mystorageaccount\" -container \"azbloblease\" -prefix \"job-\" -older-than 720h -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with the list of stale blobs and whether they were deleted")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Runs preflight checks of credentials, permissions and connectivity\n", doctorCommand.Name()))
+	fmt.Println("")
+	doctorCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease doctor -accountname \"mystorageaccount\" -container \"azbloblease\" -blobname \"myblob\" -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with one result per check")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Measures acquire, renew and release latencies over several cycles\n", benchCommand.Name()))
+	fmt.Println("")
+	benchCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease bench -accountname \"mystorageaccount\" -container \"azbloblease\" -blobname \"bench\" -cycles 50 -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with p50, p95 and p99 latencies per operation")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Obtains a single token and shows the identity it was issued to\n", testAuthCommand.Name()))
+	fmt.Println("")
+	testAuthCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease test-auth -use-system-managed-identity")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with object id, tenant id and application id of the identity")
+	fmt.Println("\t\tstderr - error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Exits 0 only if the lease in the local state file is held and was renewed recently\n", healthCheckCommand.Name()))
+	fmt.Println("")
+	healthCheckCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease healthcheck -state-file /run/azbloblease/state.json -max-age 90s")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - json response with the lease held")
+	fmt.Println("\t\texit code - 0 when healthy, 1 otherwise")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Exposes acquire, renew, release and status as an http api until interrupted\n", serveCommand.Name()))
+	fmt.Println("")
+	serveCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tAZBLOBLEASE_API_KEY=\"mysecret\" azbloblease serve -accountname \"mystorageaccount\" -container \"azbloblease\" -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\" -listen \"127.0.0.1:8080\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\thttp - json response per request, POST /v1/leases/acquire, /v1/leases/renew and /v1/leases/release, GET /v1/leases/{name}")
+	fmt.Println("\t\tstderr - one line per request and error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Maintains the leases described in a config file concurrently until interrupted, reloading it on SIGHUP\n", agentCommand.Name()))
+	fmt.Println("")
+	agentCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease agent -config /etc/azbloblease/leases.json -listen \"127.0.0.1:9090\" -use-system-managed-identity")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\thttp - /healthz with the leadership state of every lease and /metrics in prometheus text format, when listen is informed")
+	fmt.Println("\t\tstderr - lease acquisitions, losses and error messages")
+
+	fmt.Println("")
+	fmt.Printf(fmt.Sprintf("%v - Polls a lease and reports leadership transitions with the previous and the new holder until interrupted\n", watchCommand.Name()))
+	fmt.Println("")
+	watchCommand.PrintDefaults()
+	fmt.Println("")
+	fmt.Println("\tExample")
+	fmt.Println("\t\tazbloblease watch -accountname \"mystorageaccount\" -container \"azbloblease\" -blobname \"myblob\" -interval 15s -resourcegroupname \"my-rg\" -subscriptionid \"11111111-1111-1111-1111-111111111111\"")
+	fmt.Println("")
+	fmt.Println("\tOutputs")
+	fmt.Println("\t\tstdout - one json line per leadership event, observed, elected, changed or vacated, json response when watching could not start")
+	fmt.Println("\t\tstderr - error messages")
+
 	fmt.Println("")
 	fmt.Printf(fmt.Sprintf("%v - gets tool version\n", versionCommand.Name()))
 	fmt.Println("")
@@ -82,11 +286,88 @@ func PrintUsage(createLeaseBlobCommand, acquireCommand, renewCommand, versionCom
 	fmt.Println("\t\tstdout - tool version")
 }
 
-// ConsoleOutput writes to stdout.
+// ConsoleOutput writes to stdout. Diagnostics written to stderr become json objects with -diag-format json.
 func ConsoleOutput(message string, logger *log.Logger) {
+	if logger == config.Stderr() && config.DiagFormat() == "json" {
+		logDiagnostic("", message, nil)
+		return
+	}
 	logger.Println(message)
 }
 
+// Diagnostic logs a diagnostic to stderr, with -diag-format json the fields (e.g. the iteration of a renew loop)
+// are added to its json object so log pipelines don't have to parse the message
+func Diagnostic(message string, fields map[string]interface{}) {
+	logDiagnostic("info", message, fields)
+}
+
+// Debug logs a diagnostic to stderr only when debug diagnostics are enabled
+func Debug(message string) {
+	if config.Debug() {
+		logDiagnostic("debug", message, nil)
+	}
+}
+
+// logDiagnostic writes a diagnostic to stderr as a json object with time, level, message and fields, or as text
+// prefixed by its level other than info. Diagnostics of unknown level, e.g. errors and notices written with
+// ConsoleOutput, have no level.
+func logDiagnostic(level, message string, fields map[string]interface{}) {
+	if config.DiagFormat() != "json" {
+		if level != "" && level != "info" {
+			message = fmt.Sprintf("%v: %v", level, message)
+		}
+		config.Stderr().Println(message)
+		return
+	}
+
+	diagnostic := map[string]interface{}{}
+	for k, v := range fields {
+		diagnostic[k] = v
+	}
+	diagnostic["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	diagnostic["message"] = message
+	if level != "" {
+		diagnostic["level"] = level
+	}
+
+	diagnosticJSON, err := json.Marshal(diagnostic)
+	if err != nil {
+		config.Stderr().Println(message)
+		return
+	}
+	config.Stderr().Println(string(diagnosticJSON))
+}
+
+// Warn logs a non-fatal condition of the process to stderr and records it, recorded warnings are added to the
+// next result written to stdout
+func Warn(message string) {
+	logDiagnostic("warning", message, nil)
+
+	processWarningsMutex.Lock()
+	defer processWarningsMutex.Unlock()
+	if !Contains(processWarnings, message) && len(processWarnings) < maxProcessWarnings {
+		processWarnings = append(processWarnings, message)
+	}
+}
+
+// AddWarning logs a non-fatal condition of an operation to stderr and adds it to the warnings of its result,
+// conditions repeated by a loop, e.g. on every renewal, are only added once
+func AddWarning(result *models.ResponseInfo, message string) {
+	logDiagnostic("warning", message, nil)
+	if !Contains(result.Warnings, message) {
+		result.Warnings = append(result.Warnings, message)
+	}
+}
+
+// takeProcessWarnings returns the recorded warnings of the process and forgets them
+func takeProcessWarnings() []string {
+	processWarningsMutex.Lock()
+	defer processWarningsMutex.Unlock()
+	warnings := processWarnings
+	processWarnings = nil
+	return warnings
+}
+
 // Contains checks if there is a string already in an existing splice of strings
 func Contains(array []string, element string) bool {
 	for _, e := range array {
@@ -108,15 +389,263 @@ func FindInSlice(slice []string, val string) (int, bool) {
 	return -1, false
 }
 
+// StringListFlag is a flag value that can be repeated and also accepts comma separated values,
+// the default value is replaced when the flag is informed
+type StringListFlag struct {
+	values []string
+	isSet  bool
+}
+
+// NewStringListFlag returns a StringListFlag with a default value
+func NewStringListFlag(defaultValue string) *StringListFlag {
+	return &StringListFlag{values: []string{defaultValue}}
+}
+
+// String returns the comma separated values
+func (f *StringListFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.values, ",")
+}
+
+// Set adds values to the list
+func (f *StringListFlag) Set(value string) error {
+	if !f.isSet {
+		f.values = []string{}
+		f.isSet = true
+	}
+
+	for _, v := range strings.Split(value, ",") {
+		if strings.TrimSpace(v) != "" {
+			f.values = append(f.values, strings.TrimSpace(v))
+		}
+	}
+
+	return nil
+}
+
+// Values returns the list of values
+func (f *StringListFlag) Values() []string {
+	return f.values
+}
+
+// AddPrefix prepends prefix to all values
+func (f *StringListFlag) AddPrefix(prefix string) {
+	f.values = PrefixNames(prefix, f.values)
+}
+
+// SecondsFlag is a flag value holding a number of seconds, informed either as a bare integer of seconds or
+// as a duration (e.g. 90s, 2m)
+type SecondsFlag int
+
+// NewSecondsFlag defines a SecondsFlag with a default value on the flag set and returns a pointer to the number
+// of seconds
+func NewSecondsFlag(command *flag.FlagSet, name string, value int, usage string) *int {
+	seconds := new(int)
+	*seconds = value
+	command.Var((*SecondsFlag)(seconds), name, usage)
+	return seconds
+}
+
+// String returns the number of seconds
+func (f *SecondsFlag) String() string {
+	if f == nil {
+		return "0"
+	}
+	return strconv.Itoa(int(*f))
+}
+
+// Set parses a bare integer of seconds or a duration with a whole number of seconds
+func (f *SecondsFlag) Set(value string) error {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		*f = SecondsFlag(seconds)
+		return nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%v is neither a number of seconds nor a duration (e.g. 90s, 2m)", value)
+	}
+	if duration%time.Second != 0 {
+		return fmt.Errorf("%v is not a whole number of seconds", value)
+	}
+
+	*f = SecondsFlag(duration / time.Second)
+	return nil
+}
+
+// PrefixNames returns the names with prefix prepended
+func PrefixNames(prefix string, names []string) []string {
+	result := []string{}
+	for _, name := range names {
+		result = append(result, prefix+name)
+	}
+	return result
+}
+
 // BuildResultResponse returns the json formatted result
 func BuildResultResponse(result models.ResponseInfo) string {
 	responseJSON, _ := json.MarshalIndent(result, "", "    ")
 	return strings.Replace(string(responseJSON), "\"\"", "null", -1)
 }
 
-// ImportCloudConfigJson imports the cloud config json file and returns a struct
+// OutputEvent writes the event to stdout as a single json line, so streams of events can be consumed line by line
+func OutputEvent(event models.LeadershipEvent) {
+	eventJSON, _ := json.Marshal(event)
+	ConsoleOutput(string(eventJSON), config.StdoutJSON())
+}
+
+// ReadContent returns the content of a file, or of stdin when path is -
+func ReadContent(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Timestamp formats a time as RFC3339 in UTC, the format used by all timestamps in output and diagnostics
+func Timestamp(value time.Time) string {
+	return value.UTC().Format(time.RFC3339)
+}
+
+// BuildResultsResponse returns the json formatted results of a batch operation, the array of per blob
+// results with a summary of their outcomes
+func BuildResultsResponse(batchResponse models.BatchResponse) string {
+	responseJSON, _ := json.MarshalIndent(batchResponse, "", "    ")
+	return strings.Replace(string(responseJSON), "\"\"", "null", -1)
+}
+
+// BuildBatchResponse returns the per blob results of a batch operation with a summary of their outcomes,
+// succeeded counts Success and SuccessOnRenew statuses, contended counts acquisitions that spent their
+// maximum wait and failed all other results
+func BuildBatchResponse(results []models.ResponseInfo) models.BatchResponse {
+	batchResponse := models.BatchResponse{
+		Summary: models.BatchSummary{Total: len(results)},
+		Results: results,
+	}
+
+	for _, result := range results {
+		switch stringValue(result.Status) {
+		case config.Success(), config.SuccessOnRenew():
+			batchResponse.Summary.Succeeded++
+		case config.Contended():
+			batchResponse.Summary.Contended++
+		default:
+			batchResponse.Summary.Failed++
+		}
+	}
+
+	return batchResponse
+}
+
+// OutputResult outputs the json result in stdout and, in github actions output mode, also writes it as
+// step outputs. In table and csv output modes, results with blobs and lease states are printed as a table or
+// as csv instead.
+func OutputResult(result models.ResponseInfo) {
+	timestamp := Timestamp(time.Now())
+	result.Timestamp = &timestamp
+	result.CorrelationID = correlationID()
+	for _, warning := range takeProcessWarnings() {
+		if !Contains(result.Warnings, warning) {
+			result.Warnings = append(result.Warnings, warning)
+		}
+	}
+
+	if config.OutputFormat() == "csv" && (result.Blobs != nil || result.LeaseState != nil) {
+		blobs := []models.BlobInfo{leaseBlobInfo(result)}
+		if result.Blobs != nil {
+			blobs = *result.Blobs
+		}
+
+		err := writeBlobsCSV(os.Stdout, blobs)
+		if err != nil {
+			ConsoleOutput(fmt.Sprintf("an error ocurred while writing csv output: %v", err), config.Stderr())
+		}
+		return
+	}
+
+	if config.OutputFormat() == "table" && result.Blobs != nil {
+		writeBlobsTable(os.Stdout, *result.Blobs)
+		return
+	}
+
+	if config.OutputFormat() == "table" && result.LeaseState != nil {
+		writeBlobsTable(os.Stdout, []models.BlobInfo{leaseBlobInfo(result)})
+		return
+	}
+
+	ConsoleOutput(BuildResultResponse(result), config.StdoutJSON())
+
+	if config.OutputFormat() == "gha" {
+		err := writeGitHubOutputs(result, stringValue(result.Status), stringValue(result.Operation), stringValue(result.ErrorMessage))
+		if err != nil {
+			ConsoleOutput(fmt.Sprintf("an error ocurred while writing github actions outputs: %v", err), config.Stderr())
+		}
+	}
+}
+
+// OutputResults outputs the json results of a batch operation, with their summary and the exit code the process
+// ends with, in stdout and, in github actions output mode, also writes them as step outputs
+func OutputResults(results []models.ResponseInfo, exitCode int) {
+	timestamp := Timestamp(time.Now())
+	stamped := []models.ResponseInfo{}
+	for _, result := range results {
+		result.Timestamp = &timestamp
+		result.CorrelationID = correlationID()
+		stamped = append(stamped, result)
+	}
+	results = stamped
+
+	batchResponse := BuildBatchResponse(results)
+	exitStatus := ExitStatus(exitCode)
+	batchResponse.ExitCode = &exitStatus
+	batchResponse.Warnings = takeProcessWarnings()
+	ConsoleOutput(BuildResultsResponse(batchResponse), config.StdoutJSON())
+
+	if config.OutputFormat() == "gha" {
+		status, operation, errorMessage := gitHubResultsSummary(results)
+		err := writeGitHubOutputs(batchResponse, status, operation, errorMessage)
+		if err != nil {
+			ConsoleOutput(fmt.Sprintf("an error ocurred while writing github actions outputs: %v", err), config.Stderr())
+		}
+	}
+}
+
+// correlationID returns the client request id sent on azure requests, nil when none is sent
+func correlationID() *string {
+	if config.CorrelationID() == "" {
+		return nil
+	}
+	correlationID := config.CorrelationID()
+	return &correlationID
+}
+
+// IsCloudConfigStream returns true when the cloud config source is stdin or the inline json environment variable
+func IsCloudConfigStream(path string) bool {
+	return path == "-" || path == config.CloudConfigEnvSource()
+}
+
+// readCloudConfig returns the cloud config json from a file, stdin or the inline json environment variable,
+// stdin is only read once since the cloud config is imported more than once
+func readCloudConfig(path string) ([]byte, error) {
+	switch path {
+	case "-":
+		stdinCloudConfigOnce.Do(func() {
+			stdinCloudConfig, stdinCloudConfigErr = ioutil.ReadAll(os.Stdin)
+		})
+		return stdinCloudConfig, stdinCloudConfigErr
+	case config.CloudConfigEnvSource():
+		return []byte(os.Getenv(config.CloudConfigEnvVar())), nil
+	default:
+		return ioutil.ReadFile(path)
+	}
+}
+
+// ImportCloudConfigJson imports the cloud config json from a file, stdin (-) or
+// the inline json environment variable and returns a struct
 func ImportCloudConfigJson(path string) (*models.CloudConfigInfo, error) {
-	infoJSON, err := ioutil.ReadFile(path)
+	infoJSON, err := readCloudConfig(path)
 	if err != nil {
 		ConsoleOutput(fmt.Sprintf("failed to read file: %v", err), config.Stderr())
 		return &models.CloudConfigInfo{}, err
@@ -124,6 +653,289 @@ func ImportCloudConfigJson(path string) (*models.CloudConfigInfo, error) {
 
 	// Converting json to struct
 	var info models.CloudConfigInfo
-	json.Unmarshal(infoJSON, &info)
+	err = json.Unmarshal(infoJSON, &info)
+	if err != nil {
+		return &models.CloudConfigInfo{}, fmt.Errorf("cloud config is not valid json: %v", err)
+	}
+
 	return &info, nil
 }
+
+// CloudConfigFieldError describes a missing or invalid field of the custom cloud config
+type CloudConfigFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *CloudConfigFieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Field, e.Reason)
+}
+
+// ValidateCloudConfig checks that the custom cloud config has the fields needed by the current
+// configuration and that endpoints are absolute urls, fields replaced by the connection flags
+// (authority host, storage endpoint suffix) or not used (resource manager with skip-arm) are not required
+func ValidateCloudConfig(info *models.CloudConfigInfo) error {
+	err := validateCloudConfigURL("endpoints.activeDirectory", info.Endpoints.ActiveDirectoryAuthorityHost, config.AuthorityHost() == "")
+	if err != nil {
+		return err
+	}
+
+	err = validateCloudConfigURL("endpoints.resourceManager", info.Endpoints.ResourceManagerEndpoint, !config.SkipARM())
+	if err != nil {
+		return err
+	}
+
+	err = validateCloudConfigURL("endpoints.activeDirectoryResourceId", info.Endpoints.ResourceManagerAudience, false)
+	if err != nil {
+		return err
+	}
+
+	storageEndpoint := info.Suffixes.StorageEndpoint
+	if storageEndpoint == "" && config.SkipARM() && config.StorageEndpointSuffixOverride() == "" {
+		return &CloudConfigFieldError{Field: "suffixes.storageEndpoint", Reason: "missing, required to build the blob endpoint with -skip-arm"}
+	}
+
+	if strings.Contains(storageEndpoint, "/") || strings.Contains(storageEndpoint, ":") {
+		return &CloudConfigFieldError{Field: "suffixes.storageEndpoint", Reason: fmt.Sprintf("%q must be a dns suffix such as core.windows.net, not an url", storageEndpoint)}
+	}
+
+	return nil
+}
+
+// validateCloudConfigURL checks that a cloud config endpoint is an absolute http(s) url
+func validateCloudConfigURL(field, value string, required bool) error {
+	if value == "" {
+		if required {
+			return &CloudConfigFieldError{Field: field, Reason: "missing"}
+		}
+		return nil
+	}
+
+	parsedURL, err := url.Parse(value)
+	if err != nil {
+		return &CloudConfigFieldError{Field: field, Reason: fmt.Sprintf("%q is not a valid url: %v", value, err)}
+	}
+
+	if (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Host == "" {
+		return &CloudConfigFieldError{Field: field, Reason: fmt.Sprintf("%q must be an absolute url such as https://login.microsoftonline.com/", value)}
+	}
+
+	return nil
+}
+
+// ParamsToArgs converts a json document with the format {"subcommand": "<name>", "options": {"<flag>": <value>}}
+// into command line arguments, array values become repeated flags. Options are sorted by name so the
+// resulting arguments are deterministic.
+func ParamsToArgs(paramsJSON []byte) ([]string, error) {
+	var params struct {
+		Subcommand string                     `json:"subcommand"`
+		Options    map[string]json.RawMessage `json:"options"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(paramsJSON))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&params)
+	if err != nil {
+		return nil, fmt.Errorf("params is not valid json: %v", err)
+	}
+
+	if params.Subcommand == "" {
+		return nil, fmt.Errorf("subcommand is required in params")
+	}
+
+	names := []string{}
+	for name := range params.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{params.Subcommand}
+	for _, name := range names {
+		values, err := paramValues(params.Options[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of option %v: %v", name, err)
+		}
+
+		for _, value := range values {
+			args = append(args, fmt.Sprintf("-%v=%v", strings.TrimLeft(name, "-"), value))
+		}
+	}
+
+	return args, nil
+}
+
+// paramValues returns the flag values of a json option value, strings, numbers and booleans
+// result in one value and arrays of them in one value per element
+func paramValues(raw json.RawMessage) ([]string, error) {
+	var list []interface{}
+	if json.Unmarshal(raw, &list) != nil {
+		var single interface{}
+		err := json.Unmarshal(raw, &single)
+		if err != nil {
+			return nil, err
+		}
+		list = []interface{}{single}
+	}
+
+	values := []string{}
+	for _, item := range list {
+		switch v := item.(type) {
+		case string:
+			values = append(values, v)
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		case float64:
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return nil, fmt.Errorf("only strings, numbers, booleans and arrays of them are supported")
+		}
+	}
+
+	return values, nil
+}
+
+// Jitter randomly spreads a wait interval by up to config.JitterPercent() percent in both directions so
+// fleets started together do not keep hitting storage in synchronized bursts
+func Jitter(interval time.Duration) time.Duration {
+	if config.JitterPercent() <= 0 || interval <= 0 {
+		return interval
+	}
+
+	jitterRandomMutex.Lock()
+	factor := (jitterRandom.Float64()*2 - 1) * float64(config.JitterPercent()) / 100
+	jitterRandomMutex.Unlock()
+
+	return time.Duration(float64(interval) * (1 + factor))
+}
+
+// PriorityDelay returns a random initial acquire delay, up to config.PriorityBaseDelay() divided by priority, so
+// when candidates race for a freed lease the higher priority ones statistically attempt first, 0 disables it
+func PriorityDelay(priority int) time.Duration {
+	if priority <= 0 {
+		return 0
+	}
+
+	return RandomDelay(config.PriorityBaseDelay() / time.Duration(priority))
+}
+
+// RandomDelay returns a random delay between 0 and max, e.g. so a fleet restarted at once does not send its first
+// requests in a synchronized burst
+func RandomDelay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	jitterRandomMutex.Lock()
+	factor := jitterRandom.Float64()
+	jitterRandomMutex.Unlock()
+
+	return time.Duration(factor * float64(max))
+}
+
+// Sleep waits for the interval unless the context is cancelled first, in which case the context error
+// is returned right away
+func Sleep(cntx context.Context, interval time.Duration) error {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	select {
+	case <-cntx.Done():
+		return cntx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// MetadataValue returns the value of a blob metadata key, keys are compared
+// case-insensitively since the service does not preserve their casing on reads
+func MetadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+// MergeMetadata returns a copy of the existing metadata with values added or replaced
+func MergeMetadata(existing map[string]*string, values map[string]string) map[string]*string {
+	result := map[string]*string{}
+
+	for k, v := range existing {
+		if _, found := findKeyFold(values, k); !found {
+			result[k] = v
+		}
+	}
+
+	for k, v := range values {
+		value := v
+		result[k] = &value
+	}
+
+	return result
+}
+
+// ParseAccountRefs parses a comma separated list of [subscriptionid/]resourcegroup/account storage
+// account references, subscription defaults to defaultSubscriptionID when omitted
+func ParseAccountRefs(accounts, defaultSubscriptionID string) ([]models.StorageAccountRef, error) {
+	result := []models.StorageAccountRef{}
+
+	if strings.TrimSpace(accounts) == "" {
+		return result, nil
+	}
+
+	for _, account := range strings.Split(accounts, ",") {
+		parts := strings.Split(strings.TrimSpace(account), "/")
+		switch len(parts) {
+		case 2:
+			result = append(result, models.StorageAccountRef{SubscriptionID: defaultSubscriptionID, ResourceGroupName: parts[0], AccountName: parts[1]})
+		case 3:
+			result = append(result, models.StorageAccountRef{SubscriptionID: parts[0], ResourceGroupName: parts[1], AccountName: parts[2]})
+		default:
+			return nil, fmt.Errorf("invalid storage account reference %v, expected format is [subscriptionid/]resourcegroup/account", account)
+		}
+	}
+
+	return result, nil
+}
+
+// BuildShardNames returns the shard blob names from a comma separated list of names, or from a prefix
+// followed by an index from 0 to count-1
+func BuildShardNames(shards, prefix string, count int) ([]string, error) {
+	result := []string{}
+
+	if shards != "" && prefix != "" {
+		return nil, fmt.Errorf("shards and shard prefix are mutually exclusive")
+	}
+
+	if shards != "" {
+		for _, shard := range strings.Split(shards, ",") {
+			if strings.TrimSpace(shard) == "" {
+				return nil, fmt.Errorf("empty shard name in %v", shards)
+			}
+			result = append(result, strings.TrimSpace(shard))
+		}
+		return result, nil
+	}
+
+	if prefix != "" {
+		if count < 1 {
+			return nil, fmt.Errorf("shard count must be greater than 0")
+		}
+		for i := 0; i < count; i++ {
+			result = append(result, fmt.Sprintf("%v%v", prefix, i))
+		}
+	}
+
+	return result, nil
+}
+
+// findKeyFold returns the key of a map that matches case-insensitively
+func findKeyFold(values map[string]string, key string) (string, bool) {
+	for k := range values {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}